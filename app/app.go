@@ -0,0 +1,12 @@
+// Package app contains Bloggo's web app, which is embedded in the bloggo binary
+package app
+
+import "embed"
+
+// grid.css is compiled from grid.scss, run `go generate` after editing the style
+//go:generate sass --no-source-map grid.scss grid.css
+
+// Files contains the assets of the web app
+//
+//go:embed index.html grid.css fonts
+var Files embed.FS
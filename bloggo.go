@@ -1,21 +1,35 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/Ullaakut/Bloggo/app"
 	"github.com/Ullaakut/Bloggo/controller"
+	"github.com/Ullaakut/Bloggo/keys"
 	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/mailer"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/oidc"
 	"github.com/Ullaakut/Bloggo/repo"
 	"github.com/Ullaakut/Bloggo/service"
+	"github.com/Ullaakut/Bloggo/storage"
+	"github.com/Ullaakut/Bloggo/theme"
 	"github.com/jinzhu/gorm"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"gopkg.in/tylerb/graceful.v1"
 )
@@ -34,58 +48,242 @@ func main() {
 
 	zerolog.SetGlobalLevel(logger.ParseLevel(config.LogLevel))
 
+	// Run a command instead of the server if one was given
+	if len(os.Args) > 1 {
+		err = runCommand(log, config, os.Args[1], os.Args[2:])
+		if err != nil {
+			log.Fatal().Err(err).Str("command", os.Args[1]).Msg("command failed")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Catch signals
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
 
+	proxies, err := parseTrustedProxies(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid trusted proxies")
+		os.Exit(1)
+	}
+
 	e := echo.New()
+	e.Pre(controller.ClientIP(proxies))
+	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(middleware.Recover())
-	e.Use(middleware.Gzip())
+	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
+		// The app's assets are already compressed
+		Skipper: func(ctx echo.Context) bool {
+			p := ctx.Request().URL.Path
+			return p == config.AppPrefix || strings.HasPrefix(p, config.AppPrefix+"/")
+		},
+	}))
 
 	// Use zerolog for debugging HTTP requests
 	e.Logger.SetLevel(5) // Disable default logging
 	e.Use(logger.HTTPLogger(log))
 
 	// Initialize the database
-	// Retry until it is successful or the retryDuration is over
-	var db *gorm.DB
-	startTime := time.Now()
-	err = try(log, config.MySQLRetryInterval, func() error {
-		db, err = gorm.Open("mysql", config.MySQLURL)
-		return err
-	}, func() bool {
-		return time.Since(startTime) < config.MySQLRetryDuration
-	})
+	db, err := connectDatabase(log, config)
 	if err != nil {
 		log.Fatal().Err(err).Msg("could not initialize mysql connection")
 		os.Exit(1)
 	}
-	log.Info().Msg("connection to mysql successful")
 
 	blogPostRepository := repo.NewBlogPostRepositoryMySQL(log, db)
 	userRepository := repo.NewUserRepositoryMySQL(log, db)
+	mediaRepository := repo.NewMediaRepositoryMySQL(log, db)
+	refreshTokenRepository := repo.NewRefreshTokenRepositoryMySQL(log, db)
+	revokedTokenRepository := repo.NewRevokedTokenRepositoryMySQL(log, db)
+	personalTokenRepository := repo.NewPersonalTokenRepositoryMySQL(log, db)
+	identityRepository := repo.NewIdentityRepositoryMySQL(log, db)
+	oidcLoginRepository := repo.NewOIDCLoginRepositoryMySQL(log, db)
+	loginThrottleRepository := repo.NewLoginThrottleRepositoryMySQL(log, db)
+	invitationRepository := repo.NewInvitationRepositoryMySQL(log, db)
+	passwordResetRepository := repo.NewPasswordResetRepositoryMySQL(log, db)
+	recoveryCodeRepository := repo.NewRecoveryCodeRepositoryMySQL(log, db)
+	mfaLoginRepository := repo.NewMFALoginRepositoryMySQL(log, db)
+	magicLinkRepository := repo.NewMagicLinkRepositoryMySQL(log, db)
+	sessionRepository := repo.NewSessionRepositoryMySQL(log, db)
+	loginEventRepository := repo.NewLoginEventRepositoryMySQL(log, db)
+	auditEventRepository := repo.NewAuditEventRepositoryMySQL(log, db)
+
+	blobStore, err := newBlobStore(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not initialize blob storage")
+		os.Exit(1)
+	}
+
+	hasher := newHasher(config)
+
+	keySet, err := loadKeySet(log, config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load signing keys")
+		os.Exit(1)
+	}
+
+	revocations := service.NewRevocations(log, revokedTokenRepository, config.RevocationCacheTTL)
+	accessService := service.NewAccess(log, userRepository, revocations, sessionRepository, keySet, config.JWTIssuer, config.JWTAudience)
+	personalTokenService := service.NewPersonalTokens(log, personalTokenRepository, userRepository)
+	loginThrottle := service.NewLoginThrottle(log, loginThrottleRepository, config.LoginMaxFailures, config.LoginMaxFailuresPerIP, config.LoginBackoff, config.LoginLockout)
+	mfaService := service.NewMFA(log, userRepository, recoveryCodeRepository, config.SiteTitle)
+	tokenService := service.NewToken(log, userRepository, refreshTokenRepository, sessionRepository, loginEventRepository, revocations, hasher, keySet, mfaService, mfaLoginRepository, config.JWTIssuer, config.JWTAudience, config.AccessTokenTTL, config.RefreshTokenTTL, config.MFALoginTTL)
+	sessionService := service.NewSessions(log, sessionRepository, loginEventRepository, userRepository, config.RefreshTokenTTL)
+	auditService := service.NewAudit(log, auditEventRepository, userRepository)
+	impersonationService := service.NewImpersonation(log, userRepository, tokenService, auditService, config.ImpersonationTTL)
+
+	registration := service.NewRegistration(service.RegistrationMode(config.RegistrationMode), config.RegistrationDomains, config.RegistrationApproval)
+
+	identityProviders, err := newIdentityProviders(config)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not configure identity providers")
+		os.Exit(1)
+	}
+	oidcService := service.NewOIDC(log, identityProviders, oidcLoginRepository, identityRepository, userRepository, tokenService, registration, config.OIDCLoginTTL)
 
-	hasher := service.NewBcryptHasher(config.BcryptRuns)
+	setupToken, err := loadSetupToken(log, config, userRepository)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load setup token")
+		os.Exit(1)
+	}
+	setupService := service.NewSetup(log, userRepository, hasher, setupToken)
+	mailerInstance := newMailer(config)
+	passwordResetService := service.NewPasswordResets(log, passwordResetRepository, userRepository, sessionRepository, hasher, mailerInstance, config.PasswordResetURL, config.PasswordResetTTL, config.PasswordResetMaxPerHour)
+	verifyEmailURL := strings.TrimSuffix(config.SiteURL, "/") + config.APIPrefix + "/verify-email"
+	emailVerificationService := service.NewEmailVerifications(log, userRepository, keySet, keySet, mailerInstance, config.JWTIssuer, config.JWTAudience, verifyEmailURL, config.EmailVerificationTTL)
+	magicLinkService := service.NewMagicLinks(log, magicLinkRepository, userRepository, tokenService, keySet, keySet, mailerInstance, config.JWTIssuer, config.JWTAudience, config.MagicLinkURL, config.MagicLinkTTL, config.MagicLinkMaxPerHour)
+	invitationService := service.NewInvitations(log, invitationRepository, userRepository, hasher, keySet, keySet, config.JWTIssuer, config.JWTAudience, config.InvitationTTL)
 
-	accessService := service.NewAccess(log, userRepository, config.JWTSecret)
-	tokenService := service.NewToken(log, userRepository, hasher, config.JWTSecret)
+	th, err := theme.Load(config.ThemeDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load theme")
+		os.Exit(1)
+	}
+	blogSite := theme.Site{
+		Title:       config.SiteTitle,
+		Description: config.SiteDescription,
+		BaseURL:     config.SiteURL,
+	}
+
+	mediaProcessor := service.NewMediaProcessor(log, mediaRepository, blobStore, config.MediaVariantWidths, config.MediaWorkers, config.MediaQueueSize)
+
+	blogController := controller.NewBlog(log, blogPostRepository, mediaRepository)
+	mediaController := controller.NewMedia(log, mediaRepository, blobStore, mediaProcessor, config.MediaMaxSize, config.APIPrefix+"/media")
+	frontendController := controller.NewFrontend(log, blogPostRepository, th, blogSite, config.PageSize, config.FrontendCacheMaxAge)
+	// Browsers that ask for it get their tokens in cookies that are only sent to the API
+	cookieAuth := controller.NewCookieAuth(config.APIPrefix, strings.HasPrefix(config.SiteURL, "https://"), config.RefreshTokenTTL)
 
-	blogController := controller.NewBlog(log, blogPostRepository)
-	userController := controller.NewUser(log, userRepository, tokenService, hasher)
-	authController := controller.NewAuth(log, accessService)
+	userController := controller.NewUser(log, userRepository, tokenService, hasher, loginThrottle, registration, emailVerificationService, sessionService, cookieAuth)
+	setupController := controller.NewSetup(log, setupService, tokenService, cookieAuth)
+	invitationController := controller.NewInvitation(log, invitationService, tokenService, registration, cookieAuth)
+	passwordController := controller.NewPassword(log, passwordResetService)
+	magicLinkController := controller.NewMagicLink(log, magicLinkService, cookieAuth)
+	authController := controller.NewAuth(log, accessService, personalTokenService, cookieAuth, auditService, config.EmailVerificationRequired, config.MFARequiredForAdmins)
+	mfaController := controller.NewMFA(log, mfaService)
+	personalTokenController := controller.NewPersonalTokens(log, personalTokenService)
+	sessionController := controller.NewSessions(log, sessionService)
+	impersonationController := controller.NewImpersonation(log, impersonationService)
+	auditController := controller.NewAudit(log, auditService)
+	keysController := controller.NewKeys(log, keySet)
+	oidcController := controller.NewOIDC(log, oidcService, cookieAuth, config.APIPrefix+"/oidc", strings.HasPrefix(config.SiteURL, "https://"), config.OIDCLoginTTL)
+
+	assetsController, err := controller.NewAssets(log, app.Files, config.AppPrefix)
+	if err != nil {
+		log.Fatal().Err(err).Msg("could not load app assets")
+		os.Exit(1)
+	}
 
 	// Bind routes to controller methods
 
+	api := e.Group(config.APIPrefix)
+
 	// Login&Registration API
-	e.POST("/register", userController.Register)
-	e.POST("/login", userController.Login)
+	api.POST("/setup", setupController.Complete)
+	api.POST("/register", userController.Register)
+	api.POST("/login", userController.Login)
+	api.POST("/login/mfa", userController.LoginMFA)
+	api.POST("/login/magic-link", magicLinkController.Send)
+	api.POST("/login/magic-link/verify", magicLinkController.Login)
+	api.POST("/token/refresh", userController.Refresh)
+	api.POST("/logout", userController.Logout, authController.Authorize())
+
+	// Reset of forgotten passwords
+	api.POST("/password/forgot", passwordController.Forgot)
+	api.POST("/password/reset", passwordController.Reset)
+
+	// Verification of email addresses, from the link sent by email
+	api.GET("/verify-email", userController.VerifyEmail)
+
+	// Login with OpenID Connect identity providers
+	api.GET("/oidc/:provider/login", oidcController.Login)
+	api.GET("/oidc/:provider/callback", oidcController.Callback)
+
+	// Personal access tokens of the authenticated user
+	api.GET("/users/me/tokens", personalTokenController.List, authController.Authorize())
+	api.POST("/users/me/tokens", personalTokenController.Create, authController.Authorize())
+	api.DELETE("/users/me/tokens/:id", personalTokenController.Revoke, authController.Authorize())
+
+	// Sessions and login history of the authenticated user
+	api.GET("/users/me/sessions", sessionController.List, authController.Authorize())
+	api.DELETE("/users/me/sessions/:id", sessionController.Revoke, authController.Authorize())
+	api.GET("/users/me/logins", sessionController.History, authController.Authorize())
+
+	// Two-factor authentication of the authenticated user
+	api.POST("/users/me/mfa", mfaController.Enroll, authController.Authorize())
+	api.POST("/users/me/mfa/confirm", mfaController.Confirm, authController.Authorize())
+	api.POST("/users/me/mfa/disable", mfaController.Disable, authController.Authorize())
 
-	// Blog post API
-	e.POST("/posts", blogController.Create, authController.Authorize)
-	e.GET("/posts", blogController.Find)
-	e.GET("/posts/:id", blogController.Read)
-	e.PUT("/posts/:id", blogController.Update, authController.Authorize)
-	e.DELETE("/posts/:id", blogController.Delete, authController.Authorize)
+	// User management API
+	api.GET("/users", userController.List, authController.Authorize(model.ScopeUsersAdmin))
+	api.GET("/users/me", userController.Me, authController.Authorize())
+	api.PATCH("/users/me", userController.UpdateMe, authController.Authorize())
+	api.PUT("/users/me/password", userController.ChangePassword, authController.Authorize())
+	api.POST("/users/me/verify-email", userController.ResendVerification, authController.Authorize())
+	api.PUT("/users/:id/role", userController.SetRole, authController.Authorize(model.ScopeUsersAdmin))
+	api.POST("/users/:id/approve", userController.Approve, authController.Authorize(model.ScopeUsersAdmin))
+	api.POST("/users/:id/deactivate", userController.Deactivate, authController.Authorize(model.ScopeUsersAdmin))
+	api.DELETE("/users/:id/lockout", userController.Unlock, authController.Authorize(model.ScopeUsersAdmin))
+	api.GET("/users/:id/logins", sessionController.UserHistory, authController.Authorize(model.ScopeUsersAdmin))
+
+	// Impersonation of users by admins, and its audit trail
+	api.POST("/users/:id/impersonate", impersonationController.Start, authController.Authorize(model.ScopeUsersAdmin))
+	api.GET("/users/:id/audit", auditController.UserList, authController.Authorize(model.ScopeUsersAdmin))
+	api.GET("/audit", auditController.List, authController.Authorize(model.ScopeUsersAdmin))
+
+	// Invitations
+	api.POST("/invitations", invitationController.Create, authController.Authorize(model.ScopeUsersAdmin))
+	api.GET("/invitations", invitationController.List, authController.Authorize(model.ScopeUsersAdmin))
+	api.DELETE("/invitations/:id", invitationController.Revoke, authController.Authorize(model.ScopeUsersAdmin))
+	api.POST("/invitations/accept", invitationController.Accept)
+
+	// Blog post API, in which authors can only edit or delete their own blog posts
+	api.POST("/posts", blogController.Create, authController.Authorize(model.ScopePostsWrite))
+	api.GET("/posts", blogController.Find)
+	api.GET("/posts/:id", blogController.Read)
+	api.PUT("/posts/:id", blogController.Update, authController.Authorize(model.ScopePostsWrite))
+	api.DELETE("/posts/:id", blogController.Delete, authController.Authorize(model.ScopePostsDelete))
+
+	// Media API
+	api.POST("/media", mediaController.Upload, authController.Authorize(model.ScopePostsWrite))
+	api.GET("/media/:id", mediaController.Read)
+	api.GET("/media/:id/content", mediaController.Content)
+	api.GET("/media/:id/w/:width", mediaController.Variant)
+
+	// Keys with which clients can verify access tokens
+	e.GET("/.well-known/jwks.json", keysController.JWKS)
+
+	// Web app, which handles its own routing for the paths that aren't assets
+	e.GET(config.AppPrefix, assetsController.Serve)
+	e.GET(config.AppPrefix+"/*", assetsController.Serve)
+
+	// Public blog pages
+	e.GET("/", frontendController.Index)
+	e.GET("/page/:n", frontendController.Page)
+	e.GET("/p/:id", frontendController.Post)
+	if path := th.StaticPath(); path != "" {
+		e.Static("/"+theme.StaticDir, path)
+	}
 
 	// Graceful enables graceful shutdown of the HTTP server
 	e.Server.Addr = fmt.Sprintf("%v:%v", config.ServerAddress, config.ServerPort)
@@ -113,12 +311,162 @@ func main() {
 	log.Info().Msg("bloggo is shutting down")
 
 	server.Stop(15 * time.Second)
+	mediaProcessor.Close()
+	passwordResetService.Wait()
 
 	log.Info().Msg("bloggo shutdown complete")
 
 	os.Exit(0)
 }
 
+// newHasher returns the hasher of passwords. Passwords are hashed with the configured algorithm,
+// and hashes made with other algorithms or settings are upgraded when users log in.
+func newHasher(config Config) *service.MultiHasher {
+	return service.NewMultiHasher(
+		service.NewBcryptHasher(config.BcryptRuns),
+		service.NewArgon2Hasher(config.Argon2Memory, config.Argon2Time, uint8(config.Argon2Threads)),
+		config.PasswordAlgorithm == "argon2id",
+	)
+}
+
+// loadSetupToken returns the token with which the first admin can be created, or an empty string if
+// there is already one. The token is read from the setup token file if there is one, or generated
+// and logged otherwise.
+func loadSetupToken(log *zerolog.Logger, config Config, users service.AdminRepository) (string, error) {
+	if users.AdminExists() {
+		return "", nil
+	}
+
+	if config.SetupTokenFile != "" {
+		content, err := ioutil.ReadFile(config.SetupTokenFile)
+		if err != nil {
+			return "", errors.Wrap(err, "could not read setup token file")
+		}
+
+		token := strings.TrimSpace(string(content))
+		if len(token) < 16 {
+			return "", errors.New("setup token must be at least 16 characters long")
+		}
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate setup token")
+	}
+	token := hex.EncodeToString(b)
+
+	log.Warn().Str("setup_token", token).Msgf("no admin account exists yet, create it with POST %s/setup and this setup token, or with the create-admin command", config.APIPrefix)
+	return token, nil
+}
+
+// loadKeySet loads the keys that sign access tokens. If there is none yet, an
+// EdDSA key is generated so that a new instance of Bloggo works out of the box.
+func loadKeySet(log *zerolog.Logger, config Config) (*keys.KeySet, error) {
+	keySet, err := keys.Load(log, config.JWTKeyDir, config.JWTKeyOverlap)
+	if errors.Cause(err) != keys.ErrNoKey {
+		return keySet, err
+	}
+
+	kid, err := keys.Generate(config.JWTKeyDir, keys.SigningMethodEdDSA.Alg())
+	if err != nil {
+		return nil, err
+	}
+	log.Warn().Str("kid", kid).Str("dir", config.JWTKeyDir).Msg("no signing key found, generated one")
+
+	return keys.Load(log, config.JWTKeyDir, config.JWTKeyOverlap)
+}
+
+// newIdentityProviders creates the OpenID Connect identity providers with which users can log in,
+// indexed by name. Providers send users back to a callback URL that contains their name.
+func newIdentityProviders(config Config) (map[string]service.IdentityProvider, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	providers := make(map[string]service.IdentityProvider)
+	for _, p := range config.OIDCProviders {
+		provider, err := oidc.NewProvider(oidc.Config{
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			RedirectURL:  strings.TrimSuffix(config.SiteURL, "/") + config.APIPrefix + "/oidc/" + p.Name + "/callback",
+			Scopes:       p.Scopes,
+		}, client)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid identity provider %s", p.Name)
+		}
+		providers[p.Name] = provider
+	}
+
+	return providers, nil
+}
+
+// connectDatabase opens the connection to the database
+// Retries until it is successful or the retryDuration is over
+func connectDatabase(log *zerolog.Logger, config Config) (*gorm.DB, error) {
+	var db *gorm.DB
+	var err error
+
+	startTime := time.Now()
+	err = try(log, config.MySQLRetryInterval, func() error {
+		db, err = gorm.Open("mysql", config.MySQLURL)
+		return err
+	}, func() bool {
+		return time.Since(startTime) < config.MySQLRetryDuration
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msg("connection to mysql successful")
+	return db, nil
+}
+
+// newBlobStore creates the blob store in which the content of media is kept
+func newBlobStore(config Config) (storage.BlobStore, error) {
+	if config.StorageBackend != "s3" {
+		return storage.NewLocal(config.StorageDir), nil
+	}
+
+	return storage.NewS3(storage.S3Config{
+		Endpoint:  config.S3Endpoint,
+		Region:    config.S3Region,
+		Bucket:    config.S3Bucket,
+		AccessKey: config.S3AccessKey,
+		SecretKey: config.S3SecretKey,
+		PathStyle: config.S3PathStyle,
+	}, &http.Client{Timeout: time.Minute})
+}
+
+// newMailer creates the mailer with which emails are sent to users
+func newMailer(config Config) mailer.Mailer {
+	if config.MailerBackend != "smtp" {
+		return mailer.NewOutbox(config.MailerOutboxDir, config.MailFrom)
+	}
+
+	return mailer.NewSMTP(mailer.SMTPConfig{
+		Host:     config.SMTPHost,
+		Port:     config.SMTPPort,
+		Username: config.SMTPUsername,
+		Password: config.SMTPPassword,
+		From:     config.MailFrom,
+	})
+}
+
+// parseTrustedProxies returns the networks of the proxies from which the address of clients
+// is read in the X-Forwarded-For and X-Real-IP headers
+func parseTrustedProxies(config Config) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range config.TrustedProxies {
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse trusted proxy %q", proxy)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
 // try tries to execute a given function
 // if it fails, it will keep retrying until the given shouldRetry function returns false
 func try(logger *zerolog.Logger, retryDelay time.Duration, fn func() error, shouldRetry func() bool) error {
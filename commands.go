@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Ullaakut/Bloggo/keys"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+	"github.com/Ullaakut/Bloggo/service"
+	"github.com/Ullaakut/Bloggo/site"
+	"github.com/Ullaakut/Bloggo/theme"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// runCommand runs one of bloggo's command line commands
+func runCommand(log *zerolog.Logger, config Config, command string, args []string) error {
+	switch command {
+	case "build":
+		return build(log, config, args)
+	case "generate-key":
+		return generateKey(log, config, args)
+	case "create-admin":
+		return createAdmin(log, config, args)
+	default:
+		return errors.Errorf("unknown command %q", command)
+	}
+}
+
+// build renders every blog post as a static website
+func build(log *zerolog.Logger, config Config, args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	out := flags.String("out", "./public", "directory in which the site is rendered")
+	themeDir := flags.String("theme", config.ThemeDir, "directory containing the theme's templates")
+	baseURL := flags.String("base-url", config.SiteURL, "URL at which the site will be served")
+	pageSize := flags.Int("page-size", config.PageSize, "number of posts per index page")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	th, err := theme.Load(*themeDir)
+	if err != nil {
+		return errors.Wrap(err, "could not load theme")
+	}
+
+	db, err := connectDatabase(log, config)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize mysql connection")
+	}
+	defer db.Close()
+
+	generator := site.NewGenerator(log, repo.NewBlogPostRepositoryMySQL(log, db), th)
+	_, err = generator.Build(site.Options{
+		OutputDir: *out,
+		PageSize:  *pageSize,
+		Site: theme.Site{
+			Title:       config.SiteTitle,
+			Description: config.SiteDescription,
+			BaseURL:     *baseURL,
+		},
+	})
+	return err
+}
+
+// generateKey generates a new key to sign access tokens with
+func generateKey(log *zerolog.Logger, config Config, args []string) error {
+	flags := flag.NewFlagSet("generate-key", flag.ContinueOnError)
+	dir := flags.String("dir", config.JWTKeyDir, "directory in which the key is written")
+	algorithm := flags.String("alg", keys.SigningMethodEdDSA.Alg(), "algorithm of the key, either RS256 or EdDSA")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	kid, err := keys.Generate(*dir, *algorithm)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("kid", kid).Str("alg", *algorithm).Str("dir", *dir).Msg("key generated")
+	return nil
+}
+
+// createAdmin creates the first admin of the blog. Its password is read from the standard
+// input, so that it doesn't show up in the shell history or the list of processes.
+func createAdmin(log *zerolog.Logger, config Config, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the admin")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "could not read password from standard input")
+	}
+
+	user := model.User{
+		Email:    *email,
+		Password: strings.TrimRight(password, "\r\n"),
+	}
+
+	validate := v.New()
+	err = validate.Struct(user)
+	if err != nil {
+		return err
+	}
+
+	db, err := connectDatabase(log, config)
+	if err != nil {
+		return errors.Wrap(err, "could not initialize mysql connection")
+	}
+	defer db.Close()
+
+	setup := service.NewSetup(log, repo.NewUserRepositoryMySQL(log, db), newHasher(config), "")
+	_, err = setup.CreateAdmin(&user)
+	return err
+}
@@ -1,26 +1,113 @@
 package main
 
 import (
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/spf13/viper"
 	v "gopkg.in/go-playground/validator.v9"
 )
 
+// OIDCProvider configures an OpenID Connect identity provider with which users can log in
+type OIDCProvider struct {
+	Name         string   `json:"name" validate:"required,alphanum,ne=bloggo"`
+	Issuer       string   `json:"issuer" validate:"required,url"`
+	ClientID     string   `json:"client_id" validate:"required"`
+	ClientSecret string   `json:"-"`
+	Scopes       []string `json:"scopes"`
+}
+
 // Config represents the Bloggo configuration
 type Config struct {
 	LogLevel      string `json:"log_level" validate:"required,eq=DEBUG|eq=INFO|eq=WARNING|eq=ERROR|eq=FATAL"`
 	ServerAddress string `json:"server_address" validate:"required"`
 	ServerPort    uint   `json:"server_port" validate:"required,min=1,max=65535"`
+	APIPrefix     string `json:"api_prefix" validate:"required"`
+	AppPrefix     string `json:"app_prefix" validate:"required"`
+
+	TrustedProxies []string `json:"trusted_proxies" validate:"dive,cidr"`
 
 	MySQLURL           string        `json:"mysql_url"`
 	MySQLRetryInterval time.Duration `json:"mysql_retry_interval"`
 	MySQLRetryDuration time.Duration `json:"mysql_retry_duration"`
 
-	BcryptRuns int `json:"bcrypt_runs" validate:"min=4,max=31"`
+	PasswordAlgorithm string `json:"password_algorithm" validate:"required,eq=argon2id|eq=bcrypt"`
+	BcryptRuns        int    `json:"bcrypt_runs" validate:"min=4,max=31"`
+	Argon2Memory      uint32 `json:"argon2_memory" validate:"min=8"`
+	Argon2Time        uint32 `json:"argon2_time" validate:"min=1"`
+	Argon2Threads     uint   `json:"argon2_threads" validate:"min=1,max=255"`
+
+	SetupTokenFile string `json:"setup_token_file"`
+
+	RegistrationMode     string   `json:"registration_mode" validate:"required,eq=open|eq=closed|eq=invite_only|eq=domain_allowlist"`
+	RegistrationDomains  []string `json:"registration_domains" validate:"dive,hostname"`
+	RegistrationApproval bool     `json:"registration_approval"`
+
+	LoginMaxFailures      int           `json:"login_max_failures" validate:"min=1"`
+	LoginMaxFailuresPerIP int           `json:"login_max_failures_per_ip" validate:"min=1"`
+	LoginBackoff          time.Duration `json:"login_backoff" validate:"min=1"`
+	LoginLockout          time.Duration `json:"login_lockout" validate:"min=1"`
+
+	AccessTokenTTL     time.Duration `json:"access_token_ttl" validate:"min=1"`
+	RefreshTokenTTL    time.Duration `json:"refresh_token_ttl" validate:"min=1"`
+	RevocationCacheTTL time.Duration `json:"revocation_cache_ttl"`
+	InvitationTTL      time.Duration `json:"invitation_ttl" validate:"min=1"`
+	PasswordResetTTL   time.Duration `json:"password_reset_ttl" validate:"min=1"`
+	PasswordResetURL   string        `json:"password_reset_url" validate:"omitempty,url"`
+
+	PasswordResetMaxPerHour int `json:"password_reset_max_per_hour" validate:"min=1"`
+
+	EmailVerificationRequired bool          `json:"email_verification_required"`
+	EmailVerificationTTL      time.Duration `json:"email_verification_ttl" validate:"min=1"`
+
+	MagicLinkTTL        time.Duration `json:"magic_link_ttl" validate:"min=1"`
+	MagicLinkURL        string        `json:"magic_link_url" validate:"omitempty,url"`
+	MagicLinkMaxPerHour int           `json:"magic_link_max_per_hour" validate:"min=1"`
+
+	MFALoginTTL          time.Duration `json:"mfa_login_ttl" validate:"min=1"`
+	MFARequiredForAdmins bool          `json:"mfa_required_for_admins"`
+
+	ImpersonationTTL time.Duration `json:"impersonation_ttl" validate:"min=1"`
+
+	MailerBackend   string `json:"mailer_backend" validate:"required,eq=outbox|eq=smtp"`
+	MailerOutboxDir string `json:"mailer_outbox_dir"`
+	MailFrom        string `json:"mail_from" validate:"required"`
+	SMTPHost        string `json:"smtp_host"`
+	SMTPPort        uint   `json:"smtp_port" validate:"min=1,max=65535"`
+	SMTPUsername    string `json:"smtp_username"`
+	SMTPPassword    string `json:"smtp_password"`
 
-	JWTSecret string `validate:"required,min=1"`
+	JWTKeyDir     string        `json:"jwt_key_dir" validate:"required"`
+	JWTKeyOverlap time.Duration `json:"jwt_key_overlap"`
+	JWTIssuer     string        `json:"jwt_issuer" validate:"required"`
+	JWTAudience   string        `json:"jwt_audience" validate:"required"`
+
+	OIDCProviders []OIDCProvider `json:"oidc_providers" validate:"dive"`
+	OIDCLoginTTL  time.Duration  `json:"oidc_login_ttl" validate:"min=1"`
+
+	SiteTitle       string `json:"site_title" validate:"required"`
+	SiteDescription string `json:"site_description"`
+	SiteURL         string `json:"site_url" validate:"required,url"`
+	ThemeDir        string `json:"theme_dir"`
+	PageSize        int    `json:"page_size" validate:"min=1"`
+
+	FrontendCacheMaxAge time.Duration `json:"frontend_cache_max_age"`
+
+	MediaMaxSize       int64  `json:"media_max_size" validate:"min=1"`
+	MediaVariantWidths []int  `json:"media_variant_widths" validate:"dive,min=1"`
+	MediaWorkers       int    `json:"media_workers" validate:"min=1"`
+	MediaQueueSize     int    `json:"media_queue_size" validate:"min=1"`
+	StorageBackend     string `json:"storage_backend" validate:"required,eq=local|eq=s3"`
+	StorageDir         string `json:"storage_dir"`
+	S3Endpoint         string `json:"s3_endpoint" validate:"omitempty,url"`
+	S3Region           string `json:"s3_region"`
+	S3Bucket           string `json:"s3_bucket"`
+	S3AccessKey        string `json:"s3_access_key"`
+	S3SecretKey        string `json:"s3_secret_key"`
+	S3PathStyle        bool   `json:"s3_path_style"`
 }
 
 // Set default values for configuration parameters
@@ -28,10 +115,53 @@ func init() {
 	viper.SetDefault("log_level", "DEBUG")
 	viper.SetDefault("server_address", "0.0.0.0")
 	viper.SetDefault("server_port", 4242)
+	viper.SetDefault("api_prefix", "/api")
+	viper.SetDefault("app_prefix", "/app")
 	viper.SetDefault("mysql_url", "root:root@tcp(db:3306)/bloggo?charset=utf8&parseTime=True&loc=Local")
 	viper.SetDefault("mysql_retry_interval", "2s")
 	viper.SetDefault("mysql_retry_duration", "1m")
+	viper.SetDefault("password_algorithm", "argon2id")
 	viper.SetDefault("bcrypt_runs", 11)
+	viper.SetDefault("argon2_memory", 64*1024)
+	viper.SetDefault("argon2_time", 3)
+	viper.SetDefault("registration_mode", "open")
+	viper.SetDefault("argon2_threads", 2)
+	viper.SetDefault("access_token_ttl", "15m")
+	viper.SetDefault("refresh_token_ttl", "720h")
+	viper.SetDefault("revocation_cache_ttl", "30s")
+	viper.SetDefault("invitation_ttl", "168h")
+	viper.SetDefault("password_reset_ttl", "1h")
+	viper.SetDefault("password_reset_max_per_hour", 5)
+	viper.SetDefault("email_verification_required", false)
+	viper.SetDefault("email_verification_ttl", "48h")
+	viper.SetDefault("magic_link_ttl", "15m")
+	viper.SetDefault("magic_link_max_per_hour", 5)
+	viper.SetDefault("mfa_login_ttl", "5m")
+	viper.SetDefault("mfa_required_for_admins", false)
+	viper.SetDefault("impersonation_ttl", "15m")
+	viper.SetDefault("mailer_backend", "outbox")
+	viper.SetDefault("mailer_outbox_dir", "outbox")
+	viper.SetDefault("mail_from", "bloggo@localhost")
+	viper.SetDefault("smtp_port", 587)
+	viper.SetDefault("jwt_key_dir", "signing-keys")
+	viper.SetDefault("jwt_key_overlap", "1h")
+	viper.SetDefault("login_max_failures", 5)
+	viper.SetDefault("login_max_failures_per_ip", 20)
+	viper.SetDefault("login_backoff", "1s")
+	viper.SetDefault("login_lockout", "15m")
+	viper.SetDefault("jwt_audience", "bloggo")
+	viper.SetDefault("oidc_login_ttl", "10m")
+	viper.SetDefault("site_title", "Bloggo")
+	viper.SetDefault("site_url", "http://localhost/")
+	viper.SetDefault("page_size", 10)
+	viper.SetDefault("frontend_cache_max_age", "5m")
+	viper.SetDefault("media_max_size", 10<<20)
+	viper.SetDefault("media_variant_widths", "320,640,1280")
+	viper.SetDefault("media_workers", 2)
+	viper.SetDefault("media_queue_size", 100)
+	viper.SetDefault("storage_backend", "local")
+	viper.SetDefault("storage_dir", "media")
+	viper.SetDefault("s3_region", "us-east-1")
 }
 
 // GetConfig sets the default values for the configuration and gets it from the environment/command line
@@ -46,17 +176,139 @@ func GetConfig() (Config, error) {
 		return config, err
 	}
 
-	config.JWTSecret = viper.GetString("jwt_secret")
-
 	config.LogLevel = viper.GetString("log_level")
 	config.ServerAddress = viper.GetString("server_address")
 	config.ServerPort = uint(viper.GetInt("server_port"))
-	config.MySQLURL = viper.GetString("mysql_url")
+	config.APIPrefix = strings.TrimSuffix(viper.GetString("api_prefix"), "/")
+	config.AppPrefix = strings.TrimSuffix(viper.GetString("app_prefix"), "/")
 
+	// Single addresses are trusted as networks of one address
+	config.TrustedProxies = nil
+	for _, proxy := range strings.Split(viper.GetString("trusted_proxies"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		switch {
+		case proxy == "":
+			continue
+		case strings.Contains(proxy, "/"):
+		case strings.Contains(proxy, ":"):
+			proxy += "/128"
+		default:
+			proxy += "/32"
+		}
+		config.TrustedProxies = append(config.TrustedProxies, proxy)
+	}
+
+	config.MySQLURL = viper.GetString("mysql_url")
 	config.MySQLRetryInterval = viper.GetDuration("mysql_retry_interval")
 	config.MySQLRetryDuration = viper.GetDuration("mysql_retry_duration")
 
+	config.PasswordAlgorithm = viper.GetString("password_algorithm")
 	config.BcryptRuns = viper.GetInt("bcrypt_runs")
+	config.Argon2Memory = viper.GetUint32("argon2_memory")
+	config.Argon2Time = viper.GetUint32("argon2_time")
+	config.Argon2Threads = viper.GetUint("argon2_threads")
+
+	config.SetupTokenFile = viper.GetString("setup_token_file")
+
+	config.RegistrationMode = viper.GetString("registration_mode")
+	config.RegistrationDomains = nil
+	for _, domain := range strings.Split(viper.GetString("registration_domains"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			config.RegistrationDomains = append(config.RegistrationDomains, strings.ToLower(domain))
+		}
+	}
+	config.RegistrationApproval = viper.GetBool("registration_approval")
+
+	config.LoginMaxFailures = viper.GetInt("login_max_failures")
+	config.LoginMaxFailuresPerIP = viper.GetInt("login_max_failures_per_ip")
+	config.LoginBackoff = viper.GetDuration("login_backoff")
+	config.LoginLockout = viper.GetDuration("login_lockout")
+
+	config.AccessTokenTTL = viper.GetDuration("access_token_ttl")
+	config.RefreshTokenTTL = viper.GetDuration("refresh_token_ttl")
+	config.RevocationCacheTTL = viper.GetDuration("revocation_cache_ttl")
+	config.InvitationTTL = viper.GetDuration("invitation_ttl")
+	config.PasswordResetTTL = viper.GetDuration("password_reset_ttl")
+	config.PasswordResetURL = viper.GetString("password_reset_url")
+	config.PasswordResetMaxPerHour = viper.GetInt("password_reset_max_per_hour")
+	config.EmailVerificationRequired = viper.GetBool("email_verification_required")
+	config.EmailVerificationTTL = viper.GetDuration("email_verification_ttl")
+	config.MagicLinkTTL = viper.GetDuration("magic_link_ttl")
+	config.MagicLinkURL = viper.GetString("magic_link_url")
+	config.MagicLinkMaxPerHour = viper.GetInt("magic_link_max_per_hour")
+	config.MFALoginTTL = viper.GetDuration("mfa_login_ttl")
+	config.MFARequiredForAdmins = viper.GetBool("mfa_required_for_admins")
+	config.ImpersonationTTL = viper.GetDuration("impersonation_ttl")
+
+	config.MailerBackend = viper.GetString("mailer_backend")
+	config.MailerOutboxDir = viper.GetString("mailer_outbox_dir")
+	config.MailFrom = viper.GetString("mail_from")
+	config.SMTPHost = viper.GetString("smtp_host")
+	config.SMTPPort = uint(viper.GetInt("smtp_port"))
+	config.SMTPUsername = viper.GetString("smtp_username")
+	config.SMTPPassword = viper.GetString("smtp_password")
+
+	config.JWTKeyDir = viper.GetString("jwt_key_dir")
+	config.JWTKeyOverlap = viper.GetDuration("jwt_key_overlap")
+	config.JWTAudience = viper.GetString("jwt_audience")
+
+	// Each identity provider is configured by variables that contain its name
+	config.OIDCProviders = nil
+	for _, name := range strings.Split(viper.GetString("oidc_providers"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		scopes := strings.Fields(viper.GetString("oidc_" + name + "_scopes"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email"}
+		}
+
+		config.OIDCProviders = append(config.OIDCProviders, OIDCProvider{
+			Name:         name,
+			Issuer:       viper.GetString("oidc_" + name + "_issuer"),
+			ClientID:     viper.GetString("oidc_" + name + "_client_id"),
+			ClientSecret: viper.GetString("oidc_" + name + "_client_secret"),
+			Scopes:       scopes,
+		})
+	}
+	config.OIDCLoginTTL = viper.GetDuration("oidc_login_ttl")
+
+	config.SiteTitle = viper.GetString("site_title")
+	config.SiteDescription = viper.GetString("site_description")
+	config.SiteURL = viper.GetString("site_url")
+	config.ThemeDir = viper.GetString("theme_dir")
+	config.PageSize = viper.GetInt("page_size")
+
+	config.FrontendCacheMaxAge = viper.GetDuration("frontend_cache_max_age")
+
+	config.MediaMaxSize = viper.GetInt64("media_max_size")
+	config.MediaWorkers = viper.GetInt("media_workers")
+	config.MediaQueueSize = viper.GetInt("media_queue_size")
+
+	config.MediaVariantWidths = nil
+	for _, w := range strings.Split(viper.GetString("media_variant_widths"), ",") {
+		width, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil {
+			return config, errors.Wrapf(err, "invalid media variant width %q", w)
+		}
+		config.MediaVariantWidths = append(config.MediaVariantWidths, width)
+	}
+	config.StorageBackend = viper.GetString("storage_backend")
+	config.StorageDir = viper.GetString("storage_dir")
+	config.S3Endpoint = viper.GetString("s3_endpoint")
+	config.S3Region = viper.GetString("s3_region")
+	config.S3Bucket = viper.GetString("s3_bucket")
+	config.S3AccessKey = viper.GetString("s3_access_key")
+	config.S3SecretKey = viper.GetString("s3_secret_key")
+	config.S3PathStyle = viper.GetBool("s3_path_style")
+
+	// Tokens are issued by the blog unless configured otherwise
+	config.JWTIssuer = viper.GetString("jwt_issuer")
+	if config.JWTIssuer == "" {
+		config.JWTIssuer = config.SiteURL
+	}
 
 	validate := v.New()
 	err = validate.Struct(config)
@@ -64,18 +316,101 @@ func GetConfig() (Config, error) {
 		return config, err
 	}
 
+	if !strings.HasPrefix(config.APIPrefix, "/") || !strings.HasPrefix(config.AppPrefix, "/") {
+		return config, errors.New("api_prefix and app_prefix must start with a /")
+	}
+
+	if config.JWTKeyOverlap < config.AccessTokenTTL {
+		return config, errors.New("jwt_key_overlap must be longer than access_token_ttl, or rotating keys would invalidate access tokens")
+	}
+
+	if config.RegistrationMode == "domain_allowlist" && len(config.RegistrationDomains) == 0 {
+		return config, errors.New("registration_domains is required by the domain_allowlist registration mode")
+	}
+
+	if config.MailerBackend == "smtp" && config.SMTPHost == "" {
+		return config, errors.New("smtp_host is required by the smtp mailer backend")
+	}
+
+	if config.StorageBackend == "s3" && (config.S3Endpoint == "" || config.S3Bucket == "") {
+		return config, errors.New("s3_endpoint and s3_bucket are required by the s3 storage backend")
+	}
+
 	return config, nil
 }
 
 // Print prints the current configuration
 func (c Config) Print(log *zerolog.Logger) {
+	var providers []string
+	for _, provider := range c.OIDCProviders {
+		providers = append(providers, provider.Name)
+	}
+
 	log.Debug().
 		Str("log_level", c.LogLevel).
 		Str("server_address", c.ServerAddress).
 		Uint("server_port", c.ServerPort).
+		Str("api_prefix", c.APIPrefix).
+		Str("app_prefix", c.AppPrefix).
+		Strs("trusted_proxies", c.TrustedProxies).
 		Str("mysql_url", c.MySQLURL).
 		Dur("mysql_retry_interval", c.MySQLRetryInterval).
 		Dur("mysql_retry_duration", c.MySQLRetryDuration).
+		Str("password_algorithm", c.PasswordAlgorithm).
 		Int("bcrypt_runs", c.BcryptRuns).
+		Uint32("argon2_memory", c.Argon2Memory).
+		Uint32("argon2_time", c.Argon2Time).
+		Uint("argon2_threads", c.Argon2Threads).
+		Str("setup_token_file", c.SetupTokenFile).
+		Str("registration_mode", c.RegistrationMode).
+		Strs("registration_domains", c.RegistrationDomains).
+		Bool("registration_approval", c.RegistrationApproval).
+		Int("login_max_failures", c.LoginMaxFailures).
+		Int("login_max_failures_per_ip", c.LoginMaxFailuresPerIP).
+		Dur("login_backoff", c.LoginBackoff).
+		Dur("login_lockout", c.LoginLockout).
+		Dur("access_token_ttl", c.AccessTokenTTL).
+		Dur("refresh_token_ttl", c.RefreshTokenTTL).
+		Dur("revocation_cache_ttl", c.RevocationCacheTTL).
+		Dur("invitation_ttl", c.InvitationTTL).
+		Dur("password_reset_ttl", c.PasswordResetTTL).
+		Str("password_reset_url", c.PasswordResetURL).
+		Int("password_reset_max_per_hour", c.PasswordResetMaxPerHour).
+		Bool("email_verification_required", c.EmailVerificationRequired).
+		Dur("email_verification_ttl", c.EmailVerificationTTL).
+		Dur("magic_link_ttl", c.MagicLinkTTL).
+		Str("magic_link_url", c.MagicLinkURL).
+		Int("magic_link_max_per_hour", c.MagicLinkMaxPerHour).
+		Dur("mfa_login_ttl", c.MFALoginTTL).
+		Bool("mfa_required_for_admins", c.MFARequiredForAdmins).
+		Dur("impersonation_ttl", c.ImpersonationTTL).
+		Str("mailer_backend", c.MailerBackend).
+		Str("mailer_outbox_dir", c.MailerOutboxDir).
+		Str("mail_from", c.MailFrom).
+		Str("smtp_host", c.SMTPHost).
+		Uint("smtp_port", c.SMTPPort).
+		Str("smtp_username", c.SMTPUsername).
+		Str("jwt_key_dir", c.JWTKeyDir).
+		Dur("jwt_key_overlap", c.JWTKeyOverlap).
+		Str("jwt_issuer", c.JWTIssuer).
+		Str("jwt_audience", c.JWTAudience).
+		Strs("oidc_providers", providers).
+		Dur("oidc_login_ttl", c.OIDCLoginTTL).
+		Str("site_title", c.SiteTitle).
+		Str("site_description", c.SiteDescription).
+		Str("site_url", c.SiteURL).
+		Str("theme_dir", c.ThemeDir).
+		Int("page_size", c.PageSize).
+		Dur("frontend_cache_max_age", c.FrontendCacheMaxAge).
+		Int64("media_max_size", c.MediaMaxSize).
+		Ints("media_variant_widths", c.MediaVariantWidths).
+		Int("media_workers", c.MediaWorkers).
+		Int("media_queue_size", c.MediaQueueSize).
+		Str("storage_backend", c.StorageBackend).
+		Str("storage_dir", c.StorageDir).
+		Str("s3_endpoint", c.S3Endpoint).
+		Str("s3_region", c.S3Region).
+		Str("s3_bucket", c.S3Bucket).
+		Bool("s3_path_style", c.S3PathStyle).
 		Msg("configuration")
 }
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Cache-Control values for the assets. Fingerprinted assets never change,
+// since a new version of the file gets a new name.
+const (
+	cacheImmutable   = "public, max-age=31536000, immutable"
+	cacheRevalidate  = "no-cache"
+	indexHTML        = "index.html"
+	fingerprintBytes = 6
+)
+
+// mimeTypes overrides the MIME types of the extensions that are not known
+// by the mime package on every platform
+var mimeTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".js":    "application/javascript",
+	".svg":   "image/svg+xml",
+	".ttf":   "font/ttf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+}
+
+type asset struct {
+	content     []byte
+	gzipped     []byte
+	contentType string
+	etag        string
+	cache       string
+}
+
+// Assets is a controller that serves the files of a single page app
+type Assets struct {
+	prefix string
+	assets map[string]*asset
+
+	log *zerolog.Logger
+}
+
+// NewAssets creates an Assets controller that serves the given files under the given
+// prefix. Every asset is also served under a fingerprinted name that can be cached
+// forever, and references to assets in HTML files are rewritten to use it.
+func NewAssets(log *zerolog.Logger, files fs.FS, prefix string) (*Assets, error) {
+	a := &Assets{
+		prefix: strings.TrimSuffix(prefix, "/"),
+		assets: make(map[string]*asset),
+
+		log: log,
+	}
+
+	var pages []string
+	fingerprinted := make(map[string]string)
+	err := fs.WalkDir(files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+
+		// HTML pages are processed last, once the fingerprints are known
+		if path.Ext(name) == ".html" {
+			pages = append(pages, name)
+			return nil
+		}
+
+		a.add(name, content, cacheRevalidate)
+
+		sum := sha256.Sum256(content)
+		ext := path.Ext(name)
+		fingerprinted[name] = strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:fingerprintBytes]) + ext
+		a.add(fingerprinted[name], content, cacheImmutable)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read app assets")
+	}
+
+	for _, name := range pages {
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s", name)
+		}
+
+		// Rewrite the references to other assets so that they use absolute
+		// fingerprinted URLs, which also work from the fallback routes
+		for original, renamed := range fingerprinted {
+			content = bytes.Replace(content, []byte(`="`+original+`"`), []byte(`="`+a.prefix+"/"+renamed+`"`), -1)
+		}
+
+		a.add(name, content, cacheRevalidate)
+	}
+
+	if _, ok := a.assets[indexHTML]; !ok {
+		return nil, errors.New("app assets do not contain an index.html file")
+	}
+
+	return a, nil
+}
+
+// add registers an asset and precompresses it if it is worth it
+func (a *Assets) add(name string, content []byte, cache string) {
+	contentType, ok := mimeTypes[path.Ext(name)]
+	if !ok {
+		contentType = mime.TypeByExtension(path.Ext(name))
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+
+	sum := sha256.Sum256(content)
+	res := &asset{
+		content:     content,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		cache:       cache,
+	}
+
+	if compressible(contentType) {
+		buf := &bytes.Buffer{}
+		w, _ := gzip.NewWriterLevel(buf, gzip.BestCompression)
+		_, err := w.Write(content)
+		if err == nil && w.Close() == nil && buf.Len() < len(content) {
+			res.gzipped = buf.Bytes()
+		}
+	}
+
+	a.assets[name] = res
+}
+
+// Serve serves the asset at the requested path. Requests to paths that are
+// not assets are routed by the app itself, so they get its index page.
+func (a *Assets) Serve(ctx echo.Context) error {
+	name := strings.TrimPrefix(path.Clean("/"+ctx.Param("*")), "/")
+	if name == "" {
+		name = indexHTML
+	}
+
+	res, ok := a.assets[name]
+	if !ok {
+		// Missing files are not routes of the app
+		if path.Ext(name) != "" {
+			return echo.NewHTTPError(http.StatusNotFound, "asset not found")
+		}
+		res = a.assets[indexHTML]
+	}
+
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderContentType, res.contentType)
+	header.Set("Cache-Control", res.cache)
+	header.Set("ETag", res.etag)
+	if res.gzipped != nil {
+		header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+	}
+
+	if notModified(ctx.Request(), res.etag, time.Time{}) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	body := res.content
+	if res.gzipped != nil && strings.Contains(ctx.Request().Header.Get(echo.HeaderAcceptEncoding), "gzip") {
+		header.Set(echo.HeaderContentEncoding, "gzip")
+		body = res.gzipped
+	}
+
+	return ctx.Blob(http.StatusOK, res.contentType, body)
+}
+
+// compressible returns true for the content types that benefit from gzip compression
+func compressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") ||
+		strings.HasPrefix(contentType, "application/javascript") ||
+		strings.HasPrefix(contentType, "application/json") ||
+		strings.HasPrefix(contentType, "image/svg+xml") ||
+		strings.HasPrefix(contentType, "font/ttf")
+}
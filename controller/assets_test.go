@@ -0,0 +1,191 @@
+package controller
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/Ullaakut/Bloggo/logger"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStyle = strings.Repeat("body { margin: 0; }\n", 50)
+
+func newTestAssets(t *testing.T) *Assets {
+	files := fstest.MapFS{
+		"index.html":       {Data: []byte(`<link rel="stylesheet" href="grid.css"><script src="app.js"></script>`)},
+		"grid.css":         {Data: []byte(testStyle)},
+		"app.js":           {Data: []byte(`console.log("bloggo")`)},
+		"fonts/roboto.ttf": {Data: []byte{0x00, 0x01, 0x00, 0x00}},
+	}
+
+	logsBuff := &bytes.Buffer{}
+	a, err := NewAssets(logger.NewZeroLog(logsBuff), files, "/app")
+	if err != nil {
+		t.Fatalf("could not load assets: %v", err)
+	}
+	return a
+}
+
+func serveAsset(a *Assets, name string, headers map[string]string) (*httptest.ResponseRecorder, error) {
+	e := echo.New()
+	r := httptest.NewRequest(echo.GET, "/app/"+name, nil)
+	for key, value := range headers {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	ctx := e.NewContext(r, w)
+	ctx.SetParamNames("*")
+	ctx.SetParamValues(name)
+
+	return w, a.Serve(ctx)
+}
+
+func TestNewAssets(t *testing.T) {
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	a, err := NewAssets(log, fstest.MapFS{"index.html": {Data: []byte("<html></html>")}}, "/app/")
+
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "/app", a.prefix, "unexpected prefix set")
+	assert.Equal(t, log, a.log, "unexpected logger set")
+
+	_, err = NewAssets(log, fstest.MapFS{"grid.css": {Data: []byte(testStyle)}}, "/app")
+	assert.Error(t, err, "expected an error without index.html")
+}
+
+func TestAssetsServe(t *testing.T) {
+	a := newTestAssets(t)
+
+	w, err := serveAsset(a, "", nil)
+	assert.NoError(t, err, "unexpected error")
+	index := w.Body.String()
+
+	// The index references the fingerprinted assets
+	fingerprinted := regexp.MustCompile(`/app/(grid\.[0-9a-f]{12}\.css)`).FindStringSubmatch(index)
+	if assert.Len(t, fingerprinted, 2, "index.html should reference the fingerprinted style: %s", index) {
+		w, err = serveAsset(a, fingerprinted[1], nil)
+		assert.NoError(t, err, "unexpected error")
+		assert.Equal(t, http.StatusOK, w.Code, "wrong response status")
+		assert.Equal(t, testStyle, w.Body.String(), "wrong response body")
+		assert.Equal(t, cacheImmutable, w.Header().Get("Cache-Control"), "wrong cache control header")
+	}
+	assert.Regexp(t, `src="/app/app\.[0-9a-f]{12}\.js"`, index, "index.html should reference the fingerprinted script")
+
+	tests := []struct {
+		description string
+
+		name    string
+		headers map[string]string
+
+		expectedHTTPCode    int
+		expectedContentType string
+		expectedCache       string
+		expectedEncoding    string
+		expectedBody        string
+	}{
+		{
+			description: "original asset name",
+
+			name: "grid.css",
+
+			expectedHTTPCode:    http.StatusOK,
+			expectedContentType: "text/css; charset=utf-8",
+			expectedCache:       cacheRevalidate,
+			expectedBody:        testStyle,
+		},
+		{
+			description: "gzip compressed asset",
+
+			name:    "grid.css",
+			headers: map[string]string{echo.HeaderAcceptEncoding: "gzip, deflate"},
+
+			expectedHTTPCode:    http.StatusOK,
+			expectedContentType: "text/css; charset=utf-8",
+			expectedCache:       cacheRevalidate,
+			expectedEncoding:    "gzip",
+			expectedBody:        testStyle,
+		},
+		{
+			description: "font in a subdirectory",
+
+			name: "fonts/roboto.ttf",
+
+			expectedHTTPCode:    http.StatusOK,
+			expectedContentType: "font/ttf",
+			expectedCache:       cacheRevalidate,
+			expectedBody:        "\x00\x01\x00\x00",
+		},
+		{
+			description: "route of the app",
+
+			name: "posts/42",
+
+			expectedHTTPCode:    http.StatusOK,
+			expectedContentType: "text/html; charset=utf-8",
+			expectedCache:       cacheRevalidate,
+			expectedBody:        index,
+		},
+		{
+			description: "missing asset",
+
+			name: "missing.js",
+
+			expectedHTTPCode: http.StatusNotFound,
+		},
+		{
+			description: "path traversal",
+
+			name: "../../bloggo.go",
+
+			expectedHTTPCode: http.StatusNotFound,
+		},
+		{
+			description: "cached asset",
+
+			name:    "grid.css",
+			headers: map[string]string{"If-None-Match": a.assets["grid.css"].etag},
+
+			expectedHTTPCode: http.StatusNotModified,
+			expectedCache:    cacheRevalidate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			w, err := serveAsset(a, test.name, test.headers)
+			if err != nil {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				return
+			}
+
+			assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			assert.Equal(t, test.expectedCache, w.Header().Get("Cache-Control"), "wrong cache control header")
+			assert.Equal(t, test.expectedEncoding, w.Header().Get(echo.HeaderContentEncoding), "wrong content encoding")
+			if test.expectedContentType != "" {
+				assert.Equal(t, test.expectedContentType, w.Header().Get(echo.HeaderContentType), "wrong content type")
+			}
+
+			body := w.Body.Bytes()
+			if test.expectedEncoding == "gzip" {
+				reader, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal("could not read compressed response")
+				}
+				body, _ = ioutil.ReadAll(reader)
+			}
+			assert.Equal(t, test.expectedBody, string(body), "wrong response body")
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// AuditService represents a service to read the audit trail of what admins did on behalf of other users
+type AuditService interface {
+	List(offset, limit uint) ([]*model.AuditEvent, uint, error)
+	UserList(id uint, offset, limit uint) ([]*model.AuditEvent, uint, error)
+}
+
+// Audit is a controller that lets admins read the audit trail
+type Audit struct {
+	audit AuditService
+
+	log *zerolog.Logger
+}
+
+// NewAudit creates an Audit controller
+func NewAudit(log *zerolog.Logger, audit AuditService) *Audit {
+	return &Audit{
+		audit: audit,
+
+		log: log,
+	}
+}
+
+// List returns a page of the audit trail, newest first. The total number of
+// events is sent in the X-Total-Count header.
+func (a *Audit) List(ctx echo.Context) error {
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, total, err := a.audit.List(offset, limit)
+	if err != nil {
+		err = errors.Wrap(err, "could not list audit events")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return sendAuditEvents(ctx, events, total)
+}
+
+// UserList returns a page of the audit events of which a user is the actor or subject, from their
+// id, newest first. The total number of events is sent in the X-Total-Count header.
+func (a *Audit) UserList(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, total, err := a.audit.UserList(uint(id), offset, limit)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not list audit events")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return sendAuditEvents(ctx, events, total)
+}
+
+// sendAuditEvents sends a page of audit events along with their total count
+func sendAuditEvents(ctx echo.Context, events []*model.AuditEvent, total uint) error {
+	if events == nil {
+		events = []*model.AuditEvent{}
+	}
+
+	ctx.Response().Header().Set("X-Total-Count", strconv.FormatUint(uint64(total), 10))
+	return ctx.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,286 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type AuditServiceMock struct {
+	mock.Mock
+}
+
+func (m *AuditServiceMock) List(offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	args := m.Called(offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.AuditEvent), args.Get(1).(uint), args.Error(2)
+}
+
+func (m *AuditServiceMock) UserList(id uint, offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	args := m.Called(id, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.AuditEvent), args.Get(1).(uint), args.Error(2)
+}
+
+func TestNewAudit(t *testing.T) {
+	auditServiceMock := &AuditServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	a := NewAudit(log, auditServiceMock)
+
+	assert.Equal(t, auditServiceMock, a.audit, "unexpected audit service set")
+	assert.Equal(t, log, a.log, "unexpected logger set")
+}
+
+func TestListAudit(t *testing.T) {
+	events := []*model.AuditEvent{
+		{ID: 2, ActorID: "admin", SubjectID: "test", Action: model.AuditImpersonatedRequest, Detail: "GET /api/users/me", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", CreatedAt: time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)},
+		{ID: 1, ActorID: "admin", SubjectID: "test", Action: model.AuditImpersonationStarted, Detail: "expires in 15m0s", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", CreatedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		description string
+
+		query string
+
+		expectCall     bool
+		expectedOffset uint
+		expectedLimit  uint
+		events         []*model.AuditEvent
+		total          uint
+		serviceErr     error
+
+		expectedHTTPCode  int
+		expectedHTTPBody  string
+		expectedTotalHead string
+	}{
+		{
+			description: "default page",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			events:        events,
+			total:         2,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[{"id":2,"actor_id":"admin","subject_id":"test","action":"impersonated_request","detail":"GET /api/users/me","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T13:00:00Z"},{"id":1,"actor_id":"admin","subject_id":"test","action":"impersonation_started","detail":"expires in 15m0s","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T12:00:00Z"}]`,
+			expectedTotalHead: "2",
+		},
+		{
+			description: "empty page",
+
+			query: "?offset=40&limit=10",
+
+			expectCall:     true,
+			expectedOffset: 40,
+			expectedLimit:  10,
+			total:          42,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[]`,
+			expectedTotalHead: "42",
+		},
+		{
+			description: "bad request: limit too high",
+
+			query: "?limit=1000",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "limit must be between 1 and 100",
+		},
+		{
+			description: "internal server error: service failure",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not list audit events: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/audit"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			auditServiceMock := &AuditServiceMock{}
+			if test.expectCall {
+				auditServiceMock.
+					On("List", test.expectedOffset, test.expectedLimit).
+					Return(test.events, test.total, test.serviceErr).
+					Once()
+			}
+
+			a := &Audit{
+				audit: auditServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = a.List(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Equal(t, test.expectedTotalHead, w.Header().Get("X-Total-Count"), "wrong total count")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			auditServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListUserAudit(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id    string
+		query string
+
+		expectCall     bool
+		expectedOffset uint
+		expectedLimit  uint
+		events         []*model.AuditEvent
+		total          uint
+		serviceErr     error
+
+		expectedHTTPCode  int
+		expectedHTTPBody  string
+		expectedTotalHead string
+	}{
+		{
+			description: "events listed",
+
+			id:    "42",
+			query: "?limit=1",
+
+			expectCall:    true,
+			expectedLimit: 1,
+			events:        []*model.AuditEvent{{ID: 7, ActorID: "admin", SubjectID: "test", Action: model.AuditImpersonationStarted, CreatedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}},
+			total:         3,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[{"id":7,"actor_id":"admin","subject_id":"test","action":"impersonation_started","ip":"","user_agent":"","created_at":"2026-10-19T12:00:00Z"}]`,
+			expectedTotalHead: "3",
+		},
+		{
+			description: "bad request: invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse user ID",
+		},
+		{
+			description: "not found: user id 0",
+
+			id: "0",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.Wrap(errortype.ErrNotFound, "could not retrieve user id 0"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "could not retrieve user id 0: resource not found",
+		},
+		{
+			description: "not found: unknown user",
+
+			id: "42",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.Wrap(errortype.ErrNotFound, "could not retrieve user id 42"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "could not retrieve user id 42: resource not found",
+		},
+		{
+			description: "internal server error: service failure",
+
+			id: "42",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not list audit events: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/users/:id/audit")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+
+			auditServiceMock := &AuditServiceMock{}
+			if test.expectCall {
+				id, _ := strconv.ParseUint(test.id, 10, 64)
+				auditServiceMock.
+					On("UserList", uint(id), test.expectedOffset, test.expectedLimit).
+					Return(test.events, test.total, test.serviceErr).
+					Once()
+			}
+
+			a := &Audit{
+				audit: auditServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = a.UserList(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Equal(t, test.expectedTotalHead, w.Header().Get("X-Total-Count"), "wrong total count")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			auditServiceMock.AssertExpectations(t)
+		})
+	}
+}
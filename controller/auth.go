@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Ullaakut/Bloggo/model"
+
 	"github.com/labstack/echo"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -12,48 +14,168 @@ import (
 
 // AccessService represents a service to verifying access tokens
 type AccessService interface {
-	ValidateToken(IDToken string) (string, error)
+	ValidateToken(IDToken string) (*model.Principal, error)
+}
+
+// AuditRecorder represents a service that keeps an audit trail
+type AuditRecorder interface {
+	Record(event *model.AuditEvent) error
 }
 
+// impersonatedByHeader flags the responses to requests made with impersonation tokens,
+// with the user ID of the admin who made them
+const impersonatedByHeader = "X-Impersonated-By"
+
 // Auth is a controller that is in charge of authenticating and authorizing requests
 type Auth struct {
-	access AccessService
+	access         AccessService
+	personalTokens AccessService
+	cookies        *CookieAuth
+	audit          AuditRecorder
+
+	// requireVerifiedEmail forbids users whose email address is not verified from writing
+	requireVerifiedEmail bool
+	// requireAdminMFA forbids admins who didn't enable two-factor authentication from using
+	// any route that requires a scope, until they enable it
+	requireAdminMFA bool
 
 	log *zerolog.Logger
 }
 
-// NewAuth creates an Auth controller with the given endpoint
-func NewAuth(log *zerolog.Logger, access AccessService) *Auth {
+// NewAuth creates an Auth controller that verifies JWTs with access, and
+// personal access tokens with personalTokens. Browsers can send their JWT in the cookies
+// set by cookies instead. Requests made with impersonation tokens are recorded in audit.
+// If requireVerifiedEmail is set, routes that require a write scope are forbidden to users
+// who didn't verify their email address. If requireAdminMFA is set, routes that require a
+// scope are forbidden to admins who didn't enable two-factor authentication.
+func NewAuth(log *zerolog.Logger, access, personalTokens AccessService, cookies *CookieAuth, audit AuditRecorder, requireVerifiedEmail, requireAdminMFA bool) *Auth {
 	return &Auth{
-		access: access,
+		access:               access,
+		personalTokens:       personalTokens,
+		cookies:              cookies,
+		audit:                audit,
+		requireVerifiedEmail: requireVerifiedEmail,
+		requireAdminMFA:      requireAdminMFA,
 
 		log: log,
 	}
 }
 
-// Authorize returns the user info for the given access token in the Authorization header
-// It is a middleware, which is why it returns a function that, if successful, calls the
-// function bound to the route.
-func (a *Auth) Authorize(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(ctx echo.Context) error {
-		// Parse Authorization header
-		token, err := parseAuth(ctx.Request().Header.Get("Authorization"))
-		if err != nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprint("could not parse auth header: ", err))
-		}
+// Authorize returns a middleware that authenticates requests using the access token or personal
+// access token in their Authorization header, or the access token in their cookies, and only lets them
+// through if the token was granted all of the given scopes. Requests without a valid token are unauthorized,
+// and those without the scopes forbidden.
+func (a *Auth) Authorize(scopes ...model.Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			token, cookieAuth, err := a.requestToken(ctx)
+			if err != nil {
+				return err
+			}
+
+			// Verify token claims and expiration date
+			validator := a.access
+			if strings.HasPrefix(token, model.PersonalTokenPrefix) {
+				validator = a.personalTokens
+			}
+			principal, err := validator.ValidateToken(token)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprint("could not validate token: ", err))
+			}
 
-		// Verify token claims and expiration date
-		userID, err := a.access.ValidateToken(token)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprint("could not validate token: ", err))
+			if a.requireAdminMFA && len(scopes) > 0 && principal.User.Role == model.RoleAdmin && !principal.User.MFAEnabled {
+				return echo.NewHTTPError(http.StatusForbidden, "two-factor authentication is required for admins")
+			}
+
+			for _, scope := range scopes {
+				if !model.HasScope(principal.Scopes, scope) {
+					a.log.Debug().Str("scope", string(scope)).Msg("missing scope")
+					return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("token does not have the %s scope", scope))
+				}
+
+				if a.requireVerifiedEmail && scope.Writes() && !principal.User.EmailVerified {
+					return echo.NewHTTPError(http.StatusForbidden, "email address is not verified")
+				}
+			}
+
+			// Store the user ID in the context to be used by the blog controller
+			// to force-set the author later, the role to check permissions, and
+			// the token's ID and expiration date to be able to revoke it
+			ctx.Set("userID", principal.User.TokenUserID)
+			ctx.Set("role", principal.User.Role)
+			ctx.Set("tokenID", principal.TokenID)
+			ctx.Set("sessionID", principal.SessionID)
+			ctx.Set("tokenExpiresAt", principal.ExpiresAt)
+			ctx.Set("personalTokenID", principal.PersonalTokenID)
+			ctx.Set("cookieAuth", cookieAuth)
+			ctx.Set("actorID", principal.ActorID)
+
+			if principal.ActorID != "" {
+				err = a.recordImpersonation(ctx, principal)
+				if err != nil {
+					return err
+				}
+			}
+
+			return next(ctx)
 		}
+	}
+}
 
-		// Store the user ID in the context to be used by the blog controller
-		// to force-set the author later
-		ctx.Set("userID", userID)
+// recordImpersonation flags the response to a request made with an impersonation token, and
+// records the request in the audit trail. Requests that can't be recorded are refused.
+func (a *Auth) recordImpersonation(ctx echo.Context, principal *model.Principal) error {
+	ctx.Response().Header().Set(impersonatedByHeader, principal.ActorID)
 
-		return next(ctx)
+	err := a.audit.Record(&model.AuditEvent{
+		ActorID:   principal.ActorID,
+		SubjectID: principal.User.TokenUserID,
+		Action:    model.AuditImpersonatedRequest,
+		Detail:    ctx.Request().Method + " " + ctx.Request().URL.Path,
+		IP:        ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+
+	return nil
+}
+
+// forbidImpersonation returns a forbidden error if the request is made with an impersonation
+// token, which can't be used to change the credentials of the impersonated user
+func forbidImpersonation(ctx echo.Context, action string) error {
+	if actorID, _ := ctx.Get("actorID").(string); actorID != "" {
+		return echo.NewHTTPError(http.StatusForbidden, "impersonation tokens can't be used to "+action)
+	}
+	return nil
+}
+
+// requestToken returns the token of a request, and whether it was sent in a cookie. The token in the
+// Authorization header is used if there is one, so that API clients are not affected by cookies.
+func (a *Auth) requestToken(ctx echo.Context) (string, bool, error) {
+	header := ctx.Request().Header.Get("Authorization")
+
+	if header == "" {
+		if token := a.cookies.accessToken(ctx); token != "" {
+			// Other sites can make browsers send cookies, but can't read the CSRF token
+			if !safeMethod(ctx.Request().Method) {
+				err := a.cookies.checkCSRF(ctx)
+				if err != nil {
+					return "", false, err
+				}
+			}
+			return token, true, nil
+		}
+	}
+
+	// Parse Authorization header
+	token, err := parseAuth(header)
+	if err != nil {
+		return "", false, echo.NewHTTPError(http.StatusUnauthorized, fmt.Sprint("could not parse auth header: ", err))
+	}
+
+	return token, false, nil
 }
 
 func parseAuth(auth string) (string, error) {
@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
 	"github.com/labstack/echo"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
@@ -18,27 +20,47 @@ type AccessMock struct {
 	mock.Mock
 }
 
-func (m *AccessMock) ValidateToken(IDToken string) (string, error) {
+func (m *AccessMock) ValidateToken(IDToken string) (*model.Principal, error) {
 	args := m.Called(IDToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Principal), args.Error(1)
+}
+
+type AuditRecorderMock struct {
+	mock.Mock
+}
 
-	return args.String(0), args.Error(1)
+func (m *AuditRecorderMock) Record(event *model.AuditEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
 }
 
 func TestNewAuth(t *testing.T) {
 
 	accessMock := &AccessMock{}
+	personalTokensMock := &AccessMock{}
+	auditMock := &AuditRecorderMock{}
 
 	logsBuff := &bytes.Buffer{}
 	log := logger.NewZeroLog(logsBuff)
 
-	a := NewAuth(log, accessMock)
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	a := NewAuth(log, accessMock, personalTokensMock, cookies, auditMock, true, true)
 
 	assert.Equal(t, accessMock, a.access, "unexpected access service set")
+	assert.Equal(t, personalTokensMock, a.personalTokens, "unexpected personal token service set")
+	assert.True(t, a.requireVerifiedEmail, "verified email addresses should be required")
+	assert.True(t, a.requireAdminMFA, "two-factor authentication should be required for admins")
+	assert.Equal(t, cookies, a.cookies, "unexpected cookie auth set")
+	assert.Equal(t, auditMock, a.audit, "unexpected audit recorder set")
 	assert.Equal(t, log, a.log, "unexpected logger set")
 }
 
 func TestAuthorize(t *testing.T) {
 	fakeToken := "fakeToken"
+	fakePersonalToken := "bloggo_pat_fakeToken"
 
 	tests := []struct {
 		description string
@@ -46,6 +68,22 @@ func TestAuthorize(t *testing.T) {
 		authHeader        string
 		validAuthHeader   bool
 		missingAuthHeader bool
+		personalToken     bool
+		requiredScopes    []model.Scope
+
+		method       string
+		accessCookie string
+		csrfCookie   string
+		csrfHeader   string
+
+		impersonated bool
+		auditErr     error
+
+		requireVerifiedEmail bool
+		emailVerified        bool
+		requireAdminMFA      bool
+		role                 model.Role
+		mfaEnabled           bool
 
 		validClaimsErr error
 
@@ -63,6 +101,130 @@ func TestAuthorize(t *testing.T) {
 			expectedHTTPCode: http.StatusOK,
 			expectedHTTPBody: []byte("{}"),
 		},
+		{
+			description: "valid token with the required scopes",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requiredScopes:  []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "valid token without the required scope",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requiredScopes:  []model.Scope{model.ScopePostsWrite, model.ScopeUsersAdmin},
+
+			expectedHTTPCode: http.StatusForbidden,
+			expectedHTTPBody: []byte("token does not have the users:admin scope"),
+		},
+		{
+			description: "unverified email address on a write route",
+
+			authHeader:           "Bearer fakeToken",
+			validAuthHeader:      true,
+			requiredScopes:       []model.Scope{model.ScopePostsWrite},
+			requireVerifiedEmail: true,
+
+			expectedHTTPCode: http.StatusForbidden,
+			expectedHTTPBody: []byte("email address is not verified"),
+		},
+		{
+			description: "unverified email address on a read route",
+
+			authHeader:           "Bearer fakeToken",
+			validAuthHeader:      true,
+			requiredScopes:       []model.Scope{model.ScopePostsRead},
+			requireVerifiedEmail: true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "verified email address on a write route",
+
+			authHeader:           "Bearer fakeToken",
+			validAuthHeader:      true,
+			requiredScopes:       []model.Scope{model.ScopePostsWrite},
+			requireVerifiedEmail: true,
+			emailVerified:        true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "admin without two-factor authentication",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requiredScopes:  []model.Scope{model.ScopePostsRead},
+			requireAdminMFA: true,
+			role:            model.RoleAdmin,
+
+			expectedHTTPCode: http.StatusForbidden,
+			expectedHTTPBody: []byte("two-factor authentication is required for admins"),
+		},
+		{
+			description: "admin without two-factor authentication on a route without scopes",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requireAdminMFA: true,
+			role:            model.RoleAdmin,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "admin with two-factor authentication",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requiredScopes:  []model.Scope{model.ScopePostsRead},
+			requireAdminMFA: true,
+			role:            model.RoleAdmin,
+			mfaEnabled:      true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "author without two-factor authentication",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			requiredScopes:  []model.Scope{model.ScopePostsRead},
+			requireAdminMFA: true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "valid personal access token",
+
+			authHeader:      "Bearer bloggo_pat_fakeToken",
+			validAuthHeader: true,
+			personalToken:   true,
+			requiredScopes:  []model.Scope{model.ScopePostsWrite},
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "personal token service fails",
+
+			authHeader:      "Bearer bloggo_pat_fakeToken",
+			validAuthHeader: true,
+			personalToken:   true,
+
+			validClaimsErr: errors.New("unknown personal access token"),
+
+			expectedHTTPCode: http.StatusUnauthorized,
+			expectedHTTPBody: []byte("could not validate token: unknown personal access token"),
+		},
 		{
 			description: "invalid auth header format: no token",
 
@@ -90,6 +252,85 @@ func TestAuthorize(t *testing.T) {
 			expectedHTTPCode: http.StatusUnauthorized,
 			expectedHTTPBody: []byte("could not parse auth header: missing authorization header"),
 		},
+		{
+			description: "valid token in cookie on a read",
+
+			missingAuthHeader: true,
+			validAuthHeader:   true,
+			accessCookie:      "fakeToken",
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "valid token in cookie on a write with the CSRF token",
+
+			missingAuthHeader: true,
+			validAuthHeader:   true,
+			method:            echo.POST,
+			accessCookie:      "fakeToken",
+			csrfCookie:        "fakeCSRF",
+			csrfHeader:        "fakeCSRF",
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "token in cookie on a write without the CSRF token",
+
+			missingAuthHeader: true,
+			method:            echo.POST,
+			accessCookie:      "fakeToken",
+			csrfCookie:        "fakeCSRF",
+
+			expectedHTTPCode: http.StatusForbidden,
+			expectedHTTPBody: []byte("missing or invalid CSRF token"),
+		},
+		{
+			description: "token in cookie on a write with the wrong CSRF token",
+
+			missingAuthHeader: true,
+			method:            echo.DELETE,
+			accessCookie:      "fakeToken",
+			csrfCookie:        "fakeCSRF",
+			csrfHeader:        "otherCSRF",
+
+			expectedHTTPCode: http.StatusForbidden,
+			expectedHTTPBody: []byte("missing or invalid CSRF token"),
+		},
+		{
+			description: "auth header takes precedence over cookie",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			method:          echo.POST,
+			accessCookie:    "otherToken",
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "impersonation token",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			method:          echo.PUT,
+			impersonated:    true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []byte("{}"),
+		},
+		{
+			description: "impersonated request can't be recorded",
+
+			authHeader:      "Bearer fakeToken",
+			validAuthHeader: true,
+			impersonated:    true,
+			auditErr:        errors.New("could not record audit event: database exploded"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: []byte("could not record audit event: database exploded"),
+		},
 		{
 			description: "access service fails",
 
@@ -106,41 +347,104 @@ func TestAuthorize(t *testing.T) {
 		t.Run(test.description, func(t *testing.T) {
 			// initialize the echo context to use for the test
 			e := echo.New()
-			r, err := http.NewRequest(echo.GET, "/", nil)
+			method := test.method
+			if method == "" {
+				method = echo.GET
+			}
+			r, err := http.NewRequest(method, "/", nil)
 			if err != nil {
 				t.Fatal("could not create request")
 			}
 			if !test.missingAuthHeader {
 				r.Header.Set("Authorization", test.authHeader)
 			}
+			if test.accessCookie != "" {
+				r.AddCookie(&http.Cookie{Name: accessTokenCookie, Value: test.accessCookie})
+			}
+			if test.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfTokenCookie, Value: test.csrfCookie})
+			}
+			if test.csrfHeader != "" {
+				r.Header.Set(echo.HeaderXCSRFToken, test.csrfHeader)
+			}
 
 			w := httptest.NewRecorder()
 			ctx := e.NewContext(r, w)
 
-			// Setup access service mock
+			// Setup access service mocks, JWTs and personal access tokens being verified by different services
 			accessMock := &AccessMock{}
+			personalTokensMock := &AccessMock{}
+			role := test.role
+			if role == "" {
+				role = model.RoleAuthor
+			}
+			principal := &model.Principal{
+				User:      &model.User{TokenUserID: "fakeUserID", Role: role, EmailVerified: test.emailVerified, MFAEnabled: test.mfaEnabled},
+				Scopes:    []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+				TokenID:   "fakeJTI",
+				SessionID: "fakeSID",
+			}
+			if test.impersonated {
+				principal.ActorID = "fakeAdminID"
+			}
+			validator, token := accessMock, fakeToken
+			if test.personalToken {
+				principal.TokenID = ""
+				principal.PersonalTokenID = 3
+				validator, token = personalTokensMock, fakePersonalToken
+			}
 			if test.validAuthHeader {
-				accessMock.On("ValidateToken", fakeToken).Return("fakeUserID", test.validClaimsErr).Once()
+				if test.validClaimsErr != nil {
+					validator.On("ValidateToken", token).Return(nil, test.validClaimsErr).Once()
+				} else {
+					validator.On("ValidateToken", token).Return(principal, nil).Once()
+				}
+			}
+
+			// Requests made with impersonation tokens are recorded
+			auditMock := &AuditRecorderMock{}
+			if test.impersonated {
+				auditMock.
+					On("Record", &model.AuditEvent{
+						ActorID:   "fakeAdminID",
+						SubjectID: "fakeUserID",
+						Action:    model.AuditImpersonatedRequest,
+						Detail:    method + " /",
+					}).
+					Return(test.auditErr).
+					Once()
 			}
 
 			logsBuff := &bytes.Buffer{}
 			log := logger.NewZeroLog(logsBuff)
 
 			a := Auth{
-				log:    log,
-				access: accessMock,
+				log:                  log,
+				access:               accessMock,
+				personalTokens:       personalTokensMock,
+				cookies:              &CookieAuth{},
+				audit:                auditMock,
+				requireVerifiedEmail: test.requireVerifiedEmail,
+				requireAdminMFA:      test.requireAdminMFA,
 			}
 
 			// Since Authorize is a middleware, it needs to be given an HTTP handler to forward
 			// the call to, once the authorization is validated. Here we pass it a function that
 			// always returns no error :)
-			err = a.Authorize(func(ctx echo.Context) error {
+			err = a.Authorize(test.requiredScopes...)(func(ctx echo.Context) error {
+				assert.Equal(t, "fakeUserID", ctx.Get("userID"), "wrong user ID set in context")
+				assert.Equal(t, role, ctx.Get("role"), "wrong role set in context")
+				assert.Equal(t, principal.TokenID, ctx.Get("tokenID"), "wrong token ID set in context")
+				assert.Equal(t, principal.SessionID, ctx.Get("sessionID"), "wrong session ID set in context")
+				assert.Equal(t, principal.PersonalTokenID, ctx.Get("personalTokenID"), "wrong personal token ID set in context")
+				assert.Equal(t, test.missingAuthHeader, ctx.Get("cookieAuth"), "wrong cookie auth set in context")
+				assert.Equal(t, principal.ActorID, ctx.Get("actorID"), "wrong actor ID set in context")
 				return ctx.JSON(http.StatusOK, struct{}{})
 			})(ctx)
 
 			if err == nil {
 				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
-				assert.Equal(t, test.expectedHTTPBody, w.Body.Bytes(), "wrong response body")
+				assert.Equal(t, test.expectedHTTPBody, bytes.TrimSpace(w.Body.Bytes()), "wrong response body")
 			} else {
 				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
 				if test.expectedHTTPBody != nil {
@@ -150,7 +454,62 @@ func TestAuthorize(t *testing.T) {
 				}
 			}
 
+			// Responses to impersonated requests are flagged
+			assert.Equal(t, principal.ActorID, w.Header().Get("X-Impersonated-By"), "wrong impersonation header")
+
 			accessMock.AssertExpectations(t)
+			personalTokensMock.AssertExpectations(t)
+			auditMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestForbidImpersonation(t *testing.T) {
+	tests := []struct {
+		description string
+
+		actorID interface{}
+
+		expectErr bool
+	}{
+		{
+			description: "regular token",
+
+			actorID: "",
+		},
+		{
+			description: "actor ID not in context",
+		},
+		{
+			description: "impersonation token",
+
+			actorID: "fakeAdminID",
+
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.PUT, "/users/me/password", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			ctx := e.NewContext(r, httptest.NewRecorder())
+			ctx.Set("actorID", test.actorID)
+
+			err = forbidImpersonation(ctx, "change passwords")
+
+			if test.expectErr {
+				if assert.Error(t, err, "expected an error") {
+					assert.Contains(t, err.Error(), "403", "wrong error response status")
+					assert.Contains(t, err.Error(), "impersonation tokens can't be used to change passwords", "unexpected error response")
+				}
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
 		})
 	}
 }
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -22,17 +23,25 @@ type BlogRepository interface {
 	Delete(id uint) error
 }
 
+// MediaLinker represents a repository that keeps track of the media referenced by blog posts
+type MediaLinker interface {
+	LinkPost(postID uint, mediaIDs []uint) error
+}
+
 // Blog is a controller that is in charge of handling the CRUD of blog posts
 type Blog struct {
 	posts BlogRepository
+	media MediaLinker
 
 	log *zerolog.Logger
 }
 
-// NewBlog creates a Blog controller with the given blog post repository
-func NewBlog(log *zerolog.Logger, blogPostRepository BlogRepository) *Blog {
+// NewBlog creates a Blog controller with the given blog post repository. The media
+// referenced in the content of the posts are linked to them using the media linker.
+func NewBlog(log *zerolog.Logger, blogPostRepository BlogRepository, mediaLinker MediaLinker) *Blog {
 	return &Blog{
 		posts: blogPostRepository,
+		media: mediaLinker,
 
 		log: log,
 	}
@@ -68,6 +77,9 @@ func (b *Blog) Create(ctx echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+
+	b.linkMedia(createdPost)
+
 	return ctx.JSON(http.StatusCreated, createdPost)
 }
 
@@ -125,7 +137,7 @@ func (b *Blog) Find(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, blogPosts)
 }
 
-// Update edits a blog post from its id
+// Update edits a blog post from its id. Authors can only edit their own blog posts.
 func (b *Blog) Update(ctx echo.Context) error {
 	// parse the ID from the URL parameter
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
@@ -133,6 +145,12 @@ func (b *Blog) Update(ctx echo.Context) error {
 		err = errors.Wrap(err, "could not parse blog post ID")
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+
+	err = b.checkOwnership(ctx, uint(id))
+	if err != nil {
+		return err
+	}
+
 	var post model.BlogPost
 
 	err = ctx.Bind(&post)
@@ -155,10 +173,13 @@ func (b *Blog) Update(ctx echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+
+	b.linkMedia(&post)
+
 	return ctx.NoContent(http.StatusNoContent)
 }
 
-// Delete removes a blog post from its id
+// Delete removes a blog post from its id. Authors can only delete their own blog posts.
 func (b *Blog) Delete(ctx echo.Context) error {
 	// extract the ID from the request parameters
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
@@ -167,6 +188,11 @@ func (b *Blog) Delete(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err)
 	}
 
+	err = b.checkOwnership(ctx, uint(id))
+	if err != nil {
+		return err
+	}
+
 	// delete the blog post from the repository
 	err = b.posts.Delete(uint(id))
 	if errors.Cause(err) == errortype.ErrNotFound {
@@ -178,3 +204,46 @@ func (b *Blog) Delete(ctx echo.Context) error {
 	}
 	return ctx.NoContent(http.StatusNoContent)
 }
+
+// checkOwnership returns an error unless the user in the request context is the author
+// of the blog post with the given id, or is allowed to edit any blog post
+func (b *Blog) checkOwnership(ctx echo.Context, id uint) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	role, ok := ctx.Get("role").(model.Role)
+	if !ok {
+		err := errors.New("role not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if role.Can(model.PermissionEditAnyPost) {
+		return nil
+	}
+
+	post, err := b.posts.Retrieve(id)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "blog post id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not read blog post")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if post.Author != userID {
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("blog post id %d belongs to another author", id))
+	}
+	return nil
+}
+
+// linkMedia records which media are referenced by a blog post. Failing to do so
+// does not prevent the blog post from being saved.
+func (b *Blog) linkMedia(post *model.BlogPost) {
+	err := b.media.LinkPost(post.ID, mediaReferences(post.Content))
+	if err != nil {
+		b.log.Warn().Err(err).Uint("post_id", post.ID).Msg("could not link media to blog post")
+	}
+}
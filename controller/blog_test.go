@@ -35,12 +35,14 @@ func (r *ResourceNotFoundErr) Error() string {
 
 func TestNewBlog(t *testing.T) {
 	blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+	mediaRepositoryMock := &repo.MediaRepositoryMock{}
 	logsBuff := &bytes.Buffer{}
 	log := logger.NewZeroLog(logsBuff)
 
-	b := NewBlog(log, blogPostRepositoryMock)
+	b := NewBlog(log, blogPostRepositoryMock, mediaRepositoryMock)
 
 	assert.Equal(t, blogPostRepositoryMock, b.posts, "unexpected blog post repository set")
+	assert.Equal(t, mediaRepositoryMock, b.media, "unexpected media linker set")
 	assert.Equal(t, log, b.log, "unexpected logger set")
 }
 
@@ -52,6 +54,8 @@ func TestCreate(t *testing.T) {
 		userIDMissing   bool
 		repositoryErr   error
 		createdBlogPost *model.BlogPost
+		linkedMedia     []uint
+		linkErr         error
 
 		expectedHTTPCode int
 		expectedHTTPBody []byte
@@ -76,6 +80,46 @@ func TestCreate(t *testing.T) {
 			expectedHTTPCode: 201,
 			expectedHTTPBody: []byte(`{"id":1,"author":"faketoken","title":"lorem ipsum","content":"dolor sit amet","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`),
 		},
+		{
+			description: "created: referenced media are linked",
+
+			requestBody: []byte(`
+				{
+					"title": "lorem ipsum",
+					"content": "![cat](/api/media/3/content) ![dog](/api/media/7/content) ![cat](/api/media/3/content)"
+				}
+			`),
+			createdBlogPost: &model.BlogPost{
+				ID:      1,
+				Title:   "lorem ipsum",
+				Content: "![cat](/api/media/3/content) ![dog](/api/media/7/content) ![cat](/api/media/3/content)",
+				Author:  "faketoken",
+			},
+			linkedMedia: []uint{3, 7},
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"author":"faketoken","title":"lorem ipsum","content":"![cat](/api/media/3/content) ![dog](/api/media/7/content) ![cat](/api/media/3/content)","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`),
+		},
+		{
+			description: "created: media link failure is ignored",
+
+			requestBody: []byte(`
+				{
+					"title": "lorem ipsum",
+					"content": "dolor sit amet"
+				}
+			`),
+			createdBlogPost: &model.BlogPost{
+				ID:      1,
+				Title:   "lorem ipsum",
+				Content: "dolor sit amet",
+				Author:  "faketoken",
+			},
+			linkErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"author":"faketoken","title":"lorem ipsum","content":"dolor sit amet","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`),
+		},
 		{
 			description: "bad request: bind fails",
 
@@ -143,11 +187,24 @@ func TestCreate(t *testing.T) {
 					Once()
 			}
 
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			if test.createdBlogPost != nil {
+				linkedMedia := test.linkedMedia
+				if linkedMedia == nil {
+					linkedMedia = []uint{}
+				}
+				mediaRepositoryMock.
+					On("LinkPost", test.createdBlogPost.ID, linkedMedia).
+					Return(test.linkErr).
+					Once()
+			}
+
 			logsBuff := &bytes.Buffer{}
 			log := logger.NewZeroLog(logsBuff)
 
 			blogController := &Blog{
 				posts: blogPostRepositoryMock,
+				media: mediaRepositoryMock,
 
 				log: log,
 			}
@@ -167,6 +224,7 @@ func TestCreate(t *testing.T) {
 			}
 
 			blogPostRepositoryMock.AssertExpectations(t)
+			mediaRepositoryMock.AssertExpectations(t)
 		})
 	}
 }
@@ -425,6 +483,9 @@ func TestUpdate(t *testing.T) {
 
 		requestBody       []byte
 		blogPostIDMissing bool
+		role              model.Role
+		author            string
+		retrieveErr       error
 		repositoryErr     error
 
 		expectedHTTPCode int
@@ -443,6 +504,39 @@ func TestUpdate(t *testing.T) {
 			expectedHTTPCode: 204,
 			expectedHTTPBody: []byte(``),
 		},
+		{
+			description: "created: author edits their own blog post",
+
+			requestBody: []byte(`
+				{
+					"title": "lorem ipsum",
+					"content": "dolor sit amet"
+				}
+			`),
+			role:   model.RoleAuthor,
+			author: "fakeToken",
+
+			expectedHTTPCode: 204,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "forbidden: author edits the blog post of another author",
+
+			role:   model.RoleAuthor,
+			author: "someoneElse",
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`blog post id 42 belongs to another author`),
+		},
+		{
+			description: "not found: author edits a blog post that doesn't exist",
+
+			role:        model.RoleAuthor,
+			retrieveErr: &ResourceNotFoundErr{},
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`blog post id 42: resource not found`),
+		},
 		{
 			description: "bad request: missing blog post id",
 
@@ -515,7 +609,21 @@ func TestUpdate(t *testing.T) {
 				ctx.SetParamValues("42")
 			}
 
+			// Editors can edit any blog post
+			role := test.role
+			if role == "" {
+				role = model.RoleEditor
+			}
+			ctx.Set("userID", "fakeToken")
+			ctx.Set("role", role)
+
 			blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+			if role == model.RoleAuthor {
+				blogPostRepositoryMock.
+					On("Retrieve", uint(42)).
+					Return(&model.BlogPost{ID: 42, Author: test.author}, test.retrieveErr).
+					Once()
+			}
 			if test.repositoryErr != nil || test.expectedHTTPCode == 204 {
 				blogPostRepositoryMock.
 					On("Update", mock.AnythingOfType("*model.BlogPost")).
@@ -523,11 +631,20 @@ func TestUpdate(t *testing.T) {
 					Once()
 			}
 
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			if test.expectedHTTPCode == 204 {
+				mediaRepositoryMock.
+					On("LinkPost", uint(42), []uint{}).
+					Return(nil).
+					Once()
+			}
+
 			logsBuff := &bytes.Buffer{}
 			log := logger.NewZeroLog(logsBuff)
 
 			blogController := &Blog{
 				posts: blogPostRepositoryMock,
+				media: mediaRepositoryMock,
 
 				log: log,
 			}
@@ -547,6 +664,7 @@ func TestUpdate(t *testing.T) {
 			}
 
 			blogPostRepositoryMock.AssertExpectations(t)
+			mediaRepositoryMock.AssertExpectations(t)
 		})
 	}
 }
@@ -556,6 +674,9 @@ func TestDelete(t *testing.T) {
 		description string
 
 		blogPostIDMissing bool
+		role              model.Role
+		author            string
+		retrieveErr       error
 		repositoryErr     error
 
 		expectedHTTPCode int
@@ -567,6 +688,33 @@ func TestDelete(t *testing.T) {
 			expectedHTTPCode: 204,
 			expectedHTTPBody: []byte(``),
 		},
+		{
+			description: "author deletes their own blog post",
+
+			role:   model.RoleAuthor,
+			author: "fakeToken",
+
+			expectedHTTPCode: 204,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "forbidden: author deletes the blog post of another author",
+
+			role:   model.RoleAuthor,
+			author: "someoneElse",
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`blog post id 42 belongs to another author`),
+		},
+		{
+			description: "internal server error: author's blog post can't be read",
+
+			role:        model.RoleAuthor,
+			retrieveErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not read blog post: database exploded`),
+		},
 		{
 			description: "bad request: missing blog post id",
 
@@ -610,7 +758,21 @@ func TestDelete(t *testing.T) {
 				ctx.SetParamValues("42")
 			}
 
+			// Editors can delete any blog post
+			role := test.role
+			if role == "" {
+				role = model.RoleEditor
+			}
+			ctx.Set("userID", "fakeToken")
+			ctx.Set("role", role)
+
 			blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+			if role == model.RoleAuthor {
+				blogPostRepositoryMock.
+					On("Retrieve", uint(42)).
+					Return(&model.BlogPost{ID: 42, Author: test.author}, test.retrieveErr).
+					Once()
+			}
 			if test.repositoryErr != nil || test.expectedHTTPCode == 204 {
 				blogPostRepositoryMock.
 					On("Delete", uint(42)).
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+)
+
+// ClientIP makes ctx.RealIP return the address of the client that made the request. The
+// X-Forwarded-For and X-Real-IP headers can be set by anyone, so they are only read when
+// the request comes from one of the trusted proxies, and removed otherwise.
+func ClientIP(trustedProxies []*net.IPNet) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			r := ctx.Request()
+			r.Header.Set(echo.HeaderXRealIP, clientIP(r, trustedProxies))
+			r.Header.Del(echo.HeaderXForwardedFor)
+			return next(ctx)
+		}
+	}
+}
+
+// clientIP returns the address of the client that made a request. The addresses of
+// X-Forwarded-For are read from the last one, which was added by the proxy closest to
+// Bloggo, until one of them isn't a trusted proxy, since the ones before it could be forged.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !trusted(ip, trustedProxies) {
+		return ip
+	}
+
+	forwarded := r.Header.Get(echo.HeaderXForwardedFor)
+	if forwarded == "" {
+		if realIP := strings.TrimSpace(r.Header.Get(echo.HeaderXRealIP)); realIP != "" {
+			return realIP
+		}
+		return ip
+	}
+
+	addresses := strings.Split(forwarded, ",")
+	for i := len(addresses) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(addresses[i])
+		if address == "" {
+			continue
+		}
+
+		ip = address
+		if !trusted(ip, trustedProxies) {
+			break
+		}
+	}
+	return ip
+}
+
+// trusted returns true if the address belongs to one of the trusted proxies
+func trusted(address string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
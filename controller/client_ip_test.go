@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal("could not parse trusted proxies")
+	}
+
+	tests := []struct {
+		description string
+
+		trustedProxies []*net.IPNet
+		remoteAddr     string
+		forwardedFor   string
+		realIP         string
+
+		expectedIP string
+	}{
+		{
+			description: "direct request",
+
+			remoteAddr: "192.0.2.1:51234",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "forged headers without trusted proxies",
+
+			remoteAddr:   "192.0.2.1:51234",
+			forwardedFor: "198.51.100.7",
+			realIP:       "198.51.100.8",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "forged headers from an untrusted address",
+
+			trustedProxies: []*net.IPNet{proxies},
+			remoteAddr:     "192.0.2.1:51234",
+			forwardedFor:   "198.51.100.7",
+			realIP:         "198.51.100.8",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "request forwarded by a trusted proxy",
+
+			trustedProxies: []*net.IPNet{proxies},
+			remoteAddr:     "10.0.0.2:51234",
+			forwardedFor:   "192.0.2.1",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "request forwarded by trusted proxies with a forged address",
+
+			trustedProxies: []*net.IPNet{proxies},
+			remoteAddr:     "10.0.0.2:51234",
+			forwardedFor:   "198.51.100.7, 192.0.2.1, 10.0.0.3",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "request forwarded by a trusted proxy that sets X-Real-IP",
+
+			trustedProxies: []*net.IPNet{proxies},
+			remoteAddr:     "10.0.0.2:51234",
+			realIP:         "192.0.2.1",
+
+			expectedIP: "192.0.2.1",
+		},
+		{
+			description: "request from a trusted proxy",
+
+			trustedProxies: []*net.IPNet{proxies},
+			remoteAddr:     "10.0.0.2:51234",
+
+			expectedIP: "10.0.0.2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				r.Header.Set(echo.HeaderXForwardedFor, test.forwardedFor)
+			}
+			if test.realIP != "" {
+				r.Header.Set(echo.HeaderXRealIP, test.realIP)
+			}
+
+			ctx := e.NewContext(r, httptest.NewRecorder())
+
+			var ip string
+			handler := ClientIP(test.trustedProxies)(func(ctx echo.Context) error {
+				ip = ctx.RealIP()
+				return nil
+			})
+
+			err = handler(ctx)
+			if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.expectedIP, ip, "wrong client IP")
+			}
+		})
+	}
+}
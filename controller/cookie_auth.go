@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// Cookies in which the tokens of browsers are sent
+const (
+	accessTokenCookie  = "bloggo_access_token"
+	refreshTokenCookie = "bloggo_refresh_token"
+	csrfTokenCookie    = "bloggo_csrf_token"
+)
+
+// authModeHeader is the header with which clients ask for their tokens to be sent in cookies,
+// by setting it to authModeCookie
+const (
+	authModeHeader = "X-Auth-Mode"
+	authModeCookie = "cookie"
+)
+
+// CookieAuth sends tokens to browsers in cookies that scripts can't read, instead of in the body
+// of responses. Since browsers send cookies along with requests made by other sites, requests
+// authenticated with cookies that change something must also send the CSRF token of the browser
+// in their X-CSRF-Token header. The CSRF token is in a cookie that only scripts of Bloggo can read.
+type CookieAuth struct {
+	// path is the path under which browsers send the token cookies, which is the API prefix
+	path string
+	// secure restricts cookies to HTTPS
+	secure bool
+	// refreshTTL is how long browsers keep the refresh token cookie
+	refreshTTL time.Duration
+}
+
+// NewCookieAuth creates a CookieAuth whose token cookies are sent to the routes under path, only
+// over HTTPS if secure is set, and whose refresh token cookie expires after refreshTTL.
+func NewCookieAuth(path string, secure bool, refreshTTL time.Duration) *CookieAuth {
+	return &CookieAuth{
+		path:       path,
+		secure:     secure,
+		refreshTTL: refreshTTL,
+	}
+}
+
+// cookieToken is sent instead of a token to clients that get their tokens in cookies
+type cookieToken struct {
+	TokenType string `json:"token_type"`
+	ExpiresIn int64  `json:"expires_in"`
+	Scope     string `json:"scope"`
+	CSRFToken string `json:"csrf_token"`
+}
+
+// requested returns whether the client asked for its tokens to be sent in cookies
+func (c *CookieAuth) requested(ctx echo.Context) bool {
+	return ctx.Request().Header.Get(authModeHeader) == authModeCookie
+}
+
+// respond sends a token to the client, in cookies if it asked for them
+func (c *CookieAuth) respond(ctx echo.Context, code int, token *model.Token) error {
+	if !c.requested(ctx) {
+		return ctx.JSON(code, token)
+	}
+
+	return c.respondWithCookies(ctx, code, token)
+}
+
+// respondWithCookies sends a token to the client in cookies, along with a new CSRF token
+func (c *CookieAuth) respondWithCookies(ctx echo.Context, code int, token *model.Token) error {
+	csrfToken, err := randomCSRFToken()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	ctx.SetCookie(c.cookie(accessTokenCookie, token.AccessToken, int(token.ExpiresIn)))
+	ctx.SetCookie(c.cookie(refreshTokenCookie, token.RefreshToken, int(c.refreshTTL/time.Second)))
+	ctx.SetCookie(c.csrfCookie(csrfToken, int(c.refreshTTL/time.Second)))
+
+	return ctx.JSON(code, cookieToken{
+		TokenType: authModeCookie,
+		ExpiresIn: token.ExpiresIn,
+		Scope:     token.Scope,
+		CSRFToken: csrfToken,
+	})
+}
+
+// clear removes the token cookies of the client
+func (c *CookieAuth) clear(ctx echo.Context) {
+	ctx.SetCookie(c.cookie(accessTokenCookie, "", -1))
+	ctx.SetCookie(c.cookie(refreshTokenCookie, "", -1))
+	ctx.SetCookie(c.csrfCookie("", -1))
+}
+
+// accessToken returns the access token in the cookies of the request, if there is one
+func (c *CookieAuth) accessToken(ctx echo.Context) string {
+	return cookieValue(ctx, accessTokenCookie)
+}
+
+// refreshToken returns the refresh token in the cookies of the request, if there is one
+func (c *CookieAuth) refreshToken(ctx echo.Context) string {
+	return cookieValue(ctx, refreshTokenCookie)
+}
+
+// checkCSRF verifies that the CSRF token in the header of the request is the one in its cookies
+func (c *CookieAuth) checkCSRF(ctx echo.Context) error {
+	expected := cookieValue(ctx, csrfTokenCookie)
+	actual := ctx.Request().Header.Get(echo.HeaderXCSRFToken)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) != 1 {
+		return echo.NewHTTPError(http.StatusForbidden, "missing or invalid CSRF token")
+	}
+
+	return nil
+}
+
+// cookie returns a cookie that holds a token, which scripts can't read
+func (c *CookieAuth) cookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.path,
+		MaxAge:   maxAge,
+		Secure:   c.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// csrfCookie returns the cookie that holds the CSRF token, which the scripts of
+// every page can read so that the app can send it back in a header
+func (c *CookieAuth) csrfCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     csrfTokenCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   c.secure,
+		SameSite: http.SameSiteStrictMode,
+	}
+}
+
+// cookieValue returns the value of a cookie of the request, or an empty string if it has none
+func cookieValue(ctx echo.Context, name string) string {
+	cookie, err := ctx.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// safeMethod returns whether requests with the given method can't change anything
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// randomCSRFToken generates a CSRF token
+func randomCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate CSRF token")
+	}
+	return hex.EncodeToString(b), nil
+}
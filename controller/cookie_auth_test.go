@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+// responseCookies returns the values of the cookies set by a response, by name
+func responseCookies(w *httptest.ResponseRecorder) map[string]string {
+	cookies := make(map[string]string)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
+}
+
+func TestNewCookieAuth(t *testing.T) {
+	c := NewCookieAuth("/api", true, time.Hour)
+
+	assert.Equal(t, "/api", c.path, "unexpected path set")
+	assert.True(t, c.secure, "cookies should be secure")
+	assert.Equal(t, time.Hour, c.refreshTTL, "unexpected refresh TTL set")
+}
+
+func TestCookieAuthRespond(t *testing.T) {
+	tests := []struct {
+		description string
+
+		authMode string
+
+		expectCookies bool
+	}{
+		{
+			description: "bearer token in body",
+
+			expectCookies: false,
+		},
+		{
+			description: "tokens in cookies",
+
+			authMode: "cookie",
+
+			expectCookies: true,
+		},
+		{
+			description: "unknown auth mode",
+
+			authMode: "potato",
+
+			expectCookies: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/login", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			if test.authMode != "" {
+				r.Header.Set("X-Auth-Mode", test.authMode)
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			c := NewCookieAuth("/api", true, time.Hour)
+
+			err = c.respond(ctx, http.StatusOK, issuedToken)
+			if !assert.NoError(t, err, "unexpected error") {
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code, "wrong response status")
+
+			if !test.expectCookies {
+				assert.Equal(t, issuedTokenJSON, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Empty(t, w.Result().Cookies(), "no cookie should be set")
+				return
+			}
+
+			var body cookieToken
+			err = json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal("could not parse response body")
+			}
+			assert.Equal(t, "cookie", body.TokenType, "wrong token type")
+			assert.Equal(t, issuedToken.ExpiresIn, body.ExpiresIn, "wrong expiration")
+			assert.Equal(t, issuedToken.Scope, body.Scope, "wrong scope")
+			assert.NotContains(t, w.Body.String(), issuedToken.AccessToken, "tokens should not be in the response body")
+
+			for _, cookie := range w.Result().Cookies() {
+				assert.True(t, cookie.Secure, "cookie %s should be secure", cookie.Name)
+				assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite, "cookie %s should be same-site", cookie.Name)
+
+				switch cookie.Name {
+				case accessTokenCookie:
+					assert.Equal(t, issuedToken.AccessToken, cookie.Value, "wrong access token")
+					assert.Equal(t, int(issuedToken.ExpiresIn), cookie.MaxAge, "wrong access token lifetime")
+					assert.Equal(t, "/api", cookie.Path, "wrong access token path")
+					assert.True(t, cookie.HttpOnly, "access token should not be readable by scripts")
+				case refreshTokenCookie:
+					assert.Equal(t, issuedToken.RefreshToken, cookie.Value, "wrong refresh token")
+					assert.Equal(t, 3600, cookie.MaxAge, "wrong refresh token lifetime")
+					assert.Equal(t, "/api", cookie.Path, "wrong refresh token path")
+					assert.True(t, cookie.HttpOnly, "refresh token should not be readable by scripts")
+				case csrfTokenCookie:
+					assert.Equal(t, body.CSRFToken, cookie.Value, "wrong CSRF token")
+					assert.Len(t, cookie.Value, 64, "wrong CSRF token length")
+					assert.Equal(t, "/", cookie.Path, "wrong CSRF token path")
+					assert.False(t, cookie.HttpOnly, "CSRF token should be readable by scripts")
+				default:
+					t.Errorf("unexpected cookie %s", cookie.Name)
+				}
+			}
+			assert.Len(t, w.Result().Cookies(), 3, "wrong number of cookies")
+		})
+	}
+}
+
+func TestCookieAuthClear(t *testing.T) {
+	e := echo.New()
+	r, err := http.NewRequest(echo.POST, "/logout", nil)
+	if err != nil {
+		t.Fatal("could not create request")
+	}
+
+	w := httptest.NewRecorder()
+	ctx := e.NewContext(r, w)
+
+	c := NewCookieAuth("/api", true, time.Hour)
+	c.clear(ctx)
+
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 3, "wrong number of cookies")
+	for _, cookie := range cookies {
+		assert.Empty(t, cookie.Value, "cookie %s should be emptied", cookie.Name)
+		assert.True(t, cookie.MaxAge < 0, "cookie %s should expire", cookie.Name)
+	}
+}
+
+func TestCheckCSRF(t *testing.T) {
+	tests := []struct {
+		description string
+
+		csrfCookie string
+		csrfHeader string
+
+		expectErr bool
+	}{
+		{
+			description: "matching tokens",
+
+			csrfCookie: "fakeCSRF",
+			csrfHeader: "fakeCSRF",
+		},
+		{
+			description: "missing header",
+
+			csrfCookie: "fakeCSRF",
+
+			expectErr: true,
+		},
+		{
+			description: "missing cookie",
+
+			csrfHeader: "fakeCSRF",
+
+			expectErr: true,
+		},
+		{
+			description: "missing cookie and header",
+
+			expectErr: true,
+		},
+		{
+			description: "mismatching tokens",
+
+			csrfCookie: "fakeCSRF",
+			csrfHeader: "otherCSRF",
+
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/", bytes.NewReader(nil))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			if test.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfTokenCookie, Value: test.csrfCookie})
+			}
+			if test.csrfHeader != "" {
+				r.Header.Set(echo.HeaderXCSRFToken, test.csrfHeader)
+			}
+
+			ctx := e.NewContext(r, httptest.NewRecorder())
+
+			c := NewCookieAuth("/api", true, time.Hour)
+			err = c.checkCSRF(ctx)
+
+			if test.expectErr {
+				if assert.Error(t, err, "expected an error") {
+					assert.Contains(t, err.Error(), "403", "wrong error response status")
+					assert.Contains(t, err.Error(), "missing or invalid CSRF token", "unexpected error response")
+				}
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/site"
+	"github.com/Ullaakut/Bloggo/theme"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Renderer represents a theme that renders HTML pages
+type Renderer interface {
+	Render(w io.Writer, name string, data interface{}) error
+}
+
+// Frontend is a controller that renders the public blog as HTML pages
+type Frontend struct {
+	posts    BlogRepository
+	renderer Renderer
+
+	site     theme.Site
+	pageSize int
+	maxAge   time.Duration
+
+	log *zerolog.Logger
+}
+
+// NewFrontend creates a Frontend controller that renders the posts of the given
+// blog post repository using the given renderer. Pages can be cached by clients
+// for maxAge.
+func NewFrontend(log *zerolog.Logger, blogPostRepository BlogRepository, renderer Renderer, s theme.Site, pageSize int, maxAge time.Duration) *Frontend {
+	return &Frontend{
+		posts:    blogPostRepository,
+		renderer: renderer,
+
+		site:     s,
+		pageSize: pageSize,
+		maxAge:   maxAge,
+
+		log: log,
+	}
+}
+
+// Index renders the first page of the blog
+func (f *Frontend) Index(ctx echo.Context) error {
+	return f.renderPage(ctx, 1)
+}
+
+// Page renders an index page from its number
+func (f *Frontend) Page(ctx echo.Context) error {
+	// parse the page number from the URL parameter
+	page, err := strconv.Atoi(ctx.Param("n"))
+	if err != nil || page < 1 {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("page %q not found", ctx.Param("n")))
+	}
+
+	// The first page is the root of the blog
+	if page == 1 {
+		return ctx.Redirect(http.StatusMovedPermanently, f.site.URL(""))
+	}
+
+	return f.renderPage(ctx, page)
+}
+
+// Post renders a blog post from its id
+func (f *Frontend) Post(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("blog post %q not found", ctx.Param("id")))
+	}
+
+	// retrieve the blog post from the blog post repository
+	post, err := f.posts.Retrieve(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "blog post id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not read blog post")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return f.render(ctx, theme.PostTemplate, theme.PostPage{
+		Site: f.site,
+		Post: post,
+		URL:  f.site.URL(site.PostPath(post.ID)),
+	}, post.UpdatedAt)
+}
+
+func (f *Frontend) renderPage(ctx echo.Context, page int) error {
+	posts, err := f.posts.Find(nil, nil)
+	if err != nil {
+		err = errors.Wrap(err, "could not read blog posts")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	site.SortPosts(posts)
+
+	if page > site.PageCount(len(posts), f.pageSize) {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("page %d not found", page))
+	}
+
+	return f.render(ctx, theme.IndexTemplate, site.NewIndexPage(f.site, posts, page, f.pageSize), lastModified(posts))
+}
+
+// render executes the given template and writes it along with caching headers,
+// or responds that the client's cached version is still valid
+func (f *Frontend) render(ctx echo.Context, name string, data interface{}, modified time.Time) error {
+	buf := &bytes.Buffer{}
+	err := f.renderer.Render(buf, name, data)
+	if err != nil {
+		err = errors.Wrapf(err, "could not render %s", name)
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	header := ctx.Response().Header()
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(f.maxAge.Seconds())))
+	header.Set("ETag", etag)
+	if !modified.IsZero() {
+		header.Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(ctx.Request(), etag, modified) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	return ctx.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+// notModified returns true if the client's cached version of a page is still valid
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return match == etag || match == "W/"+etag
+	}
+
+	since, err := http.ParseTime(r.Header.Get(echo.HeaderIfModifiedSince))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// lastModified returns the most recent update time of the given posts
+func lastModified(posts []*model.BlogPost) time.Time {
+	var last time.Time
+	for _, post := range posts {
+		if post.UpdatedAt.After(last) {
+			last = post.UpdatedAt
+		}
+	}
+	return last
+}
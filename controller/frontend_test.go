@@ -0,0 +1,365 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+	"github.com/Ullaakut/Bloggo/theme"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFrontendTestPosts(count int) []*model.BlogPost {
+	var posts []*model.BlogPost
+	for i := 1; i <= count; i++ {
+		posts = append(posts, &model.BlogPost{
+			ID:        uint(i),
+			Title:     fmt.Sprintf("post number %d", i),
+			Content:   "dolor sit amet",
+			Author:    "faketoken",
+			CreatedAt: time.Date(2018, 8, i, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2018, 8, i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return posts
+}
+
+func newTestFrontend(t *testing.T, blogPostRepositoryMock *repo.BlogPostRepositoryMock) *Frontend {
+	th, err := theme.Load("")
+	if err != nil {
+		t.Fatal("could not load default theme")
+	}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	return NewFrontend(log, blogPostRepositoryMock, th, theme.Site{
+		Title:   "Bloggo",
+		BaseURL: "https://blog.example.com/",
+	}, 2, 5*time.Minute)
+}
+
+func TestNewFrontend(t *testing.T) {
+	blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+	th := &theme.Theme{}
+	s := theme.Site{Title: "Bloggo"}
+
+	f := NewFrontend(log, blogPostRepositoryMock, th, s, 10, time.Minute)
+
+	assert.Equal(t, blogPostRepositoryMock, f.posts, "unexpected blog post repository set")
+	assert.Equal(t, th, f.renderer, "unexpected renderer set")
+	assert.Equal(t, s, f.site, "unexpected site set")
+	assert.Equal(t, 10, f.pageSize, "unexpected page size set")
+	assert.Equal(t, time.Minute, f.maxAge, "unexpected max age set")
+	assert.Equal(t, log, f.log, "unexpected logger set")
+}
+
+func TestFrontendPage(t *testing.T) {
+	tests := []struct {
+		description string
+
+		page           string
+		retrievedPosts []*model.BlogPost
+		repositoryErr  error
+
+		expectedHTTPCode int
+		expectedHTTPBody []string
+	}{
+		{
+			description: "first page",
+
+			retrievedPosts: newFrontendTestPosts(3),
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []string{
+				"post number 3",
+				"post number 2",
+				`<a rel="next" href="https://blog.example.com/page/2/">`,
+				`<meta property="og:type" content="website" />`,
+			},
+		},
+		{
+			description: "second page",
+
+			page:           "2",
+			retrievedPosts: newFrontendTestPosts(3),
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []string{
+				"post number 1",
+				`<a rel="prev" href="https://blog.example.com/">`,
+			},
+		},
+		{
+			description: "page 1 redirects to the root",
+
+			page: "1",
+
+			expectedHTTPCode: http.StatusMovedPermanently,
+		},
+		{
+			description: "page out of range",
+
+			page:           "3",
+			retrievedPosts: newFrontendTestPosts(3),
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: []string{"page 3 not found"},
+		},
+		{
+			description: "invalid page number",
+
+			page: "potato",
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: []string{`page "potato" not found`},
+		},
+		{
+			description: "repository error",
+
+			retrievedPosts: []*model.BlogPost{},
+			repositoryErr:  errors.New("database exploded"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: []string{"could not read blog posts: database exploded"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+			if test.retrievedPosts != nil {
+				blogPostRepositoryMock.
+					On("Find", (*string)(nil), (*uint)(nil)).
+					Return(test.retrievedPosts, test.repositoryErr).
+					Once()
+			}
+
+			f := newTestFrontend(t, blogPostRepositoryMock)
+
+			if test.page == "" {
+				err = f.Index(ctx)
+			} else {
+				ctx.SetParamNames("n")
+				ctx.SetParamValues(test.page)
+				err = f.Page(ctx)
+			}
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				for _, expected := range test.expectedHTTPBody {
+					assert.Contains(t, w.Body.String(), expected, "wrong response body")
+				}
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				for _, expected := range test.expectedHTTPBody {
+					assert.Contains(t, err.Error(), expected, "unexpected error response")
+				}
+			}
+
+			blogPostRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFrontendPost(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id            string
+		retrievedPost *model.BlogPost
+		repositoryErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []string
+	}{
+		{
+			description: "blog post exists",
+
+			id:            "1",
+			retrievedPost: newFrontendTestPosts(1)[0],
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: []string{
+				"<h2>post number 1</h2>",
+				`<meta property="og:type" content="article" />`,
+				`<meta property="og:url" content="https://blog.example.com/p/1/" />`,
+				`<meta name="twitter:title" content="post number 1" />`,
+			},
+		},
+		{
+			description: "invalid blog post id",
+
+			id: "potato",
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: []string{`blog post "potato" not found`},
+		},
+		{
+			description: "blog post does not exist",
+
+			id:            "1",
+			repositoryErr: &ResourceNotFoundErr{},
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: []string{"blog post id 1: resource not found"},
+		},
+		{
+			description: "repository error",
+
+			id:            "1",
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: []string{"could not read blog post: database exploded"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/p/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+
+			blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+			if test.retrievedPost != nil || test.repositoryErr != nil {
+				blogPostRepositoryMock.
+					On("Retrieve", uint(1)).
+					Return(test.retrievedPost, test.repositoryErr).
+					Once()
+			}
+
+			f := newTestFrontend(t, blogPostRepositoryMock)
+
+			err = f.Post(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"), "wrong cache control header")
+				assert.Equal(t, "Wed, 01 Aug 2018 00:00:00 GMT", w.Header().Get(echo.HeaderLastModified), "wrong last modified header")
+				assert.NotEmpty(t, w.Header().Get("ETag"), "missing etag header")
+				for _, expected := range test.expectedHTTPBody {
+					assert.Contains(t, w.Body.String(), expected, "wrong response body")
+				}
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				for _, expected := range test.expectedHTTPBody {
+					assert.Contains(t, err.Error(), expected, "unexpected error response")
+				}
+			}
+
+			blogPostRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFrontendConditionalRequests(t *testing.T) {
+	tests := []struct {
+		description string
+
+		headers map[string]string
+
+		expectedHTTPCode int
+	}{
+		{
+			description: "no cache validators",
+
+			expectedHTTPCode: http.StatusOK,
+		},
+		{
+			description: "matching etag",
+
+			headers: map[string]string{"If-None-Match": "etag"},
+
+			expectedHTTPCode: http.StatusNotModified,
+		},
+		{
+			description: "stale etag",
+
+			headers: map[string]string{"If-None-Match": `"stale"`},
+
+			expectedHTTPCode: http.StatusOK,
+		},
+		{
+			description: "not modified since",
+
+			headers: map[string]string{echo.HeaderIfModifiedSince: "Thu, 02 Aug 2018 00:00:00 GMT"},
+
+			expectedHTTPCode: http.StatusNotModified,
+		},
+		{
+			description: "modified since",
+
+			headers: map[string]string{echo.HeaderIfModifiedSince: "Tue, 31 Jul 2018 00:00:00 GMT"},
+
+			expectedHTTPCode: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+			blogPostRepositoryMock.
+				On("Retrieve", uint(1)).
+				Return(newFrontendTestPosts(1)[0], nil)
+
+			f := newTestFrontend(t, blogPostRepositoryMock)
+
+			// Make a first request to learn the page's etag
+			e := echo.New()
+			r := httptest.NewRequest(echo.GET, "/p/1", nil)
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("1")
+			assert.NoError(t, f.Post(ctx), "unexpected error")
+			etag := w.Header().Get("ETag")
+
+			r = httptest.NewRequest(echo.GET, "/p/1", nil)
+			for key, value := range test.headers {
+				if value == "etag" {
+					value = etag
+				}
+				r.Header.Set(key, value)
+			}
+			w = httptest.NewRecorder()
+			ctx = e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("1")
+
+			err := f.Post(ctx)
+
+			assert.NoError(t, err, "unexpected error")
+			assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// ImpersonationService represents a service that lets admins act as other users
+type ImpersonationService interface {
+	Start(actorID string, id uint, client *model.Client) (*model.Token, error)
+}
+
+// Impersonation is a controller that lets admins act as other users, to see what they see
+type Impersonation struct {
+	impersonations ImpersonationService
+
+	log *zerolog.Logger
+}
+
+// NewImpersonation creates an Impersonation controller
+func NewImpersonation(log *zerolog.Logger, impersonations ImpersonationService) *Impersonation {
+	return &Impersonation{
+		impersonations: impersonations,
+
+		log: log,
+	}
+}
+
+// Start gives the admin making the request a short-lived token with which they act as a user, from their id
+func (i *Impersonation) Start(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if personalTokenID, _ := ctx.Get("personalTokenID").(uint); personalTokenID != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "personal access tokens can't be used to impersonate users")
+	}
+
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	token, err := i.impersonations.Start(userID, uint(id), requestClient(ctx))
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errortype.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		err = errors.Wrap(err, "could not impersonate user")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	i.log.Info().Str("actor_id", userID).Uint64("id", id).Msg("user impersonated")
+
+	return ctx.JSON(http.StatusCreated, token)
+}
@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type ImpersonationServiceMock struct {
+	mock.Mock
+}
+
+func (m *ImpersonationServiceMock) Start(actorID string, id uint, client *model.Client) (*model.Token, error) {
+	args := m.Called(actorID, id, client)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Token), args.Error(1)
+}
+
+func TestNewImpersonation(t *testing.T) {
+	impersonationServiceMock := &ImpersonationServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	i := NewImpersonation(log, impersonationServiceMock)
+
+	assert.Equal(t, impersonationServiceMock, i.impersonations, "unexpected impersonation service set")
+	assert.Equal(t, log, i.log, "unexpected logger set")
+}
+
+func TestStartImpersonation(t *testing.T) {
+	token := &model.Token{AccessToken: "x.y.z", TokenType: "Bearer", ExpiresIn: 900, Scope: "posts:read", ImpersonatedBy: "admin"}
+
+	tests := []struct {
+		description string
+
+		id              string
+		personalTokenID uint
+
+		expectCall bool
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "impersonation started",
+
+			id: "42",
+
+			expectCall: true,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: `{"access_token":"x.y.z","token_type":"Bearer","expires_in":900,"scope":"posts:read","impersonated_by":"admin"}`,
+		},
+		{
+			description: "bad request: invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse user ID",
+		},
+		{
+			description: "forbidden: personal access token",
+
+			id:              "42",
+			personalTokenID: 3,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "personal access tokens can't be used to impersonate users",
+		},
+		{
+			description: "not found: user id 0",
+
+			id: "0",
+
+			expectCall: true,
+			serviceErr: errors.Wrap(errortype.ErrNotFound, "could not retrieve user id 0"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "could not retrieve user id 0: resource not found",
+		},
+		{
+			description: "not found: unknown user",
+
+			id: "42",
+
+			expectCall: true,
+			serviceErr: errors.Wrap(errortype.ErrNotFound, "could not retrieve user id 42"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "could not retrieve user id 42: resource not found",
+		},
+		{
+			description: "forbidden: admin",
+
+			id: "42",
+
+			expectCall: true,
+			serviceErr: errors.Wrap(errortype.ErrForbidden, "admins can't be impersonated"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "admins can't be impersonated: forbidden",
+		},
+		{
+			description: "internal server error: service failure",
+
+			id: "42",
+
+			expectCall: true,
+			serviceErr: errors.New("could not record audit event: database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not impersonate user: could not record audit event: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/users/:id/impersonate")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+			ctx.Set("userID", "admin")
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			impersonationServiceMock := &ImpersonationServiceMock{}
+			if test.expectCall {
+				id, _ := strconv.ParseUint(test.id, 10, 64)
+				impersonationServiceMock.
+					On("Start", "admin", uint(id), &model.Client{}).
+					Return(token, test.serviceErr).
+					Once()
+			}
+
+			i := &Impersonation{
+				impersonations: impersonationServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = i.Start(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			impersonationServiceMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// InvitationService represents a service that invites users to register with a given role
+type InvitationService interface {
+	Create(invitedBy, email string, role model.Role) (*model.Invitation, error)
+	List() ([]*model.Invitation, error)
+	Revoke(id uint) error
+	Accept(token string, user *model.User) (*model.User, error)
+}
+
+// Invitation is a controller that is in charge of the invitations of users
+type Invitation struct {
+	invitations  InvitationService
+	tokens       TokenIssuer
+	registration RegistrationPolicy
+	cookies      *CookieAuth
+
+	log *zerolog.Logger
+}
+
+// NewInvitation creates an Invitation controller
+func NewInvitation(log *zerolog.Logger, invitations InvitationService, tokens TokenIssuer, registration RegistrationPolicy, cookies *CookieAuth) *Invitation {
+	return &Invitation{
+		invitations:  invitations,
+		tokens:       tokens,
+		registration: registration,
+		cookies:      cookies,
+
+		log: log,
+	}
+}
+
+// Create invites someone to register with a role, and returns the invitation along with its token
+func (i *Invitation) Create(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, "could not get user ID from context")
+	}
+
+	var invitation model.Invitation
+
+	err := ctx.Bind(&invitation)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse invitation from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(invitation)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	created, err := i.invitations.Create(userID, invitation.Email, invitation.Role)
+	if err != nil {
+		err = errors.Wrap(err, "could not create invitation")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusCreated, created)
+}
+
+// List returns every invitation
+func (i *Invitation) List(ctx echo.Context) error {
+	invitations, err := i.invitations.List()
+	if err != nil {
+		err = errors.Wrap(err, "could not list invitations")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if invitations == nil {
+		invitations = []*model.Invitation{}
+	}
+
+	return ctx.JSON(http.StatusOK, invitations)
+}
+
+// Revoke revokes a pending invitation from its id
+func (i *Invitation) Revoke(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse invitation ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = i.invitations.Revoke(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "pending invitation id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not revoke invitation")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// acceptRequest holds the credentials of an invited user, and their invitation token
+type acceptRequest struct {
+	model.User
+	InviteToken string `json:"invite_token"`
+}
+
+// Accept registers a user with an invitation, and gives them a token
+func (i *Invitation) Accept(ctx echo.Context) error {
+	var request acceptRequest
+
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user data from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(request.User)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	err = i.registration.Check(request.Email, true)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+
+	user, err := i.invitations.Accept(request.InviteToken, &request.User)
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidToken:
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errortype.ErrUnprocessableEntity:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	case errortype.ErrDuplicateEntry:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		err = errors.Wrap(err, "could not accept invitation")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	token, err := i.tokens.LoginUser(user, model.LoginMethodInvitation, requestClient(ctx))
+	if err != nil {
+		err = errors.Wrap(err, "could not log user in")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return i.cookies.respond(ctx, http.StatusCreated, token)
+}
@@ -0,0 +1,556 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type InvitationServiceMock struct {
+	mock.Mock
+}
+
+func (m *InvitationServiceMock) Create(invitedBy, email string, role model.Role) (*model.Invitation, error) {
+	args := m.Called(invitedBy, email, role)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Invitation), args.Error(1)
+}
+
+func (m *InvitationServiceMock) List() ([]*model.Invitation, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Invitation), args.Error(1)
+}
+
+func (m *InvitationServiceMock) Revoke(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *InvitationServiceMock) Accept(token string, user *model.User) (*model.User, error) {
+	args := m.Called(token, user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func TestNewInvitation(t *testing.T) {
+	invitationServiceMock := &InvitationServiceMock{}
+	tokenIssuerMock := &TokenIssuerMock{}
+	registrationMock := &RegistrationPolicyMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	i := NewInvitation(log, invitationServiceMock, tokenIssuerMock, registrationMock, cookies)
+
+	assert.Equal(t, invitationServiceMock, i.invitations, "unexpected invitation service set")
+	assert.Equal(t, tokenIssuerMock, i.tokens, "unexpected token issuer set")
+	assert.Equal(t, registrationMock, i.registration, "unexpected registration policy set")
+	assert.Equal(t, cookies, i.cookies, "unexpected cookie auth set")
+	assert.Equal(t, log, i.log, "unexpected logger set")
+}
+
+func TestCreateInvitation(t *testing.T) {
+	expiresAt := time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+
+		requestBody []byte
+		expectCall  bool
+		email       string
+		role        model.Role
+		serviceErr  error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "invitation created",
+
+			requestBody: []byte(`{"email": "phyllis@vance-refrigeration.com", "role": "editor"}`),
+			expectCall:  true,
+			email:       "phyllis@vance-refrigeration.com",
+			role:        model.RoleEditor,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"email":"phyllis@vance-refrigeration.com","role":"editor","invited_by":"test","expires_at":"2026-10-26T12:00:00Z","created_at":"2026-10-19T12:00:00Z","token":"x.y.z"}`),
+		},
+		{
+			description: "invitation for anyone",
+
+			requestBody: []byte(`{"role": "admin"}`),
+			expectCall:  true,
+			role:        model.RoleAdmin,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"role":"admin","invited_by":"test","expires_at":"2026-10-26T12:00:00Z","created_at":"2026-10-19T12:00:00Z","token":"x.y.z"}`),
+		},
+		{
+			description: "service error",
+
+			requestBody: []byte(`{"role": "reader"}`),
+			expectCall:  true,
+			role:        model.RoleReader,
+			serviceErr:  errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not create invitation: dummy error`),
+		},
+		{
+			description: "invalid role",
+
+			requestBody: []byte(`{"role": "overlord"}`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'Invitation.Role' Error:Field validation for 'Role' failed on the 'oneof' tag`),
+		},
+		{
+			description: "invalid email",
+
+			requestBody: []byte(`{"email": "phyllis", "role": "reader"}`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'Invitation.Email' Error:Field validation for 'Email' failed on the 'email' tag`),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse invitation from request body`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/invitations", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "test")
+
+			invitationServiceMock := &InvitationServiceMock{}
+			if test.expectCall {
+				invitationServiceMock.
+					On("Create", "test", test.email, test.role).
+					Return(&model.Invitation{
+						ID:        1,
+						Email:     test.email,
+						Role:      test.role,
+						InvitedBy: "test",
+						ExpiresAt: expiresAt,
+						CreatedAt: createdAt,
+						Token:     "x.y.z",
+					}, test.serviceErr).
+					Once()
+			}
+
+			i := &Invitation{
+				invitations: invitationServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = i.Create(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			invitationServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListInvitations(t *testing.T) {
+	acceptedAt := time.Date(2026, 10, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+
+		invitations []*model.Invitation
+		serviceErr  error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "invitations listed",
+
+			invitations: []*model.Invitation{
+				{
+					ID:         1,
+					Role:       model.RoleAuthor,
+					InvitedBy:  "test",
+					ExpiresAt:  time.Date(2026, 10, 26, 12, 0, 0, 0, time.UTC),
+					AcceptedAt: &acceptedAt,
+					CreatedAt:  time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+				},
+			},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`[{"id":1,"role":"author","invited_by":"test","expires_at":"2026-10-26T12:00:00Z","accepted_at":"2026-10-20T12:00:00Z","created_at":"2026-10-19T12:00:00Z"}]`),
+		},
+		{
+			description: "no invitation",
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`[]`),
+		},
+		{
+			description: "service error",
+
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not list invitations: dummy error`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/invitations", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			invitationServiceMock := &InvitationServiceMock{}
+			invitationServiceMock.
+				On("List").
+				Return(test.invitations, test.serviceErr).
+				Once()
+
+			i := &Invitation{
+				invitations: invitationServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = i.List(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			invitationServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id         string
+		expectCall bool
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "invitation revoked",
+
+			id:         "1",
+			expectCall: true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "invitation not found or already accepted",
+
+			id:         "1",
+			expectCall: true,
+			serviceErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`pending invitation id 1: resource not found`),
+		},
+		{
+			description: "service error",
+
+			id:         "1",
+			expectCall: true,
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not revoke invitation: dummy error`),
+		},
+		{
+			description: "invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse invitation ID`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.DELETE, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/invitations/:id")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+
+			invitationServiceMock := &InvitationServiceMock{}
+			if test.expectCall {
+				invitationServiceMock.
+					On("Revoke", uint(1)).
+					Return(test.serviceErr).
+					Once()
+			}
+
+			i := &Invitation{
+				invitations: invitationServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = i.Revoke(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			invitationServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	invited := &model.User{
+		ID:          2,
+		TokenUserID: "bloggo|id",
+		Email:       "phyllis@vance-refrigeration.com",
+		Role:        model.RoleEditor,
+	}
+
+	validBody := []byte(`
+		{
+			"invite_token": "inviteToken",
+			"email": "phyllis@vance-refrigeration.com",
+			"password": "refrigerator2000"
+		}
+	`)
+
+	tests := []struct {
+		description string
+
+		requestBody []byte
+		expectCheck bool
+		policyErr   error
+		expectCall  bool
+		acceptErr   error
+		loginErr    error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "invitation accepted",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "invalid invitation",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+			acceptErr:   errors.Wrap(errortype.ErrInvalidToken, "invitation has expired"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`invitation has expired: invalid token`),
+		},
+		{
+			description: "invitation sent to another email address",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+			acceptErr:   errors.Wrap(errortype.ErrUnprocessableEntity, "invitation was sent to another email address"),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`invitation was sent to another email address: unprocessable entity`),
+		},
+		{
+			description: "email already registered",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+			acceptErr:   errortype.ErrDuplicateEntry,
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: []byte(errortype.ErrDuplicateEntry.Error()),
+		},
+		{
+			description: "service error",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+			acceptErr:   errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not accept invitation: database exploded`),
+		},
+		{
+			description: "login error",
+
+			requestBody: validBody,
+			expectCheck: true,
+			expectCall:  true,
+			loginErr:    errors.New("no key"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not log user in: no key`),
+		},
+		{
+			description: "registration is closed",
+
+			requestBody: validBody,
+			expectCheck: true,
+			policyErr:   errors.Wrap(errortype.ErrForbidden, "registration is closed"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`registration is closed: forbidden`),
+		},
+		{
+			description: "invalid password (too short)",
+
+			requestBody: []byte(`
+				{
+					"invite_token": "inviteToken",
+					"email": "phyllis@vance-refrigeration.com",
+					"password": "12345"
+				}
+			`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'User.Password' Error:Field validation for 'Password' failed on the 'min' tag`),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse user data from request body`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/invitations/accept", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			invitationServiceMock := &InvitationServiceMock{}
+			tokenIssuerMock := &TokenIssuerMock{}
+			registrationMock := &RegistrationPolicyMock{}
+			if test.expectCheck {
+				registrationMock.
+					On("Check", "phyllis@vance-refrigeration.com", true).
+					Return(test.policyErr).
+					Once()
+			}
+			if test.expectCall {
+				invitationServiceMock.
+					On("Accept", "inviteToken", &model.User{Email: "phyllis@vance-refrigeration.com", Password: "refrigerator2000"}).
+					Return(invited, test.acceptErr).
+					Once()
+			}
+			if test.expectCall && test.acceptErr == nil {
+				tokenIssuerMock.
+					On("LoginUser", invited, model.LoginMethodInvitation, &model.Client{}).
+					Return(issuedToken, test.loginErr).
+					Once()
+			}
+
+			i := &Invitation{
+				invitations:  invitationServiceMock,
+				tokens:       tokenIssuerMock,
+				registration: registrationMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = i.Accept(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			invitationServiceMock.AssertExpectations(t)
+			tokenIssuerMock.AssertExpectations(t)
+			registrationMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/rs/zerolog"
+)
+
+// jwksCacheControl lets clients cache the key set for as long as it takes Bloggo to pick up new keys
+const jwksCacheControl = "public, max-age=60"
+
+// KeyPublisher represents a set of keys of which the public parts can be published
+type KeyPublisher interface {
+	JWKS() *model.JSONWebKeySet
+}
+
+// Keys is a controller that publishes the keys with which access tokens can be verified
+type Keys struct {
+	keys KeyPublisher
+
+	log *zerolog.Logger
+}
+
+// NewKeys creates a Keys controller that publishes the given keys
+func NewKeys(log *zerolog.Logger, keys KeyPublisher) *Keys {
+	return &Keys{
+		keys: keys,
+
+		log: log,
+	}
+}
+
+// JWKS returns the public keys with which access tokens can be verified
+func (k *Keys) JWKS(ctx echo.Context) error {
+	ctx.Response().Header().Set("Cache-Control", jwksCacheControl)
+	return ctx.JSON(http.StatusOK, k.keys.JWKS())
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type KeyPublisherMock struct {
+	mock.Mock
+}
+
+func (m *KeyPublisherMock) JWKS() *model.JSONWebKeySet {
+	args := m.Called()
+	return args.Get(0).(*model.JSONWebKeySet)
+}
+
+func TestNewKeys(t *testing.T) {
+	keysMock := &KeyPublisherMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	k := NewKeys(log, keysMock)
+
+	assert.Equal(t, keysMock, k.keys, "unexpected key publisher set")
+	assert.Equal(t, log, k.log, "unexpected logger set")
+}
+
+func TestJWKS(t *testing.T) {
+	set := &model.JSONWebKeySet{
+		Keys: []model.JSONWebKey{
+			{KeyType: "OKP", KeyID: "20261019T120000Z", Use: "sig", Algorithm: "EdDSA", Curve: "Ed25519", X: "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		},
+	}
+
+	e := echo.New()
+	r, err := http.NewRequest(echo.GET, "/.well-known/jwks.json", nil)
+	if err != nil {
+		t.Fatal("could not create request")
+	}
+
+	w := httptest.NewRecorder()
+	ctx := e.NewContext(r, w)
+
+	keysMock := &KeyPublisherMock{}
+	keysMock.On("JWKS").Return(set).Once()
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	k := &Keys{
+		keys: keysMock,
+
+		log: log,
+	}
+
+	err = k.JWKS(ctx)
+	if assert.NoError(t, err, "unexpected error") {
+		assert.Equal(t, http.StatusOK, w.Code, "wrong response status")
+		assert.Equal(t, jwksCacheControl, w.Header().Get("Cache-Control"), "wrong cache control header")
+
+		var body map[string][]map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), "response should be JSON")
+		assert.Equal(t, []map[string]string{{
+			"kty": "OKP",
+			"kid": "20261019T120000Z",
+			"use": "sig",
+			"alg": "EdDSA",
+			"crv": "Ed25519",
+			"x":   "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+		}}, body["keys"], "wrong keys published")
+	}
+
+	keysMock.AssertExpectations(t)
+}
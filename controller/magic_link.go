@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// MagicLinkService represents a service that lets users log in with links sent by email
+type MagicLinkService interface {
+	Send(email string, scopes []model.Scope) error
+	Login(token string, client *model.Client) (*model.Token, *model.MFAChallenge, error)
+}
+
+// MagicLink is a controller that is in charge of passwordless logins
+type MagicLink struct {
+	links   MagicLinkService
+	cookies *CookieAuth
+
+	log *zerolog.Logger
+}
+
+// NewMagicLink creates a MagicLink controller
+func NewMagicLink(log *zerolog.Logger, links MagicLinkService, cookies *CookieAuth) *MagicLink {
+	return &MagicLink{
+		links:   links,
+		cookies: cookies,
+
+		log: log,
+	}
+}
+
+// Send sends a magic link by email to the user who wants to log in. The response is
+// the same whether or not the email address has an account.
+func (m *MagicLink) Send(ctx echo.Context) error {
+	var body struct {
+		Email string `json:"email" validate:"required,email"`
+		Scope string `json:"scope"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse email from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	err = m.links.Send(body.Email, model.ParseScopes(body.Scope))
+	if err != nil {
+		err = errors.Wrap(err, "could not send magic link")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Login gives a token to the user who was sent a magic link
+func (m *MagicLink) Login(ctx echo.Context) error {
+	var body struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse magic link token from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	token, challenge, err := m.links.Login(body.Token, requestClient(ctx))
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidToken:
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errortype.ErrInvalidScope:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errortype.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		err = errors.Wrap(err, "could not log in with magic link")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// Users who enabled two-factor authentication complete their login with LoginMFA
+	if challenge != nil {
+		return ctx.JSON(http.StatusOK, challenge)
+	}
+
+	return m.cookies.respond(ctx, http.StatusOK, token)
+}
@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MagicLinkServiceMock struct {
+	mock.Mock
+}
+
+func (m *MagicLinkServiceMock) Send(email string, scopes []model.Scope) error {
+	args := m.Called(email, scopes)
+	return args.Error(0)
+}
+
+func (m *MagicLinkServiceMock) Login(token string, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	args := m.Called(token, client)
+	issued, _ := args.Get(0).(*model.Token)
+	challenge, _ := args.Get(1).(*model.MFAChallenge)
+	return issued, challenge, args.Error(2)
+}
+
+func TestNewMagicLink(t *testing.T) {
+	magicLinkServiceMock := &MagicLinkServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	m := NewMagicLink(log, magicLinkServiceMock, cookies)
+
+	assert.Equal(t, magicLinkServiceMock, m.links, "unexpected magic link service set")
+	assert.Equal(t, cookies, m.cookies, "unexpected cookie auth set")
+	assert.Equal(t, log, m.log, "unexpected logger set")
+}
+
+func TestSendMagicLink(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody    string
+		expectCall     bool
+		expectedScopes []model.Scope
+		sendErr        error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "link sent, or not",
+
+			requestBody: `{"email":"pam@dunder-mifflin.com"}`,
+			expectCall:  true,
+
+			expectedHTTPCode: 202,
+		},
+		{
+			description: "link sent with narrower scopes",
+
+			requestBody:    `{"email":"pam@dunder-mifflin.com","scope":"posts:read"}`,
+			expectCall:     true,
+			expectedScopes: []model.Scope{model.ScopePostsRead},
+
+			expectedHTTPCode: 202,
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"email":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse email from request body",
+		},
+		{
+			description: "unprocessable entity: invalid email",
+
+			requestBody: `{"email":"potato"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Email' failed on the 'email' tag",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"email":"pam@dunder-mifflin.com"}`,
+			expectCall:  true,
+			sendErr:     errors.New("connection refused"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not send magic link: connection refused",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/login/magic-link", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			magicLinkServiceMock := &MagicLinkServiceMock{}
+			if test.expectCall {
+				magicLinkServiceMock.
+					On("Send", "pam@dunder-mifflin.com", test.expectedScopes).
+					Return(test.sendErr).
+					Once()
+			}
+
+			magicLinkController := NewMagicLink(log, magicLinkServiceMock, &CookieAuth{})
+
+			err = magicLinkController.Send(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			magicLinkServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody string
+		expectCall  bool
+		token       *model.Token
+		challenge   *model.MFAChallenge
+		loginErr    error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "logged in",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			token:       &model.Token{AccessToken: "a.b.c", TokenType: "Bearer", ExpiresIn: 900, RefreshToken: "refresh", Scope: "posts:read"},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"access_token":"a.b.c","token_type":"Bearer","expires_in":900,"refresh_token":"refresh","scope":"posts:read"}`,
+		},
+		{
+			description: "two-factor authentication required",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			challenge:   &model.MFAChallenge{MFARequired: true, MFAToken: "mfa", ExpiresIn: 300},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"mfa_required":true,"mfa_token":"mfa","expires_in":300}`,
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"token":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse magic link token from request body",
+		},
+		{
+			description: "unprocessable entity: missing token",
+
+			requestBody: `{}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Token' failed on the 'required' tag",
+		},
+		{
+			description: "unauthorized: link already used",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			loginErr:    errors.Wrap(errortype.ErrInvalidToken, "magic link was already used"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: "magic link was already used: invalid token",
+		},
+		{
+			description: "bad request: scope not granted to the user's role",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			loginErr:    errors.Wrap(errortype.ErrInvalidScope, "scope users:admin can't be granted to reader users"),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "scope users:admin can't be granted to reader users",
+		},
+		{
+			description: "forbidden: account deactivated",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			loginErr:    errors.Wrap(errortype.ErrForbidden, "account is deactivated"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "account is deactivated",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"token":"x.y.z"}`,
+			expectCall:  true,
+			loginErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not log in with magic link: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/login/magic-link/verify", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			magicLinkServiceMock := &MagicLinkServiceMock{}
+			if test.expectCall {
+				magicLinkServiceMock.
+					On("Login", "x.y.z", &model.Client{}).
+					Return(test.token, test.challenge, test.loginErr).
+					Once()
+			}
+
+			magicLinkController := NewMagicLink(log, magicLinkServiceMock, &CookieAuth{})
+
+			err = magicLinkController.Login(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			magicLinkServiceMock.AssertExpectations(t)
+		})
+	}
+}
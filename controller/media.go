@@ -0,0 +1,356 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// mediaTypes are the content types that can be uploaded
+var mediaTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// multipartOverhead is the room left in upload requests for the boundaries and
+// headers of the multipart form, on top of the largest file that can be uploaded
+const multipartOverhead = 64 << 10
+
+// mediaReference matches the URLs of media in the content of blog posts
+var mediaReference = regexp.MustCompile(`/media/([0-9]+)\b`)
+
+// MediaRepository represents a repository that allows to store and read media
+type MediaRepository interface {
+	Store(media *model.Media) (*model.Media, error)
+	Retrieve(id uint) (*model.Media, error)
+	FindByHash(hash string) (*model.Media, error)
+	LinkPost(postID uint, mediaIDs []uint) error
+}
+
+// BlobStore represents a store that holds the content of media
+type BlobStore interface {
+	Put(key string, content []byte, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+}
+
+// MediaProcessor represents a service that processes uploaded images in the background
+type MediaProcessor interface {
+	Process(media *model.Media, content []byte) error
+}
+
+// Media is a controller that is in charge of uploading and serving media
+type Media struct {
+	media     MediaRepository
+	blobs     BlobStore
+	processor MediaProcessor
+
+	maxSize int64
+	path    string
+
+	log *zerolog.Logger
+}
+
+// NewMedia creates a Media controller that stores media records in the given
+// repository and their content in the given blob store. Uploads larger than
+// maxSize bytes are refused. The path is the one under which media are served,
+// and is used to generate the URLs of media.
+func NewMedia(log *zerolog.Logger, mediaRepository MediaRepository, blobs BlobStore, processor MediaProcessor, maxSize int64, path string) *Media {
+	return &Media{
+		media:     mediaRepository,
+		blobs:     blobs,
+		processor: processor,
+
+		maxSize: maxSize,
+		path:    path,
+
+		log: log,
+	}
+}
+
+// Upload stores the file of a multipart form. Files are identified by the hash of
+// their content, so uploading the same file twice returns the existing media.
+func (m *Media) Upload(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// The whole form is read before the size of the file is known, so the request body
+	// is limited to stop clients from sending more than the largest file
+	r := ctx.Request()
+	limit := m.maxSize + multipartOverhead
+	if r.ContentLength > limit {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", m.maxSize))
+	}
+	r.Body = http.MaxBytesReader(ctx.Response(), r.Body, limit)
+
+	header, err := ctx.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", m.maxSize))
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not read file from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if header.Size > m.maxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", m.maxSize))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		err = errors.Wrap(err, "could not open uploaded file")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer file.Close()
+
+	// Never trust the declared size
+	content, err := ioutil.ReadAll(io.LimitReader(file, m.maxSize+1))
+	if err != nil {
+		err = errors.Wrap(err, "could not read uploaded file")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if int64(len(content)) > m.maxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than %d bytes", m.maxSize))
+	}
+	if len(content) == 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "file is empty")
+	}
+
+	// The declared content type is ignored, it is sniffed from the content instead
+	contentType := http.DetectContentType(content)
+	if !mediaTypes[contentType] {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, fmt.Sprintf("unsupported media type %s", contentType))
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+
+	existing, err := m.media.FindByHash(hash)
+	if err == nil {
+		// Give media that could not be processed another chance
+		if existing.Status == model.MediaFailed {
+			m.process(existing, content)
+		}
+		return ctx.JSON(http.StatusOK, m.withURLs(existing))
+	}
+	if errors.Cause(err) != errortype.ErrNotFound {
+		err = errors.Wrap(err, "could not look for existing media")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	media := &model.Media{
+		Hash:        hash,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Uploader:    userID,
+		Status:      model.MediaProcessing,
+		Posts:       []uint{},
+	}
+
+	err = m.blobs.Put(media.BlobKey(), content, contentType)
+	if err != nil {
+		err = errors.Wrap(err, "could not store media content")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	created, err := m.media.Store(media)
+	if errors.Cause(err) == errortype.ErrDuplicateEntry {
+		// The same file was uploaded concurrently
+		existing, err = m.media.FindByHash(hash)
+		if err == nil {
+			return ctx.JSON(http.StatusOK, m.withURLs(existing))
+		}
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not store media")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	m.log.Debug().Uint("id", created.ID).Str("hash", hash).Str("content_type", contentType).Msg("media uploaded")
+
+	m.process(created, content)
+
+	return ctx.JSON(http.StatusCreated, m.withURLs(created))
+}
+
+// Read retrieves a media record from its id
+func (m *Media) Read(ctx echo.Context) error {
+	media, err := m.retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.JSON(http.StatusOK, m.withURLs(media))
+}
+
+// Content serves the content of a media from its id, once its metadata
+// has been stripped.
+func (m *Media) Content(ctx echo.Context) error {
+	media, err := m.retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	return m.serve(ctx, media, media.StrippedKey(), "full")
+}
+
+// Variant serves a resized version of a media from its id and its width
+func (m *Media) Variant(ctx echo.Context) error {
+	media, err := m.retrieve(ctx)
+	if err != nil {
+		return err
+	}
+
+	width, err := strconv.Atoi(ctx.Param("width"))
+	if err != nil {
+		err = errors.Wrap(err, "could not parse variant width")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = checkProcessed(ctx, media)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range media.Widths() {
+		if w == width {
+			return m.serve(ctx, media, media.VariantKey(width), ctx.Param("width"))
+		}
+	}
+
+	// Images smaller than a variant are served at their original size
+	if width >= media.Width {
+		return m.serve(ctx, media, media.StrippedKey(), "full")
+	}
+
+	return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("media id %d has no %dpx variant", media.ID, width))
+}
+
+// serve sends a processed image. Processed images never change, so they can be cached forever.
+func (m *Media) serve(ctx echo.Context, media *model.Media, key, variant string) error {
+	err := checkProcessed(ctx, media)
+	if err != nil {
+		return err
+	}
+
+	etag := `"` + media.Hash + "-" + variant + `"`
+	header := ctx.Response().Header()
+	header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	header.Set("ETag", etag)
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	if notModified(ctx.Request(), etag, media.CreatedAt) {
+		return ctx.NoContent(http.StatusNotModified)
+	}
+
+	blob, err := m.blobs.Get(key)
+	if err != nil {
+		err = errors.Wrap(err, "could not read media content")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer blob.Close()
+
+	contentType := media.ContentType
+	if variant != "full" && contentType == "image/gif" {
+		// Variants of GIF images are single frames
+		contentType = "image/png"
+	}
+
+	return ctx.Stream(http.StatusOK, contentType, blob)
+}
+
+// checkProcessed returns an error if a media is not ready to be served
+func checkProcessed(ctx echo.Context, media *model.Media) error {
+	switch media.Status {
+	case model.MediaReady:
+		return nil
+	case model.MediaProcessing:
+		ctx.Response().Header().Set("Retry-After", "1")
+		return echo.NewHTTPError(http.StatusServiceUnavailable, fmt.Sprintf("media id %d is being processed", media.ID))
+	default:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("media id %d could not be processed", media.ID))
+	}
+}
+
+// process queues an image to be processed. If it can't be, the media
+// is marked as failed, which is all the client needs to know.
+func (m *Media) process(media *model.Media, content []byte) {
+	err := m.processor.Process(media, content)
+	if err != nil {
+		m.log.Warn().Err(err).Uint("id", media.ID).Msg("could not queue media for processing")
+		media.Status = model.MediaFailed
+		return
+	}
+	media.Status = model.MediaProcessing
+}
+
+// withURLs sets the URLs at which the content and the variants of a media are served
+func (m *Media) withURLs(media *model.Media) *model.Media {
+	media.URL = fmt.Sprintf("%s/%d/content", m.path, media.ID)
+
+	var srcset []string
+	media.Variants = nil
+	for _, width := range media.Widths() {
+		url := fmt.Sprintf("%s/%d/w/%d", m.path, media.ID, width)
+		media.Variants = append(media.Variants, model.MediaVariant{Width: width, URL: url})
+		srcset = append(srcset, fmt.Sprintf("%s %dw", url, width))
+	}
+	if media.Width > 0 {
+		srcset = append(srcset, fmt.Sprintf("%s %dw", media.URL, media.Width))
+	}
+	media.SrcSet = strings.Join(srcset, ", ")
+
+	return media
+}
+
+func (m *Media) retrieve(ctx echo.Context) (*model.Media, error) {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse media ID")
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	media, err := m.media.Retrieve(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "media id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not read media")
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return media, nil
+}
+
+// mediaReferences returns the IDs of the media referenced in a blog post's content
+func mediaReferences(content string) []uint {
+	ids := []uint{}
+	seen := make(map[uint]bool)
+	for _, match := range mediaReference.FindAllStringSubmatch(content, -1) {
+		id, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || seen[uint(id)] {
+			continue
+		}
+
+		seen[uint(id)] = true
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
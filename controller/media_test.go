@@ -0,0 +1,662 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type BlobStoreMock struct {
+	mock.Mock
+}
+
+func (m *BlobStoreMock) Put(key string, content []byte, contentType string) error {
+	args := m.Called(key, content, contentType)
+	return args.Error(0)
+}
+
+func (m *BlobStoreMock) Get(key string) (io.ReadCloser, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+type MediaProcessorMock struct {
+	mock.Mock
+}
+
+func (m *MediaProcessorMock) Process(media *model.Media, content []byte) error {
+	args := m.Called(media, content)
+	return args.Error(0)
+}
+
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func testHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func newUploadRequest(t *testing.T, field string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile(field, "picture.png")
+	if err != nil {
+		t.Fatal("could not create multipart form")
+	}
+	part.Write(content)
+	writer.Close()
+
+	r, err := http.NewRequest(echo.POST, "/media", body)
+	if err != nil {
+		t.Fatal("could not create request")
+	}
+	r.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	return r
+}
+
+func TestNewMedia(t *testing.T) {
+	mediaRepositoryMock := &repo.MediaRepositoryMock{}
+	blobStoreMock := &BlobStoreMock{}
+	mediaProcessorMock := &MediaProcessorMock{}
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	m := NewMedia(log, mediaRepositoryMock, blobStoreMock, mediaProcessorMock, 1024, "/api/media")
+
+	assert.Equal(t, mediaRepositoryMock, m.media, "unexpected media repository set")
+	assert.Equal(t, blobStoreMock, m.blobs, "unexpected blob store set")
+	assert.Equal(t, mediaProcessorMock, m.processor, "unexpected media processor set")
+	assert.Equal(t, int64(1024), m.maxSize, "unexpected max size set")
+	assert.Equal(t, "/api/media", m.path, "unexpected path set")
+	assert.Equal(t, log, m.log, "unexpected logger set")
+}
+
+func TestMediaUpload(t *testing.T) {
+	existingMedia := &model.Media{
+		ID:            3,
+		Hash:          testHash(testPNG),
+		ContentType:   "image/png",
+		Size:          int64(len(testPNG)),
+		Uploader:      "faketoken",
+		Status:        model.MediaReady,
+		Width:         1024,
+		VariantWidths: "320",
+		Posts:         []uint{1},
+	}
+
+	tests := []struct {
+		description string
+
+		field         string
+		content       []byte
+		unknownLength bool
+		userIDMissing bool
+
+		existingMedia *model.Media
+		findErr       error
+		putErr        error
+		storeErr      error
+		storedMedia   *model.Media
+		processed     bool
+		processErr    error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "created: new image",
+
+			field:   "file",
+			content: testPNG,
+
+			findErr: errortype.ErrNotFound,
+			storedMedia: &model.Media{
+				ID:          3,
+				Hash:        testHash(testPNG),
+				ContentType: "image/png",
+				Size:        int64(len(testPNG)),
+				Uploader:    "faketoken",
+				Status:      model.MediaProcessing,
+				Posts:       []uint{},
+			},
+			processed: true,
+
+			expectedHTTPCode: http.StatusCreated,
+			expectedHTTPBody: `{"id":3,"hash":"` + testHash(testPNG) + `","content_type":"image/png","size":16,"uploader":"faketoken","created_at":"0001-01-01T00:00:00Z","status":"processing","url":"/api/media/3/content","posts":[]}`,
+		},
+		{
+			description: "created: processing queue is full",
+
+			field:   "file",
+			content: testPNG,
+
+			findErr: errortype.ErrNotFound,
+			storedMedia: &model.Media{
+				ID:     3,
+				Hash:   testHash(testPNG),
+				Status: model.MediaProcessing,
+			},
+			processed:  true,
+			processErr: errors.New("media processing queue is full"),
+
+			expectedHTTPCode: http.StatusCreated,
+			expectedHTTPBody: `"status":"failed"`,
+		},
+		{
+			description: "ok: image already uploaded",
+
+			field:   "file",
+			content: testPNG,
+
+			existingMedia: existingMedia,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: `"srcset":"/api/media/3/w/320 320w, /api/media/3/content 1024w","variants":[{"width":320,"url":"/api/media/3/w/320"}],"posts":[1]`,
+		},
+		{
+			description: "ok: image that could not be processed is processed again",
+
+			field:   "file",
+			content: testPNG,
+
+			existingMedia: &model.Media{
+				ID:     3,
+				Hash:   testHash(testPNG),
+				Status: model.MediaFailed,
+			},
+			processed: true,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: `"status":"processing"`,
+		},
+		{
+			description: "ok: image uploaded concurrently",
+
+			field:   "file",
+			content: testPNG,
+
+			findErr:       errortype.ErrNotFound,
+			storeErr:      errortype.ErrDuplicateEntry,
+			existingMedia: existingMedia,
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: `"id":3`,
+		},
+		{
+			description: "internal server error: could not find userID in context",
+
+			field:         "file",
+			content:       testPNG,
+			userIDMissing: true,
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: "userID not set in request context",
+		},
+		{
+			description: "bad request: missing file",
+
+			field:   "picture",
+			content: testPNG,
+
+			expectedHTTPCode: http.StatusBadRequest,
+			expectedHTTPBody: "could not read file from request body",
+		},
+		{
+			description: "request entity too large",
+
+			field:   "file",
+			content: append(testPNG, make([]byte, 64)...),
+
+			expectedHTTPCode: http.StatusRequestEntityTooLarge,
+			expectedHTTPBody: "file is larger than 64 bytes",
+		},
+		{
+			description: "request entity too large: body larger than the limit",
+
+			field:   "file",
+			content: make([]byte, 128<<10),
+
+			expectedHTTPCode: http.StatusRequestEntityTooLarge,
+			expectedHTTPBody: "file is larger than 64 bytes",
+		},
+		{
+			description: "request entity too large: body of unknown length larger than the limit",
+
+			field:         "file",
+			content:       make([]byte, 128<<10),
+			unknownLength: true,
+
+			expectedHTTPCode: http.StatusRequestEntityTooLarge,
+			expectedHTTPBody: "file is larger than 64 bytes",
+		},
+		{
+			description: "unprocessable entity: empty file",
+
+			field:   "file",
+			content: []byte{},
+
+			expectedHTTPCode: http.StatusUnprocessableEntity,
+			expectedHTTPBody: "file is empty",
+		},
+		{
+			description: "unsupported media type",
+
+			field:   "file",
+			content: []byte("<html><script>alert(1)</script></html>"),
+
+			expectedHTTPCode: http.StatusUnsupportedMediaType,
+			expectedHTTPBody: "unsupported media type text/html; charset=utf-8",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			field:   "file",
+			content: testPNG,
+
+			findErr: errors.New("database exploded"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: "could not look for existing media: database exploded",
+		},
+		{
+			description: "internal server error: blob store failure",
+
+			field:   "file",
+			content: testPNG,
+
+			findErr: errortype.ErrNotFound,
+			putErr:  errors.New("disk full"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: "could not store media content: disk full",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			w := httptest.NewRecorder()
+			r := newUploadRequest(t, test.field, test.content)
+			if test.unknownLength {
+				r.ContentLength = -1
+			}
+			ctx := e.NewContext(r, w)
+
+			if !test.userIDMissing {
+				ctx.Set("userID", "faketoken")
+			}
+
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			if test.findErr != nil {
+				mediaRepositoryMock.
+					On("FindByHash", testHash(test.content)).
+					Return(nil, test.findErr).
+					Once()
+			}
+			if test.existingMedia != nil {
+				mediaRepositoryMock.
+					On("FindByHash", testHash(test.content)).
+					Return(test.existingMedia, nil).
+					Once()
+			}
+			if test.storedMedia != nil || test.storeErr != nil {
+				mediaRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.Media")).
+					Return(test.storedMedia, test.storeErr).
+					Once()
+			}
+
+			blobStoreMock := &BlobStoreMock{}
+			if test.putErr != nil || test.storedMedia != nil || test.storeErr != nil {
+				hash := testHash(test.content)
+				blobStoreMock.
+					On("Put", hash[:2]+"/"+hash, test.content, "image/png").
+					Return(test.putErr).
+					Once()
+			}
+
+			mediaProcessorMock := &MediaProcessorMock{}
+			if test.processed {
+				mediaProcessorMock.
+					On("Process", mock.AnythingOfType("*model.Media"), test.content).
+					Return(test.processErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mediaController := &Media{
+				media:     mediaRepositoryMock,
+				blobs:     blobStoreMock,
+				processor: mediaProcessorMock,
+				maxSize:   64,
+				path:      "/api/media",
+
+				log: log,
+			}
+
+			err := mediaController.Upload(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Contains(t, w.Body.String(), test.expectedHTTPBody, "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mediaRepositoryMock.AssertExpectations(t)
+			blobStoreMock.AssertExpectations(t)
+			mediaProcessorMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMediaRead(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id             string
+		retrievedMedia *model.Media
+		repositoryErr  error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "ok: media exists",
+
+			id: "3",
+			retrievedMedia: &model.Media{
+				ID:            3,
+				Hash:          testHash(testPNG),
+				ContentType:   "image/png",
+				Size:          16,
+				Status:        model.MediaReady,
+				Width:         2000,
+				Height:        1000,
+				Blurhash:      "LEHV6nWB2yk8pyo0adR*.7kCMdnj",
+				DominantColor: "#ff0000",
+				VariantWidths: "320,640",
+				Posts:         []uint{1, 2},
+			},
+
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: `"content_type":"image/png","size":16,"created_at":"0001-01-01T00:00:00Z","status":"ready","width":2000,"height":1000,"blurhash":"LEHV6nWB2yk8pyo0adR*.7kCMdnj","dominant_color":"#ff0000","url":"/api/media/3/content","srcset":"/api/media/3/w/320 320w, /api/media/3/w/640 640w, /api/media/3/content 2000w","variants":[{"width":320,"url":"/api/media/3/w/320"},{"width":640,"url":"/api/media/3/w/640"}],"posts":[1,2]}`,
+		},
+		{
+			description: "bad request: invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: http.StatusBadRequest,
+			expectedHTTPBody: "could not parse media ID",
+		},
+		{
+			description: "not found",
+
+			id:            "3",
+			repositoryErr: &ResourceNotFoundErr{},
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: "media id 3: resource not found",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			id:            "3",
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: "could not read media: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/media/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			if test.retrievedMedia != nil || test.repositoryErr != nil {
+				mediaRepositoryMock.
+					On("Retrieve", uint(3)).
+					Return(test.retrievedMedia, test.repositoryErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mediaController := &Media{
+				media: mediaRepositoryMock,
+				path:  "/api/media",
+
+				log: log,
+			}
+
+			err = mediaController.Read(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Contains(t, w.Body.String(), test.expectedHTTPBody, "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mediaRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMediaContent(t *testing.T) {
+	hash := testHash(testPNG)
+	media := &model.Media{
+		ID:            3,
+		Hash:          hash,
+		ContentType:   "image/png",
+		Size:          16,
+		Status:        model.MediaReady,
+		Width:         1000,
+		VariantWidths: "320,640",
+		CreatedAt:     time.Date(2018, 8, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		description string
+
+		width       string
+		status      string
+		ifNoneMatch string
+		blobErr     error
+
+		expectedKey      string
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "ok: stripped content is served",
+
+			expectedKey:      "variants/" + hash[:2] + "/" + hash + "/full",
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: string(testPNG),
+		},
+		{
+			description: "ok: variant is served",
+
+			width: "640",
+
+			expectedKey:      "variants/" + hash[:2] + "/" + hash + "/640",
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: string(testPNG),
+		},
+		{
+			description: "ok: variant larger than the image is the image itself",
+
+			width: "1280",
+
+			expectedKey:      "variants/" + hash[:2] + "/" + hash + "/full",
+			expectedHTTPCode: http.StatusOK,
+			expectedHTTPBody: string(testPNG),
+		},
+		{
+			description: "not modified",
+
+			ifNoneMatch: `"` + hash + `-full"`,
+
+			expectedHTTPCode: http.StatusNotModified,
+		},
+		{
+			description: "not found: unknown variant",
+
+			width: "500",
+
+			expectedHTTPCode: http.StatusNotFound,
+			expectedHTTPBody: "media id 3 has no 500px variant",
+		},
+		{
+			description: "bad request: invalid width",
+
+			width: "potato",
+
+			expectedHTTPCode: http.StatusBadRequest,
+			expectedHTTPBody: "could not parse variant width",
+		},
+		{
+			description: "service unavailable: media is being processed",
+
+			status: model.MediaProcessing,
+
+			expectedHTTPCode: http.StatusServiceUnavailable,
+			expectedHTTPBody: "media id 3 is being processed",
+		},
+		{
+			description: "unprocessable entity: media could not be processed",
+
+			width:  "640",
+			status: model.MediaFailed,
+
+			expectedHTTPCode: http.StatusUnprocessableEntity,
+			expectedHTTPBody: "media id 3 could not be processed",
+		},
+		{
+			description: "internal server error: blob store failure",
+
+			blobErr: errors.New("bucket exploded"),
+
+			expectedKey:      "variants/" + hash[:2] + "/" + hash + "/full",
+			expectedHTTPCode: http.StatusInternalServerError,
+			expectedHTTPBody: "could not read media content: bucket exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/media/3/content", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			if test.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id", "width")
+			ctx.SetParamValues("3", test.width)
+
+			retrieved := *media
+			if test.status != "" {
+				retrieved.Status = test.status
+			}
+
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			mediaRepositoryMock.
+				On("Retrieve", uint(3)).
+				Return(&retrieved, nil).
+				Once()
+
+			blobStoreMock := &BlobStoreMock{}
+			if test.expectedKey != "" {
+				var blob io.ReadCloser
+				if test.blobErr == nil {
+					blob = ioutil.NopCloser(bytes.NewReader(testPNG))
+				}
+				blobStoreMock.
+					On("Get", test.expectedKey).
+					Return(blob, test.blobErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mediaController := &Media{
+				media: mediaRepositoryMock,
+				blobs: blobStoreMock,
+
+				log: log,
+			}
+
+			if test.width == "" {
+				err = mediaController.Content(ctx)
+			} else {
+				err = mediaController.Variant(ctx)
+			}
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, w.Body.String(), "wrong response body")
+				assert.Equal(t, "public, max-age=31536000, immutable", w.Header().Get("Cache-Control"), "wrong cache control header")
+				assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"), "wrong content type options header")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mediaRepositoryMock.AssertExpectations(t)
+			blobStoreMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMediaReferences(t *testing.T) {
+	assert.Equal(t, []uint{}, mediaReferences("no pictures here"))
+	assert.Equal(t, []uint{3, 7}, mediaReferences("![](/api/media/3/content) ![](https://blog.example.com/api/media/7/content) ![](/api/media/3/content)"))
+	assert.Equal(t, []uint{}, mediaReferences("/media/potato /multimedia/42x"))
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// MFAService represents a service that lets users enroll in two-factor authentication
+type MFAService interface {
+	Enroll(userID string) (*model.MFAEnrollment, error)
+	Confirm(userID, code string) ([]string, error)
+	Disable(userID, code string) error
+}
+
+// MFA is a controller that lets users enable and disable two-factor authentication
+type MFA struct {
+	mfa MFAService
+
+	log *zerolog.Logger
+}
+
+// NewMFA creates an MFA controller
+func NewMFA(log *zerolog.Logger, mfa MFAService) *MFA {
+	return &MFA{
+		mfa: mfa,
+
+		log: log,
+	}
+}
+
+// mfaCodeRequest holds a TOTP code or a recovery code
+type mfaCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// Enroll starts the enrollment of the user making the request in two-factor authentication, and returns
+// their TOTP secret along with its provisioning URI
+func (m *MFA) Enroll(ctx echo.Context) error {
+	userID, err := mfaUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	enrollment, err := m.mfa.Enroll(userID)
+	if errors.Cause(err) == errortype.ErrConflict {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not enroll in two-factor authentication")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, enrollment)
+}
+
+// Confirm enables the two-factor authentication of the user making the request, with a code of
+// their authenticator app, and returns their recovery codes
+func (m *MFA) Confirm(ctx echo.Context) error {
+	userID, err := mfaUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	request, err := bindMFACode(ctx)
+	if err != nil {
+		return err
+	}
+
+	codes, err := m.mfa.Confirm(userID, request.Code)
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidCredentials:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "invalid code")
+	case errortype.ErrConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		err = errors.Wrap(err, "could not enable two-factor authentication")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{codes})
+}
+
+// Disable disables the two-factor authentication of the user making the request, with a code of
+// their authenticator app or a recovery code
+func (m *MFA) Disable(ctx echo.Context) error {
+	userID, err := mfaUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	request, err := bindMFACode(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = m.mfa.Disable(userID, request.Code)
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidCredentials:
+		return echo.NewHTTPError(http.StatusForbidden, "invalid code")
+	case errortype.ErrConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		err = errors.Wrap(err, "could not disable two-factor authentication")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// mfaUserID returns the ID of the user making the request. Personal access tokens can't
+// be used to manage two-factor authentication, since they don't go through it, and
+// impersonation tokens can't either.
+func mfaUserID(ctx echo.Context) (string, error) {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return "", echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if personalTokenID, _ := ctx.Get("personalTokenID").(uint); personalTokenID != 0 {
+		return "", echo.NewHTTPError(http.StatusForbidden, "personal access tokens can't be used to manage two-factor authentication")
+	}
+	if err := forbidImpersonation(ctx, "manage two-factor authentication"); err != nil {
+		return "", err
+	}
+
+	return userID, nil
+}
+
+// bindMFACode parses and validates the code in the body of the request
+func bindMFACode(ctx echo.Context) (*mfaCodeRequest, error) {
+	var request mfaCodeRequest
+
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse code from request body")
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(request)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	return &request, nil
+}
@@ -0,0 +1,360 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MFAServiceMock struct {
+	mock.Mock
+}
+
+func (m *MFAServiceMock) Enroll(userID string) (*model.MFAEnrollment, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.MFAEnrollment), args.Error(1)
+}
+
+func (m *MFAServiceMock) Confirm(userID, code string) ([]string, error) {
+	args := m.Called(userID, code)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MFAServiceMock) Disable(userID, code string) error {
+	args := m.Called(userID, code)
+	return args.Error(0)
+}
+
+func TestNewMFA(t *testing.T) {
+	mfaMock := &MFAServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	m := NewMFA(log, mfaMock)
+
+	assert.Equal(t, mfaMock, m.mfa, "unexpected MFA service set")
+	assert.Equal(t, log, m.log, "unexpected logger set")
+}
+
+func TestEnrollMFA(t *testing.T) {
+	tests := []struct {
+		description string
+
+		personalTokenID uint
+		enrollment      *model.MFAEnrollment
+		enrollErr       error
+
+		expectEnroll     bool
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "enrollment started",
+
+			enrollment: &model.MFAEnrollment{Secret: "GEZDGNBV", URI: "otpauth://totp/Bloggo:pam@dunder-mifflin.com?secret=GEZDGNBV"},
+
+			expectEnroll:     true,
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"secret":"GEZDGNBV","uri":"otpauth://totp/Bloggo:pam@dunder-mifflin.com?secret=GEZDGNBV"}`,
+		},
+		{
+			description: "forbidden: personal access token",
+
+			personalTokenID: 7,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "personal access tokens can't be used to manage two-factor authentication",
+		},
+		{
+			description: "conflict: already enabled",
+
+			enrollErr: errors.Wrap(errortype.ErrConflict, "two-factor authentication is already enabled"),
+
+			expectEnroll:     true,
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "two-factor authentication is already enabled",
+		},
+		{
+			description: "internal server error: service failure",
+
+			enrollErr: errors.New("database exploded"),
+
+			expectEnroll:     true,
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not enroll in two-factor authentication: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/me/mfa", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mfaMock := &MFAServiceMock{}
+			if test.expectEnroll {
+				mfaMock.
+					On("Enroll", "abc").
+					Return(test.enrollment, test.enrollErr).
+					Once()
+			}
+
+			m := NewMFA(log, mfaMock)
+
+			err = m.Enroll(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mfaMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConfirmMFA(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody string
+		codes       []string
+		confirmErr  error
+
+		expectConfirm    bool
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "two-factor authentication enabled",
+
+			requestBody: `{"code":"123456"}`,
+			codes:       []string{"0a1b-2c3d-4e5f-6a7b", "8c9d-0e1f-2a3b-4c5d"},
+
+			expectConfirm:    true,
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"recovery_codes":["0a1b-2c3d-4e5f-6a7b","8c9d-0e1f-2a3b-4c5d"]}`,
+		},
+		{
+			description: "unprocessable entity: invalid code",
+
+			requestBody: `{"code":"123456"}`,
+			confirmErr:  errors.Wrap(errortype.ErrInvalidCredentials, "invalid code"),
+
+			expectConfirm:    true,
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "invalid code",
+		},
+		{
+			description: "conflict: enrollment not started",
+
+			requestBody: `{"code":"123456"}`,
+			confirmErr:  errors.Wrap(errortype.ErrConflict, "enrollment in two-factor authentication was not started"),
+
+			expectConfirm:    true,
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "enrollment in two-factor authentication was not started",
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"code":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse code from request body",
+		},
+		{
+			description: "unprocessable entity: missing code",
+
+			requestBody: `{}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Code' failed on the 'required' tag",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"code":"123456"}`,
+			confirmErr:  errors.New("database exploded"),
+
+			expectConfirm:    true,
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not enable two-factor authentication: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/me/mfa/confirm", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("personalTokenID", uint(0))
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mfaMock := &MFAServiceMock{}
+			if test.expectConfirm {
+				mfaMock.
+					On("Confirm", "abc", "123456").
+					Return(test.codes, test.confirmErr).
+					Once()
+			}
+
+			m := NewMFA(log, mfaMock)
+
+			err = m.Confirm(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mfaMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDisableMFA(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody     string
+		personalTokenID uint
+		disableErr      error
+
+		expectDisable    bool
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "two-factor authentication disabled",
+
+			requestBody: `{"code":"123456"}`,
+
+			expectDisable:    true,
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "forbidden: invalid code",
+
+			requestBody: `{"code":"123456"}`,
+			disableErr:  errors.Wrap(errortype.ErrInvalidCredentials, "invalid code"),
+
+			expectDisable:    true,
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "invalid code",
+		},
+		{
+			description: "forbidden: personal access token",
+
+			requestBody:     `{"code":"123456"}`,
+			personalTokenID: 7,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "personal access tokens can't be used to manage two-factor authentication",
+		},
+		{
+			description: "conflict: not enabled",
+
+			requestBody: `{"code":"123456"}`,
+			disableErr:  errors.Wrap(errortype.ErrConflict, "two-factor authentication is not enabled"),
+
+			expectDisable:    true,
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "two-factor authentication is not enabled",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"code":"123456"}`,
+			disableErr:  errors.New("database exploded"),
+
+			expectDisable:    true,
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not disable two-factor authentication: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/me/mfa/disable", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mfaMock := &MFAServiceMock{}
+			if test.expectDisable {
+				mfaMock.
+					On("Disable", "abc", "123456").
+					Return(test.disableErr).
+					Once()
+			}
+
+			m := NewMFA(log, mfaMock)
+
+			err = m.Disable(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			mfaMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// oidcStateCookie is the cookie that binds a login with an identity provider to the browser that started it
+const oidcStateCookie = "bloggo_oidc_state"
+
+// oidcModeCookie is the cookie that remembers whether the browser that started a login
+// with an identity provider wants its tokens in cookies
+const oidcModeCookie = "bloggo_oidc_mode"
+
+// OIDCService represents a service with which users log in using OpenID Connect identity providers
+type OIDCService interface {
+	Start(provider string) (string, string, error)
+	Callback(provider, state, code string, client *model.Client) (*model.Token, *model.MFAChallenge, error)
+}
+
+// OIDC is a controller that logs users in with OpenID Connect identity providers
+type OIDC struct {
+	oidc    OIDCService
+	cookies *CookieAuth
+
+	// cookiePath is the path of the routes to which the state cookie is sent
+	cookiePath   string
+	secureCookie bool
+	loginTTL     time.Duration
+
+	log *zerolog.Logger
+}
+
+// NewOIDC creates an OIDC controller. The state cookie is only sent to the routes under cookiePath,
+// over HTTPS if secureCookie is set, and expires after loginTTL. Browsers that ask for it get their
+// tokens in the cookies set by cookies.
+func NewOIDC(log *zerolog.Logger, oidc OIDCService, cookies *CookieAuth, cookiePath string, secureCookie bool, loginTTL time.Duration) *OIDC {
+	return &OIDC{
+		oidc:         oidc,
+		cookies:      cookies,
+		cookiePath:   cookiePath,
+		secureCookie: secureCookie,
+		loginTTL:     loginTTL,
+
+		log: log,
+	}
+}
+
+// Login redirects the user to the login page of an identity provider. Browsers that
+// want their tokens in cookies set the mode query parameter to cookie.
+func (o *OIDC) Login(ctx echo.Context) error {
+	provider := ctx.Param("provider")
+
+	authURL, state, err := o.oidc.Start(provider)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not start login")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// The callback only accepts the state of the browser that started the login, so
+	// that users can't be made to log in with the account of someone else
+	ctx.SetCookie(o.loginCookie(oidcStateCookie, state, int(o.loginTTL/time.Second)))
+
+	// Browsers can't set headers on the navigation back from the identity provider
+	if ctx.QueryParam("mode") == authModeCookie {
+		ctx.SetCookie(o.loginCookie(oidcModeCookie, authModeCookie, int(o.loginTTL/time.Second)))
+	}
+
+	return ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes a login with an identity provider, and gives a token to the user
+func (o *OIDC) Callback(ctx echo.Context) error {
+	provider := ctx.Param("provider")
+
+	// The state can only be used once
+	ctx.SetCookie(o.loginCookie(oidcStateCookie, "", -1))
+
+	withCookies := cookieValue(ctx, oidcModeCookie) == authModeCookie
+	if withCookies {
+		ctx.SetCookie(o.loginCookie(oidcModeCookie, "", -1))
+	}
+
+	if code := ctx.QueryParam("error"); code != "" {
+		message := fmt.Sprintf("identity provider returned an error: %s %s", code, ctx.QueryParam("error_description"))
+		return echo.NewHTTPError(http.StatusUnauthorized, message)
+	}
+
+	state := ctx.QueryParam("state")
+	code := ctx.QueryParam("code")
+	if state == "" || code == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing state or code")
+	}
+
+	cookie, err := ctx.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized, "login was not started by this browser")
+	}
+
+	token, challenge, err := o.oidc.Callback(provider, state, code, requestClient(ctx))
+	switch errors.Cause(err) {
+	case nil:
+		// Users who enabled two-factor authentication complete their login with LoginMFA
+		if challenge != nil {
+			return ctx.JSON(http.StatusOK, challenge)
+		}
+		if withCookies {
+			return o.cookies.respondWithCookies(ctx, http.StatusOK, token)
+		}
+		return ctx.JSON(http.StatusOK, token)
+	case errortype.ErrNotFound:
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errortype.ErrInvalidToken:
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errortype.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errortype.ErrConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errortype.ErrUnprocessableEntity:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	default:
+		err = errors.Wrap(err, "could not complete login")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+}
+
+// loginCookie returns a cookie that lasts for the duration of a login
+func (o *OIDC) loginCookie(name, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     o.cookiePath,
+		MaxAge:   maxAge,
+		Secure:   o.secureCookie,
+		HttpOnly: true,
+		// Identity providers send users back with a top-level navigation
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,370 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type OIDCServiceMock struct {
+	mock.Mock
+}
+
+func (m *OIDCServiceMock) Start(provider string) (string, string, error) {
+	args := m.Called(provider)
+	return args.String(0), args.String(1), args.Error(2)
+}
+
+func (m *OIDCServiceMock) Callback(provider, state, code string, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	args := m.Called(provider, state, code, client)
+	token, _ := args.Get(0).(*model.Token)
+	challenge, _ := args.Get(1).(*model.MFAChallenge)
+	return token, challenge, args.Error(2)
+}
+
+func TestNewOIDC(t *testing.T) {
+	oidcServiceMock := &OIDCServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	o := NewOIDC(log, oidcServiceMock, cookies, "/api/oidc", true, 10*time.Minute)
+
+	assert.Equal(t, oidcServiceMock, o.oidc, "unexpected oidc service set")
+	assert.Equal(t, "/api/oidc", o.cookiePath, "unexpected cookie path set")
+	assert.Equal(t, true, o.secureCookie, "unexpected cookie security set")
+	assert.Equal(t, 10*time.Minute, o.loginTTL, "unexpected login TTL set")
+	assert.Equal(t, cookies, o.cookies, "unexpected cookie auth set")
+	assert.Equal(t, log, o.log, "unexpected logger set")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	tests := []struct {
+		description string
+
+		query    string
+		startErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+		expectModeCookie bool
+	}{
+		{
+			description: "redirected to the identity provider",
+
+			expectedHTTPCode: 302,
+		},
+		{
+			description: "redirected to the identity provider with cookie mode",
+
+			query: "mode=cookie",
+
+			expectedHTTPCode: 302,
+			expectModeCookie: true,
+		},
+		{
+			description: "unknown provider",
+
+			startErr: errors.Wrap(errortype.ErrNotFound, "identity provider example"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`identity provider example: resource not found`),
+		},
+		{
+			description: "service error",
+
+			startErr: errors.New("connection refused"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not start login: connection refused`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/?"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/oidc/:provider/login")
+			ctx.SetParamNames("provider")
+			ctx.SetParamValues("example")
+
+			oidcServiceMock := &OIDCServiceMock{}
+			oidcServiceMock.
+				On("Start", "example").
+				Return("https://idp.example.com/authorize?state=state", "state", test.startErr).
+				Once()
+
+			o := &OIDC{
+				oidc:         oidcServiceMock,
+				cookiePath:   "/api/oidc",
+				secureCookie: true,
+				loginTTL:     10 * time.Minute,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = o.Login(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, "https://idp.example.com/authorize?state=state", w.Header().Get(echo.HeaderLocation), "wrong redirection")
+
+				cookie := w.Header().Get(echo.HeaderSetCookie)
+				assert.Contains(t, cookie, "bloggo_oidc_state=state", "state cookie should be set")
+				assert.Contains(t, cookie, "Path=/api/oidc", "state cookie should only be sent to the oidc routes")
+				assert.Contains(t, cookie, "Max-Age=600", "state cookie should expire with the login")
+				assert.Contains(t, cookie, "HttpOnly", "state cookie should not be readable by scripts")
+				assert.Contains(t, cookie, "Secure", "state cookie should only be sent over HTTPS")
+				assert.Contains(t, cookie, "SameSite=Lax", "state cookie should be sent when the provider redirects back")
+
+				mode, ok := responseCookies(w)[oidcModeCookie]
+				assert.Equal(t, test.expectModeCookie, ok, "wrong mode cookie")
+				if ok {
+					assert.Equal(t, "cookie", mode, "wrong auth mode remembered")
+				}
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			oidcServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	tests := []struct {
+		description string
+
+		query       string
+		cookie      string
+		modeCookie  bool
+		expectCall  bool
+		challenge   *model.MFAChallenge
+		callbackErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+		expectCookies    bool
+	}{
+		{
+			description: "login completed",
+
+			query:      "state=state&code=code",
+			cookie:     "state",
+			expectCall: true,
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "login completed with cookie mode",
+
+			query:      "state=state&code=code",
+			cookie:     "state",
+			modeCookie: true,
+			expectCall: true,
+
+			expectedHTTPCode: 200,
+			expectCookies:    true,
+		},
+		{
+			description: "second factor required",
+
+			query:      "state=state&code=code",
+			cookie:     "state",
+			modeCookie: true,
+			expectCall: true,
+			challenge:  &model.MFAChallenge{MFARequired: true, MFAToken: "mfa", ExpiresIn: 300},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`{"mfa_required":true,"mfa_token":"mfa","expires_in":300}`),
+		},
+		{
+			description: "state of another browser",
+
+			query:  "state=state&code=code",
+			cookie: "another-state",
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`login was not started by this browser`),
+		},
+		{
+			description: "missing state cookie",
+
+			query: "state=state&code=code",
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`login was not started by this browser`),
+		},
+		{
+			description: "missing code",
+
+			query:  "state=state",
+			cookie: "state",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`missing state or code`),
+		},
+		{
+			description: "login denied by the user",
+
+			query:  "state=state&error=access_denied&error_description=User+cancelled",
+			cookie: "state",
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`identity provider returned an error: access_denied User cancelled`),
+		},
+		{
+			description: "unknown provider",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.Wrap(errortype.ErrNotFound, "identity provider example"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`identity provider example: resource not found`),
+		},
+		{
+			description: "invalid login",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.Wrap(errortype.ErrInvalidToken, "login has expired"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`login has expired: invalid token`),
+		},
+		{
+			description: "registration closed",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.Wrap(errortype.ErrForbidden, "registration is closed"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`registration is closed: forbidden`),
+		},
+		{
+			description: "account with an unverified email",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.Wrap(errortype.ErrConflict, "an account already exists for jane@example.com, which the identity provider did not verify"),
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: []byte(`an account already exists for jane@example.com, which the identity provider did not verify: datamodel conflict`),
+		},
+		{
+			description: "identity without email",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.Wrap(errortype.ErrUnprocessableEntity, "identity provider did not share an email address"),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`identity provider did not share an email address: unprocessable entity`),
+		},
+		{
+			description: "service error",
+
+			query:       "state=state&code=code",
+			cookie:      "state",
+			expectCall:  true,
+			callbackErr: errors.New("connection refused"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not complete login: connection refused`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/?"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			if test.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "bloggo_oidc_state", Value: test.cookie})
+			}
+			if test.modeCookie {
+				r.AddCookie(&http.Cookie{Name: "bloggo_oidc_mode", Value: "cookie"})
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/oidc/:provider/callback")
+			ctx.SetParamNames("provider")
+			ctx.SetParamValues("example")
+
+			oidcServiceMock := &OIDCServiceMock{}
+			if test.expectCall {
+				token := issuedToken
+				if test.challenge != nil {
+					token = nil
+				}
+				oidcServiceMock.
+					On("Callback", "example", "state", "code", &model.Client{}).
+					Return(token, test.challenge, test.callbackErr).
+					Once()
+			}
+
+			o := &OIDC{
+				oidc:       oidcServiceMock,
+				cookies:    NewCookieAuth("/api", true, time.Hour),
+				cookiePath: "/api/oidc",
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = o.Callback(ctx)
+
+			// The state cookie is always cleared
+			assert.Contains(t, w.Header().Get(echo.HeaderSetCookie), "Max-Age=0", "state cookie should be cleared")
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				if test.expectCookies {
+					cookies := responseCookies(w)
+					assert.Equal(t, "", cookies[oidcModeCookie], "mode cookie should be cleared")
+					assert.Equal(t, issuedToken.AccessToken, cookies[accessTokenCookie], "wrong access token cookie")
+					assert.Equal(t, issuedToken.RefreshToken, cookies[refreshTokenCookie], "wrong refresh token cookie")
+					assert.NotContains(t, w.Body.String(), issuedToken.RefreshToken, "tokens should not be in the response body")
+				} else {
+					assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+				}
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			oidcServiceMock.AssertExpectations(t)
+		})
+	}
+}
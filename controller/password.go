@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// PasswordResetService represents a service that lets users who forgot their password choose a new one
+type PasswordResetService interface {
+	Forgot(email string) error
+	Reset(token, password string) error
+}
+
+// Password is a controller that is in charge of resetting forgotten passwords
+type Password struct {
+	resets PasswordResetService
+
+	log *zerolog.Logger
+}
+
+// NewPassword creates a Password controller
+func NewPassword(log *zerolog.Logger, resets PasswordResetService) *Password {
+	return &Password{
+		resets: resets,
+
+		log: log,
+	}
+}
+
+// Forgot sends a reset token by email to the user who forgot their password. The response is
+// the same whether or not the email address has an account.
+func (p *Password) Forgot(ctx echo.Context) error {
+	var body struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse email from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	err = p.resets.Forgot(body.Email)
+	if err != nil {
+		err = errors.Wrap(err, "could not send password reset")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// Reset replaces the password of a user with a reset token
+func (p *Password) Reset(ctx echo.Context) error {
+	var body struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=10"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse password reset from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	err = p.resets.Reset(body.Token, body.Password)
+	if errors.Cause(err) == errortype.ErrInvalidToken {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not reset password")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
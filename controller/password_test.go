@@ -0,0 +1,234 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type PasswordResetServiceMock struct {
+	mock.Mock
+}
+
+func (m *PasswordResetServiceMock) Forgot(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *PasswordResetServiceMock) Reset(token, password string) error {
+	args := m.Called(token, password)
+	return args.Error(0)
+}
+
+func TestNewPassword(t *testing.T) {
+	passwordResetServiceMock := &PasswordResetServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	p := NewPassword(log, passwordResetServiceMock)
+
+	assert.Equal(t, passwordResetServiceMock, p.resets, "unexpected password reset service set")
+	assert.Equal(t, log, p.log, "unexpected logger set")
+}
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody string
+		expectCall  bool
+		forgotErr   error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "reset sent, or not",
+
+			requestBody: `{"email":"pam@dunder-mifflin.com"}`,
+			expectCall:  true,
+
+			expectedHTTPCode: 202,
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"email":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse email from request body",
+		},
+		{
+			description: "unprocessable entity: invalid email",
+
+			requestBody: `{"email":"potato"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Email' failed on the 'email' tag",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"email":"pam@dunder-mifflin.com"}`,
+			expectCall:  true,
+			forgotErr:   errors.New("connection refused"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not send password reset: connection refused",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/password/forgot", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			passwordResetServiceMock := &PasswordResetServiceMock{}
+			if test.expectCall {
+				passwordResetServiceMock.
+					On("Forgot", "pam@dunder-mifflin.com").
+					Return(test.forgotErr).
+					Once()
+			}
+
+			passwordController := NewPassword(log, passwordResetServiceMock)
+
+			err = passwordController.Forgot(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			passwordResetServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody string
+		expectCall  bool
+		resetErr    error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "password reset",
+
+			requestBody: `{"token":"resettoken","password":"halpert1234"}`,
+			expectCall:  true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"token":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse password reset from request body",
+		},
+		{
+			description: "unprocessable entity: password too short",
+
+			requestBody: `{"token":"resettoken","password":"short"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Password' failed on the 'min' tag",
+		},
+		{
+			description: "unprocessable entity: missing token",
+
+			requestBody: `{"password":"halpert1234"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Token' failed on the 'required' tag",
+		},
+		{
+			description: "unauthorized: invalid token",
+
+			requestBody: `{"token":"resettoken","password":"halpert1234"}`,
+			expectCall:  true,
+			resetErr:    errors.Wrap(errortype.ErrInvalidToken, "reset token is expired"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: "reset token is expired: invalid token",
+		},
+		{
+			description: "internal server error: service failure",
+
+			requestBody: `{"token":"resettoken","password":"halpert1234"}`,
+			expectCall:  true,
+			resetErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not reset password: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/password/reset", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			passwordResetServiceMock := &PasswordResetServiceMock{}
+			if test.expectCall {
+				passwordResetServiceMock.
+					On("Reset", "resettoken", "halpert1234").
+					Return(test.resetErr).
+					Once()
+			}
+
+			passwordController := NewPassword(log, passwordResetServiceMock)
+
+			err = passwordController.Reset(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			passwordResetServiceMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// PersonalTokenService represents a service to manage the personal access tokens of users
+type PersonalTokenService interface {
+	Create(owner *model.User, name string, scopes []model.Scope, expiresAt *time.Time) (*model.PersonalAccessToken, error)
+	List(userID string) ([]*model.PersonalAccessToken, error)
+	Revoke(userID string, id uint) error
+}
+
+// PersonalTokens is a controller that lets users manage their personal access tokens
+type PersonalTokens struct {
+	tokens PersonalTokenService
+
+	log *zerolog.Logger
+}
+
+// NewPersonalTokens creates a PersonalTokens controller
+func NewPersonalTokens(log *zerolog.Logger, tokens PersonalTokenService) *PersonalTokens {
+	return &PersonalTokens{
+		tokens: tokens,
+
+		log: log,
+	}
+}
+
+// Create creates a personal access token for the user making the request. Personal access
+// tokens can't be used to create other tokens, so that a leaked token can't outlive its revocation.
+func (p *PersonalTokens) Create(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	role, ok := ctx.Get("role").(model.Role)
+	if !ok {
+		err := errors.New("role not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if personalTokenID, _ := ctx.Get("personalTokenID").(uint); personalTokenID != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "personal access tokens can't be used to create personal access tokens")
+	}
+	if err := forbidImpersonation(ctx, "create personal access tokens"); err != nil {
+		return err
+	}
+
+	var request model.PersonalAccessToken
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse personal access token from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	owner := &model.User{TokenUserID: userID, Role: role}
+	token, err := p.tokens.Create(owner, request.Name, model.ParseScopes(request.Scope), request.ExpiresAt)
+	if errors.Cause(err) == errortype.ErrInvalidScope {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Cause(err) == errortype.ErrUnprocessableEntity {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not create personal access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.JSON(http.StatusCreated, token)
+}
+
+// List returns the personal access tokens of the user making the request
+func (p *PersonalTokens) List(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	tokens, err := p.tokens.List(userID)
+	if err != nil {
+		err = errors.Wrap(err, "could not list personal access tokens")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if tokens == nil {
+		tokens = []*model.PersonalAccessToken{}
+	}
+
+	return ctx.JSON(http.StatusOK, tokens)
+}
+
+// Revoke revokes a personal access token of the user making the request from its id
+func (p *PersonalTokens) Revoke(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := forbidImpersonation(ctx, "revoke personal access tokens"); err != nil {
+		return err
+	}
+
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse personal access token ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = p.tokens.Revoke(userID, uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "personal access token id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not revoke personal access token")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
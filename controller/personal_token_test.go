@@ -0,0 +1,411 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type PersonalTokenServiceMock struct {
+	mock.Mock
+}
+
+func (m *PersonalTokenServiceMock) Create(owner *model.User, name string, scopes []model.Scope, expiresAt *time.Time) (*model.PersonalAccessToken, error) {
+	args := m.Called(owner, name, scopes, expiresAt)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.PersonalAccessToken), args.Error(1)
+}
+
+func (m *PersonalTokenServiceMock) List(userID string) ([]*model.PersonalAccessToken, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.PersonalAccessToken), args.Error(1)
+}
+
+func (m *PersonalTokenServiceMock) Revoke(userID string, id uint) error {
+	args := m.Called(userID, id)
+	return args.Error(0)
+}
+
+func TestNewPersonalTokens(t *testing.T) {
+	personalTokenServiceMock := &PersonalTokenServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	p := NewPersonalTokens(log, personalTokenServiceMock)
+
+	assert.Equal(t, personalTokenServiceMock, p.tokens, "unexpected personal token service set")
+	assert.Equal(t, log, p.log, "unexpected logger set")
+}
+
+func TestCreatePersonalToken(t *testing.T) {
+	createdAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	expiresAt := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+
+		requestBody     []byte
+		userID          interface{}
+		personalTokenID uint
+
+		expectCall bool
+		scopes     []model.Scope
+		expiresAt  *time.Time
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "token created",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "posts:read posts:write"}`),
+			userID:      "test",
+
+			expectCall: true,
+			scopes:     []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"name":"release-bot","scope":"posts:read posts:write","created_at":"2026-10-19T12:00:00Z","token":"bloggo_pat_fakeToken"}`),
+		},
+		{
+			description: "token created with an expiration date",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "posts:write", "expires_at": "2027-01-01T00:00:00Z"}`),
+			userID:      "test",
+
+			expectCall: true,
+			scopes:     []model.Scope{model.ScopePostsWrite},
+			expiresAt:  &expiresAt,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(`{"id":1,"name":"release-bot","scope":"posts:write","expires_at":"2027-01-01T00:00:00Z","created_at":"2026-10-19T12:00:00Z","token":"bloggo_pat_fakeToken"}`),
+		},
+		{
+			description: "scope not granted to the user's role",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "users:admin"}`),
+			userID:      "test",
+
+			expectCall: true,
+			scopes:     []model.Scope{model.ScopeUsersAdmin},
+			serviceErr: errors.Wrap(errortype.ErrInvalidScope, "scope users:admin can't be granted to author users"),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`scope users:admin can't be granted to author users: invalid scope`),
+		},
+		{
+			description: "expiration date in the past",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "posts:write", "expires_at": "2027-01-01T00:00:00Z"}`),
+			userID:      "test",
+
+			expectCall: true,
+			scopes:     []model.Scope{model.ScopePostsWrite},
+			expiresAt:  &expiresAt,
+			serviceErr: errors.Wrap(errortype.ErrUnprocessableEntity, "expiration date is in the past"),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`expiration date is in the past: unprocessable entity`),
+		},
+		{
+			description: "service error",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "posts:write"}`),
+			userID:      "test",
+
+			expectCall: true,
+			scopes:     []model.Scope{model.ScopePostsWrite},
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not create personal access token: dummy error`),
+		},
+		{
+			description: "missing name",
+
+			requestBody: []byte(`{"scope": "posts:write"}`),
+			userID:      "test",
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'PersonalAccessToken.Name' Error:Field validation for 'Name' failed on the 'required' tag`),
+		},
+		{
+			description: "missing scope",
+
+			requestBody: []byte(`{"name": "release-bot"}`),
+			userID:      "test",
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'PersonalAccessToken.Scope' Error:Field validation for 'Scope' failed on the 'required' tag`),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+			userID:      "test",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`Syntax error: offset=1, error=invalid character 'p' looking for beginning of value`),
+		},
+		{
+			description: "request made with a personal access token",
+
+			requestBody:     []byte(`{"name": "release-bot", "scope": "posts:write"}`),
+			userID:          "test",
+			personalTokenID: 3,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`personal access tokens can't be used to create personal access tokens`),
+		},
+		{
+			description: "user ID not in context",
+
+			requestBody: []byte(`{"name": "release-bot", "scope": "posts:write"}`),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`userID not set in request context`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/me/tokens", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", test.userID)
+			ctx.Set("role", model.RoleAuthor)
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			personalTokenServiceMock := &PersonalTokenServiceMock{}
+			if test.expectCall {
+				personalTokenServiceMock.
+					On("Create", &model.User{TokenUserID: "test", Role: model.RoleAuthor}, "release-bot", test.scopes, test.expiresAt).
+					Return(&model.PersonalAccessToken{
+						ID:        1,
+						Name:      "release-bot",
+						Scope:     model.FormatScopes(test.scopes),
+						ExpiresAt: test.expiresAt,
+						CreatedAt: createdAt,
+						Token:     "bloggo_pat_fakeToken",
+					}, test.serviceErr).
+					Once()
+			}
+
+			p := &PersonalTokens{
+				tokens: personalTokenServiceMock,
+
+				log: log,
+			}
+
+			err = p.Create(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			personalTokenServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListPersonalTokens(t *testing.T) {
+	lastUsedAt := time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+
+		tokens     []*model.PersonalAccessToken
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "tokens listed",
+
+			tokens: []*model.PersonalAccessToken{
+				{ID: 2, Name: "release-bot", Scope: "posts:write", LastUsedAt: &lastUsedAt, CreatedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+			},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`[{"id":2,"name":"release-bot","scope":"posts:write","last_used_at":"2026-10-19T13:00:00Z","created_at":"2026-10-19T12:00:00Z"}]`),
+		},
+		{
+			description: "no token",
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`[]`),
+		},
+		{
+			description: "service error",
+
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not list personal access tokens: dummy error`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/users/me/tokens", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "test")
+
+			personalTokenServiceMock := &PersonalTokenServiceMock{}
+			personalTokenServiceMock.
+				On("List", "test").
+				Return(test.tokens, test.serviceErr).
+				Once()
+
+			p := &PersonalTokens{
+				tokens: personalTokenServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = p.List(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			personalTokenServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokePersonalToken(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id         string
+		expectCall bool
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "token revoked",
+
+			id:         "1",
+			expectCall: true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "token not found",
+
+			id:         "1",
+			expectCall: true,
+			serviceErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`personal access token id 1: resource not found`),
+		},
+		{
+			description: "service error",
+
+			id:         "1",
+			expectCall: true,
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not revoke personal access token: dummy error`),
+		},
+		{
+			description: "invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse personal access token ID`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.DELETE, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/users/me/tokens/:id")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+			ctx.Set("userID", "test")
+
+			personalTokenServiceMock := &PersonalTokenServiceMock{}
+			if test.expectCall {
+				personalTokenServiceMock.
+					On("Revoke", "test", uint(1)).
+					Return(test.serviceErr).
+					Once()
+			}
+
+			p := &PersonalTokens{
+				tokens: personalTokenServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = p.Revoke(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			personalTokenServiceMock.AssertExpectations(t)
+		})
+	}
+}
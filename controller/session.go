@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SessionService represents a service to manage the sessions and login history of users
+type SessionService interface {
+	List(userID string) ([]*model.Session, error)
+	Revoke(userID, id string) error
+	History(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error)
+	UserHistory(id uint, offset, limit uint) ([]*model.LoginEvent, uint, error)
+}
+
+// Sessions is a controller that lets users see where they are logged in and log out other devices
+type Sessions struct {
+	sessions SessionService
+
+	log *zerolog.Logger
+}
+
+// NewSessions creates a Sessions controller
+func NewSessions(log *zerolog.Logger, sessions SessionService) *Sessions {
+	return &Sessions{
+		sessions: sessions,
+
+		log: log,
+	}
+}
+
+// List returns the active sessions of the user making the request. The session
+// of the request is marked as current.
+func (s *Sessions) List(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	sessions, err := s.sessions.List(userID)
+	if err != nil {
+		err = errors.Wrap(err, "could not list sessions")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if sessions == nil {
+		sessions = []*model.Session{}
+	}
+
+	// Personal access tokens don't belong to a session
+	sessionID, _ := ctx.Get("sessionID").(string)
+	for _, session := range sessions {
+		session.Current = sessionID != "" && session.ID == sessionID
+	}
+
+	return ctx.JSON(http.StatusOK, sessions)
+}
+
+// Revoke logs out a session of the user making the request from its id
+func (s *Sessions) Revoke(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := forbidImpersonation(ctx, "log out sessions"); err != nil {
+		return err
+	}
+
+	id := ctx.Param("id")
+	err := s.sessions.Revoke(userID, id)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "session id %s", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not revoke session")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// History returns a page of the login attempts of the user making the request, newest first.
+// The total number of attempts is sent in the X-Total-Count header.
+func (s *Sessions) History(ctx echo.Context) error {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		err := errors.New("userID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, total, err := s.sessions.History(userID, offset, limit)
+	if err != nil {
+		err = errors.Wrap(err, "could not list login history")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return sendHistory(ctx, events, total)
+}
+
+// UserHistory returns a page of the login attempts of a user from their id, newest first.
+// The total number of attempts is sent in the X-Total-Count header.
+func (s *Sessions) UserHistory(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, total, err := s.sessions.UserHistory(uint(id), offset, limit)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not list login history")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return sendHistory(ctx, events, total)
+}
+
+// sendHistory sends a page of login events along with their total count
+func sendHistory(ctx echo.Context, events []*model.LoginEvent, total uint) error {
+	if events == nil {
+		events = []*model.LoginEvent{}
+	}
+
+	ctx.Response().Header().Set("X-Total-Count", strconv.FormatUint(uint64(total), 10))
+	return ctx.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,462 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type SessionServiceMock struct {
+	mock.Mock
+}
+
+func (m *SessionServiceMock) List(userID string) ([]*model.Session, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Session), args.Error(1)
+}
+
+func (m *SessionServiceMock) Revoke(userID, id string) error {
+	args := m.Called(userID, id)
+	return args.Error(0)
+}
+
+func (m *SessionServiceMock) History(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	args := m.Called(userID, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.LoginEvent), args.Get(1).(uint), args.Error(2)
+}
+
+func (m *SessionServiceMock) UserHistory(id uint, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	args := m.Called(id, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.LoginEvent), args.Get(1).(uint), args.Error(2)
+}
+
+func TestNewSessions(t *testing.T) {
+	sessionServiceMock := &SessionServiceMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	s := NewSessions(log, sessionServiceMock)
+
+	assert.Equal(t, sessionServiceMock, s.sessions, "unexpected session service set")
+	assert.Equal(t, log, s.log, "unexpected logger set")
+}
+
+func TestListSessions(t *testing.T) {
+	createdAt := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	lastSeenAt := time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)
+	sessions := func() []*model.Session {
+		return []*model.Session{
+			{ID: "a", UserID: "test", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", CreatedAt: createdAt, LastSeenAt: lastSeenAt},
+			{ID: "b", UserID: "test", IP: "10.0.0.2", UserAgent: "curl/7.64.1", CreatedAt: createdAt, LastSeenAt: createdAt},
+		}
+	}
+
+	tests := []struct {
+		description string
+
+		sessionID  interface{}
+		sessions   []*model.Session
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "sessions listed",
+
+			sessionID: "b",
+			sessions:  sessions(),
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `[{"id":"a","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T12:00:00Z","last_seen_at":"2026-10-19T13:00:00Z","current":false},{"id":"b","ip":"10.0.0.2","user_agent":"curl/7.64.1","created_at":"2026-10-19T12:00:00Z","last_seen_at":"2026-10-19T12:00:00Z","current":true}]`,
+		},
+		{
+			description: "sessions listed with a personal access token",
+
+			sessionID: "",
+			sessions:  sessions()[:1],
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `[{"id":"a","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T12:00:00Z","last_seen_at":"2026-10-19T13:00:00Z","current":false}]`,
+		},
+		{
+			description: "no session",
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `[]`,
+		},
+		{
+			description: "service error",
+
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: `could not list sessions: dummy error`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/users/me/sessions", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "test")
+			ctx.Set("sessionID", test.sessionID)
+
+			sessionServiceMock := &SessionServiceMock{}
+			sessionServiceMock.
+				On("List", "test").
+				Return(test.sessions, test.serviceErr).
+				Once()
+
+			s := &Sessions{
+				sessions: sessionServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = s.List(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			sessionServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		description string
+
+		serviceErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "session revoked",
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "session not found",
+
+			serviceErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: `session id abc: resource not found`,
+		},
+		{
+			description: "service error",
+
+			serviceErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: `could not revoke session: dummy error`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.DELETE, "/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/users/me/sessions/:id")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues("abc")
+			ctx.Set("userID", "test")
+
+			sessionServiceMock := &SessionServiceMock{}
+			sessionServiceMock.
+				On("Revoke", "test", "abc").
+				Return(test.serviceErr).
+				Once()
+
+			s := &Sessions{
+				sessions: sessionServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = s.Revoke(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			sessionServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	events := []*model.LoginEvent{
+		{ID: 2, UserID: "test", Email: "pam@dunder-mifflin.com", Method: model.LoginMethodPassword, Success: true, SessionID: "a", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", CreatedAt: time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC)},
+		{ID: 1, UserID: "test", Email: "pam@dunder-mifflin.com", Method: model.LoginMethodPassword, Reason: "invalid password", IP: "10.0.0.1", UserAgent: "Mozilla/5.0", CreatedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		description string
+
+		query string
+
+		expectCall     bool
+		expectedOffset uint
+		expectedLimit  uint
+		events         []*model.LoginEvent
+		total          uint
+		serviceErr     error
+
+		expectedHTTPCode  int
+		expectedHTTPBody  string
+		expectedTotalHead string
+	}{
+		{
+			description: "default page",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			events:        events,
+			total:         2,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[{"id":2,"email":"pam@dunder-mifflin.com","method":"password","success":true,"session_id":"a","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T13:00:00Z"},{"id":1,"email":"pam@dunder-mifflin.com","method":"password","success":false,"reason":"invalid password","ip":"10.0.0.1","user_agent":"Mozilla/5.0","created_at":"2026-10-19T12:00:00Z"}]`,
+			expectedTotalHead: "2",
+		},
+		{
+			description: "empty page",
+
+			query: "?offset=40&limit=10",
+
+			expectCall:     true,
+			expectedOffset: 40,
+			expectedLimit:  10,
+			total:          42,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[]`,
+			expectedTotalHead: "42",
+		},
+		{
+			description: "bad request: limit too high",
+
+			query: "?limit=1000",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "limit must be between 1 and 100",
+		},
+		{
+			description: "internal server error: service failure",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not list login history: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/users/me/logins"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "test")
+
+			sessionServiceMock := &SessionServiceMock{}
+			if test.expectCall {
+				sessionServiceMock.
+					On("History", "test", test.expectedOffset, test.expectedLimit).
+					Return(test.events, test.total, test.serviceErr).
+					Once()
+			}
+
+			s := &Sessions{
+				sessions: sessionServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = s.History(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Equal(t, test.expectedTotalHead, w.Header().Get("X-Total-Count"), "wrong total count")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			sessionServiceMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserLoginHistory(t *testing.T) {
+	tests := []struct {
+		description string
+
+		id    string
+		query string
+
+		expectCall     bool
+		expectedOffset uint
+		expectedLimit  uint
+		events         []*model.LoginEvent
+		total          uint
+		serviceErr     error
+
+		expectedHTTPCode  int
+		expectedHTTPBody  string
+		expectedTotalHead string
+	}{
+		{
+			description: "history listed",
+
+			id:    "42",
+			query: "?limit=1",
+
+			expectCall:    true,
+			expectedLimit: 1,
+			events:        []*model.LoginEvent{{ID: 7, Email: "pam@dunder-mifflin.com", Method: model.LoginMethodOIDC, Success: true, CreatedAt: time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)}},
+			total:         3,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[{"id":7,"email":"pam@dunder-mifflin.com","method":"oidc","success":true,"ip":"","user_agent":"","created_at":"2026-10-19T12:00:00Z"}]`,
+			expectedTotalHead: "3",
+		},
+		{
+			description: "bad request: invalid id",
+
+			id: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse user ID",
+		},
+		{
+			description: "bad request: invalid offset",
+
+			id:    "42",
+			query: "?offset=potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse offset",
+		},
+		{
+			description: "not found: unknown user",
+
+			id: "42",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.Wrap(errortype.ErrNotFound, "could not retrieve user id 42"),
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "could not retrieve user id 42: resource not found",
+		},
+		{
+			description: "internal server error: service failure",
+
+			id: "42",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			serviceErr:    errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not list login history: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetPath("/users/:id/logins")
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.id)
+
+			sessionServiceMock := &SessionServiceMock{}
+			if test.expectCall {
+				sessionServiceMock.
+					On("UserHistory", uint(42), test.expectedOffset, test.expectedLimit).
+					Return(test.events, test.total, test.serviceErr).
+					Once()
+			}
+
+			s := &Sessions{
+				sessions: sessionServiceMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = s.UserHistory(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Equal(t, test.expectedTotalHead, w.Header().Get("X-Total-Count"), "wrong total count")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			sessionServiceMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	v "gopkg.in/go-playground/validator.v9"
+)
+
+// SetupService represents a service that creates the first admin of the blog
+type SetupService interface {
+	Complete(token string, user *model.User) (*model.User, error)
+}
+
+// TokenIssuer represents a service that gives tokens to users who were already authenticated
+type TokenIssuer interface {
+	LoginUser(user *model.User, method model.LoginMethod, client *model.Client) (*model.Token, error)
+}
+
+// Setup is a controller that is in charge of the first run of the blog
+type Setup struct {
+	setup   SetupService
+	tokens  TokenIssuer
+	cookies *CookieAuth
+
+	log *zerolog.Logger
+}
+
+// NewSetup creates a Setup controller
+func NewSetup(log *zerolog.Logger, setup SetupService, tokens TokenIssuer, cookies *CookieAuth) *Setup {
+	return &Setup{
+		setup:   setup,
+		tokens:  tokens,
+		cookies: cookies,
+
+		log: log,
+	}
+}
+
+// setupRequest holds the credentials of the first admin, and the setup token
+type setupRequest struct {
+	model.User
+	SetupToken string `json:"setup_token"`
+}
+
+// Complete creates the first admin of the blog, and gives them a token
+func (s *Setup) Complete(ctx echo.Context) error {
+	var request setupRequest
+
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user data from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(request.User)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	admin, err := s.setup.Complete(request.SetupToken, &request.User)
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidToken:
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errortype.ErrConflict:
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		err = errors.Wrap(err, "could not complete setup")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	token, err := s.tokens.LoginUser(admin, model.LoginMethodSetup, requestClient(ctx))
+	if err != nil {
+		err = errors.Wrap(err, "could not log admin in")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return s.cookies.respond(ctx, http.StatusCreated, token)
+}
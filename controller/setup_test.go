@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type SetupServiceMock struct {
+	mock.Mock
+}
+
+func (m *SetupServiceMock) Complete(token string, user *model.User) (*model.User, error) {
+	args := m.Called(token, user)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+type TokenIssuerMock struct {
+	mock.Mock
+}
+
+func (m *TokenIssuerMock) LoginUser(user *model.User, method model.LoginMethod, client *model.Client) (*model.Token, error) {
+	args := m.Called(user, method, client)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Token), args.Error(1)
+}
+
+func TestNewSetup(t *testing.T) {
+	setupServiceMock := &SetupServiceMock{}
+	tokenIssuerMock := &TokenIssuerMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	s := NewSetup(log, setupServiceMock, tokenIssuerMock, cookies)
+
+	assert.Equal(t, setupServiceMock, s.setup, "unexpected setup service set")
+	assert.Equal(t, tokenIssuerMock, s.tokens, "unexpected token issuer set")
+	assert.Equal(t, cookies, s.cookies, "unexpected cookie auth set")
+	assert.Equal(t, log, s.log, "unexpected logger set")
+}
+
+func TestCompleteSetup(t *testing.T) {
+	admin := &model.User{
+		ID:          1,
+		TokenUserID: "bloggo|id",
+		Email:       "bob@vance-refrigeration.com",
+		Role:        model.RoleAdmin,
+	}
+
+	tests := []struct {
+		description string
+
+		requestBody []byte
+		expectCall  bool
+		setupErr    error
+		loginErr    error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "admin created",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			expectCall: true,
+
+			expectedHTTPCode: 201,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "invalid setup token",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			expectCall: true,
+			setupErr:   errors.Wrap(errortype.ErrInvalidToken, "invalid setup token"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`invalid setup token: invalid token`),
+		},
+		{
+			description: "admin already exists",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			expectCall: true,
+			setupErr:   errors.Wrap(errortype.ErrConflict, "admin account has already been created"),
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: []byte(`admin account has already been created: datamodel conflict`),
+		},
+		{
+			description: "setup error",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			expectCall: true,
+			setupErr:   errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not complete setup: database exploded`),
+		},
+		{
+			description: "login error",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			expectCall: true,
+			loginErr:   errors.New("no key"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not log admin in: no key`),
+		},
+		{
+			description: "invalid password (too short)",
+
+			requestBody: []byte(`
+				{
+					"setup_token": "setupToken",
+					"email": "bob@vance-refrigeration.com",
+					"password": "12345"
+				}
+			`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'User.Password' Error:Field validation for 'Password' failed on the 'min' tag`),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse user data from request body`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/setup", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			setupServiceMock := &SetupServiceMock{}
+			tokenIssuerMock := &TokenIssuerMock{}
+			if test.expectCall {
+				setupServiceMock.
+					On("Complete", "setupToken", &model.User{Email: "bob@vance-refrigeration.com", Password: "refrigerator2000"}).
+					Return(admin, test.setupErr).
+					Once()
+			}
+			if test.expectCall && test.setupErr == nil {
+				tokenIssuerMock.
+					On("LoginUser", admin, model.LoginMethodSetup, &model.Client{}).
+					Return(issuedToken, test.loginErr).
+					Once()
+			}
+
+			s := &Setup{
+				setup:  setupServiceMock,
+				tokens: tokenIssuerMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err = s.Complete(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			setupServiceMock.AssertExpectations(t)
+			tokenIssuerMock.AssertExpectations(t)
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package controller
 
 import (
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/model"
 
 	"github.com/labstack/echo"
@@ -11,39 +17,87 @@ import (
 	v "gopkg.in/go-playground/validator.v9"
 )
 
-// UserRepository represents a repository that allows to create users and
-// verify whether or not the admin user has already been setup
+// UserRepository represents a repository that allows to create and retrieve users, and change their roles
 type UserRepository interface {
 	Store(user *model.User) (*model.User, error)
-	AdminExists() bool
+	Retrieve(user *model.User) (*model.User, error)
+	FindByID(id uint) (*model.User, error)
+	SetRole(id uint, role model.Role) error
+	Approve(id uint) error
+	Deactivate(id uint) error
+	UpdateEmail(id uint, email string) error
+	UpdatePassword(id uint, hash string) error
+	List(status model.UserStatus, offset, limit uint) ([]*model.User, uint, error)
 }
 
-// TokenGenerator represents a service to generate tokens from user info
+// TokenGenerator represents a service to generate tokens with the given scopes from user
+// info, or a challenge for users who enabled two-factor authentication, to refresh them and to revoke them
 type TokenGenerator interface {
-	Login(user *model.User) (string, error)
+	Login(user *model.User, scopes []model.Scope, client *model.Client) (*model.Token, *model.MFAChallenge, error)
+	LoginMFA(mfaToken, code string, client *model.Client) (*model.Token, error)
+	Refresh(refreshToken string) (*model.Token, error)
+	Logout(tokenID, sessionID string, expiresAt time.Time, refreshToken string) error
 	GenerateID() string
 }
 
-// Hasher represents a service that hashes passwords
+// Hasher represents a service that hashes passwords, and compares them to their hashes
 type Hasher interface {
 	Hash(password string) (string, error)
+	Compare(hash, password string) error
+}
+
+// LoginThrottle represents a service that slows down and locks out repeated failed logins
+type LoginThrottle interface {
+	Check(email, ip string) (time.Duration, error)
+	Fail(email, ip string) error
+	Succeed(email string) error
+	Unlock(email string) error
+}
+
+// RegistrationPolicy represents a policy that decides who can create an account, and to which
+// email addresses accounts can be moved
+type RegistrationPolicy interface {
+	Check(email string, invited bool) error
+	CheckEmail(email string) error
+	Status() model.UserStatus
+}
+
+// EmailVerifier represents a service that sends verification links to users, and verifies them
+type EmailVerifier interface {
+	Send(user *model.User) error
+	Verify(token string) error
+}
+
+// SessionRevoker represents a service that logs out the other sessions of a user
+type SessionRevoker interface {
+	RevokeOthers(userID, id string) error
 }
 
 // User is a controller that is in charge of handling the CRUD of users
 type User struct {
-	users  UserRepository
-	tokens TokenGenerator
-	hasher Hasher
+	users        UserRepository
+	tokens       TokenGenerator
+	hasher       Hasher
+	throttle     LoginThrottle
+	registration RegistrationPolicy
+	verifier     EmailVerifier
+	sessions     SessionRevoker
+	cookies      *CookieAuth
 
 	log *zerolog.Logger
 }
 
 // NewUser creates a User controller with the given user repository
-func NewUser(log *zerolog.Logger, userRepository UserRepository, tokens TokenGenerator, hasher Hasher) *User {
+func NewUser(log *zerolog.Logger, userRepository UserRepository, tokens TokenGenerator, hasher Hasher, throttle LoginThrottle, registration RegistrationPolicy, verifier EmailVerifier, sessions SessionRevoker, cookies *CookieAuth) *User {
 	return &User{
-		users:  userRepository,
-		tokens: tokens,
-		hasher: hasher,
+		users:        userRepository,
+		tokens:       tokens,
+		hasher:       hasher,
+		throttle:     throttle,
+		registration: registration,
+		verifier:     verifier,
+		sessions:     sessions,
+		cookies:      cookies,
 
 		log: log,
 	}
@@ -74,44 +128,669 @@ func (u *User) Register(ctx echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	// Ensure we don't have more than 1 admin user
-	if user.IsAdmin && u.users.AdminExists() {
-		return echo.NewHTTPError(http.StatusForbidden, errors.New("admin account has already been created"))
+	// Users are readers until an admin gives them another role. The first
+	// admin is created by the setup, or with the create-admin command.
+	switch user.Role {
+	case "":
+		user.Role = model.RoleReader
+	case model.RoleReader:
+	default:
+		return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("the %s role can only be given by an admin", user.Role))
+	}
+
+	err = u.registration.Check(user.Email, false)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
 	}
+	user.Status = u.registration.Status()
 
 	createdUser, err := u.users.Store(&user)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	u.sendVerification(createdUser)
+
+	// Pending users can't log in until an admin approves them
+	if createdUser.Status == model.StatusPending {
+		u.log.Info().Uint("id", createdUser.ID).Str("email", createdUser.Email).Msg("user registered, pending approval")
+		return ctx.NoContent(http.StatusAccepted)
+	}
+
 	createdUser.Password = plainTextPwd
 
-	token, err := u.tokens.Login(createdUser)
+	// New users can't have enabled two-factor authentication yet
+	token, _, err := u.tokens.Login(createdUser, nil, requestClient(ctx))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-	return ctx.JSON(http.StatusCreated, token)
+	return u.cookies.respond(ctx, http.StatusCreated, token)
+}
+
+// loginRequest holds the credentials of a user, and the scopes they request
+// for their token as a space-separated list. All of the scopes of the user's
+// role are granted if none are requested.
+type loginRequest struct {
+	model.User
+	Scope string `json:"scope"`
 }
 
 // Login gives a token to the user upon providing their credentials
 func (u *User) Login(ctx echo.Context) error {
-	var user model.User
+	var request loginRequest
 
-	err := ctx.Bind(&user)
+	err := ctx.Bind(&request)
 	if err != nil {
 		err = errors.Wrap(err, "could not parse user data from request body")
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	validate := v.New()
-	err = validate.Struct(user)
+	err = validate.Struct(request.User)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	// Blocked clients are turned away before their password is hashed
+	ip := ctx.RealIP()
+	wait, err := u.throttle.Check(request.Email, ip)
+	if err != nil {
+		err = errors.Wrap(err, "could not check failed login attempts")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if wait > 0 {
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed login attempts")
+	}
+
+	token, challenge, err := u.tokens.Login(&request.User, model.ParseScopes(request.Scope), requestClient(ctx))
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidCredentials:
+		u.log.Debug().Err(err).Str("email", request.Email).Str("ip", ip).Msg("login failed")
+
+		if err := u.throttle.Fail(request.Email, ip); err != nil {
+			u.log.Error().Err(err).Str("email", request.Email).Str("ip", ip).Msg("could not record failed login attempt")
+		}
+
+		// Unknown users and wrong passwords are rejected alike, so that
+		// the response doesn't reveal which email addresses have an account
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid email or password")
+	case errortype.ErrInvalidScope:
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errortype.ErrForbidden:
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := u.throttle.Succeed(request.Email); err != nil {
+		u.log.Error().Err(err).Str("email", request.Email).Msg("could not reset failed login attempts")
+	}
+
+	// Users who enabled two-factor authentication complete their login with LoginMFA
+	if challenge != nil {
+		return ctx.JSON(http.StatusOK, challenge)
+	}
+
+	return u.cookies.respond(ctx, http.StatusOK, token)
+}
+
+// mfaLoginRequest holds the challenge token of a login and the code that completes it
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// LoginMFA gives a token to a user who entered their password, upon providing a TOTP code or a recovery code
+func (u *User) LoginMFA(ctx echo.Context) error {
+	var request mfaLoginRequest
+
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse MFA login from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(request)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	token, err := u.tokens.LoginMFA(request.MFAToken, request.Code, requestClient(ctx))
+	switch errors.Cause(err) {
+	case nil:
+	case errortype.ErrInvalidCredentials:
+		u.log.Debug().Err(err).Str("ip", ctx.RealIP()).Msg("MFA login failed")
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid code")
+	case errortype.ErrInvalidToken:
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	default:
+		err = errors.Wrap(err, "could not complete login")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return u.cookies.respond(ctx, http.StatusOK, token)
+}
+
+// requestClient returns the device that made the request, to which the sessions of logins are bound
+func requestClient(ctx echo.Context) *model.Client {
+	return &model.Client{
+		IP:        ctx.RealIP(),
+		UserAgent: ctx.Request().UserAgent(),
+	}
+}
+
+// refreshRequest holds the refresh token to exchange or revoke
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. Browsers that got their tokens in
+// cookies send their refresh token in a cookie, and get the new pair of tokens in cookies.
+func (u *User) Refresh(ctx echo.Context) error {
+	request, err := bindRefreshRequest(ctx)
+	if err != nil {
+		return err
+	}
+
+	fromCookie := false
+	if request.RefreshToken == "" {
+		request.RefreshToken = u.cookies.refreshToken(ctx)
+		fromCookie = true
+	}
+
+	if request.RefreshToken == "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "missing refresh token")
+	}
+
+	if fromCookie {
+		err = u.cookies.checkCSRF(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	token, err := u.tokens.Refresh(request.RefreshToken)
+	if errors.Cause(err) == errortype.ErrInvalidToken {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not refresh token")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if fromCookie {
+		return u.cookies.respondWithCookies(ctx, http.StatusOK, token)
+	}
+	return ctx.JSON(http.StatusOK, token)
+}
+
+// Logout revokes the access token of the request along with its session, and the refresh token from
+// the request body or cookies if there is one. Browsers authenticated with cookies have them removed.
+func (u *User) Logout(ctx echo.Context) error {
+	tokenID, ok := ctx.Get("tokenID").(string)
+	if !ok {
+		err := errors.New("tokenID not set in request context")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	sessionID, _ := ctx.Get("sessionID").(string)
+	expiresAt, _ := ctx.Get("tokenExpiresAt").(time.Time)
+
+	// The refresh token is optional
+	request, err := bindRefreshRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if request.RefreshToken == "" {
+		request.RefreshToken = u.cookies.refreshToken(ctx)
+	}
+
+	err = u.tokens.Logout(tokenID, sessionID, expiresAt, request.RefreshToken)
+	if err != nil {
+		err = errors.Wrap(err, "could not log out")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if cookieAuth, _ := ctx.Get("cookieAuth").(bool); cookieAuth {
+		u.cookies.clear(ctx)
+	}
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// bindRefreshRequest parses the refresh token from the request body, which can be empty
+// for browsers that send their refresh token in a cookie
+func bindRefreshRequest(ctx echo.Context) (*refreshRequest, error) {
+	var request refreshRequest
+	if ctx.Request().ContentLength == 0 {
+		return &request, nil
+	}
+
+	err := ctx.Bind(&request)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse refresh token from request body")
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return &request, nil
+}
+
+// SetRole changes the role of a user from their id
+func (u *User) SetRole(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	var body struct {
+		Role model.Role `json:"role" validate:"required,oneof=reader author editor admin"`
+	}
+
+	err = ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse role from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
 	}
 
-	token, err := u.tokens.Login(&user)
+	err = u.users.SetRole(uint(id), body.Role)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "user id %d", id).Error())
+	}
+	if errors.Cause(err) == errortype.ErrConflict {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not change user role")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	u.log.Info().Uint64("id", id).Str("role", string(body.Role)).Msg("user role changed")
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Approve lets a user who is pending approval log in, from their id
+func (u *User) Approve(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = u.users.Approve(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "pending user id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not approve user")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	u.log.Info().Uint64("id", id).Msg("user approved")
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Unlock lifts the lockout of a user from their id, after too many failed login attempts
+func (u *User) Unlock(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := u.users.FindByID(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "user id %d", id).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not retrieve user")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	err = u.throttle.Unlock(user.Email)
 	if err != nil {
+		err = errors.Wrap(err, "could not unlock user")
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-	return ctx.JSON(http.StatusCreated, token)
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Pagination limits of lists
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// parsePage parses the limit and offset query parameters of a paginated list
+func parsePage(ctx echo.Context) (offset, limit uint, err error) {
+	parsedLimit := uint64(defaultListLimit)
+	if param := ctx.QueryParam("limit"); param != "" {
+		parsedLimit, err = strconv.ParseUint(param, 10, 64)
+		if err != nil || parsedLimit == 0 || parsedLimit > maxListLimit {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxListLimit))
+		}
+	}
+
+	var parsedOffset uint64
+	if param := ctx.QueryParam("offset"); param != "" {
+		parsedOffset, err = strconv.ParseUint(param, 10, 64)
+		if err != nil {
+			err = errors.Wrap(err, "could not parse offset")
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	return uint(parsedOffset), uint(parsedLimit), nil
+}
+
+// userResponse is the representation of a user that is sent to clients, without their password hash
+type userResponse struct {
+	ID            uint             `json:"id"`
+	UserID        string           `json:"user_id"`
+	Email         string           `json:"email"`
+	EmailVerified bool             `json:"email_verified"`
+	Role          model.Role       `json:"role"`
+	Status        model.UserStatus `json:"status"`
+
+	// ImpersonatedBy is the user ID of the admin who impersonates the user, when they request their own account
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+}
+
+func newUserResponse(user *model.User) userResponse {
+	return userResponse{
+		ID:            user.ID,
+		UserID:        user.TokenUserID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Role:          user.Role,
+		Status:        user.Status,
+	}
+}
+
+// List returns a page of users, optionally filtered by status. The total number
+// of users that match the filter is sent in the X-Total-Count header.
+func (u *User) List(ctx echo.Context) error {
+	offset, limit, err := parsePage(ctx)
+	if err != nil {
+		return err
+	}
+
+	status := model.UserStatus(ctx.QueryParam("status"))
+	switch status {
+	case "", model.StatusActive, model.StatusPending, model.StatusDeactivated:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown user status %q", status))
+	}
+
+	users, total, err := u.users.List(status, offset, limit)
+	if err != nil {
+		err = errors.Wrap(err, "could not list users")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	response := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, newUserResponse(user))
+	}
+
+	ctx.Response().Header().Set("X-Total-Count", strconv.FormatUint(uint64(total), 10))
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// Me returns the user who made the request, along with the admin who impersonates them if there is one
+func (u *User) Me(ctx echo.Context) error {
+	user, err := u.currentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	response := newUserResponse(user)
+	response.ImpersonatedBy, _ = ctx.Get("actorID").(string)
+
+	return ctx.JSON(http.StatusOK, response)
+}
+
+// UpdateMe changes the email address of the user who made the request
+func (u *User) UpdateMe(ctx echo.Context) error {
+	if personalTokenID, _ := ctx.Get("personalTokenID").(uint); personalTokenID != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "personal access tokens can't be used to update accounts")
+	}
+	if err := forbidImpersonation(ctx, "update accounts"); err != nil {
+		return err
+	}
+
+	var body struct {
+		Email string `json:"email" validate:"omitempty,email"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user data from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	user, err := u.currentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	email := strings.TrimSpace(body.Email)
+	if email != "" && email != user.Email {
+		err = u.registration.CheckEmail(email)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+
+		err = u.users.UpdateEmail(user.ID, email)
+		if errors.Cause(err) == errortype.ErrDuplicateEntry {
+			return echo.NewHTTPError(http.StatusConflict, "email address already in use")
+		}
+		if err != nil {
+			err = errors.Wrap(err, "could not update user")
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+
+		u.log.Info().Uint("id", user.ID).Str("email", email).Msg("user email changed")
+		user.Email = email
+		user.EmailVerified = false
+
+		u.sendVerification(user)
+	}
+
+	return ctx.JSON(http.StatusOK, newUserResponse(user))
+}
+
+// ChangePassword replaces the password of the user who made the request, if they provide their current
+// one. Wrong passwords count as failed logins, and the other sessions of the user are logged out.
+func (u *User) ChangePassword(ctx echo.Context) error {
+	if personalTokenID, _ := ctx.Get("personalTokenID").(uint); personalTokenID != 0 {
+		return echo.NewHTTPError(http.StatusForbidden, "personal access tokens can't be used to change passwords")
+	}
+	if err := forbidImpersonation(ctx, "change passwords"); err != nil {
+		return err
+	}
+
+	var body struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		NewPassword     string `json:"new_password" validate:"required,min=10"`
+	}
+
+	err := ctx.Bind(&body)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse passwords from request body")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	validate := v.New()
+	err = validate.Struct(body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	user, err := u.currentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Stolen sessions can't be used to guess the password faster than logins can
+	ip := ctx.RealIP()
+	wait, err := u.throttle.Check(user.Email, ip)
+	if err != nil {
+		err = errors.Wrap(err, "could not check failed login attempts")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if wait > 0 {
+		ctx.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return echo.NewHTTPError(http.StatusTooManyRequests, "too many failed login attempts")
+	}
+
+	err = u.hasher.Compare(user.Password, body.CurrentPassword)
+	if err != nil {
+		if err := u.throttle.Fail(user.Email, ip); err != nil {
+			u.log.Error().Err(err).Str("email", user.Email).Str("ip", ip).Msg("could not record failed login attempt")
+		}
+		return echo.NewHTTPError(http.StatusForbidden, "invalid current password")
+	}
+
+	if err := u.throttle.Succeed(user.Email); err != nil {
+		u.log.Error().Err(err).Str("email", user.Email).Msg("could not reset failed login attempts")
+	}
+
+	hash, err := u.hasher.Hash(body.NewPassword)
+	if err != nil {
+		err = errors.Wrap(err, "could not hash password")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	err = u.users.UpdatePassword(user.ID, hash)
+	if err != nil {
+		err = errors.Wrap(err, "could not update password")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	// Whoever knew the previous password is logged out
+	sessionID, _ := ctx.Get("sessionID").(string)
+	err = u.sessions.RevokeOthers(user.TokenUserID, sessionID)
+	if err != nil {
+		err = errors.Wrap(err, "could not revoke other sessions")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	u.log.Info().Uint("id", user.ID).Msg("user password changed")
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// Deactivate prevents a user from logging in and using their tokens, from their id
+func (u *User) Deactivate(ctx echo.Context) error {
+	// parse the ID from the URL parameter
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(err, "could not parse user ID")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = u.users.Deactivate(uint(id))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "user id %d", id).Error())
+	}
+	if errors.Cause(err) == errortype.ErrConflict {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not deactivate user")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	u.log.Info().Uint64("id", id).Msg("user deactivated")
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// VerifyEmail marks the email address of a user as verified, from the token of the link they received
+func (u *User) VerifyEmail(ctx echo.Context) error {
+	token := ctx.QueryParam("token")
+	if token == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing verification token")
+	}
+
+	err := u.verifier.Verify(token)
+	if errors.Cause(err) == errortype.ErrInvalidToken {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not verify email address")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// ResendVerification sends a new verification link to the user who made the request
+func (u *User) ResendVerification(ctx echo.Context) error {
+	user, err := u.currentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified {
+		return echo.NewHTTPError(http.StatusConflict, "email address is already verified")
+	}
+
+	err = u.verifier.Send(user)
+	if err != nil {
+		err = errors.Wrap(err, "could not send verification email")
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.NoContent(http.StatusAccepted)
+}
+
+// sendVerification sends a verification link to a user whose email address is not verified yet. Failures
+// are only logged, since the user can ask for another link.
+func (u *User) sendVerification(user *model.User) {
+	if user.EmailVerified {
+		return
+	}
+
+	err := u.verifier.Send(user)
+	if err != nil {
+		u.log.Error().Err(err).Uint("id", user.ID).Str("email", user.Email).Msg("could not send verification email")
+	}
+}
+
+// currentUser retrieves the user who made the request
+func (u *User) currentUser(ctx echo.Context) (*model.User, error) {
+	userID, ok := ctx.Get("userID").(string)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "could not get user ID from context")
+	}
+
+	user, err := u.users.Retrieve(&model.User{TokenUserID: userID})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, echo.NewHTTPError(http.StatusNotFound, errors.Wrapf(err, "user %s", userID).Error())
+	}
+	if err != nil {
+		err = errors.Wrap(err, "could not retrieve user")
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return user, nil
 }
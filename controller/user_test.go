@@ -5,9 +5,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/logger"
 	"github.com/Ullaakut/Bloggo/model"
 	"github.com/Ullaakut/Bloggo/repo"
@@ -22,9 +25,32 @@ type TokenGeneratorMock struct {
 	mock.Mock
 }
 
-func (m *TokenGeneratorMock) Login(user *model.User) (string, error) {
-	args := m.Called(user)
-	return args.String(0), args.Error(1)
+func (m *TokenGeneratorMock) Login(user *model.User, scopes []model.Scope, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	args := m.Called(user, scopes, client)
+	token, _ := args.Get(0).(*model.Token)
+	challenge, _ := args.Get(1).(*model.MFAChallenge)
+	return token, challenge, args.Error(2)
+}
+
+func (m *TokenGeneratorMock) LoginMFA(mfaToken, code string, client *model.Client) (*model.Token, error) {
+	args := m.Called(mfaToken, code, client)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Token), args.Error(1)
+}
+
+func (m *TokenGeneratorMock) Refresh(refreshToken string) (*model.Token, error) {
+	args := m.Called(refreshToken)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Token), args.Error(1)
+}
+
+func (m *TokenGeneratorMock) Logout(tokenID, sessionID string, expiresAt time.Time, refreshToken string) error {
+	args := m.Called(tokenID, sessionID, expiresAt, refreshToken)
+	return args.Error(0)
 }
 
 func (m *TokenGeneratorMock) GenerateID() string {
@@ -32,6 +58,16 @@ func (m *TokenGeneratorMock) GenerateID() string {
 	return args.String(0)
 }
 
+var issuedToken = &model.Token{
+	AccessToken:  "x.y.z",
+	TokenType:    "Bearer",
+	ExpiresIn:    900,
+	RefreshToken: "fakeRefreshToken",
+	Scope:        "posts:read",
+}
+
+const issuedTokenJSON = `{"access_token":"x.y.z","token_type":"Bearer","expires_in":900,"refresh_token":"fakeRefreshToken","scope":"posts:read"}`
+
 type HasherMock struct {
 	mock.Mock
 }
@@ -41,18 +77,99 @@ func (m *HasherMock) Hash(password string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+func (m *HasherMock) Compare(hash, password string) error {
+	args := m.Called(hash, password)
+	return args.Error(0)
+}
+
+type LoginThrottleMock struct {
+	mock.Mock
+}
+
+func (m *LoginThrottleMock) Check(email, ip string) (time.Duration, error) {
+	args := m.Called(email, ip)
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func (m *LoginThrottleMock) Fail(email, ip string) error {
+	args := m.Called(email, ip)
+	return args.Error(0)
+}
+
+func (m *LoginThrottleMock) Succeed(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *LoginThrottleMock) Unlock(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+type SessionRevokerMock struct {
+	mock.Mock
+}
+
+func (m *SessionRevokerMock) RevokeOthers(userID, id string) error {
+	args := m.Called(userID, id)
+	return args.Error(0)
+}
+
+type RegistrationPolicyMock struct {
+	mock.Mock
+}
+
+func (m *RegistrationPolicyMock) Check(email string, invited bool) error {
+	args := m.Called(email, invited)
+	return args.Error(0)
+}
+
+func (m *RegistrationPolicyMock) CheckEmail(email string) error {
+	args := m.Called(email)
+	return args.Error(0)
+}
+
+func (m *RegistrationPolicyMock) Status() model.UserStatus {
+	args := m.Called()
+	return args.Get(0).(model.UserStatus)
+}
+
+type EmailVerifierMock struct {
+	mock.Mock
+}
+
+func (m *EmailVerifierMock) Send(user *model.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *EmailVerifierMock) Verify(token string) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
 func TestNewUser(t *testing.T) {
 	userRepositoryMock := &repo.UserRepositoryMock{}
 	hasherMock := &HasherMock{}
 	tokenMock := &TokenGeneratorMock{}
+	throttleMock := &LoginThrottleMock{}
+	registrationMock := &RegistrationPolicyMock{}
+	verifierMock := &EmailVerifierMock{}
+	sessionsMock := &SessionRevokerMock{}
 	logsBuff := &bytes.Buffer{}
 	log := logger.NewZeroLog(logsBuff)
 
-	b := NewUser(log, userRepositoryMock, tokenMock, hasherMock)
+	cookies := NewCookieAuth("/api", true, time.Hour)
+	b := NewUser(log, userRepositoryMock, tokenMock, hasherMock, throttleMock, registrationMock, verifierMock, sessionsMock, cookies)
 
 	assert.Equal(t, userRepositoryMock, b.users, "unexpected user repository set")
 	assert.Equal(t, tokenMock, b.tokens, "unexpected token service set")
 	assert.Equal(t, hasherMock, b.hasher, "unexpected hashing service set")
+	assert.Equal(t, throttleMock, b.throttle, "unexpected login throttle set")
+	assert.Equal(t, registrationMock, b.registration, "unexpected registration policy set")
+	assert.Equal(t, verifierMock, b.verifier, "unexpected email verifier set")
+	assert.Equal(t, sessionsMock, b.sessions, "unexpected session revoker set")
+	assert.Equal(t, cookies, b.cookies, "unexpected cookie auth set")
 	assert.Equal(t, log, b.log, "unexpected logger set")
 }
 
@@ -62,13 +179,15 @@ func TestRegister(t *testing.T) {
 
 		requestBody    []byte
 		user           *model.User
-		adminExists    bool
 		generatedToken string
 		generatedHash  string
 
 		repositoryErr error
 		loginErr      error
 		hashErr       error
+		policyErr     error
+		status        model.UserStatus
+		sendErr       error
 
 		expectedHTTPCode int
 		expectedHTTPBody []byte
@@ -91,46 +210,109 @@ func TestRegister(t *testing.T) {
 			generatedToken: "x.y.z",
 
 			expectedHTTPCode: 201,
-			expectedHTTPBody: []byte(`"x.y.z"`),
+			expectedHTTPBody: []byte(issuedTokenJSON),
 		},
 		{
-			description: "register admin: passing test",
+			description: "register: pending approval",
 
 			requestBody: []byte(`
 				{
 					"email": "bob@vance-refrigeration.com",
-					"password": "refrigerator2000",
-					"is_admin": true
+					"password": "refrigerator2000"
+				}
+			`),
+			user: &model.User{
+				ID:          42,
+				Email:       "bob@vance-refrigeration.com",
+				TokenUserID: "test",
+				Status:      model.StatusPending,
+			},
+			status:         model.StatusPending,
+			generatedHash:  "fakeHash",
+			generatedToken: "x.y.z",
+
+			expectedHTTPCode: 202,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "register: verification email could not be sent",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
 				}
 			`),
 			user: &model.User{
 				Email:       "bob@vance-refrigeration.com",
 				Password:    "refrigerator2000",
 				TokenUserID: "test",
-				IsAdmin:     true,
 			},
 			generatedHash:  "fakeHash",
 			generatedToken: "x.y.z",
+			sendErr:        errors.New("connection refused"),
 
 			expectedHTTPCode: 201,
-			expectedHTTPBody: []byte(`"x.y.z"`),
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "register: registration is closed",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			policyErr:      errors.Wrap(errortype.ErrForbidden, "registration is closed"),
+			generatedToken: "x.y.z",
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`registration is closed: forbidden`),
 		},
 		{
-			description: "register admin: admin has already been setup",
+			description: "register admin: role can only be given by an admin",
 
 			requestBody: []byte(`
 				{
 					"email": "bob@vance-refrigeration.com",
 					"password": "refrigerator2000",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
-			generatedHash:  "fakeHash",
 			generatedToken: "x.y.z",
-			adminExists:    true,
 
 			expectedHTTPCode: 403,
-			expectedHTTPBody: []byte(`admin account has already been created`),
+			expectedHTTPBody: []byte(`the admin role can only be given by an admin`),
+		},
+		{
+			description: "register author: role can only be given by an admin",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000",
+					"role": "author"
+				}
+			`),
+			generatedToken: "x.y.z",
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`the author role can only be given by an admin`),
+		},
+		{
+			description: "invalid role",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000",
+					"role": "superuser"
+				}
+			`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Key: 'User.Role' Error:Field validation for 'Role' failed on the 'oneof' tag`),
 		},
 		{
 			description: "invalid email address",
@@ -139,7 +321,7 @@ func TestRegister(t *testing.T) {
 				{
 					"email": "not-an-email-address",
 					"password": "refrigerator2000",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -153,7 +335,7 @@ func TestRegister(t *testing.T) {
 				{
 					"email": "bob@vance-refrigeration.com",
 					"password": "12345",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -167,7 +349,7 @@ func TestRegister(t *testing.T) {
 				{
 					"email": "bob@vance-refrigeration.com",
 					"password": "123456789012345",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -247,25 +429,21 @@ func TestRegister(t *testing.T) {
 			log := logger.NewZeroLog(logsBuff)
 
 			userRepositoryMock := &repo.UserRepositoryMock{}
-			if (test.generatedHash != "" || test.repositoryErr != nil) && !test.adminExists {
+			if test.generatedHash != "" || test.repositoryErr != nil {
 				userRepositoryMock.
 					On("Store", mock.AnythingOfType("*model.User")).
 					Return(test.user, test.repositoryErr).
 					Once()
 			}
 
-			if strings.Contains(string(test.requestBody), "is_admin") && test.generatedHash != "" {
-				userRepositoryMock.On("AdminExists").Return(test.adminExists).Once()
-			}
-
 			tokenMock := &TokenGeneratorMock{}
 			if test.generatedToken != "" {
 				tokenMock.On("GenerateID").Return("test").Once()
 			}
-			if test.repositoryErr == nil && !test.adminExists && test.generatedHash != "" {
+			if test.repositoryErr == nil && test.generatedHash != "" && test.status != model.StatusPending {
 				tokenMock.
-					On("Login", mock.AnythingOfType("*model.User")).
-					Return("x.y.z", test.loginErr).
+					On("Login", mock.AnythingOfType("*model.User"), []model.Scope(nil), &model.Client{}).
+					Return(issuedToken, nil, test.loginErr).
 					Once()
 			}
 
@@ -277,10 +455,38 @@ func TestRegister(t *testing.T) {
 					Once()
 			}
 
+			registrationMock := &RegistrationPolicyMock{}
+			if test.generatedHash != "" || test.repositoryErr != nil || test.policyErr != nil {
+				registrationMock.
+					On("Check", "bob@vance-refrigeration.com", false).
+					Return(test.policyErr).
+					Once()
+			}
+			if test.generatedHash != "" || test.repositoryErr != nil {
+				status := test.status
+				if status == "" {
+					status = model.StatusActive
+				}
+				registrationMock.
+					On("Status").
+					Return(status).
+					Once()
+			}
+
+			verifierMock := &EmailVerifierMock{}
+			if test.generatedHash != "" && test.repositoryErr == nil {
+				verifierMock.
+					On("Send", test.user).
+					Return(test.sendErr).
+					Once()
+			}
+
 			userController := &User{
-				users:  userRepositoryMock,
-				tokens: tokenMock,
-				hasher: hasherMock,
+				users:        userRepositoryMock,
+				tokens:       tokenMock,
+				hasher:       hasherMock,
+				registration: registrationMock,
+				verifier:     verifierMock,
 
 				log: log,
 			}
@@ -289,7 +495,7 @@ func TestRegister(t *testing.T) {
 
 			if err == nil {
 				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
-				assert.Equal(t, string(test.expectedHTTPBody), w.Body.String(), "wrong response body")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
 			} else {
 				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
 				if test.expectedHTTPBody != nil {
@@ -302,6 +508,8 @@ func TestRegister(t *testing.T) {
 			userRepositoryMock.AssertExpectations(t)
 			tokenMock.AssertExpectations(t)
 			hasherMock.AssertExpectations(t)
+			registrationMock.AssertExpectations(t)
+			verifierMock.AssertExpectations(t)
 		})
 	}
 }
@@ -312,10 +520,15 @@ func TestLogin(t *testing.T) {
 
 		requestBody []byte
 		loginErr    error
+		challenge   *model.MFAChallenge
 		validUser   bool
+		scopes      []model.Scope
+		wait        time.Duration
+		checkErr    error
 
-		expectedHTTPCode int
-		expectedHTTPBody []byte
+		expectedHTTPCode   int
+		expectedHTTPBody   []byte
+		expectedRetryAfter string
 	}{
 		{
 			description: "login: passing test",
@@ -328,8 +541,130 @@ func TestLogin(t *testing.T) {
 			`),
 			validUser: true,
 
-			expectedHTTPCode: 201,
-			expectedHTTPBody: []byte(`"x.y.z"`),
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "login: narrower scopes requested",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000",
+					"scope": "posts:read  posts:write posts:read"
+				}
+			`),
+			validUser: true,
+			scopes:    []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "login: two-factor authentication enabled",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			validUser: true,
+			challenge: &model.MFAChallenge{MFARequired: true, MFAToken: "fakeMFAToken", ExpiresIn: 300},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(`{"mfa_required":true,"mfa_token":"fakeMFAToken","expires_in":300}`),
+		},
+		{
+			description: "login: scope not granted to the user's role",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000",
+					"scope": "users:admin"
+				}
+			`),
+			validUser: true,
+			scopes:    []model.Scope{model.ScopeUsersAdmin},
+			loginErr:  errors.Wrap(errortype.ErrInvalidScope, "scope users:admin can't be granted to reader users"),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`scope users:admin can't be granted to reader users: invalid scope`),
+		},
+		{
+			description: "login: wrong password",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2001"
+				}
+			`),
+			validUser: true,
+			loginErr:  errors.Wrap(errortype.ErrInvalidCredentials, "invalid password"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`invalid email or password`),
+		},
+		{
+			description: "login: unknown user",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			validUser: true,
+			loginErr:  errors.Wrap(errortype.ErrInvalidCredentials, "user not found"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`invalid email or password`),
+		},
+		{
+			description: "login: account pending approval",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			validUser: true,
+			loginErr:  errors.Wrap(errortype.ErrForbidden, "account is pending approval"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`account is pending approval: forbidden`),
+		},
+		{
+			description: "login: too many failed attempts",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			wait: 1500 * time.Millisecond,
+
+			expectedHTTPCode:   429,
+			expectedHTTPBody:   []byte(`too many failed login attempts`),
+			expectedRetryAfter: "2",
+		},
+		{
+			description: "login: failed attempts can't be checked",
+
+			requestBody: []byte(`
+				{
+					"email": "bob@vance-refrigeration.com",
+					"password": "refrigerator2000"
+				}
+			`),
+			checkErr: errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not check failed login attempts: dummy error`),
 		},
 		{
 			description: "invalid email address",
@@ -338,7 +673,7 @@ func TestLogin(t *testing.T) {
 				{
 					"email": "not-an-email-address",
 					"password": "refrigerator2000",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -352,7 +687,7 @@ func TestLogin(t *testing.T) {
 				{
 					"email": "bob@vance-refrigeration.com",
 					"password": "12345",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -402,12 +737,38 @@ func TestLogin(t *testing.T) {
 
 			tokenMock := &TokenGeneratorMock{}
 			if test.validUser {
+				token := issuedToken
+				if test.challenge != nil {
+					token = nil
+				}
 				tokenMock.
-					On("Login", mock.AnythingOfType("*model.User")).
-					Return("x.y.z", test.loginErr).
+					On("Login", mock.AnythingOfType("*model.User"), test.scopes, &model.Client{IP: "192.0.2.1", UserAgent: "Mozilla/5.0"}).
+					Return(token, test.challenge, test.loginErr).
 					Once()
 			}
 
+			throttleMock := &LoginThrottleMock{}
+			if test.validUser || test.wait > 0 || test.checkErr != nil {
+				throttleMock.
+					On("Check", "bob@vance-refrigeration.com", "192.0.2.1").
+					Return(test.wait, test.checkErr).
+					Once()
+			}
+			if test.validUser {
+				switch errors.Cause(test.loginErr) {
+				case nil:
+					throttleMock.
+						On("Succeed", "bob@vance-refrigeration.com").
+						Return(nil).
+						Once()
+				case errortype.ErrInvalidCredentials:
+					throttleMock.
+						On("Fail", "bob@vance-refrigeration.com", "192.0.2.1").
+						Return(nil).
+						Once()
+				}
+			}
+
 			hasherMock := &HasherMock{}
 			hasherMock.
 				On("Hash", mock.AnythingOfType("string")).
@@ -415,16 +776,19 @@ func TestLogin(t *testing.T) {
 				Once()
 
 			userController := &User{
-				tokens: tokenMock,
+				tokens:   tokenMock,
+				throttle: throttleMock,
 
 				log: log,
 			}
 
+			r.RemoteAddr = "192.0.2.1:4242"
+			r.Header.Set("User-Agent", "Mozilla/5.0")
 			err = userController.Login(ctx)
 
 			if err == nil {
 				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
-				assert.Equal(t, string(test.expectedHTTPBody), w.Body.String(), "wrong response body")
+				assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
 			} else {
 				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
 				if test.expectedHTTPBody != nil {
@@ -433,8 +797,1692 @@ func TestLogin(t *testing.T) {
 					assert.Contains(t, w.Body, nil, "unexpected error response")
 				}
 			}
+			assert.Equal(t, test.expectedRetryAfter, w.Header().Get("Retry-After"), "wrong Retry-After header")
 
 			tokenMock.AssertExpectations(t)
+			throttleMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	tests := []struct {
+		description string
+
+		userID        string
+		requestBody   []byte
+		repositoryErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "role changed",
+
+			userID:      "42",
+			requestBody: []byte(`{"role": "author"}`),
+
+			expectedHTTPCode: 204,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "bad request: invalid user id",
+
+			userID: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse user ID`),
+		},
+		{
+			description: "bad request: not json",
+
+			userID:      "42",
+			requestBody: []byte(`potato`),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse role from request body`),
+		},
+		{
+			description: "unprocessable entity: missing role",
+
+			userID:      "42",
+			requestBody: []byte(`{}`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Error:Field validation for 'Role' failed on the 'required' tag`),
+		},
+		{
+			description: "unprocessable entity: unknown role",
+
+			userID:      "42",
+			requestBody: []byte(`{"role": "superuser"}`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`Error:Field validation for 'Role' failed on the 'oneof' tag`),
+		},
+		{
+			description: "not found",
+
+			userID:        "42",
+			requestBody:   []byte(`{"role": "author"}`),
+			repositoryErr: &ResourceNotFoundErr{},
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`user id 42: resource not found`),
+		},
+		{
+			description: "conflict: last admin",
+
+			userID:        "42",
+			requestBody:   []byte(`{"role": "author"}`),
+			repositoryErr: errors.Wrap(errortype.ErrConflict, "the last admin can't be demoted"),
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: []byte(`the last admin can't be demoted: datamodel conflict`),
+		},
+		{
+			description: "internal server error: repository failure",
+
+			userID:        "42",
+			requestBody:   []byte(`{"role": "author"}`),
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not change user role: database exploded`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.PUT, "/users/", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.userID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.repositoryErr != nil || test.expectedHTTPCode == 204 {
+				userRepositoryMock.
+					On("SetRole", uint(42), model.RoleAuthor).
+					Return(test.repositoryErr).
+					Once()
+			}
+
+			userController := &User{
+				users: userRepositoryMock,
+
+				log: log,
+			}
+
+			err = userController.SetRole(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), w.Body.String(), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestApprove(t *testing.T) {
+	tests := []struct {
+		description string
+
+		userID        string
+		repositoryErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "user approved",
+
+			userID: "42",
+
+			expectedHTTPCode: 204,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "bad request: invalid user id",
+
+			userID: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse user ID`),
+		},
+		{
+			description: "not found: unknown or already approved user",
+
+			userID:        "42",
+			repositoryErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`pending user id 42: resource not found`),
+		},
+		{
+			description: "internal server error: repository failure",
+
+			userID:        "42",
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not approve user: database exploded`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.userID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.userID == "42" {
+				userRepositoryMock.
+					On("Approve", uint(42)).
+					Return(test.repositoryErr).
+					Once()
+			}
+
+			userController := &User{
+				users: userRepositoryMock,
+
+				log: log,
+			}
+
+			err = userController.Approve(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), w.Body.String(), "wrong response body")
+				assert.Contains(t, logsBuff.String(), "user approved", "approval should be logged")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	tests := []struct {
+		description string
+
+		userID        string
+		repositoryErr error
+		unlockErr     error
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "user unlocked",
+
+			userID: "42",
+
+			expectedHTTPCode: 204,
+			expectedHTTPBody: []byte(``),
+		},
+		{
+			description: "bad request: invalid user id",
+
+			userID: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`could not parse user ID`),
+		},
+		{
+			description: "not found",
+
+			userID:        "42",
+			repositoryErr: &ResourceNotFoundErr{},
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`user id 42: resource not found`),
+		},
+		{
+			description: "not found: user id 0",
+
+			userID:        "0",
+			repositoryErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: []byte(`user id 0: resource not found`),
+		},
+		{
+			description: "internal server error: repository failure",
+
+			userID:        "42",
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not retrieve user: database exploded`),
+		},
+		{
+			description: "internal server error: throttle failure",
+
+			userID:    "42",
+			unlockErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not unlock user: database exploded`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			// initialize the echo context to use for the test
+			e := echo.New()
+			r, err := http.NewRequest(echo.DELETE, "/users/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.userID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			user := &model.User{
+				ID:    42,
+				Email: "bob@vance-refrigeration.com",
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			throttleMock := &LoginThrottleMock{}
+			if test.userID != "potato" {
+				id, _ := strconv.ParseUint(test.userID, 10, 64)
+				userRepositoryMock.
+					On("FindByID", uint(id)).
+					Return(user, test.repositoryErr).
+					Once()
+			}
+			if test.userID == "42" && test.repositoryErr == nil {
+				throttleMock.
+					On("Unlock", "bob@vance-refrigeration.com").
+					Return(test.unlockErr).
+					Once()
+			}
+
+			userController := &User{
+				users:    userRepositoryMock,
+				throttle: throttleMock,
+
+				log: log,
+			}
+
+			err = userController.Unlock(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, string(test.expectedHTTPBody), w.Body.String(), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			throttleMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody string
+		loginErr    error
+
+		expectLogin      bool
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "login completed",
+
+			requestBody: `{"mfa_token":"fakeMFAToken","code":"123456"}`,
+
+			expectLogin:      true,
+			expectedHTTPCode: 200,
+			expectedHTTPBody: issuedTokenJSON,
+		},
+		{
+			description: "unauthorized: invalid code",
+
+			requestBody: `{"mfa_token":"fakeMFAToken","code":"123456"}`,
+			loginErr:    errors.Wrap(errortype.ErrInvalidCredentials, "code was already used"),
+
+			expectLogin:      true,
+			expectedHTTPCode: 401,
+			expectedHTTPBody: "invalid code",
+		},
+		{
+			description: "unauthorized: invalid MFA token",
+
+			requestBody: `{"mfa_token":"fakeMFAToken","code":"123456"}`,
+			loginErr:    errors.Wrap(errortype.ErrInvalidToken, "MFA token is expired"),
+
+			expectLogin:      true,
+			expectedHTTPCode: 401,
+			expectedHTTPBody: "MFA token is expired: invalid token",
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"mfa_token":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse MFA login from request body",
+		},
+		{
+			description: "unprocessable entity: missing code",
+
+			requestBody: `{"mfa_token":"fakeMFAToken"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Code' failed on the 'required' tag",
+		},
+		{
+			description: "internal server error: token service failure",
+
+			requestBody: `{"mfa_token":"fakeMFAToken","code":"123456"}`,
+			loginErr:    errors.New("database exploded"),
+
+			expectLogin:      true,
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not complete login: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/login/mfa", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			tokenMock := &TokenGeneratorMock{}
+			if test.expectLogin {
+				tokenMock.
+					On("LoginMFA", "fakeMFAToken", "123456", &model.Client{}).
+					Return(issuedToken, test.loginErr).
+					Once()
+			}
+
+			userController := &User{
+				tokens: tokenMock,
+
+				log: log,
+			}
+
+			err = userController.LoginMFA(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			tokenMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody   []byte
+		refreshCookie string
+		csrfCookie    string
+		csrfHeader    string
+		refreshErr    error
+		expectCall    bool
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+		expectCookies    bool
+	}{
+		{
+			description: "refresh: passing test",
+
+			requestBody: []byte(`{"refresh_token": "fakeRefreshToken"}`),
+			expectCall:  true,
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "invalid refresh token",
+
+			requestBody: []byte(`{"refresh_token": "fakeRefreshToken"}`),
+			expectCall:  true,
+			refreshErr:  errors.Wrap(errortype.ErrInvalidToken, "refresh token was already used"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: []byte(`refresh token was already used: invalid token`),
+		},
+		{
+			description: "refresh error",
+
+			requestBody: []byte(`{"refresh_token": "fakeRefreshToken"}`),
+			expectCall:  true,
+			refreshErr:  errors.New("dummy error"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not refresh token: dummy error`),
+		},
+		{
+			description: "missing refresh token",
+
+			requestBody: []byte(`{}`),
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`missing refresh token`),
+		},
+		{
+			description: "missing refresh token without body",
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: []byte(`missing refresh token`),
+		},
+		{
+			description: "refresh token in cookie",
+
+			refreshCookie: "fakeRefreshToken",
+			csrfCookie:    "fakeCSRF",
+			csrfHeader:    "fakeCSRF",
+			expectCall:    true,
+
+			expectedHTTPCode: 200,
+			expectCookies:    true,
+		},
+		{
+			description: "refresh token in cookie without the CSRF token",
+
+			refreshCookie: "fakeRefreshToken",
+			csrfCookie:    "fakeCSRF",
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: []byte(`missing or invalid CSRF token`),
+		},
+		{
+			description: "refresh token in body takes precedence over cookie",
+
+			requestBody:   []byte(`{"refresh_token": "fakeRefreshToken"}`),
+			refreshCookie: "otherRefreshToken",
+			expectCall:    true,
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: []byte(issuedTokenJSON),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`Syntax error: offset=1, error=invalid character 'p' looking for beginning of value`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/token/refresh", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if test.refreshCookie != "" {
+				r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: test.refreshCookie})
+			}
+			if test.csrfCookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfTokenCookie, Value: test.csrfCookie})
+			}
+			if test.csrfHeader != "" {
+				r.Header.Set(echo.HeaderXCSRFToken, test.csrfHeader)
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			tokenMock := &TokenGeneratorMock{}
+			if test.expectCall {
+				tokenMock.
+					On("Refresh", "fakeRefreshToken").
+					Return(issuedToken, test.refreshErr).
+					Once()
+			}
+
+			userController := &User{
+				tokens:  tokenMock,
+				cookies: NewCookieAuth("/api", true, time.Hour),
+
+				log: log,
+			}
+
+			err = userController.Refresh(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				if test.expectCookies {
+					cookies := responseCookies(w)
+					assert.Equal(t, issuedToken.AccessToken, cookies[accessTokenCookie], "wrong access token cookie")
+					assert.Equal(t, issuedToken.RefreshToken, cookies[refreshTokenCookie], "wrong refresh token cookie")
+					assert.NotContains(t, w.Body.String(), issuedToken.RefreshToken, "tokens should not be in the response body")
+				} else {
+					assert.Equal(t, string(test.expectedHTTPBody), strings.TrimSpace(w.Body.String()), "wrong response body")
+				}
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			tokenMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		description string
+
+		requestBody   []byte
+		refreshCookie string
+		cookieAuth    bool
+		tokenID       interface{}
+		sessionID     string
+		refreshToken  string
+		logoutErr     error
+		expectCall    bool
+
+		expectedHTTPCode int
+		expectedHTTPBody []byte
+	}{
+		{
+			description: "logout: passing test",
+
+			requestBody:  []byte(`{"refresh_token": "fakeRefreshToken"}`),
+			tokenID:      "fakeJTI",
+			sessionID:    "fakeSID",
+			refreshToken: "fakeRefreshToken",
+			expectCall:   true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "logout without refresh token",
+
+			tokenID:    "fakeJTI",
+			expectCall: true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "logout with cookies",
+
+			refreshCookie: "fakeRefreshToken",
+			cookieAuth:    true,
+			tokenID:       "fakeJTI",
+			sessionID:     "fakeSID",
+			refreshToken:  "fakeRefreshToken",
+			expectCall:    true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "logout error",
+
+			tokenID:    "fakeJTI",
+			logoutErr:  errors.New("dummy error"),
+			expectCall: true,
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`could not log out: dummy error`),
+		},
+		{
+			description: "not json",
+
+			requestBody: []byte(`potato`),
+			tokenID:     "fakeJTI",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: []byte(`Syntax error: offset=1, error=invalid character 'p' looking for beginning of value`),
+		},
+		{
+			description: "token ID not in context",
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: []byte(`tokenID not set in request context`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/logout", bytes.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if test.refreshCookie != "" {
+				r.AddCookie(&http.Cookie{Name: refreshTokenCookie, Value: test.refreshCookie})
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("cookieAuth", test.cookieAuth)
+			ctx.Set("tokenID", test.tokenID)
+			ctx.Set("sessionID", test.sessionID)
+			ctx.Set("tokenExpiresAt", expiresAt)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			tokenMock := &TokenGeneratorMock{}
+			if test.expectCall {
+				tokenMock.
+					On("Logout", test.tokenID, test.sessionID, expiresAt, test.refreshToken).
+					Return(test.logoutErr).
+					Once()
+			}
+
+			userController := &User{
+				tokens:  tokenMock,
+				cookies: NewCookieAuth("/api", true, time.Hour),
+
+				log: log,
+			}
+
+			err = userController.Logout(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+
+				// Browsers authenticated with cookies have them removed
+				_, cleared := responseCookies(w)[accessTokenCookie]
+				assert.Equal(t, test.cookieAuth, cleared, "wrong cookie removal")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), string(test.expectedHTTPBody), "unexpected error response")
+			}
+
+			tokenMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	users := []*model.User{
+		{ID: 1, TokenUserID: "abc", Email: "michael@dunder-mifflin.com", EmailVerified: true, Password: "hash", Role: model.RoleAdmin, Status: model.StatusActive},
+		{ID: 2, TokenUserID: "def", Email: "dwight@dunder-mifflin.com", Password: "hash", Role: model.RoleAuthor, Status: model.StatusActive},
+	}
+
+	tests := []struct {
+		description string
+
+		query string
+
+		expectCall     bool
+		expectedStatus model.UserStatus
+		expectedOffset uint
+		expectedLimit  uint
+		users          []*model.User
+		total          uint
+		repositoryErr  error
+
+		expectedHTTPCode  int
+		expectedHTTPBody  string
+		expectedTotalHead string
+	}{
+		{
+			description: "default page",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			users:         users,
+			total:         2,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[{"id":1,"user_id":"abc","email":"michael@dunder-mifflin.com","email_verified":true,"role":"admin","status":"active"},{"id":2,"user_id":"def","email":"dwight@dunder-mifflin.com","email_verified":false,"role":"author","status":"active"}]`,
+			expectedTotalHead: "2",
+		},
+		{
+			description: "filtered page",
+
+			query: "?status=pending&offset=40&limit=10",
+
+			expectCall:     true,
+			expectedStatus: model.StatusPending,
+			expectedOffset: 40,
+			expectedLimit:  10,
+			total:          42,
+
+			expectedHTTPCode:  200,
+			expectedHTTPBody:  `[]`,
+			expectedTotalHead: "42",
+		},
+		{
+			description: "bad request: limit too high",
+
+			query: "?limit=1000",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "limit must be between 1 and 100",
+		},
+		{
+			description: "bad request: invalid offset",
+
+			query: "?offset=potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse offset",
+		},
+		{
+			description: "bad request: unknown status",
+
+			query: "?status=potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: `unknown user status "potato"`,
+		},
+		{
+			description: "internal server error: repository failure",
+
+			expectCall:    true,
+			expectedLimit: 20,
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not list users: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/users"+test.query, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectCall {
+				userRepositoryMock.
+					On("List", test.expectedStatus, test.expectedOffset, test.expectedLimit).
+					Return(test.users, test.total, test.repositoryErr).
+					Once()
+			}
+
+			userController := &User{
+				users: userRepositoryMock,
+
+				log: log,
+			}
+
+			err = userController.List(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+				assert.Equal(t, test.expectedTotalHead, w.Header().Get("X-Total-Count"), "wrong total count")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMe(t *testing.T) {
+	tests := []struct {
+		description string
+
+		user          *model.User
+		actorID       string
+		repositoryErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "user found",
+
+			user: &model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com", EmailVerified: true, Password: "hash", Role: model.RoleReader, Status: model.StatusActive},
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"id":42,"user_id":"abc","email":"pam@dunder-mifflin.com","email_verified":true,"role":"reader","status":"active"}`,
+		},
+		{
+			description: "user found through impersonation",
+
+			user:    &model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com", EmailVerified: true, Password: "hash", Role: model.RoleReader, Status: model.StatusActive},
+			actorID: "admin",
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"id":42,"user_id":"abc","email":"pam@dunder-mifflin.com","email_verified":true,"role":"reader","status":"active","impersonated_by":"admin"}`,
+		},
+		{
+			description: "not found: user was deleted",
+
+			repositoryErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "user abc: resource not found",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not retrieve user: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/users/me", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("actorID", test.actorID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{TokenUserID: "abc"}).
+				Return(test.user, test.repositoryErr).
+				Once()
+
+			userController := &User{
+				users: userRepositoryMock,
+
+				log: log,
+			}
+
+			err = userController.Me(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateMe(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody     string
+		personalTokenID uint
+
+		expectRetrieve bool
+		expectCheck    bool
+		checkErr       error
+		expectUpdate   bool
+		email          string
+		updateErr      error
+		sendErr        error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "email changed",
+
+			requestBody: `{"email":"pam@athlead.com"}`,
+
+			expectRetrieve: true,
+			expectUpdate:   true,
+			email:          "pam@athlead.com",
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"id":42,"user_id":"abc","email":"pam@athlead.com","email_verified":false,"role":"reader","status":"active"}`,
+		},
+		{
+			description: "email changed but verification email could not be sent",
+
+			requestBody: `{"email":"pam@athlead.com"}`,
+
+			expectRetrieve: true,
+			expectUpdate:   true,
+			email:          "pam@athlead.com",
+			sendErr:        errors.New("connection refused"),
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"id":42,"user_id":"abc","email":"pam@athlead.com","email_verified":false,"role":"reader","status":"active"}`,
+		},
+		{
+			description: "nothing to change",
+
+			requestBody: `{"email":"pam@dunder-mifflin.com"}`,
+
+			expectRetrieve: true,
+
+			expectedHTTPCode: 200,
+			expectedHTTPBody: `{"id":42,"user_id":"abc","email":"pam@dunder-mifflin.com","email_verified":true,"role":"reader","status":"active"}`,
+		},
+		{
+			description: "forbidden: personal access token",
+
+			requestBody:     `{"email":"pam@athlead.com"}`,
+			personalTokenID: 7,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "personal access tokens can't be used to update accounts",
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"email":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse user data from request body",
+		},
+		{
+			description: "unprocessable entity: invalid email",
+
+			requestBody: `{"email":"potato"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'Email' failed on the 'email' tag",
+		},
+		{
+			description: "forbidden: email domain not allowed",
+
+			requestBody: `{"email":"pam@athlead.com"}`,
+
+			expectRetrieve: true,
+			expectCheck:    true,
+			email:          "pam@athlead.com",
+			checkErr:       errors.Wrap(errortype.ErrForbidden, "email addresses of this domain are not allowed"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "email addresses of this domain are not allowed: forbidden",
+		},
+		{
+			description: "conflict: email already in use",
+
+			requestBody: `{"email":"angela@dunder-mifflin.com"}`,
+
+			expectRetrieve: true,
+			expectUpdate:   true,
+			email:          "angela@dunder-mifflin.com",
+			updateErr:      errortype.ErrDuplicateEntry,
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "email address already in use",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			requestBody: `{"email":"pam@athlead.com"}`,
+
+			expectRetrieve: true,
+			expectUpdate:   true,
+			email:          "pam@athlead.com",
+			updateErr:      errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not update user: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.PATCH, "/users/me", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "abc"}).
+					Return(&model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com", EmailVerified: true, Password: "hash", Role: model.RoleReader, Status: model.StatusActive}, nil).
+					Once()
+			}
+			if test.expectUpdate {
+				userRepositoryMock.
+					On("UpdateEmail", uint(42), test.email).
+					Return(test.updateErr).
+					Once()
+			}
+
+			registrationMock := &RegistrationPolicyMock{}
+			if test.expectCheck || test.expectUpdate {
+				registrationMock.
+					On("CheckEmail", test.email).
+					Return(test.checkErr).
+					Once()
+			}
+
+			// The new email address has to be verified again
+			verifierMock := &EmailVerifierMock{}
+			if test.expectUpdate && test.updateErr == nil {
+				verifierMock.
+					On("Send", &model.User{ID: 42, TokenUserID: "abc", Email: test.email, Password: "hash", Role: model.RoleReader, Status: model.StatusActive}).
+					Return(test.sendErr).
+					Once()
+			}
+
+			userController := &User{
+				users:        userRepositoryMock,
+				registration: registrationMock,
+				verifier:     verifierMock,
+
+				log: log,
+			}
+
+			err = userController.UpdateMe(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Equal(t, test.expectedHTTPBody, strings.TrimSpace(w.Body.String()), "wrong response body")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			registrationMock.AssertExpectations(t)
+			verifierMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	tests := []struct {
+		description string
+
+		requestBody     string
+		personalTokenID uint
+
+		expectRetrieve bool
+		wait           time.Duration
+		checkErr       error
+		expectCompare  bool
+		compareErr     error
+		expectHash     bool
+		hashErr        error
+		expectUpdate   bool
+		updateErr      error
+		expectRevoke   bool
+		revokeErr      error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "password changed",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			expectCompare:  true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectRevoke:   true,
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "forbidden: wrong current password",
+
+			requestBody: `{"current_password":"potato1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			expectCompare:  true,
+			compareErr:     errors.New("mismatched hash and password"),
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "invalid current password",
+		},
+		{
+			description: "too many requests: too many failed attempts",
+
+			requestBody: `{"current_password":"potato1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			wait:           1500 * time.Millisecond,
+
+			expectedHTTPCode: 429,
+			expectedHTTPBody: "too many failed login attempts",
+		},
+		{
+			description: "forbidden: personal access token",
+
+			requestBody:     `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+			personalTokenID: 7,
+
+			expectedHTTPCode: 403,
+			expectedHTTPBody: "personal access tokens can't be used to change passwords",
+		},
+		{
+			description: "bad request: invalid body",
+
+			requestBody: `{"current_password":`,
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse passwords from request body",
+		},
+		{
+			description: "unprocessable entity: new password too short",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"short"}`,
+
+			expectedHTTPCode: 422,
+			expectedHTTPBody: "Field validation for 'NewPassword' failed on the 'min' tag",
+		},
+		{
+			description: "internal server error: throttle failure",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			checkErr:       errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not check failed login attempts: database exploded",
+		},
+		{
+			description: "internal server error: hashing failure",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			expectCompare:  true,
+			expectHash:     true,
+			hashErr:        errors.New("out of entropy"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not hash password: out of entropy",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			expectCompare:  true,
+			expectHash:     true,
+			expectUpdate:   true,
+			updateErr:      errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not update password: database exploded",
+		},
+		{
+			description: "internal server error: sessions can't be revoked",
+
+			requestBody: `{"current_password":"beesly1234","new_password":"halpert1234"}`,
+
+			expectRetrieve: true,
+			expectCompare:  true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectRevoke:   true,
+			revokeErr:      errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not revoke other sessions: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.PUT, "/users/me/password", strings.NewReader(test.requestBody))
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+			r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+			ctx.Set("sessionID", "fakeSession")
+			ctx.Set("personalTokenID", test.personalTokenID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			hasherMock := &HasherMock{}
+			throttleMock := &LoginThrottleMock{}
+			sessionsMock := &SessionRevokerMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "abc"}).
+					Return(&model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com", Password: "old-hash"}, nil).
+					Once()
+
+				throttleMock.
+					On("Check", "pam@dunder-mifflin.com", "").
+					Return(test.wait, test.checkErr).
+					Once()
+			}
+			if test.expectCompare {
+				hasherMock.
+					On("Compare", "old-hash", mock.AnythingOfType("string")).
+					Return(test.compareErr).
+					Once()
+
+				// Wrong passwords count as failed logins
+				if test.compareErr != nil {
+					throttleMock.On("Fail", "pam@dunder-mifflin.com", "").Return(nil).Once()
+				} else {
+					throttleMock.On("Succeed", "pam@dunder-mifflin.com").Return(nil).Once()
+				}
+			}
+			if test.expectHash {
+				hasherMock.
+					On("Hash", "halpert1234").
+					Return("new-hash", test.hashErr).
+					Once()
+			}
+			if test.expectUpdate {
+				userRepositoryMock.
+					On("UpdatePassword", uint(42), "new-hash").
+					Return(test.updateErr).
+					Once()
+			}
+			if test.expectRevoke {
+				sessionsMock.
+					On("RevokeOthers", "abc", "fakeSession").
+					Return(test.revokeErr).
+					Once()
+			}
+
+			userController := &User{
+				users:    userRepositoryMock,
+				hasher:   hasherMock,
+				throttle: throttleMock,
+				sessions: sessionsMock,
+
+				log: log,
+			}
+
+			err = userController.ChangePassword(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Contains(t, logsBuff.String(), "user password changed", "password change should be logged")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+			if test.wait > 0 {
+				assert.Equal(t, "2", w.Header().Get("Retry-After"), "wrong Retry-After header")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			hasherMock.AssertExpectations(t)
+			throttleMock.AssertExpectations(t)
+			sessionsMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeactivate(t *testing.T) {
+	tests := []struct {
+		description string
+
+		userID        string
+		repositoryErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "user deactivated",
+
+			userID: "42",
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "bad request: invalid user id",
+
+			userID: "potato",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "could not parse user ID",
+		},
+		{
+			description: "not found: unknown user",
+
+			userID:        "42",
+			repositoryErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "user id 42: resource not found",
+		},
+		{
+			description: "conflict: last admin",
+
+			userID:        "42",
+			repositoryErr: errors.Wrap(errortype.ErrConflict, "the last admin can't be deactivated"),
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "the last admin can't be deactivated",
+		},
+		{
+			description: "internal server error: repository failure",
+
+			userID:        "42",
+			repositoryErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not deactivate user: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(test.userID)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.userID == "42" {
+				userRepositoryMock.
+					On("Deactivate", uint(42)).
+					Return(test.repositoryErr).
+					Once()
+			}
+
+			userController := &User{
+				users: userRepositoryMock,
+
+				log: log,
+			}
+
+			err = userController.Deactivate(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+				assert.Contains(t, logsBuff.String(), "user deactivated", "deactivation should be logged")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	tests := []struct {
+		description string
+
+		token     string
+		verifyErr error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "email address verified",
+
+			token: "x.y.z",
+
+			expectedHTTPCode: 204,
+		},
+		{
+			description: "bad request: missing token",
+
+			expectedHTTPCode: 400,
+			expectedHTTPBody: "missing verification token",
+		},
+		{
+			description: "unauthorized: invalid token",
+
+			token:     "x.y.z",
+			verifyErr: errors.Wrap(errortype.ErrInvalidToken, "verification token has expired"),
+
+			expectedHTTPCode: 401,
+			expectedHTTPBody: "verification token has expired: invalid token",
+		},
+		{
+			description: "internal server error: verification failure",
+
+			token:     "x.y.z",
+			verifyErr: errors.New("database exploded"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not verify email address: database exploded",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.GET, "/verify-email?token="+test.token, nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			verifierMock := &EmailVerifierMock{}
+			if test.token != "" {
+				verifierMock.
+					On("Verify", test.token).
+					Return(test.verifyErr).
+					Once()
+			}
+
+			userController := &User{
+				verifier: verifierMock,
+
+				log: log,
+			}
+
+			err = userController.VerifyEmail(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			verifierMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	tests := []struct {
+		description string
+
+		user          *model.User
+		repositoryErr error
+		expectSend    bool
+		sendErr       error
+
+		expectedHTTPCode int
+		expectedHTTPBody string
+	}{
+		{
+			description: "verification link sent",
+
+			user:       &model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com"},
+			expectSend: true,
+
+			expectedHTTPCode: 202,
+		},
+		{
+			description: "conflict: already verified",
+
+			user: &model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com", EmailVerified: true},
+
+			expectedHTTPCode: 409,
+			expectedHTTPBody: "email address is already verified",
+		},
+		{
+			description: "not found: user was deleted",
+
+			repositoryErr: errortype.ErrNotFound,
+
+			expectedHTTPCode: 404,
+			expectedHTTPBody: "user abc: resource not found",
+		},
+		{
+			description: "internal server error: mailer failure",
+
+			user:       &model.User{ID: 42, TokenUserID: "abc", Email: "pam@dunder-mifflin.com"},
+			expectSend: true,
+			sendErr:    errors.New("connection refused"),
+
+			expectedHTTPCode: 500,
+			expectedHTTPBody: "could not send verification email: connection refused",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			e := echo.New()
+			r, err := http.NewRequest(echo.POST, "/users/me/verify-email", nil)
+			if err != nil {
+				t.Fatal("could not create request")
+			}
+
+			w := httptest.NewRecorder()
+			ctx := e.NewContext(r, w)
+			ctx.Set("userID", "abc")
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{TokenUserID: "abc"}).
+				Return(test.user, test.repositoryErr).
+				Once()
+
+			verifierMock := &EmailVerifierMock{}
+			if test.expectSend {
+				verifierMock.
+					On("Send", test.user).
+					Return(test.sendErr).
+					Once()
+			}
+
+			userController := &User{
+				users:    userRepositoryMock,
+				verifier: verifierMock,
+
+				log: log,
+			}
+
+			err = userController.ResendVerification(ctx)
+
+			if err == nil {
+				assert.Equal(t, test.expectedHTTPCode, w.Code, "wrong response status")
+			} else {
+				assert.Contains(t, err.Error(), fmt.Sprint(test.expectedHTTPCode), "wrong error response status")
+				assert.Contains(t, err.Error(), test.expectedHTTPBody, "unexpected error response")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			verifierMock.AssertExpectations(t)
 		})
 	}
 }
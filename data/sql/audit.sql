@@ -0,0 +1,22 @@
+SET NAMES utf8;
+SET time_zone = '+00:00';
+SET foreign_key_checks = 0;
+SET sql_mode = 'NO_AUTO_VALUE_ON_ZERO';
+
+SET NAMES utf8mb4;
+
+DROP TABLE IF EXISTS `audit_events`;
+CREATE TABLE `audit_events` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `actor_id` varchar(255) NOT NULL,
+  `subject_id` varchar(255) NOT NULL,
+  `action` varchar(32) NOT NULL,
+  `detail` varchar(512) NOT NULL,
+  `ip` varchar(45) NOT NULL,
+  `user_agent` varchar(512) NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY (`created_at`),
+  KEY (`actor_id`, `created_at`),
+  KEY (`subject_id`, `created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,15 @@
+SET NAMES utf8;
+SET time_zone = '+00:00';
+SET foreign_key_checks = 0;
+SET sql_mode = 'NO_AUTO_VALUE_ON_ZERO';
+
+SET NAMES utf8mb4;
+
+DROP TABLE IF EXISTS `login_throttles`;
+CREATE TABLE `login_throttles` (
+  `key` varchar(320) NOT NULL,
+  `failures` int(10) unsigned NOT NULL,
+  `last_failure_at` datetime NOT NULL,
+  `blocked_until` datetime NOT NULL,
+  PRIMARY KEY (`key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,34 @@
+SET NAMES utf8;
+SET time_zone = '+00:00';
+SET foreign_key_checks = 0;
+SET sql_mode = 'NO_AUTO_VALUE_ON_ZERO';
+
+SET NAMES utf8mb4;
+
+DROP TABLE IF EXISTS `media`;
+CREATE TABLE `media` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `hash` char(64) NOT NULL,
+  `content_type` varchar(255) NOT NULL,
+  `size` bigint(20) NOT NULL,
+  `uploader` varchar(255) NOT NULL,
+  `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `status` varchar(16) NOT NULL DEFAULT 'processing',
+  `width` int(10) unsigned NOT NULL DEFAULT '0',
+  `height` int(10) unsigned NOT NULL DEFAULT '0',
+  `blurhash` varchar(64) NOT NULL DEFAULT '',
+  `dominant_color` char(7) NOT NULL DEFAULT '',
+  `variant_widths` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `hash` (`hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+DROP TABLE IF EXISTS `post_media`;
+CREATE TABLE `post_media` (
+  `post_id` int(10) unsigned NOT NULL,
+  `media_id` int(10) unsigned NOT NULL,
+  PRIMARY KEY (`post_id`, `media_id`),
+  KEY `media_id` (`media_id`),
+  CONSTRAINT `post_media_post` FOREIGN KEY (`post_id`) REFERENCES `blog_posts` (`id`) ON DELETE CASCADE,
+  CONSTRAINT `post_media_media` FOREIGN KEY (`media_id`) REFERENCES `media` (`id`) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,29 @@
+SET NAMES utf8;
+SET time_zone = '+00:00';
+SET foreign_key_checks = 0;
+SET sql_mode = 'NO_AUTO_VALUE_ON_ZERO';
+
+SET NAMES utf8mb4;
+
+DROP TABLE IF EXISTS `identities`;
+CREATE TABLE `identities` (
+  `id` int(10) unsigned NOT NULL AUTO_INCREMENT,
+  `provider` varchar(64) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `user_id` varchar(255) NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY (`provider`, `subject`),
+  KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
+
+DROP TABLE IF EXISTS `oidc_logins`;
+CREATE TABLE `oidc_logins` (
+  `state_hash` char(64) NOT NULL,
+  `provider` varchar(64) NOT NULL,
+  `nonce` varchar(255) NOT NULL,
+  `code_verifier` varchar(255) NOT NULL,
+  `expires_at` datetime NOT NULL,
+  `created_at` datetime NOT NULL,
+  PRIMARY KEY (`state_hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
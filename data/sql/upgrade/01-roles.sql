@@ -0,0 +1,8 @@
+-- Replaces the is_admin flag of users with their role. Admins keep their role,
+-- and every other user becomes a reader.
+
+SET NAMES utf8mb4;
+
+ALTER TABLE `users` ADD `role` varchar(16) NOT NULL DEFAULT 'reader' AFTER `token_user_id`;
+UPDATE `users` SET `role` = 'admin' WHERE `is_admin` = 1;
+ALTER TABLE `users` DROP `is_admin`;
@@ -0,0 +1,6 @@
+-- Adds the status of users, which can wait for the approval of an admin or be
+-- deactivated. Existing users are active.
+
+SET NAMES utf8mb4;
+
+ALTER TABLE `users` ADD `status` varchar(16) NOT NULL DEFAULT 'active' AFTER `role`;
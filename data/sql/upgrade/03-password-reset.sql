@@ -0,0 +1,6 @@
+-- Adds the time of the last password reset of users, before which their access
+-- tokens are no longer accepted.
+
+SET NAMES utf8mb4;
+
+ALTER TABLE `users` ADD `password_reset_at` datetime DEFAULT NULL AFTER `status`;
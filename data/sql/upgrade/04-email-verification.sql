@@ -0,0 +1,6 @@
+-- Adds whether the email address of users was verified. The addresses of existing
+-- users are not.
+
+SET NAMES utf8mb4;
+
+ALTER TABLE `users` ADD `email_verified` tinyint(1) NOT NULL DEFAULT 0 AFTER `status`;
@@ -0,0 +1,9 @@
+-- Adds the TOTP secret of users and whether their two-factor authentication is
+-- enabled. Existing users don't have it enabled.
+
+SET NAMES utf8mb4;
+
+ALTER TABLE `users`
+  ADD `mfa_secret` varchar(64) NOT NULL DEFAULT '' AFTER `password_reset_at`,
+  ADD `mfa_enabled` tinyint(1) NOT NULL DEFAULT 0 AFTER `mfa_secret`,
+  ADD `mfa_last_step` bigint NOT NULL DEFAULT 0 AFTER `mfa_enabled`;
@@ -13,7 +13,13 @@ CREATE TABLE `users` (
   `email` varchar(255) NOT NULL,
   `password` varchar(255) NOT NULL,
   `token_user_id` varchar(255) NOT NULL,
-  `is_admin` tinyint(4) NOT NULL DEFAULT '1',
+  `role` varchar(16) NOT NULL DEFAULT 'reader',
+  `status` varchar(16) NOT NULL DEFAULT 'active',
+  `email_verified` tinyint(1) NOT NULL DEFAULT 0,
+  `password_reset_at` datetime DEFAULT NULL,
+  `mfa_secret` varchar(64) NOT NULL DEFAULT '',
+  `mfa_enabled` tinyint(1) NOT NULL DEFAULT 0,
+  `mfa_last_step` bigint NOT NULL DEFAULT 0,
   PRIMARY KEY (`id`),
   UNIQUE KEY (email)
 
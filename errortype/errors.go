@@ -8,4 +8,8 @@ var (
 	ErrConflict            = errors.New("datamodel conflict")
 	ErrDuplicateEntry      = errors.New("duplicate entry")
 	ErrUnprocessableEntity = errors.New("unprocessable entity")
+	ErrInvalidScope        = errors.New("invalid scope")
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrForbidden           = errors.New("forbidden")
 )
@@ -0,0 +1,83 @@
+// Package imaging processes uploaded images: it fixes their orientation, strips
+// their metadata, resizes them and computes placeholders to show while they load
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// JPEGQuality is the quality used to encode JPEG images
+const JPEGQuality = 85
+
+// Decode decodes a JPEG, PNG or GIF image. JPEG images are rotated according
+// to their EXIF orientation. Only the first frame of animated GIFs is decoded.
+func Decode(content []byte) (image.Image, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not decode image")
+	}
+
+	if format == "jpeg" {
+		img = Orient(img, Orientation(content))
+	}
+	return img, format, nil
+}
+
+// Encode encodes an image in the given format. GIF images are encoded as PNG,
+// since single frames don't benefit from a palette. It returns the content type
+// of the encoded image.
+func Encode(w io.Writer, img image.Image, format string) (string, error) {
+	if format == "jpeg" {
+		return "image/jpeg", jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+	}
+	return "image/png", png.Encode(w, img)
+}
+
+// Strip re-encodes an image at its original size, which drops all of its metadata.
+// JPEG images are rotated according to their EXIF orientation, and the frames of
+// animated GIFs are kept.
+func Strip(content []byte) ([]byte, string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", errors.Wrap(err, "could not decode image")
+	}
+
+	buf := &bytes.Buffer{}
+	if format == "gif" {
+		animation, err := gif.DecodeAll(bytes.NewReader(content))
+		if err != nil {
+			return nil, "", errors.Wrap(err, "could not decode image")
+		}
+
+		err = gif.EncodeAll(buf, animation)
+		return buf.Bytes(), "image/gif", errors.Wrap(err, "could not encode image")
+	}
+
+	img, format, err := Decode(content)
+	if err != nil {
+		return nil, "", err
+	}
+
+	contentType, err := Encode(buf, img, format)
+	return buf.Bytes(), contentType, errors.Wrap(err, "could not encode image")
+}
+
+// toRGBA converts an image to a premultiplied RGBA image
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
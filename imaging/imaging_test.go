@@ -0,0 +1,212 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red  = color.RGBA{R: 255, A: 255}
+	blue = color.RGBA{B: 255, A: 255}
+)
+
+// newHalves creates an image that is red on its left half and blue on its right half
+func newHalves(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+// withOrientation inserts an EXIF segment with the given orientation in a JPEG image
+func withOrientation(t *testing.T, content []byte, orientation uint16, order binary.ByteOrder) []byte {
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+	binary.Write(tiff, order, uint16(2))
+	// A tag that is not the orientation, followed by the orientation
+	binary.Write(tiff, order, []uint16{0x010f, 2})
+	binary.Write(tiff, order, []uint32{4, 0})
+	binary.Write(tiff, order, []uint16{0x0112, 3})
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, []uint16{orientation, 0})
+	binary.Write(tiff, order, uint32(0))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	header := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(header[4:], uint16(len(segment)+2))
+
+	if content[0] != 0xff || content[1] != 0xd8 {
+		t.Fatal("not a jpeg image")
+	}
+	return append(append(header, segment...), content[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	buf := &bytes.Buffer{}
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 100})
+	if err != nil {
+		t.Fatal("could not encode jpeg")
+	}
+	return buf.Bytes()
+}
+
+func isRed(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xc000 && g < 0x4000 && b < 0x4000
+}
+
+func TestOrientation(t *testing.T) {
+	content := encodeJPEG(t, newHalves(16, 8))
+
+	assert.Equal(t, 1, Orientation(content), "images without exif are upright")
+	assert.Equal(t, 6, Orientation(withOrientation(t, content, 6, binary.BigEndian)))
+	assert.Equal(t, 8, Orientation(withOrientation(t, content, 8, binary.LittleEndian)))
+	assert.Equal(t, 1, Orientation(withOrientation(t, content, 42, binary.BigEndian)), "invalid orientations are ignored")
+	assert.Equal(t, 1, Orientation([]byte("not an image")))
+	assert.Equal(t, 1, Orientation(withOrientation(t, content, 6, binary.BigEndian)[:20]), "truncated images are ignored")
+}
+
+func TestOrient(t *testing.T) {
+	src := newHalves(2, 1)
+
+	tests := []struct {
+		orientation int
+
+		expectedSize image.Point
+		expectedRed  image.Point
+	}{
+		{orientation: 1, expectedSize: image.Pt(2, 1), expectedRed: image.Pt(0, 0)},
+		{orientation: 2, expectedSize: image.Pt(2, 1), expectedRed: image.Pt(1, 0)},
+		{orientation: 3, expectedSize: image.Pt(2, 1), expectedRed: image.Pt(1, 0)},
+		{orientation: 4, expectedSize: image.Pt(2, 1), expectedRed: image.Pt(0, 0)},
+		{orientation: 5, expectedSize: image.Pt(1, 2), expectedRed: image.Pt(0, 0)},
+		{orientation: 6, expectedSize: image.Pt(1, 2), expectedRed: image.Pt(0, 0)},
+		{orientation: 7, expectedSize: image.Pt(1, 2), expectedRed: image.Pt(0, 1)},
+		{orientation: 8, expectedSize: image.Pt(1, 2), expectedRed: image.Pt(0, 1)},
+	}
+
+	for _, test := range tests {
+		dst := Orient(src, test.orientation)
+		assert.Equal(t, test.expectedSize, dst.Bounds().Size(), "wrong size for orientation %d", test.orientation)
+		assert.True(t, isRed(dst.At(test.expectedRed.X, test.expectedRed.Y)), "wrong red pixel for orientation %d", test.orientation)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	content := withOrientation(t, encodeJPEG(t, newHalves(16, 8)), 6, binary.BigEndian)
+
+	stripped, contentType, err := Strip(content)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "image/jpeg", contentType, "wrong content type")
+	assert.False(t, bytes.Contains(stripped, []byte("Exif")), "exif should be stripped")
+
+	img, _, err := image.Decode(bytes.NewReader(stripped))
+	if assert.NoError(t, err, "unexpected error") {
+		assert.Equal(t, image.Pt(8, 16), img.Bounds().Size(), "image should be rotated")
+		assert.True(t, isRed(img.At(4, 2)), "image should be rotated clockwise")
+	}
+
+	// Animated GIFs keep their frames
+	palette := color.Palette{red, blue}
+	animation := &gif.GIF{
+		Image: []*image.Paletted{image.NewPaletted(image.Rect(0, 0, 4, 4), palette), image.NewPaletted(image.Rect(0, 0, 4, 4), palette)},
+		Delay: []int{10, 10},
+	}
+	buf := &bytes.Buffer{}
+	err = gif.EncodeAll(buf, animation)
+	if err != nil {
+		t.Fatal("could not encode gif")
+	}
+
+	stripped, contentType, err = Strip(buf.Bytes())
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "image/gif", contentType, "wrong content type")
+	decoded, err := gif.DecodeAll(bytes.NewReader(stripped))
+	if assert.NoError(t, err, "unexpected error") {
+		assert.Len(t, decoded.Image, 2, "frames should be kept")
+	}
+
+	_, _, err = Strip([]byte("not an image"))
+	assert.Error(t, err, "expected an error")
+}
+
+func TestEncode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	contentType, err := Encode(buf, newHalves(4, 4), "gif")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, "image/png", contentType, "gif frames should be encoded as png")
+	_, err = png.Decode(buf)
+	assert.NoError(t, err, "expected a png image")
+}
+
+func TestResize(t *testing.T) {
+	src := newHalves(100, 50)
+
+	dst := Resize(src, 10)
+	assert.Equal(t, image.Pt(10, 5), dst.Bounds().Size(), "aspect ratio should be kept")
+	assert.True(t, isRed(dst.At(2, 2)), "left half should stay red")
+	assert.False(t, isRed(dst.At(7, 2)), "right half should stay blue")
+
+	// A pixel that covers both halves is a mix of both colors
+	odd := Resize(newHalves(6, 2), 3)
+	r, _, b, _ := odd.At(1, 0).RGBA()
+	assert.InDelta(t, 0x7f7f, r, 0x200, "pixel should be half red")
+	assert.InDelta(t, 0x7f7f, b, 0x200, "pixel should be half blue")
+
+	assert.Equal(t, image.Pt(100, 50), Resize(src, 200).Bounds().Size(), "images are never scaled up")
+	assert.Equal(t, image.Pt(10, 1), Resize(image.NewRGBA(image.Rect(0, 0, 100, 1)), 10).Bounds().Size(), "height is at least 1")
+}
+
+func TestBlurhash(t *testing.T) {
+	uniform := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	for i := 0; i < len(uniform.Pix); i += 4 {
+		copy(uniform.Pix[i:], []byte{255, 0, 0, 255})
+	}
+
+	hash, err := Blurhash(uniform, 4, 3)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, hash, 6+2*11, "wrong blurhash length")
+	assert.Equal(t, "L", hash[:1], "wrong component count")
+	assert.Equal(t, "TI:j", hash[2:6], "average color should be red")
+
+	halves, err := Blurhash(newHalves(32, 32), 4, 3)
+	assert.NoError(t, err, "unexpected error")
+	assert.Len(t, halves, 6+2*11, "wrong blurhash length")
+	assert.NotEqual(t, hash, halves, "different images should have different hashes")
+	assert.True(t, strings.HasSuffix(halves, strings.Repeat("fQ", 4)), "image without vertical details should have empty vertical components")
+
+	_, err = Blurhash(uniform, 0, 10)
+	assert.Error(t, err, "expected an error for invalid components")
+}
+
+func TestDominantColor(t *testing.T) {
+	img := newHalves(40, 10)
+	for y := 0; y < 10; y++ {
+		img.Set(25, y, red)
+	}
+	assert.Equal(t, "#ff0000", DominantColor(img))
+
+	assert.Equal(t, "#000000", DominantColor(image.NewRGBA(image.Rect(0, 0, 4, 4))), "transparent images are black")
+}
@@ -0,0 +1,132 @@
+package imaging
+
+import (
+	"encoding/binary"
+	"image"
+)
+
+const (
+	jpegSOI     = 0xd8
+	jpegSOS     = 0xda
+	jpegAPP1    = 0xe1
+	orientation = 0x0112
+)
+
+// Orientation returns the EXIF orientation of a JPEG image, between 1 and 8.
+// Images without a valid orientation are considered upright, which is 1.
+func Orientation(content []byte) int {
+	if len(content) < 4 || content[0] != 0xff || content[1] != jpegSOI {
+		return 1
+	}
+
+	// Walk through the segments of the image until the image data starts
+	for offset := 2; offset+4 <= len(content); {
+		if content[offset] != 0xff {
+			return 1
+		}
+
+		marker := content[offset+1]
+		if marker == jpegSOS {
+			return 1
+		}
+
+		length := int(binary.BigEndian.Uint16(content[offset+2:]))
+		if length < 2 || offset+2+length > len(content) {
+			return 1
+		}
+
+		segment := content[offset+4 : offset+2+length]
+		if marker == jpegAPP1 && len(segment) > 6 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+
+		offset += 2 + length
+	}
+
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of TIFF data
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:]) != orientation {
+			continue
+		}
+
+		value := int(order.Uint16(tiff[entry+8:]))
+		if value < 1 || value > 8 {
+			return 1
+		}
+		return value
+	}
+
+	return 1
+}
+
+// Orient transforms an image so that it is upright, given its EXIF orientation
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+
+	// Orientations 5 to 8 swap the width and the height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // flipped horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotated by 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped vertically
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated by 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated by 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
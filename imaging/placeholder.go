@@ -0,0 +1,165 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+)
+
+// placeholderWidth is the width to which images are scaled down before computing
+// their placeholders, since placeholders don't need any detail
+const placeholderWidth = 64
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash computes the BlurHash of an image, a short string that clients can
+// decode into a blurred version of the image. The components set the level of
+// detail on each axis, and must be between 1 and 9.
+// See https://blurha.sh for the algorithm.
+func Blurhash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9, got %dx%d", xComponents, yComponents)
+	}
+
+	src := Resize(img, placeholderWidth)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if w == 0 || h == 0 {
+		return "", fmt.Errorf("can't compute the blurhash of an empty image")
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+
+			var factor [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+
+					pixel := src.Pix[src.PixOffset(x, y):]
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * sRGBToLinear(pixel[c])
+					}
+				}
+			}
+
+			scale := 1 / float64(w*h)
+			for c := 0; c < 3; c++ {
+				factor[c] *= scale
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximum := 1.0
+	if len(factors) > 1 {
+		var actualMaximum float64
+		for _, factor := range factors[1:] {
+			for _, v := range factor {
+				actualMaximum = math.Max(actualMaximum, math.Abs(v))
+			}
+		}
+
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximum = float64(quantisedMaximum+1) / 166
+		hash.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, factor := range factors[1:] {
+		var quantised [3]int
+		for c, v := range factor {
+			quantised[c] = int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantised[0]*19*19+quantised[1]*19+quantised[2], 2))
+	}
+
+	return hash.String(), nil
+}
+
+// DominantColor returns the most common color of an image, as a hexadecimal CSS
+// color. Similar colors are grouped together, and transparent pixels are ignored.
+func DominantColor(img image.Image) string {
+	src := Resize(img, placeholderWidth)
+
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+
+	// Group colors by their 4 most significant bits
+	buckets := make(map[int]*bucket)
+	var dominant *bucket
+	for i := 0; i+3 < len(src.Pix); i += 4 {
+		r, g, b, a := int(src.Pix[i]), int(src.Pix[i+1]), int(src.Pix[i+2]), int(src.Pix[i+3])
+		if a < 128 {
+			continue
+		}
+
+		// Pixels are premultiplied by their alpha
+		r, g, b = r*255/a, g*255/a, b*255/a
+
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+		bu, ok := buckets[key]
+		if !ok {
+			bu = &bucket{}
+			buckets[key] = bu
+		}
+		bu.count++
+		bu.r += r
+		bu.g += g
+		bu.b += b
+
+		if dominant == nil || bu.count > dominant.count {
+			dominant = bu
+		}
+	}
+
+	if dominant == nil {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", dominant.r/dominant.count, dominant.g/dominant.count, dominant.b/dominant.count)
+}
+
+func encode83(value, length int) string {
+	result := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		result[i] = base83[value%83]
+		value /= 83
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,93 @@
+package imaging
+
+import (
+	"image"
+	"math"
+)
+
+type contribution struct {
+	index  int
+	weight float64
+}
+
+// Resize scales an image down to the given width, keeping its aspect ratio.
+// Each pixel of the resized image is the average of the area of the original
+// image that it covers. Images are never scaled up.
+func Resize(img image.Image, width int) *image.RGBA {
+	src := toRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if width >= w || width < 1 || w == 0 || h == 0 {
+		return src
+	}
+
+	height := int(math.Round(float64(h) * float64(width) / float64(w)))
+	if height < 1 {
+		height = 1
+	}
+
+	// Resize horizontally, then vertically
+	columns := contributions(width, w)
+	tmp := make([]float64, width*h*4)
+	for y := 0; y < h; y++ {
+		for x, column := range columns {
+			out := tmp[(y*width+x)*4:]
+			for _, c := range column {
+				in := src.Pix[src.PixOffset(c.index, y):]
+				for i := 0; i < 4; i++ {
+					out[i] += float64(in[i]) * c.weight
+				}
+			}
+		}
+	}
+
+	rows := contributions(height, h)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, row := range rows {
+		for x := 0; x < width; x++ {
+			var sum [4]float64
+			for _, c := range row {
+				in := tmp[(c.index*width+x)*4:]
+				for i := 0; i < 4; i++ {
+					sum[i] += in[i] * c.weight
+				}
+			}
+
+			out := dst.Pix[dst.PixOffset(x, y):]
+			for i := 0; i < 4; i++ {
+				out[i] = clamp(sum[i])
+			}
+		}
+	}
+
+	return dst
+}
+
+// contributions computes which source pixels cover each destination pixel, weighted
+// by how much of the destination pixel they cover
+func contributions(dst, src int) [][]contribution {
+	scale := float64(src) / float64(dst)
+
+	result := make([][]contribution, dst)
+	for i := range result {
+		start := float64(i) * scale
+		end := start + scale
+		for j := int(start); j < src && float64(j) < end; j++ {
+			weight := math.Min(end, float64(j+1)) - math.Max(start, float64(j))
+			if weight > 0 {
+				result[i] = append(result[i], contribution{index: j, weight: weight / scale})
+			}
+		}
+	}
+	return result
+}
+
+func clamp(v float64) uint8 {
+	v = math.Round(v)
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
@@ -0,0 +1,53 @@
+package keys
+
+import (
+	"crypto/ed25519"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// SigningMethodEdDSA signs tokens with Ed25519 keys, which jwt-go doesn't support
+var SigningMethodEdDSA jwt.SigningMethod = &signingMethodEdDSA{}
+
+// Make the EdDSA algorithm available to token parsers
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+type signingMethodEdDSA struct{}
+
+// Alg returns the name of the algorithm, as used in the alg header of tokens
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Sign signs the given string with an ed25519.PrivateKey
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// Verify verifies the signature of the given string with an ed25519.PublicKey
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigningMethodEdDSA(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key")
+	}
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("could not generate key")
+	}
+
+	assert.Equal(t, SigningMethodEdDSA, jwt.GetSigningMethod("EdDSA"), "EdDSA should be registered")
+
+	signature, err := SigningMethodEdDSA.Sign("header.payload", private)
+	if !assert.NoError(t, err, "unexpected error") {
+		return
+	}
+
+	assert.NoError(t, SigningMethodEdDSA.Verify("header.payload", signature, public), "signature should be valid")
+	assert.Error(t, SigningMethodEdDSA.Verify("header.tampered", signature, public), "signature of another string should be invalid")
+	assert.Error(t, SigningMethodEdDSA.Verify("header.payload", signature, otherPublic), "signature should be invalid with another key")
+	assert.Error(t, SigningMethodEdDSA.Verify("header.payload", "%%%", public), "malformed signature should be invalid")
+
+	_, err = SigningMethodEdDSA.Sign("header.payload", []byte("secret"))
+	assert.Equal(t, jwt.ErrInvalidKeyType, err, "only ed25519 keys should sign")
+	assert.Equal(t, jwt.ErrInvalidKeyType, SigningMethodEdDSA.Verify("header.payload", signature, []byte("secret")), "only ed25519 keys should verify")
+}
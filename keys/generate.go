@@ -0,0 +1,59 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// Generate generates a new key for the given algorithm in a directory, and returns its ID. Keys
+// are named after the time at which they were generated, and start signing tokens right away.
+func Generate(dir, algorithm string) (string, error) {
+	var private interface{}
+	var err error
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, minRSAKeySize)
+	case SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return "", errors.Errorf("unsupported algorithm %q", algorithm)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate key")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", errors.Wrap(err, "could not encode key")
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create key directory")
+	}
+
+	id := time.Now().UTC().Format("20060102T150405Z")
+	file, err := os.OpenFile(filepath.Join(dir, id+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "could not create key file")
+	}
+
+	err = pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "could not write key file")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,316 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// ErrNoKey is returned when a key directory doesn't contain any key
+var ErrNoKey = errors.New("no signing key found")
+
+// minRSAKeySize is the smallest RSA key size that is accepted, in bits
+const minRSAKeySize = 2048
+
+// reloadInterval is how often the key directory is read again, so that new keys are picked up
+const reloadInterval = time.Minute
+
+// Key is a private key that signs access tokens
+type Key struct {
+	// ID is the name of the key file without its extension, and is used as the kid header of tokens
+	ID string
+	// Algorithm is the algorithm with which the key signs tokens
+	Algorithm string
+	// ActiveAt is when the key starts signing tokens, which is the modification date of its file
+	ActiveAt time.Time
+
+	private crypto.Signer
+}
+
+// KeySet is a set of keys loaded from the PEM files of a directory. The most recent key signs
+// tokens, and keys that it replaced can still verify tokens during an overlap window, so that
+// keys can be rotated without invalidating the tokens that were already issued.
+type KeySet struct {
+	dir     string
+	overlap time.Duration
+
+	mutex    sync.RWMutex
+	keys     []*Key
+	loadedAt time.Time
+
+	now            func() time.Time
+	reloadInterval time.Duration
+
+	log *zerolog.Logger
+}
+
+// Algorithms are the algorithms with which tokens can be signed
+var Algorithms = []string{jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg()}
+
+// Load loads a KeySet from the PEM files of the given directory. Keys that were replaced remain
+// valid for the duration of the overlap window, which should be longer than the lifetime of tokens.
+func Load(log *zerolog.Logger, dir string, overlap time.Duration) (*KeySet, error) {
+	ks := &KeySet{
+		dir:     dir,
+		overlap: overlap,
+
+		now:            time.Now,
+		reloadInterval: reloadInterval,
+
+		log: log,
+	}
+
+	err := ks.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return ks, nil
+}
+
+// Sign signs the given claims with the current key
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.signingKey()
+	if key == nil {
+		return "", ErrNoKey
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// Keyfunc returns the public key with which a token should be verified, from its kid header.
+// Tokens can only be verified with keys of the algorithm they were signed with.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("missing 'kid' header")
+	}
+
+	for _, key := range ks.validKeys() {
+		if key.ID != kid {
+			continue
+		}
+
+		if key.Algorithm != token.Method.Alg() {
+			return nil, errors.Errorf("key %q can't be used with %s", kid, token.Method.Alg())
+		}
+		return key.private.Public(), nil
+	}
+
+	return nil, errors.Errorf("unknown key %q", kid)
+}
+
+// Algorithms returns the algorithms with which tokens can be signed
+func (ks *KeySet) Algorithms() []string {
+	return Algorithms
+}
+
+// JWKS returns the public keys that can currently be used to verify tokens. Keys that
+// will only sign tokens in the future are included, so that clients can learn about them
+// before they are used.
+func (ks *KeySet) JWKS() *model.JSONWebKeySet {
+	set := &model.JSONWebKeySet{
+		Keys: []model.JSONWebKey{},
+	}
+
+	for _, key := range ks.validKeys() {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+
+	return set
+}
+
+// signingKey returns the most recent key that is active
+func (ks *KeySet) signingKey() *Key {
+	keys := ks.current()
+	now := ks.now()
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		if !keys[i].ActiveAt.After(now) {
+			return keys[i]
+		}
+	}
+	return nil
+}
+
+// validKeys returns the keys that can currently verify tokens, which are the key that signs tokens,
+// the keys that will sign tokens in the future, and the keys that were replaced less than the overlap
+// window ago.
+func (ks *KeySet) validKeys() []*Key {
+	keys := ks.current()
+	now := ks.now()
+
+	var valid []*Key
+	for i, key := range keys {
+		// Keys are sorted by activation date, so the next key replaced this one
+		if i+1 < len(keys) && !keys[i+1].ActiveAt.After(now) && now.Sub(keys[i+1].ActiveAt) > ks.overlap {
+			continue
+		}
+		valid = append(valid, key)
+	}
+	return valid
+}
+
+// current returns the loaded keys, after reloading them if they were loaded too long ago.
+// If the directory can't be read anymore, the keys that were already loaded are kept.
+func (ks *KeySet) current() []*Key {
+	ks.mutex.RLock()
+	stale := ks.now().Sub(ks.loadedAt) > ks.reloadInterval
+	keys := ks.keys
+	ks.mutex.RUnlock()
+
+	if !stale {
+		return keys
+	}
+
+	err := ks.reload()
+	if err != nil {
+		ks.log.Warn().Err(err).Str("dir", ks.dir).Msg("could not reload signing keys")
+	}
+
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.keys
+}
+
+// reload reads the keys of the directory
+func (ks *KeySet) reload() error {
+	keys, err := readKeys(ks.dir)
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+
+	// Failures are only retried after the reload interval
+	ks.loadedAt = ks.now()
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+
+	ks.log.Debug().Int("keys", len(keys)).Str("dir", ks.dir).Msg("signing keys loaded")
+	return nil
+}
+
+// readKeys reads the keys of a directory, sorted by activation date
+func readKeys(dir string) ([]*Key, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list keys")
+	}
+
+	var keys []*Key
+	for _, file := range files {
+		key, err := loadKey(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load key %s", filepath.Base(file))
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.Wrap(ErrNoKey, dir)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ActiveAt.Equal(keys[j].ActiveAt) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].ActiveAt.Before(keys[j].ActiveAt)
+	})
+
+	return keys, nil
+}
+
+// loadKey loads a private key from a PEM file
+func loadKey(file string) (*Key, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("not a PEM file")
+	}
+
+	var private interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{
+		ID:       strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+		ActiveAt: info.ModTime(),
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < minRSAKeySize {
+			return nil, errors.Errorf("RSA keys must be at least %d bits long", minRSAKeySize)
+		}
+		key.Algorithm = jwt.SigningMethodRS256.Alg()
+		key.private = private
+	case ed25519.PrivateKey:
+		key.Algorithm = SigningMethodEdDSA.Alg()
+		key.private = private
+	default:
+		return nil, errors.Errorf("unsupported key type %T", private)
+	}
+
+	return key, nil
+}
+
+// jwk returns the public part of the key as a JSON web key
+func (k *Key) jwk() model.JSONWebKey {
+	jwk := model.JSONWebKey{
+		KeyID:     k.ID,
+		Use:       "sig",
+		Algorithm: k.Algorithm,
+	}
+
+	switch public := k.private.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.Modulus = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.Exponent = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return jwk
+}
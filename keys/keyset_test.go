@@ -0,0 +1,358 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/logger"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeKey writes a key for the given algorithm that becomes active at the given time
+func writeKey(t *testing.T, dir, id, algorithm string, activeAt time.Time) {
+	var private interface{}
+	var err error
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "RS256-weak":
+		private, err = rsa.GenerateKey(rand.Reader, 1024)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal("could not generate key")
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal("could not encode key")
+	}
+
+	file := filepath.Join(dir, id+".pem")
+	err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal("could not write key")
+	}
+
+	err = os.Chtimes(file, activeAt, activeAt)
+	if err != nil {
+		t.Fatal("could not set key activation date")
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bloggo-keys")
+	if err != nil {
+		t.Fatal("could not create temporary directory")
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		description string
+
+		files map[string]string
+
+		expectedKeys  int
+		expectedError error
+	}{
+		{
+			description: "RSA and Ed25519 keys",
+
+			files: map[string]string{"rsa": "RS256", "ed25519": "EdDSA"},
+
+			expectedKeys: 2,
+		},
+		{
+			description: "other files are ignored",
+
+			files: map[string]string{"ed25519": "EdDSA", "README.md": "# Keys"},
+
+			expectedKeys: 1,
+		},
+		{
+			description: "no key",
+
+			files: map[string]string{"README.md": "# Keys"},
+
+			expectedError: ErrNoKey,
+		},
+		{
+			description: "not a PEM file",
+
+			files: map[string]string{"ed25519": "EdDSA", "invalid.pem": "potato"},
+
+			expectedError: errors.New("could not load key invalid.pem: not a PEM file"),
+		},
+		{
+			description: "unsupported PEM block",
+
+			files: map[string]string{"cert.pem": "-----BEGIN CERTIFICATE-----\nMA==\n-----END CERTIFICATE-----\n"},
+
+			expectedError: errors.New(`could not load key cert.pem: unsupported PEM block type "CERTIFICATE"`),
+		},
+		{
+			description: "RSA key too short",
+
+			files: map[string]string{"weak": "RS256-weak"},
+
+			expectedError: errors.New("could not load key weak.pem: RSA keys must be at least 2048 bits long"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			for name, content := range test.files {
+				switch content {
+				case "RS256", "RS256-weak", "EdDSA":
+					writeKey(t, dir, name, content, time.Now())
+				default:
+					ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600)
+				}
+			}
+
+			ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+			if test.expectedError == ErrNoKey {
+				assert.Equal(t, ErrNoKey, errors.Cause(err), "expected no key to be found")
+			} else if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Len(t, ks.keys, test.expectedKeys, "wrong number of keys")
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			writeKey(t, dir, "key", algorithm, time.Now().Add(-time.Minute))
+
+			ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+			if err != nil {
+				t.Fatal("could not load keys")
+			}
+
+			signed, err := ks.Sign(jwt.MapClaims{"sub": "test"})
+			if !assert.NoError(t, err, "unexpected error") {
+				return
+			}
+
+			p := &jwt.Parser{ValidMethods: ks.Algorithms()}
+			token, err := p.Parse(signed, ks.Keyfunc)
+			if assert.NoError(t, err, "token should be valid") {
+				assert.Equal(t, algorithm, token.Header["alg"], "wrong algorithm")
+				assert.Equal(t, "key", token.Header["kid"], "wrong key ID")
+				assert.Equal(t, "test", token.Claims.(jwt.MapClaims)["sub"], "wrong claims")
+			}
+		})
+	}
+}
+
+func TestKeyfunc(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	writeKey(t, dir, "ed25519", "EdDSA", time.Now().Add(-time.Minute))
+
+	ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+	if err != nil {
+		t.Fatal("could not load keys")
+	}
+
+	tests := []struct {
+		description string
+
+		header map[string]interface{}
+		method jwt.SigningMethod
+
+		expectedError error
+	}{
+		{
+			description: "known key",
+
+			header: map[string]interface{}{"kid": "ed25519"},
+			method: SigningMethodEdDSA,
+		},
+		{
+			description: "missing kid",
+
+			header: map[string]interface{}{},
+			method: SigningMethodEdDSA,
+
+			expectedError: errors.New("missing 'kid' header"),
+		},
+		{
+			description: "unknown kid",
+
+			header: map[string]interface{}{"kid": "potato"},
+			method: SigningMethodEdDSA,
+
+			expectedError: errors.New(`unknown key "potato"`),
+		},
+		{
+			description: "algorithm of another key",
+
+			header: map[string]interface{}{"kid": "ed25519"},
+			method: jwt.SigningMethodRS256,
+
+			expectedError: errors.New(`key "ed25519" can't be used with RS256`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			key, err := ks.Keyfunc(&jwt.Token{Header: test.header, Method: test.method})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.IsType(t, ed25519.PublicKey{}, key, "the public key should be returned")
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	writeKey(t, dir, "old", "EdDSA", now.Add(-2*time.Hour))
+	writeKey(t, dir, "current", "EdDSA", now.Add(-30*time.Minute))
+	writeKey(t, dir, "next", "EdDSA", now.Add(time.Hour))
+
+	ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+	if err != nil {
+		t.Fatal("could not load keys")
+	}
+
+	kids := func() []string {
+		var kids []string
+		for _, key := range ks.JWKS().Keys {
+			kids = append(kids, key.KeyID)
+		}
+		return kids
+	}
+
+	// The old key was replaced less than an hour ago, and the next key is published in advance
+	assert.Equal(t, "current", ks.signingKey().ID, "the most recent active key should sign tokens")
+	assert.Equal(t, []string{"old", "current", "next"}, kids(), "wrong keys published")
+
+	// An hour and a half later, the next key replaced the current one
+	ks.now = func() time.Time { return now.Add(90 * time.Minute) }
+	assert.Equal(t, "next", ks.signingKey().ID, "the next key should sign tokens once active")
+	assert.Equal(t, []string{"current", "next"}, kids(), "keys replaced more than the overlap ago should be dropped")
+
+	_, err = ks.Keyfunc(&jwt.Token{Header: map[string]interface{}{"kid": "old"}, Method: SigningMethodEdDSA})
+	assert.EqualError(t, err, `unknown key "old"`, "tokens signed by dropped keys should be rejected")
+}
+
+func TestReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	writeKey(t, dir, "first", "EdDSA", now.Add(-time.Hour))
+
+	ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+	if err != nil {
+		t.Fatal("could not load keys")
+	}
+
+	writeKey(t, dir, "second", "EdDSA", now)
+	assert.Equal(t, "first", ks.signingKey().ID, "keys should not be reloaded before the reload interval")
+
+	ks.now = func() time.Time { return now.Add(2 * reloadInterval) }
+	assert.Equal(t, "second", ks.signingKey().ID, "new keys should be picked up after the reload interval")
+
+	// Keys are kept if the directory becomes unreadable
+	os.RemoveAll(dir)
+	ks.now = func() time.Time { return now.Add(4 * reloadInterval) }
+	assert.Equal(t, "second", ks.signingKey().ID, "loaded keys should be kept")
+}
+
+func TestJWKS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	writeKey(t, dir, "rsa", "RS256", time.Now().Add(-time.Hour))
+	writeKey(t, dir, "ed25519", "EdDSA", time.Now().Add(-time.Minute))
+
+	ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), dir, time.Hour)
+	if err != nil {
+		t.Fatal("could not load keys")
+	}
+
+	set := ks.JWKS()
+	if !assert.Len(t, set.Keys, 2, "both keys should be published") {
+		return
+	}
+
+	rsaKey := set.Keys[0]
+	assert.Equal(t, "RSA", rsaKey.KeyType, "wrong key type")
+	assert.Equal(t, "rsa", rsaKey.KeyID, "wrong key ID")
+	assert.Equal(t, "sig", rsaKey.Use, "wrong key use")
+	assert.Equal(t, "RS256", rsaKey.Algorithm, "wrong algorithm")
+	assert.Equal(t, "AQAB", rsaKey.Exponent, "wrong exponent")
+	assert.Len(t, rsaKey.Modulus, 342, "wrong modulus length")
+	assert.Empty(t, rsaKey.X, "RSA keys have no x coordinate")
+
+	edKey := set.Keys[1]
+	assert.Equal(t, "OKP", edKey.KeyType, "wrong key type")
+	assert.Equal(t, "ed25519", edKey.KeyID, "wrong key ID")
+	assert.Equal(t, "EdDSA", edKey.Algorithm, "wrong algorithm")
+	assert.Equal(t, "Ed25519", edKey.Curve, "wrong curve")
+	assert.Len(t, edKey.X, 43, "wrong x coordinate length")
+	assert.Empty(t, edKey.Modulus, "Ed25519 keys have no modulus")
+}
+
+func TestGenerate(t *testing.T) {
+	for _, algorithm := range []string{"RS256", "EdDSA"} {
+		t.Run(algorithm, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			kid, err := Generate(filepath.Join(dir, "keys"), algorithm)
+			if !assert.NoError(t, err, "unexpected error") {
+				return
+			}
+
+			info, err := os.Stat(filepath.Join(dir, "keys", kid+".pem"))
+			if assert.NoError(t, err, "key should be written") {
+				assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "key should only be readable by its owner")
+			}
+
+			ks, err := Load(logger.NewZeroLog(&bytes.Buffer{}), filepath.Join(dir, "keys"), time.Hour)
+			if assert.NoError(t, err, "generated key should be loadable") {
+				assert.Equal(t, algorithm, ks.signingKey().Algorithm, "wrong algorithm")
+				assert.Equal(t, kid, ks.signingKey().ID, "wrong key ID")
+			}
+		})
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	_, err := Generate(dir, "HS256")
+	assert.EqualError(t, err, `unsupported algorithm "HS256"`, "only asymmetric algorithms should be supported")
+}
@@ -0,0 +1,39 @@
+// Package mailer contains the mailers with which Bloggo sends emails
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Mailer represents something that sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// compose builds a plain text email from its sender, recipient, subject and body
+func compose(from, to, subject, body string, date time.Time) ([]byte, error) {
+	// Line breaks in headers would let them inject other headers
+	for _, header := range []string{from, to, subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errors.New("email headers can't contain line breaks")
+		}
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", from)
+	fmt.Fprintf(&message, "To: %s\r\n", to)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return message.Bytes(), nil
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Outbox is a mailer that writes emails as .eml files in a directory instead of sending
+// them, so that Bloggo can be used without an SMTP server during development.
+type Outbox struct {
+	dir  string
+	from string
+
+	now func() time.Time
+}
+
+// NewOutbox creates an Outbox mailer that writes in the given directory
+func NewOutbox(dir, from string) *Outbox {
+	return &Outbox{
+		dir:  dir,
+		from: from,
+
+		now: time.Now,
+	}
+}
+
+// Send writes an email to the outbox directory
+func (o *Outbox) Send(to, subject, body string) error {
+	now := o.now()
+
+	message, err := compose(o.from, to, subject, body, now)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(o.dir, 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not create outbox directory")
+	}
+
+	// Emails are named after the date at which they were sent, so that they are listed in order
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return errors.Wrap(err, "could not generate email file name")
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	err = ioutil.WriteFile(filepath.Join(o.dir, name), message, 0600)
+	return errors.Wrap(err, "could not write email to outbox")
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloggo-outbox")
+	if err != nil {
+		t.Fatal("could not create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	o := NewOutbox(filepath.Join(dir, "outbox"), "bloggo@localhost")
+	o.now = func() time.Time { return time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC) }
+
+	assert.NoError(t, o.Send("pam@dunder-mifflin.com", "Réinitialisez votre mot de passe", "Hello"), "unexpected error")
+	assert.NoError(t, o.Send("jim@dunder-mifflin.com", "Reset your password", "Hello"), "unexpected error")
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "outbox"))
+	if !assert.NoError(t, err, "outbox directory should be created") {
+		return
+	}
+	if !assert.Len(t, files, 2, "each email should be written in its own file") {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(files[0].Name(), "20261019T120000.000000000-"), "emails should be named after their date")
+	assert.True(t, strings.HasSuffix(files[0].Name(), ".eml"), "emails should be .eml files")
+
+	var messages []string
+	for _, file := range files {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "outbox", file.Name()))
+		assert.NoError(t, err, "unexpected error")
+		messages = append(messages, string(content))
+	}
+	all := strings.Join(messages, "\n")
+
+	assert.Contains(t, all, "From: bloggo@localhost\r\nTo: pam@dunder-mifflin.com\r\n", "wrong headers")
+	assert.Contains(t, all, "Subject: =?utf-8?q?R=C3=A9initialisez_votre_mot_de_passe?=\r\n", "subjects should be encoded")
+	assert.Contains(t, all, "To: jim@dunder-mifflin.com\r\nSubject: Reset your password\r\n", "wrong headers")
+
+	err = o.Send("pam@dunder-mifflin.com", "Hello\nBcc: toby@dunder-mifflin.com", "Hello")
+	assert.EqualError(t, err, "email headers can't contain line breaks", "headers with line breaks should be refused")
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SMTPConfig configures the connection to an SMTP server
+type SMTPConfig struct {
+	Host string
+	Port uint
+
+	// Username and Password are only used if the server requires authentication
+	Username string
+	Password string
+
+	// From is the address from which emails are sent
+	From string
+}
+
+// SMTP is a mailer that sends emails through an SMTP server. The connection is
+// upgraded with STARTTLS when the server supports it.
+type SMTP struct {
+	config SMTPConfig
+
+	send func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error
+	now  func() time.Time
+}
+
+// NewSMTP creates an SMTP mailer
+func NewSMTP(config SMTPConfig) *SMTP {
+	return &SMTP{
+		config: config,
+
+		send: smtp.SendMail,
+		now:  time.Now,
+	}
+}
+
+// Send sends an email to the given address
+func (s *SMTP) Send(to, subject, body string) error {
+	message, err := compose(s.config.From, to, subject, body, s.now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
+	err = s.send(addr, auth, s.config.From, []string{to}, message)
+	return errors.Wrap(err, "could not send email")
+}
@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSMTP(t *testing.T) {
+	date := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		description string
+
+		username string
+		to       string
+		subject  string
+		sendErr  error
+
+		expectSend    bool
+		expectAuth    bool
+		expectedError error
+	}{
+		{
+			description: "email sent",
+
+			to:      "pam@dunder-mifflin.com",
+			subject: "Reset your password",
+
+			expectSend: true,
+		},
+		{
+			description: "email sent with authentication",
+
+			username: "bloggo",
+			to:       "pam@dunder-mifflin.com",
+			subject:  "Reset your password",
+
+			expectSend: true,
+			expectAuth: true,
+		},
+		{
+			description: "header injection",
+
+			to:      "pam@dunder-mifflin.com\r\nBcc: toby@dunder-mifflin.com",
+			subject: "Reset your password",
+
+			expectedError: errors.New("email headers can't contain line breaks"),
+		},
+		{
+			description: "server failure",
+
+			to:      "pam@dunder-mifflin.com",
+			subject: "Reset your password",
+			sendErr: errors.New("connection refused"),
+
+			expectSend:    true,
+			expectedError: errors.New("could not send email: connection refused"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			s := NewSMTP(SMTPConfig{
+				Host:     "smtp.dunder-mifflin.com",
+				Port:     587,
+				Username: test.username,
+				Password: "secret",
+				From:     "bloggo@dunder-mifflin.com",
+			})
+			s.now = func() time.Time { return date }
+
+			var sent bool
+			s.send = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+				sent = true
+
+				assert.Equal(t, "smtp.dunder-mifflin.com:587", addr, "wrong server address")
+				assert.Equal(t, test.expectAuth, auth != nil, "wrong authentication")
+				assert.Equal(t, "bloggo@dunder-mifflin.com", from, "wrong sender")
+				assert.Equal(t, []string{test.to}, to, "wrong recipients")
+				assert.Equal(t, "From: bloggo@dunder-mifflin.com\r\n"+
+					"To: pam@dunder-mifflin.com\r\n"+
+					"Subject: Reset your password\r\n"+
+					"Date: Mon, 19 Oct 2026 12:00:00 +0000\r\n"+
+					"MIME-Version: 1.0\r\n"+
+					"Content-Type: text/plain; charset=utf-8\r\n"+
+					"\r\n"+
+					"Hello,\r\nGoodbye.", string(msg), "wrong message")
+
+				return test.sendErr
+			}
+
+			err := s.Send(test.to, test.subject, "Hello,\nGoodbye.")
+
+			assert.Equal(t, test.expectSend, sent, "wrong send")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
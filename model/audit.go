@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+// AuditAction represents what an admin did on behalf of another user
+type AuditAction string
+
+// Audited actions
+const (
+	AuditImpersonationStarted AuditAction = "impersonation_started"
+	AuditImpersonatedRequest  AuditAction = "impersonated_request"
+)
+
+// AuditEvent records an action of an admin that affects another user. The actor is the
+// admin who did it, and the subject the user on behalf of whom it was done.
+type AuditEvent struct {
+	ID        uint        `json:"id" gorm:"primary_key"`
+	ActorID   string      `json:"actor_id"`
+	SubjectID string      `json:"subject_id"`
+	Action    AuditAction `json:"action"`
+	Detail    string      `json:"detail,omitempty"`
+	IP        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	CreatedAt time.Time   `json:"created_at"`
+}
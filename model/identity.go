@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// Identity links a user to their account on an OpenID Connect identity provider,
+// which is identified by its subject on that provider
+type Identity struct {
+	ID        uint `gorm:"primary_key"`
+	Provider  string
+	Subject   string
+	UserID    string
+	CreatedAt time.Time
+}
+
+// OIDCLogin is a login with an identity provider that is in progress. Only the hash of its
+// state is stored, along with the nonce and PKCE code verifier that complete the login.
+type OIDCLogin struct {
+	StateHash    string `gorm:"primary_key"`
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
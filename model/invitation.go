@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// Invitation represents an invitation to register with a role given by an admin. Invitations
+// can be restricted to an email address, and can only be accepted once before they expire.
+type Invitation struct {
+	ID         uint       `json:"id" gorm:"primary_key"`
+	Email      string     `json:"email,omitempty" validate:"omitempty,email"`
+	Role       Role       `json:"role" validate:"required,oneof=reader author editor admin"`
+	InvitedBy  string     `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Set once, when the invitation is created
+	Token string `json:"token,omitempty" gorm:"-"`
+}
@@ -0,0 +1,23 @@
+package model
+
+// JSONWebKey is the public part of a key that signs access tokens, as described by RFC 7517
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+
+	// RSA keys
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+
+	// Ed25519 and elliptic curve keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of keys that can be used to verify tokens
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// LoginThrottle holds the failed login attempts made on an account or from an IP address, and
+// the time until which further attempts are refused. Its key is prefixed with what it tracks,
+// such as "account:" followed by an email address, or "ip:" followed by an IP address.
+type LoginThrottle struct {
+	Key           string `gorm:"primary_key"`
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
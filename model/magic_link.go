@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// MagicLink represents a login link sent to a user by email. Only the hash of the ID of its token
+// is stored, and the link can only be used once before it expires.
+type MagicLink struct {
+	ID        uint `gorm:"primary_key"`
+	UserID    string
+	Email     string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
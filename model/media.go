@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Processing statuses of media
+const (
+	MediaProcessing = "processing"
+	MediaReady      = "ready"
+	MediaFailed     = "failed"
+)
+
+// Media represents a file uploaded to be used in blog posts
+type Media struct {
+	ID          uint      `json:"id,omitempty" gorm:"primary_key"`
+	Hash        string    `json:"hash"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Uploader    string    `json:"uploader,omitempty"`
+	CreatedAt   time.Time `json:"created_at,omitempty"`
+
+	// Set once the media is processed
+	Status        string `json:"status"`
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	Blurhash      string `json:"blurhash,omitempty"`
+	DominantColor string `json:"dominant_color,omitempty"`
+	VariantWidths string `json:"-"`
+
+	// Set by the API for clients to use the processed images
+	URL      string         `json:"url,omitempty" gorm:"-"`
+	SrcSet   string         `json:"srcset,omitempty" gorm:"-"`
+	Variants []MediaVariant `json:"variants,omitempty" gorm:"-"`
+
+	// Posts contains the IDs of the blog posts that reference the media
+	Posts []uint `json:"posts" gorm:"-"`
+}
+
+// MediaVariant is a resized version of a media
+type MediaVariant struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// TableName overrides the table name used by gorm, since media is already plural
+func (Media) TableName() string {
+	return "media"
+}
+
+// BlobKey returns the key of the media's content in the blob store
+func (m *Media) BlobKey() string {
+	return m.Hash[:2] + "/" + m.Hash
+}
+
+// StrippedKey returns the key of the media's content without its metadata in the blob store
+func (m *Media) StrippedKey() string {
+	return "variants/" + m.BlobKey() + "/full"
+}
+
+// VariantKey returns the key of the media resized to the given width in the blob store
+func (m *Media) VariantKey(width int) string {
+	return fmt.Sprintf("variants/%s/%d", m.BlobKey(), width)
+}
+
+// Widths returns the widths of the resized variants of the media
+func (m *Media) Widths() []int {
+	var widths []int
+	for _, w := range strings.Split(m.VariantWidths, ",") {
+		width, err := strconv.Atoi(w)
+		if err == nil {
+			widths = append(widths, width)
+		}
+	}
+	return widths
+}
+
+// SetWidths sets the widths of the resized variants of the media
+func (m *Media) SetWidths(widths []int) {
+	parts := make([]string, len(widths))
+	for i, width := range widths {
+		parts[i] = strconv.Itoa(width)
+	}
+	m.VariantWidths = strings.Join(parts, ",")
+}
@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+// MFAChallenge is given to users who logged in with their password while two-factor authentication
+// is enabled for their account. Its token is exchanged for a token along with a code.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// MFALogin represents a login that waits for its second factor. Only the hash of its
+// token is stored, and only a few codes can be tried before it has to start over.
+type MFALogin struct {
+	ID        uint `gorm:"primary_key"`
+	Hash      string
+	UserID    string
+	Scope     string
+	Attempts  uint
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// MFAEnrollment holds the TOTP secret of a user who starts enrolling in two-factor authentication,
+// along with its provisioning URI, which authenticator apps scan as a QR code
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCode represents a single-use code with which a user can log in when they can't generate
+// TOTP codes anymore. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primary_key"`
+	UserID    string
+	Hash      string
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// PasswordReset represents a request of a user to reset their forgotten password. Only the hash
+// of its token is stored, and the token can only be used once before it expires.
+type PasswordReset struct {
+	ID        uint `gorm:"primary_key"`
+	UserID    string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
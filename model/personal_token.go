@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// PersonalTokenPrefix starts every personal access token, so that they can be told apart from
+// JWTs and recognized by secret scanners
+const PersonalTokenPrefix = "bloggo_pat_"
+
+// PersonalAccessToken represents a long-lived token that a user creates for automation. Only the
+// hash of the token is stored, so the token itself is only returned when it is created.
+type PersonalAccessToken struct {
+	ID         uint       `json:"id" gorm:"primary_key"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name" validate:"required,max=64"`
+	Scope      string     `json:"scope" validate:"required"`
+	Hash       string     `json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+
+	// Set once, when the token is created
+	Token string `json:"token,omitempty" gorm:"-"`
+}
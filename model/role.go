@@ -0,0 +1,50 @@
+package model
+
+// Role represents what a user is allowed to do
+type Role string
+
+// Roles that can be given to users, from the least to the most privileged
+const (
+	RoleReader Role = "reader"
+	RoleAuthor Role = "author"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// Permission represents an action that requires a specific role
+type Permission string
+
+// Permissions that are checked by controllers
+const (
+	// PermissionEditAnyPost allows to edit or delete the blog posts of other authors
+	PermissionEditAnyPost Permission = "edit_any_post"
+)
+
+// roleScopes are the scopes that can be granted to the access tokens of each role
+var roleScopes = map[Role][]Scope{
+	RoleReader: {ScopePostsRead},
+	RoleAuthor: {ScopePostsRead, ScopePostsWrite, ScopePostsDelete},
+	RoleEditor: {ScopePostsRead, ScopePostsWrite, ScopePostsDelete},
+	RoleAdmin:  {ScopePostsRead, ScopePostsWrite, ScopePostsDelete, ScopeUsersAdmin},
+}
+
+// permissions are the permissions of each role
+var permissions = map[Role][]Permission{
+	RoleEditor: {PermissionEditAnyPost},
+	RoleAdmin:  {PermissionEditAnyPost},
+}
+
+// Scopes returns the scopes that can be granted to the access tokens of users with the role
+func (r Role) Scopes() []Scope {
+	return roleScopes[r]
+}
+
+// Can returns whether or not the role is granted the given permission
+func (r Role) Can(permission Permission) bool {
+	for _, p := range permissions[r] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
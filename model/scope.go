@@ -0,0 +1,73 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Scope represents what an access token can be used for
+type Scope string
+
+// Scopes that can be granted to access tokens
+const (
+	ScopePostsRead   Scope = "posts:read"
+	ScopePostsWrite  Scope = "posts:write"
+	ScopePostsDelete Scope = "posts:delete"
+	ScopeUsersAdmin  Scope = "users:admin"
+)
+
+// Writes returns true if the scope lets tokens create, change or delete blog posts and media
+func (s Scope) Writes() bool {
+	return s == ScopePostsWrite || s == ScopePostsDelete
+}
+
+// Principal represents the user on behalf of whom a request is
+// made, and the access token used to make it
+type Principal struct {
+	User   *User
+	Scopes []Scope
+
+	// TokenID is the jti claim of the access token, which is used to revoke it
+	TokenID   string
+	ExpiresAt time.Time
+
+	// SessionID is the sid claim of the access token, which is empty for personal access tokens
+	SessionID string
+
+	// PersonalTokenID is set when the request is made with a personal access token
+	PersonalTokenID uint
+
+	// ActorID is the act claim of impersonation tokens, which is the user ID of the admin
+	// who acts as User
+	ActorID string
+}
+
+// ParseScopes parses a space-separated list of scopes, as used in OAuth
+func ParseScopes(list string) []Scope {
+	var scopes []Scope
+	for _, field := range strings.Fields(list) {
+		if !HasScope(scopes, Scope(field)) {
+			scopes = append(scopes, Scope(field))
+		}
+	}
+	return scopes
+}
+
+// FormatScopes formats scopes as a space-separated list
+func FormatScopes(scopes []Scope) string {
+	fields := make([]string, len(scopes))
+	for i, scope := range scopes {
+		fields[i] = string(scope)
+	}
+	return strings.Join(fields, " ")
+}
+
+// HasScope returns whether or not a list of scopes contains the given scope
+func HasScope(scopes []Scope, scope Scope) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package model
+
+import "time"
+
+// Session represents a login of a user, from which its access and refresh tokens are issued. The
+// refresh tokens of a session belong to the family of the same ID, and its access tokens carry its ID
+// in their sid claim, so that revoking the session logs out the device right away.
+type Session struct {
+	ID         string     `json:"id" gorm:"primary_key"`
+	UserID     string     `json:"-"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	RevokedAt  *time.Time `json:"-"`
+
+	// Set when the session is the one of the request that lists it
+	Current bool `json:"current" gorm:"-"`
+}
+
+// Client represents the device from which a user logs in
+type Client struct {
+	IP        string
+	UserAgent string
+}
+
+// LoginMethod represents how a user logged in
+type LoginMethod string
+
+// Login methods
+const (
+	LoginMethodPassword   LoginMethod = "password"
+	LoginMethodMFA        LoginMethod = "mfa"
+	LoginMethodMagicLink  LoginMethod = "magic_link"
+	LoginMethodOIDC       LoginMethod = "oidc"
+	LoginMethodSetup      LoginMethod = "setup"
+	LoginMethodInvitation LoginMethod = "invitation"
+)
+
+// LoginEvent represents a login attempt, successful or not. Attempts on unknown email
+// addresses are stored without a user ID.
+type LoginEvent struct {
+	ID        uint        `json:"id" gorm:"primary_key"`
+	UserID    string      `json:"-"`
+	Email     string      `json:"email"`
+	Method    LoginMethod `json:"method"`
+	Success   bool        `json:"success"`
+	Reason    string      `json:"reason,omitempty"`
+	SessionID string      `json:"session_id,omitempty"`
+	IP        string      `json:"ip"`
+	UserAgent string      `json:"user_agent"`
+	CreatedAt time.Time   `json:"created_at"`
+}
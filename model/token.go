@@ -0,0 +1,36 @@
+package model
+
+import "time"
+
+// Token is given to users when they log in. The access token is short-lived, and
+// the refresh token can be exchanged once for a new pair of tokens. Tokens with which
+// an admin impersonates a user have no refresh token, and carry the ID of the admin.
+type Token struct {
+	AccessToken    string `json:"access_token"`
+	TokenType      string `json:"token_type"`
+	ExpiresIn      int64  `json:"expires_in"`
+	RefreshToken   string `json:"refresh_token,omitempty"`
+	Scope          string `json:"scope"`
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+}
+
+// RefreshToken represents a refresh token, of which only the hash is stored. Refresh tokens
+// obtained by refreshing the same login belong to the same family, so that they can all be
+// revoked if one of them is used twice.
+type RefreshToken struct {
+	ID        uint `gorm:"primary_key"`
+	Hash      string
+	Family    string
+	UserID    string
+	Scope     string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
+
+// RevokedToken represents an access token that was revoked before it expired
+type RevokedToken struct {
+	JTI       string `gorm:"primary_key"`
+	ExpiresAt time.Time
+}
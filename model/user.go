@@ -1,10 +1,41 @@
 package model
 
+import "time"
+
 // User reprensents a registered user
 type User struct {
 	ID          uint `gorm:"primary_key"`
 	TokenUserID string
-	Email       string `validate:"required,email"`
-	Password    string `validate:"required,min=10"`
-	IsAdmin     bool   `json:"is_admin"`
+	Email       string     `validate:"required,email"`
+	Password    string     `validate:"required,min=10"`
+	Role        Role       `json:"role" validate:"omitempty,oneof=reader author editor admin"`
+	Status      UserStatus `json:"status,omitempty" gorm:"default:'active'"`
+
+	// EmailVerified is set once the user proved that they own their email address
+	EmailVerified bool `json:"-"`
+
+	// PasswordResetAt is the last time the user reset their password. Access tokens
+	// issued before then are refused.
+	PasswordResetAt *time.Time `json:"-"`
+
+	// MFASecret is the TOTP secret of the user. Two-factor authentication is only enabled once the
+	// user confirmed their enrollment with a code, and MFALastStep is the time step of the last code
+	// they used, so that codes can't be used twice.
+	MFASecret   string `json:"-"`
+	MFAEnabled  bool   `json:"-"`
+	MFALastStep int64  `json:"-"`
 }
+
+// UserStatus represents whether a user can log in
+type UserStatus string
+
+// Statuses of the accounts of users
+const (
+	// StatusActive users can log in
+	StatusActive UserStatus = "active"
+	// StatusPending users registered while new accounts had to be approved, and can't
+	// log in until an admin approves them
+	StatusPending UserStatus = "pending"
+	// StatusDeactivated users were deactivated by an admin, and can't log in or use their tokens
+	StatusDeactivated UserStatus = "deactivated"
+)
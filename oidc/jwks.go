@@ -0,0 +1,195 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// keysTTL is how long the keys of a provider are cached
+const keysTTL = time.Hour
+
+// minRefreshInterval is how often the keys of a provider can be fetched again when a token is
+// signed by an unknown key, which happens when the provider rotates its keys
+const minRefreshInterval = time.Minute
+
+// remoteKey is a public key of a provider
+type remoteKey struct {
+	// algorithm is the algorithm with which the key signs tokens, if the provider specified it
+	algorithm string
+	public    interface{}
+}
+
+// remoteKeySet is the set of keys of a provider, which is cached and fetched again
+// when it expires or when a token is signed with a key that it doesn't contain
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mutex     sync.Mutex
+	keys      map[string]*remoteKey
+	fetchedAt time.Time
+
+	now func() time.Time
+}
+
+// newRemoteKeySet creates a key set that fetches the keys of a provider from the given URL
+func newRemoteKeySet(url string, client *http.Client, now func() time.Time) *remoteKeySet {
+	return &remoteKeySet{
+		url:    url,
+		client: client,
+		now:    now,
+	}
+}
+
+// Keyfunc returns the public key with which a token should be verified, from its kid header
+func (r *remoteKeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, err := r.key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.algorithm != "" && key.algorithm != token.Method.Alg() {
+		return nil, errors.Errorf("key %q can't be used with %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// key returns the key with the given ID. Tokens without a kid header can
+// only be verified by providers that have a single key.
+func (r *remoteKeySet) key(kid string) (*remoteKey, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := r.now()
+	stale := now.Sub(r.fetchedAt) >= keysTTL
+
+	key := r.find(kid)
+	if key != nil && !stale {
+		return key, nil
+	}
+
+	if stale || now.Sub(r.fetchedAt) >= minRefreshInterval {
+		keys, err := r.fetch()
+		if err != nil {
+			return nil, err
+		}
+		r.keys = keys
+		r.fetchedAt = now
+	}
+
+	key = r.find(kid)
+	if key == nil {
+		return nil, errors.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// find returns the cached key with the given ID
+func (r *remoteKeySet) find(kid string) *remoteKey {
+	if kid == "" && len(r.keys) == 1 {
+		for _, key := range r.keys {
+			return key
+		}
+	}
+	return r.keys[kid]
+}
+
+// fetch fetches the signing keys of the provider. Keys of unsupported types are ignored.
+func (r *remoteKeySet) fetch() (map[string]*remoteKey, error) {
+	var set model.JSONWebKeySet
+	err := getJSON(r.client, r.url, &set)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch identity provider keys")
+	}
+
+	keys := make(map[string]*remoteKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		public, err := parseJWK(jwk)
+		if err != nil {
+			continue
+		}
+		keys[jwk.KeyID] = &remoteKey{
+			algorithm: jwk.Algorithm,
+			public:    public,
+		}
+	}
+
+	return keys, nil
+}
+
+// parseJWK returns the public key of a JSON web key
+func parseJWK(jwk model.JSONWebKey) (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.Modulus)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve %q", jwk.Curve)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid elliptic curve point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, errors.Errorf("unsupported key type %q", jwk.KeyType)
+}
+
+// decodeBigInt decodes an integer encoded in unpadded base64url
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
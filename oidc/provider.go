@@ -0,0 +1,384 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+// metadataTTL is how long the configuration of a provider is cached
+const metadataTTL = 24 * time.Hour
+
+// clockSkew is how far the clock of a provider can be from ours
+const clockSkew = time.Minute
+
+// maxResponseSize is the largest response that is read from a provider
+const maxResponseSize = 1 << 20
+
+// supportedAlgorithms are the algorithms with which ID tokens can be signed
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// Config configures the connection to an OpenID Connect identity provider
+type Config struct {
+	// Issuer is the URL of the provider, from which its configuration is discovered
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the URL to which the provider sends users back after they log in
+	RedirectURL string
+
+	// Scopes are the scopes requested to the provider, which should include openid and email
+	Scopes []string
+}
+
+// Identity is the identity of a user, as asserted by the ID token of a provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// metadata is the configuration of a provider, as described by OpenID Connect Discovery
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgorithms     []string `json:"id_token_signing_alg_values_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider is an OpenID Connect identity provider, with which users log in using the authorization
+// code flow with PKCE. The configuration and keys of the provider are discovered and cached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mutex        sync.Mutex
+	metadata     *metadata
+	discoveredAt time.Time
+	keys         *remoteKeySet
+
+	now func() time.Time
+}
+
+// NewProvider creates a Provider that sends its requests using the given HTTP client
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+	issuer, err := url.Parse(config.Issuer)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid issuer")
+	}
+	if issuer.Scheme == "" || issuer.Host == "" {
+		return nil, errors.Errorf("invalid issuer %q", config.Issuer)
+	}
+	if config.ClientID == "" {
+		return nil, errors.New("missing client ID")
+	}
+
+	return &Provider{
+		config: config,
+		client: client,
+
+		now: time.Now,
+	}, nil
+}
+
+// AuthCodeURL returns the URL of the provider to which users are redirected to log in. The
+// state and nonce are sent back by the provider, and the verifier is the PKCE code verifier
+// which has to be given to Exchange.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	m, _, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	endpoint, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid authorization endpoint")
+	}
+
+	query := endpoint.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(p.config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", challenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+// tokenResponse is the response of the token endpoint of a provider
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange exchanges an authorization code for an ID token, and returns the identity that it
+// asserts. The verifier and nonce must be the ones with which the login was started.
+func (p *Provider) Exchange(code, verifier, nonce string) (*Identity, error) {
+	m, keys, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// Public clients only identify themselves, since the code verifier authenticates the exchange
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not exchange authorization code")
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not decode token response with status %d", resp.StatusCode)
+	}
+
+	// Codes that are invalid, expired or that don't match the code verifier are answered with
+	// invalid_grant, while other errors mean that Bloggo is not configured properly
+	if token.Error == "invalid_grant" {
+		return nil, errors.Wrapf(errortype.ErrInvalidToken, "identity provider rejected the authorization code: %s", token.ErrorDescription)
+	}
+	if token.Error != "" {
+		return nil, errors.Errorf("identity provider rejected the token request: %s %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("token endpoint responded with status %d", resp.StatusCode)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response does not contain an ID token")
+	}
+
+	return p.verify(m, keys, token.IDToken, nonce)
+}
+
+// idTokenClaims are the claims of an ID token that are verified or used
+type idTokenClaims struct {
+	Issuer          string        `json:"iss"`
+	Subject         string        `json:"sub"`
+	Audience        audience      `json:"aud"`
+	AuthorizedParty string        `json:"azp"`
+	ExpiresAt       int64         `json:"exp"`
+	Nonce           string        `json:"nonce"`
+	Email           string        `json:"email"`
+	EmailVerified   emailVerified `json:"email_verified"`
+}
+
+// Valid always succeeds, since the claims are verified by the provider
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// verify verifies the signature and claims of an ID token
+func (p *Provider) verify(m *metadata, keys *remoteKeySet, rawToken, nonce string) (*Identity, error) {
+	parser := &jwt.Parser{
+		ValidMethods:         algorithms(m),
+		SkipClaimsValidation: true,
+	}
+
+	var claims idTokenClaims
+	_, err := parser.ParseWithClaims(rawToken, &claims, keys.Keyfunc)
+	if err != nil {
+		return nil, errors.Wrapf(errortype.ErrInvalidToken, "invalid ID token: %v", err)
+	}
+
+	switch {
+	case claims.Issuer != p.config.Issuer:
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invalid 'iss' claim")
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invalid 'aud' claim")
+	case claims.AuthorizedParty != "" && claims.AuthorizedParty != p.config.ClientID:
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invalid 'azp' claim")
+	case claims.ExpiresAt == 0:
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "missing 'exp' claim")
+	case !p.now().Add(-clockSkew).Before(time.Unix(claims.ExpiresAt, 0)):
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "ID token is expired")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invalid 'nonce' claim")
+	case claims.Subject == "":
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "missing 'sub' claim")
+	}
+
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+	}, nil
+}
+
+// discover returns the configuration and keys of the provider, which are fetched
+// from its discovery document if they were not already cached
+func (p *Provider) discover() (*metadata, *remoteKeySet, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.metadata != nil && p.now().Sub(p.discoveredAt) < metadataTTL {
+		return p.metadata, p.keys, nil
+	}
+
+	var m metadata
+	err := getJSON(p.client, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &m)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not discover identity provider")
+	}
+
+	// The issuer of the discovery document must be exactly the one that was configured
+	if m.Issuer != p.config.Issuer {
+		return nil, nil, errors.Errorf("identity provider claims to be %q instead of %q", m.Issuer, p.config.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, nil, errors.New("identity provider configuration is missing endpoints")
+	}
+	if len(m.CodeChallengeMethods) > 0 && !contains(m.CodeChallengeMethods, "S256") {
+		return nil, nil, errors.New("identity provider does not support PKCE with S256")
+	}
+	if len(algorithms(&m)) == 0 {
+		return nil, nil, errors.Errorf("identity provider signs ID tokens with unsupported algorithms %v", m.SigningAlgorithms)
+	}
+
+	if p.keys == nil || p.keys.url != m.JWKSURI {
+		p.keys = newRemoteKeySet(m.JWKSURI, p.client, p.now)
+	}
+	p.metadata = &m
+	p.discoveredAt = p.now()
+
+	return p.metadata, p.keys, nil
+}
+
+// algorithms returns the algorithms with which the ID tokens of a provider can be signed
+func algorithms(m *metadata) []string {
+	// RS256 is the default algorithm of ID tokens
+	if len(m.SigningAlgorithms) == 0 {
+		return []string{"RS256"}
+	}
+
+	var algorithms []string
+	for _, alg := range m.SigningAlgorithms {
+		if contains(supportedAlgorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// challenge returns the S256 PKCE code challenge of a code verifier
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// getJSON fetches a JSON document
+func getJSON(client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("%s responded with status %d: %s", url, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// contains returns whether a list contains a value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// audience is the aud claim, which can either be a string or an array of strings
+type audience []string
+
+// UnmarshalJSON decodes a string or an array of strings
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	err := json.Unmarshal(data, &multiple)
+	if err != nil {
+		return errors.New("'aud' claim must be a string or an array of strings")
+	}
+	*a = multiple
+	return nil
+}
+
+// contains returns whether the audience includes the given client
+func (a audience) contains(clientID string) bool {
+	return contains(a, clientID)
+}
+
+// emailVerified is the email_verified claim, which some providers send as a string
+type emailVerified bool
+
+// UnmarshalJSON decodes a boolean or a string
+func (e *emailVerified) UnmarshalJSON(data []byte) error {
+	var verified bool
+	if json.Unmarshal(data, &verified) == nil {
+		*e = emailVerified(verified)
+		return nil
+	}
+
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return errors.New("'email_verified' claim must be a boolean")
+	}
+	*e = emailVerified(s == "true")
+	return nil
+}
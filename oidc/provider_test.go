@@ -0,0 +1,633 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeIdP is a local stand-in for an OpenID Connect identity provider
+type fakeIdP struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	keys       map[string]interface{}
+	codes      map[string]fakeGrant
+	jwksCalls  int
+	discovered int
+	metadata   map[string]interface{}
+}
+
+// fakeGrant is an authorization code issued by the fake identity provider
+type fakeGrant struct {
+	challenge string
+	idToken   string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	f := &fakeIdP{
+		keys:  make(map[string]interface{}),
+		codes: make(map[string]fakeGrant),
+	}
+	f.server = httptest.NewServer(f)
+	f.metadata = map[string]interface{}{
+		"issuer":                                f.server.URL,
+		"authorization_endpoint":                f.server.URL + "/authorize?prompt=login",
+		"token_endpoint":                        f.server.URL + "/token",
+		"jwks_uri":                              f.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+	f.addKey(t, "rsa-1", jwt.SigningMethodRS256)
+	return f
+}
+
+func (f *fakeIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		f.discovered++
+		json.NewEncoder(w).Encode(f.metadata)
+
+	case "/jwks":
+		f.jwksCalls++
+		set := model.JSONWebKeySet{Keys: []model.JSONWebKey{}}
+		for kid, private := range f.keys {
+			set.Keys = append(set.Keys, publicJWK(kid, private))
+		}
+		json.NewEncoder(w).Encode(set)
+
+	case "/token":
+		user, password, _ := r.BasicAuth()
+		if user != "bloggo" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		grant, ok := f.codes[r.PostFormValue("code")]
+		delete(f.codes, r.PostFormValue("code"))
+		if !ok || r.PostFormValue("grant_type") != "authorization_code" || challenge(r.PostFormValue("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "invalid code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "idp-access-token", "id_token": grant.idToken})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// addKey generates a new signing key
+func (f *fakeIdP) addKey(t *testing.T, kid string, method jwt.SigningMethod) {
+	var private interface{}
+	var err error
+	switch method {
+	case jwt.SigningMethodES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		t.Fatal("could not generate key")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys[kid] = private
+}
+
+// authorize issues an authorization code for the given PKCE challenge, that can be exchanged for the given ID token
+func (f *fakeIdP) authorize(code, challenge, idToken string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.codes[code] = fakeGrant{challenge: challenge, idToken: idToken}
+}
+
+// sign signs claims with one of the keys of the identity provider
+func (f *fakeIdP) sign(t *testing.T, kid string, method jwt.SigningMethod, claims jwt.MapClaims) string {
+	f.mu.Lock()
+	private := f.keys[kid]
+	f.mu.Unlock()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal("could not sign token")
+	}
+	return signed
+}
+
+func publicJWK(kid string, private interface{}) model.JSONWebKey {
+	switch key := private.(type) {
+	case *ecdsa.PrivateKey:
+		return model.JSONWebKey{
+			KeyType:   "EC",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "ES256",
+			Curve:     "P-256",
+			X:         base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			Y:         base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}
+	case *rsa.PrivateKey:
+		return model.JSONWebKey{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			Modulus:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	}
+	return model.JSONWebKey{}
+}
+
+func TestNewProvider(t *testing.T) {
+	tests := []struct {
+		description string
+
+		config Config
+
+		expectedErr bool
+	}{
+		{
+			description: "valid configuration",
+
+			config: Config{Issuer: "https://accounts.example.com", ClientID: "bloggo"},
+		},
+		{
+			description: "issuer is not a URL",
+
+			config: Config{Issuer: "accounts", ClientID: "bloggo"},
+
+			expectedErr: true,
+		},
+		{
+			description: "missing client ID",
+
+			config: Config{Issuer: "https://accounts.example.com"},
+
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			p, err := NewProvider(test.config, http.DefaultClient)
+
+			if test.expectedErr {
+				assert.Error(t, err, "expected an error")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Equal(t, test.config, p.config, "unexpected config")
+			}
+		})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.server.Close()
+
+	p, err := NewProvider(Config{
+		Issuer:      idp.server.URL,
+		ClientID:    "bloggo",
+		RedirectURL: "https://blog.example.com/api/oidc/example/callback",
+		Scopes:      []string{"openid", "email"},
+	}, idp.server.Client())
+	if err != nil {
+		t.Fatal("could not create provider")
+	}
+
+	authURL, err := p.AuthCodeURL("state", "nonce", "verifier")
+	if !assert.NoError(t, err, "unexpected error") {
+		return
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal("invalid authorization URL")
+	}
+	query := u.Query()
+
+	assert.Equal(t, idp.server.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path, "unexpected authorization endpoint")
+	assert.Equal(t, "login", query.Get("prompt"), "query of the authorization endpoint should be kept")
+	assert.Equal(t, "code", query.Get("response_type"), "unexpected response type")
+	assert.Equal(t, "bloggo", query.Get("client_id"), "unexpected client ID")
+	assert.Equal(t, "https://blog.example.com/api/oidc/example/callback", query.Get("redirect_uri"), "unexpected redirect URI")
+	assert.Equal(t, "openid email", query.Get("scope"), "unexpected scopes")
+	assert.Equal(t, "state", query.Get("state"), "unexpected state")
+	assert.Equal(t, "nonce", query.Get("nonce"), "unexpected nonce")
+	assert.Equal(t, "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ", query.Get("code_challenge"), "unexpected code challenge")
+	assert.Equal(t, "S256", query.Get("code_challenge_method"), "unexpected code challenge method")
+
+	// The configuration of the provider is cached
+	_, err = p.AuthCodeURL("state", "nonce", "verifier")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, 1, idp.discovered, "configuration should only be discovered once")
+}
+
+func TestDiscover(t *testing.T) {
+	tests := []struct {
+		description string
+
+		metadata map[string]interface{}
+
+		expectedErr string
+	}{
+		{
+			description: "valid configuration",
+		},
+		{
+			description: "issuer mismatch",
+
+			metadata: map[string]interface{}{"issuer": "https://evil.example.com"},
+
+			expectedErr: "identity provider claims to be",
+		},
+		{
+			description: "missing endpoint",
+
+			metadata: map[string]interface{}{"token_endpoint": ""},
+
+			expectedErr: "identity provider configuration is missing endpoints",
+		},
+		{
+			description: "PKCE not supported",
+
+			metadata: map[string]interface{}{"code_challenge_methods_supported": []string{"plain"}},
+
+			expectedErr: "identity provider does not support PKCE with S256",
+		},
+		{
+			description: "unsupported signing algorithms",
+
+			metadata: map[string]interface{}{"id_token_signing_alg_values_supported": []string{"HS256", "none"}},
+
+			expectedErr: "identity provider signs ID tokens with unsupported algorithms",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			defer idp.server.Close()
+
+			for key, value := range test.metadata {
+				idp.metadata[key] = value
+			}
+
+			p, err := NewProvider(Config{Issuer: idp.server.URL, ClientID: "bloggo"}, idp.server.Client())
+			if err != nil {
+				t.Fatal("could not create provider")
+			}
+
+			_, _, err = p.discover()
+
+			if test.expectedErr != "" {
+				assert.Error(t, err, "expected an error")
+				if err != nil {
+					assert.Contains(t, err.Error(), test.expectedErr, "unexpected error")
+				}
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
+
+func TestExchange(t *testing.T) {
+	now := time.Now()
+
+	validClaims := func(issuer string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":            issuer,
+			"sub":            "248289761001",
+			"aud":            "bloggo",
+			"exp":            now.Add(time.Hour).Unix(),
+			"iat":            now.Unix(),
+			"nonce":          "nonce",
+			"email":          "jane@example.com",
+			"email_verified": true,
+		}
+	}
+
+	tests := []struct {
+		description string
+
+		claims   func(claims jwt.MapClaims)
+		kid      string
+		method   jwt.SigningMethod
+		code     string
+		verifier string
+		newKey   bool
+
+		expectedIdentity *Identity
+		expectedErr      string
+		invalidToken     bool
+	}{
+		{
+			description: "valid ID token",
+
+			expectedIdentity: &Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true},
+		},
+		{
+			description: "several audiences with the client as authorized party",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["aud"] = []string{"bloggo", "other"}
+				claims["azp"] = "bloggo"
+				claims["email_verified"] = "false"
+			},
+
+			expectedIdentity: &Identity{Subject: "248289761001", Email: "jane@example.com"},
+		},
+		{
+			description: "ID token signed with an elliptic curve key",
+
+			kid:    "ec-1",
+			method: jwt.SigningMethodES256,
+			newKey: true,
+
+			expectedIdentity: &Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true},
+		},
+		{
+			description: "ID token signed with a key added after the keys were cached",
+
+			kid:    "rsa-2",
+			newKey: true,
+
+			expectedIdentity: &Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true},
+		},
+		{
+			description: "wrong code verifier",
+
+			verifier: "another-verifier",
+
+			expectedErr:  "identity provider rejected the authorization code: invalid code",
+			invalidToken: true,
+		},
+		{
+			description: "unknown code",
+
+			code: "unknown",
+
+			expectedErr:  "identity provider rejected the authorization code: invalid code",
+			invalidToken: true,
+		},
+		{
+			description: "wrong nonce",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["nonce"] = "replayed"
+			},
+
+			expectedErr:  "invalid 'nonce' claim",
+			invalidToken: true,
+		},
+		{
+			description: "wrong audience",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["aud"] = "another-client"
+			},
+
+			expectedErr:  "invalid 'aud' claim",
+			invalidToken: true,
+		},
+		{
+			description: "wrong authorized party",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["aud"] = []string{"bloggo", "other"}
+				claims["azp"] = "other"
+			},
+
+			expectedErr:  "invalid 'azp' claim",
+			invalidToken: true,
+		},
+		{
+			description: "wrong issuer",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["iss"] = "https://evil.example.com"
+			},
+
+			expectedErr:  "invalid 'iss' claim",
+			invalidToken: true,
+		},
+		{
+			description: "expired ID token",
+
+			claims: func(claims jwt.MapClaims) {
+				claims["exp"] = now.Add(-time.Hour).Unix()
+			},
+
+			expectedErr:  "ID token is expired",
+			invalidToken: true,
+		},
+		{
+			description: "missing exp claim",
+
+			claims: func(claims jwt.MapClaims) {
+				delete(claims, "exp")
+			},
+
+			expectedErr:  "missing 'exp' claim",
+			invalidToken: true,
+		},
+		{
+			description: "missing sub claim",
+
+			claims: func(claims jwt.MapClaims) {
+				delete(claims, "sub")
+			},
+
+			expectedErr:  "missing 'sub' claim",
+			invalidToken: true,
+		},
+		{
+			description: "ID token signed with an unknown key",
+
+			kid: "unknown",
+
+			expectedErr:  "unknown key",
+			invalidToken: true,
+		},
+		{
+			description: "ID token signed with HMAC",
+
+			method: jwt.SigningMethodHS256,
+
+			expectedErr:  "signing method HS256 is invalid",
+			invalidToken: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			idp := newFakeIdP(t)
+			defer idp.server.Close()
+
+			p, err := NewProvider(Config{
+				Issuer:       idp.server.URL,
+				ClientID:     "bloggo",
+				ClientSecret: "secret",
+				RedirectURL:  "https://blog.example.com/api/oidc/example/callback",
+			}, idp.server.Client())
+			if err != nil {
+				t.Fatal("could not create provider")
+			}
+
+			// Caches the keys of the provider, as a previous login would
+			_, keys, err := p.discover()
+			if err != nil {
+				t.Fatal("could not discover provider")
+			}
+			_, _ = keys.key("rsa-1")
+			keys.fetchedAt = now.Add(-2 * minRefreshInterval)
+
+			kid := "rsa-1"
+			if test.kid != "" {
+				kid = test.kid
+			}
+			method := jwt.SigningMethod(jwt.SigningMethodRS256)
+			if test.method != nil {
+				method = test.method
+			}
+			if test.newKey {
+				idp.addKey(t, kid, method)
+			}
+
+			claims := validClaims(idp.server.URL)
+			if test.claims != nil {
+				test.claims(claims)
+			}
+
+			var idToken string
+			if method == jwt.SigningMethodHS256 {
+				token := jwt.NewWithClaims(method, claims)
+				token.Header["kid"] = kid
+				idToken, _ = token.SignedString([]byte("secret"))
+			} else if _, ok := idp.keys[kid]; ok {
+				idToken = idp.sign(t, kid, method, claims)
+			} else {
+				idToken = idp.sign(t, "rsa-1", method, claims)
+				idToken = resignHeader(t, idToken, kid)
+			}
+
+			idp.authorize("code", challenge("verifier"), idToken)
+
+			code := "code"
+			if test.code != "" {
+				code = test.code
+			}
+			verifier := "verifier"
+			if test.verifier != "" {
+				verifier = test.verifier
+			}
+
+			identity, err := p.Exchange(code, verifier, "nonce")
+
+			if test.expectedErr != "" {
+				assert.Error(t, err, "expected an error")
+				if err != nil {
+					assert.Contains(t, err.Error(), test.expectedErr, "unexpected error")
+					assert.Equal(t, test.invalidToken, errors.Cause(err) == errortype.ErrInvalidToken, "unexpected error type")
+				}
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Equal(t, test.expectedIdentity, identity, "unexpected identity")
+			}
+		})
+	}
+}
+
+func TestExchangeClientAuthentication(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.server.Close()
+
+	p, err := NewProvider(Config{
+		Issuer:       idp.server.URL,
+		ClientID:     "bloggo",
+		ClientSecret: "wrong",
+	}, idp.server.Client())
+	if err != nil {
+		t.Fatal("could not create provider")
+	}
+
+	idp.authorize("code", challenge("verifier"), "")
+
+	// A wrong client secret is not the fault of the user
+	_, err = p.Exchange("code", "verifier", "nonce")
+	assert.Error(t, err, "expected an error")
+	if err != nil {
+		assert.Contains(t, err.Error(), "invalid_client", "unexpected error")
+		assert.NotEqual(t, errortype.ErrInvalidToken, errors.Cause(err), "unexpected error type")
+	}
+}
+
+func TestRemoteKeySetCache(t *testing.T) {
+	idp := newFakeIdP(t)
+	defer idp.server.Close()
+
+	now := time.Now()
+	keys := newRemoteKeySet(idp.server.URL+"/jwks", idp.server.Client(), func() time.Time { return now })
+
+	_, err := keys.key("rsa-1")
+	assert.NoError(t, err, "unexpected error")
+	_, err = keys.key("rsa-1")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, 1, idp.jwksCalls, "keys should be cached")
+
+	// Unknown keys don't cause the keys to be fetched more than once a minute
+	_, err = keys.key("unknown")
+	assert.Error(t, err, "expected an error")
+	assert.Equal(t, 1, idp.jwksCalls, "keys should not be fetched again right away")
+
+	now = now.Add(minRefreshInterval)
+	_, err = keys.key("unknown")
+	assert.Error(t, err, "expected an error")
+	assert.Equal(t, 2, idp.jwksCalls, "keys should be fetched again for an unknown key")
+
+	now = now.Add(keysTTL)
+	_, err = keys.key("rsa-1")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, 3, idp.jwksCalls, "keys should be fetched again once expired")
+}
+
+// resignHeader replaces the kid header of a token, which invalidates its signature
+func resignHeader(t *testing.T, token, kid string) string {
+	parts := []byte(token)
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+
+	for i, c := range parts {
+		if c == '.' {
+			return base64.RawURLEncoding.EncodeToString(header) + string(parts[i:])
+		}
+	}
+	t.Fatal("malformed token")
+	return ""
+}
@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// AuditEventRepositoryMock is a mock of AuditEventRepository
+type AuditEventRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *AuditEventRepositoryMock) Store(event *model.AuditEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+// List mock
+func (m *AuditEventRepositoryMock) List(userID string, offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	args := m.Called(userID, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.AuditEvent), args.Get(1).(uint), args.Error(2)
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// AuditEventRepositoryMySQL is a repository to manage audit events stored using Gorm
+type AuditEventRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewAuditEventRepositoryMySQL creates a new audit event repository using the given gorm DB as backend
+func NewAuditEventRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *AuditEventRepositoryMySQL {
+	return &AuditEventRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new audit event in the database
+func (r *AuditEventRepositoryMySQL) Store(event *model.AuditEvent) error {
+	err := r.db.Create(event).Error
+	return errors.Wrap(err, "could not save audit event in DB")
+}
+
+// List returns audit events, the most recent first, along with how many there are in total. Events
+// are filtered by the user who was their actor or subject if userID is not empty.
+func (r *AuditEventRepositoryMySQL) List(userID string, offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	query := r.db.Model(&model.AuditEvent{})
+	if userID != "" {
+		query = query.Where("actor_id = ? OR subject_id = ?", userID, userID)
+	}
+
+	var total uint
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not count audit events in DB")
+	}
+
+	var events []*model.AuditEvent
+	err = query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not get audit events from DB")
+	}
+
+	return events, total, nil
+}
@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// IdentityRepositoryMock is a mock of IdentityRepository
+type IdentityRepositoryMock struct {
+	mock.Mock
+}
+
+// Find mock
+func (m *IdentityRepositoryMock) Find(provider, subject string) (*model.Identity, error) {
+	args := m.Called(provider, subject)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Identity), args.Error(1)
+}
+
+// Store mock
+func (m *IdentityRepositoryMock) Store(identity *model.Identity) error {
+	args := m.Called(identity)
+	return args.Error(0)
+}
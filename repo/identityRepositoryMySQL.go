@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// IdentityRepositoryMySQL is a repository to manage the identities of users on identity providers stored using Gorm
+type IdentityRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewIdentityRepositoryMySQL creates a new identity repository using the given gorm DB as backend
+func NewIdentityRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *IdentityRepositoryMySQL {
+	return &IdentityRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Find returns the identity with the given subject on an identity provider from the database
+func (r *IdentityRepositoryMySQL) Find(provider, subject string) (*model.Identity, error) {
+	var identity model.Identity
+
+	err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get identity from db")
+	}
+
+	return &identity, nil
+}
+
+// Store saves a new identity in the database
+func (r *IdentityRepositoryMySQL) Store(identity *model.Identity) error {
+	err := r.db.Create(identity).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save identity in DB")
+}
@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// InvitationRepositoryMock is a mock of InvitationRepository
+type InvitationRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *InvitationRepositoryMock) Store(invitation *model.Invitation) error {
+	args := m.Called(invitation)
+	return args.Error(0)
+}
+
+// Find mock
+func (m *InvitationRepositoryMock) Find(id uint) (*model.Invitation, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Invitation), args.Error(1)
+}
+
+// FindAll mock
+func (m *InvitationRepositoryMock) FindAll() ([]*model.Invitation, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Invitation), args.Error(1)
+}
+
+// Accept mock
+func (m *InvitationRepositoryMock) Accept(id uint, acceptedAt time.Time) error {
+	args := m.Called(id, acceptedAt)
+	return args.Error(0)
+}
+
+// Release mock
+func (m *InvitationRepositoryMock) Release(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// Delete mock
+func (m *InvitationRepositoryMock) Delete(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
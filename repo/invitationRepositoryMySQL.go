@@ -0,0 +1,93 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// InvitationRepositoryMySQL is a repository to manage invitations stored using Gorm
+type InvitationRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewInvitationRepositoryMySQL creates a new invitation repository using the given gorm DB as backend
+func NewInvitationRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *InvitationRepositoryMySQL {
+	return &InvitationRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new invitation in the database
+func (r *InvitationRepositoryMySQL) Store(invitation *model.Invitation) error {
+	err := r.db.Create(invitation).Error
+	return errors.Wrap(err, "could not save invitation in DB")
+}
+
+// Find returns the invitation with the given ID from the database
+func (r *InvitationRepositoryMySQL) Find(id uint) (*model.Invitation, error) {
+	var invitation model.Invitation
+
+	err := r.db.First(&invitation, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get invitation from db")
+	}
+
+	return &invitation, nil
+}
+
+// FindAll returns every invitation, from the most recent one
+func (r *InvitationRepositoryMySQL) FindAll() ([]*model.Invitation, error) {
+	var invitations []*model.Invitation
+
+	err := r.db.Order("id desc").Find(&invitations).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get invitations from db")
+	}
+
+	return invitations, nil
+}
+
+// Accept marks an invitation as accepted. ErrConflict is returned if it was already accepted,
+// so that only one of concurrent registrations with the same invitation succeeds.
+func (r *InvitationRepositoryMySQL) Accept(id uint, acceptedAt time.Time) error {
+	result := r.db.Model(&model.Invitation{}).Where("id = ? AND accepted_at IS NULL", id).Update("accepted_at", acceptedAt)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not accept invitation in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
+// Release marks an accepted invitation as pending again
+func (r *InvitationRepositoryMySQL) Release(id uint) error {
+	err := r.db.Model(&model.Invitation{}).Where("id = ?", id).Update("accepted_at", gorm.Expr("NULL")).Error
+	return errors.Wrap(err, "could not release invitation in DB")
+}
+
+// Delete deletes an invitation that was not accepted yet. ErrNotFound
+// is returned if there is no such invitation.
+func (r *InvitationRepositoryMySQL) Delete(id uint) error {
+	result := r.db.Where("id = ? AND accepted_at IS NULL", id).Delete(&model.Invitation{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not delete invitation from DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
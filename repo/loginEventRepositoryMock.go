@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// LoginEventRepositoryMock is a mock of LoginEventRepository
+type LoginEventRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *LoginEventRepositoryMock) Store(event *model.LoginEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+// List mock
+func (m *LoginEventRepositoryMock) List(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	args := m.Called(userID, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.LoginEvent), args.Get(1).(uint), args.Error(2)
+}
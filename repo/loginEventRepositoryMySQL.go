@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// LoginEventRepositoryMySQL is a repository to manage login events stored using Gorm
+type LoginEventRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewLoginEventRepositoryMySQL creates a new login event repository using the given gorm DB as backend
+func NewLoginEventRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *LoginEventRepositoryMySQL {
+	return &LoginEventRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new login event in the database
+func (r *LoginEventRepositoryMySQL) Store(event *model.LoginEvent) error {
+	err := r.db.Create(event).Error
+	return errors.Wrap(err, "could not save login event in DB")
+}
+
+// List returns the login events of a user, the most recent first, along with how many there are in total
+func (r *LoginEventRepositoryMySQL) List(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	query := r.db.Model(&model.LoginEvent{}).Where("user_id = ?", userID)
+
+	var total uint
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not count login events in DB")
+	}
+
+	var events []*model.LoginEvent
+	err = query.Order("created_at DESC, id DESC").Offset(offset).Limit(limit).Find(&events).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not get login events from DB")
+	}
+
+	return events, total, nil
+}
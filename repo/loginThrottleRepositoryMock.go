@@ -0,0 +1,32 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// LoginThrottleRepositoryMock is a mock of LoginThrottleRepository
+type LoginThrottleRepositoryMock struct {
+	mock.Mock
+}
+
+// Find mock
+func (m *LoginThrottleRepositoryMock) Find(key string) (*model.LoginThrottle, error) {
+	args := m.Called(key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.LoginThrottle), args.Error(1)
+}
+
+// Save mock
+func (m *LoginThrottleRepositoryMock) Save(throttle *model.LoginThrottle) error {
+	args := m.Called(throttle)
+	return args.Error(0)
+}
+
+// Delete mock
+func (m *LoginThrottleRepositoryMock) Delete(key string) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// LoginThrottleRepositoryMySQL is a repository to manage failed login attempts stored using Gorm
+type LoginThrottleRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewLoginThrottleRepositoryMySQL creates a new login throttle repository using the given gorm DB as backend
+func NewLoginThrottleRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *LoginThrottleRepositoryMySQL {
+	return &LoginThrottleRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Find returns the failed login attempts tracked under the given key from the database
+func (r *LoginThrottleRepositoryMySQL) Find(key string) (*model.LoginThrottle, error) {
+	var throttle model.LoginThrottle
+
+	err := r.db.Where("`key` = ?", key).First(&throttle).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get login throttle from db")
+	}
+
+	return &throttle, nil
+}
+
+// Save creates or updates failed login attempts in the database
+func (r *LoginThrottleRepositoryMySQL) Save(throttle *model.LoginThrottle) error {
+	err := r.db.Save(throttle).Error
+	return errors.Wrap(err, "could not save login throttle in DB")
+}
+
+// Delete forgets the failed login attempts tracked under the given key. Deleting
+// attempts that aren't tracked is not an error.
+func (r *LoginThrottleRepositoryMySQL) Delete(key string) error {
+	err := r.db.Where("`key` = ?", key).Delete(&model.LoginThrottle{}).Error
+	return errors.Wrap(err, "could not delete login throttle from DB")
+}
@@ -0,0 +1,31 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// MagicLinkRepositoryMock is a mock of MagicLinkRepository
+type MagicLinkRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *MagicLinkRepositoryMock) Store(link *model.MagicLink) error {
+	args := m.Called(link)
+	return args.Error(0)
+}
+
+// CountSince mock
+func (m *MagicLinkRepositoryMock) CountSince(email string, since time.Time) (int, error) {
+	args := m.Called(email, since)
+	return args.Int(0), args.Error(1)
+}
+
+// Use mock
+func (m *MagicLinkRepositoryMock) Use(hash string, usedAt time.Time) error {
+	args := m.Called(hash, usedAt)
+	return args.Error(0)
+}
@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// MagicLinkRepositoryMySQL is a repository to manage magic links stored using Gorm
+type MagicLinkRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewMagicLinkRepositoryMySQL creates a new magic link repository using the given gorm DB as backend
+func NewMagicLinkRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *MagicLinkRepositoryMySQL {
+	return &MagicLinkRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new magic link in the database
+func (r *MagicLinkRepositoryMySQL) Store(link *model.MagicLink) error {
+	err := r.db.Create(link).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save magic link in DB")
+}
+
+// CountSince returns how many magic links were sent to the given email address since the given date
+func (r *MagicLinkRepositoryMySQL) CountSince(email string, since time.Time) (int, error) {
+	var count int
+	err := r.db.Model(&model.MagicLink{}).Where("email = ? AND created_at >= ?", email, since).Count(&count).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count magic links in DB")
+	}
+	return count, nil
+}
+
+// Use marks the magic link with the given hash as used. Since a magic link can only be used once,
+// ErrNotFound is returned if it is unknown or was already used.
+func (r *MagicLinkRepositoryMySQL) Use(hash string, usedAt time.Time) error {
+	result := r.db.Model(&model.MagicLink{}).
+		Where("hash = ? AND used_at IS NULL", hash).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update magic link in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
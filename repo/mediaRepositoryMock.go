@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// MediaRepositoryMock is a mock of MediaRepository
+type MediaRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *MediaRepositoryMock) Store(media *model.Media) (*model.Media, error) {
+	args := m.Called(media)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Media), args.Error(1)
+}
+
+// Retrieve mock
+func (m *MediaRepositoryMock) Retrieve(id uint) (*model.Media, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Media), args.Error(1)
+}
+
+// FindByHash mock
+func (m *MediaRepositoryMock) FindByHash(hash string) (*model.Media, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Media), args.Error(1)
+}
+
+// LinkPost mock
+func (m *MediaRepositoryMock) LinkPost(postID uint, mediaIDs []uint) error {
+	args := m.Called(postID, mediaIDs)
+	return args.Error(0)
+}
+
+// Update mock
+func (m *MediaRepositoryMock) Update(media *model.Media) error {
+	args := m.Called(media)
+	return args.Error(0)
+}
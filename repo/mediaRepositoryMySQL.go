@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// MediaRepositoryMySQL is a repository to manage media stored using Gorm
+type MediaRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewMediaRepositoryMySQL creates a new media repository using the given gorm DB as backend
+func NewMediaRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *MediaRepositoryMySQL {
+	return &MediaRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new media in the database.
+func (r *MediaRepositoryMySQL) Store(media *model.Media) (*model.Media, error) {
+	err := r.db.Create(media).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return nil, errortype.ErrDuplicateEntry
+		}
+	}
+
+	return media, err
+}
+
+// Retrieve returns the media with the given ID from the database, along with
+// the IDs of the blog posts that reference it
+func (r *MediaRepositoryMySQL) Retrieve(id uint) (*model.Media, error) {
+	media := model.Media{
+		ID: id,
+	}
+
+	err := r.db.Where(&media).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return &media, errortype.ErrNotFound
+	}
+	if err != nil {
+		return &media, err
+	}
+
+	return &media, r.loadPosts(&media)
+}
+
+// FindByHash returns the media with the given content hash from the database
+func (r *MediaRepositoryMySQL) FindByHash(hash string) (*model.Media, error) {
+	media := model.Media{
+		Hash: hash,
+	}
+
+	err := r.db.Where(&media).First(&media).Error
+	if err == gorm.ErrRecordNotFound {
+		return &media, errortype.ErrNotFound
+	}
+	if err != nil {
+		return &media, err
+	}
+
+	return &media, r.loadPosts(&media)
+}
+
+// LinkPost replaces the media referenced by a blog post. IDs of media that
+// don't exist are ignored.
+func (r *MediaRepositoryMySQL) LinkPost(postID uint, mediaIDs []uint) error {
+	tx := r.db.Begin()
+
+	err := tx.Exec("DELETE FROM post_media WHERE post_id = ?", postID).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not unlink media from blog post")
+	}
+
+	if len(mediaIDs) > 0 {
+		err = tx.Exec("INSERT INTO post_media (post_id, media_id) SELECT ?, id FROM media WHERE id IN (?)", postID, mediaIDs).Error
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "could not link media to blog post")
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+func (r *MediaRepositoryMySQL) loadPosts(media *model.Media) error {
+	media.Posts = []uint{}
+	err := r.db.Table("post_media").Where("media_id = ?", media.ID).Order("post_id").Pluck("post_id", &media.Posts).Error
+	return errors.Wrap(err, "could not get blog posts referencing media")
+}
+
+// Update saves the processing results of a media in the database
+func (r *MediaRepositoryMySQL) Update(media *model.Media) error {
+	err := r.db.Model(&model.Media{ID: media.ID}).Updates(map[string]interface{}{
+		"status":         media.Status,
+		"width":          media.Width,
+		"height":         media.Height,
+		"blurhash":       media.Blurhash,
+		"dominant_color": media.DominantColor,
+		"variant_widths": media.VariantWidths,
+	}).Error
+	return errors.Wrap(err, "could not save media in DB")
+}
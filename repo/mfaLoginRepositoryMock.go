@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// MFALoginRepositoryMock is a mock of MFALoginRepository
+type MFALoginRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *MFALoginRepositoryMock) Store(login *model.MFALogin) error {
+	args := m.Called(login)
+	return args.Error(0)
+}
+
+// FindByHash mock
+func (m *MFALoginRepositoryMock) FindByHash(hash string) (*model.MFALogin, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.MFALogin), args.Error(1)
+}
+
+// Attempt mock
+func (m *MFALoginRepositoryMock) Attempt(id uint, maxAttempts uint) error {
+	args := m.Called(id, maxAttempts)
+	return args.Error(0)
+}
+
+// Delete mock
+func (m *MFALoginRepositoryMock) Delete(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
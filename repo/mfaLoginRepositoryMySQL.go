@@ -0,0 +1,85 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// MFALoginRepositoryMySQL is a repository to manage the logins that wait for their second factor, stored using Gorm
+type MFALoginRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewMFALoginRepositoryMySQL creates a new MFA login repository using the given gorm DB as backend
+func NewMFALoginRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *MFALoginRepositoryMySQL {
+	return &MFALoginRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new MFA login in the database
+func (r *MFALoginRepositoryMySQL) Store(login *model.MFALogin) error {
+	err := r.db.Create(login).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save MFA login in DB")
+}
+
+// FindByHash returns the MFA login with the given token hash from the database
+func (r *MFALoginRepositoryMySQL) FindByHash(hash string) (*model.MFALogin, error) {
+	login := model.MFALogin{
+		Hash: hash,
+	}
+
+	err := r.db.Where(&login).First(&login).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get MFA login from db")
+	}
+
+	return &login, nil
+}
+
+// Attempt counts an attempt to complete an MFA login. ErrConflict is returned if
+// maxAttempts were already made, including concurrently.
+func (r *MFALoginRepositoryMySQL) Attempt(id uint, maxAttempts uint) error {
+	result := r.db.Model(&model.MFALogin{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update MFA login in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
+// Delete deletes an MFA login. Since an MFA login can only be completed once, ErrNotFound
+// is returned if it was already deleted in the meantime.
+func (r *MFALoginRepositoryMySQL) Delete(id uint) error {
+	result := r.db.Where("id = ?", id).Delete(&model.MFALogin{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not delete MFA login from DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
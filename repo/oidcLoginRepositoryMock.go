@@ -0,0 +1,26 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// OIDCLoginRepositoryMock is a mock of OIDCLoginRepository
+type OIDCLoginRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *OIDCLoginRepositoryMock) Store(login *model.OIDCLogin) error {
+	args := m.Called(login)
+	return args.Error(0)
+}
+
+// Consume mock
+func (m *OIDCLoginRepositoryMock) Consume(stateHash string) (*model.OIDCLogin, error) {
+	args := m.Called(stateHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.OIDCLogin), args.Error(1)
+}
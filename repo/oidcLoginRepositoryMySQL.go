@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// OIDCLoginRepositoryMySQL is a repository to manage the logins with identity providers that are in progress stored using Gorm
+type OIDCLoginRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewOIDCLoginRepositoryMySQL creates a new login repository using the given gorm DB as backend
+func NewOIDCLoginRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *OIDCLoginRepositoryMySQL {
+	return &OIDCLoginRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new login in the database
+func (r *OIDCLoginRepositoryMySQL) Store(login *model.OIDCLogin) error {
+	err := r.db.Create(login).Error
+	return errors.Wrap(err, "could not save login in DB")
+}
+
+// Consume returns the login with the given state hash and deletes it from the database, so that
+// each login can only be completed once. ErrNotFound is returned if it was already consumed.
+func (r *OIDCLoginRepositoryMySQL) Consume(stateHash string) (*model.OIDCLogin, error) {
+	var login model.OIDCLogin
+
+	err := r.db.Where("state_hash = ?", stateHash).First(&login).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get login from db")
+	}
+
+	// Only one of concurrent requests completing the same login deletes it
+	result := r.db.Where("state_hash = ?", stateHash).Delete(&model.OIDCLogin{})
+	if result.Error != nil {
+		return nil, errors.Wrap(result.Error, "could not delete login from DB")
+	}
+	if result.RowsAffected == 0 {
+		return nil, errortype.ErrNotFound
+	}
+
+	return &login, nil
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// PasswordResetRepositoryMock is a mock of PasswordResetRepository
+type PasswordResetRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *PasswordResetRepositoryMock) Store(reset *model.PasswordReset) error {
+	args := m.Called(reset)
+	return args.Error(0)
+}
+
+// CountSince mock
+func (m *PasswordResetRepositoryMock) CountSince(userID string, since time.Time) (int, error) {
+	args := m.Called(userID, since)
+	return args.Int(0), args.Error(1)
+}
+
+// FindByHash mock
+func (m *PasswordResetRepositoryMock) FindByHash(hash string) (*model.PasswordReset, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.PasswordReset), args.Error(1)
+}
+
+// MarkUsed mock
+func (m *PasswordResetRepositoryMock) MarkUsed(id uint, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+// DeleteByUser mock
+func (m *PasswordResetRepositoryMock) DeleteByUser(userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// PasswordResetRepositoryMySQL is a repository to manage password resets stored using Gorm
+type PasswordResetRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewPasswordResetRepositoryMySQL creates a new password reset repository using the given gorm DB as backend
+func NewPasswordResetRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *PasswordResetRepositoryMySQL {
+	return &PasswordResetRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new password reset in the database
+func (r *PasswordResetRepositoryMySQL) Store(reset *model.PasswordReset) error {
+	err := r.db.Create(reset).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save password reset in DB")
+}
+
+// CountSince returns how many password resets were sent to the given user since the given date
+func (r *PasswordResetRepositoryMySQL) CountSince(userID string, since time.Time) (int, error) {
+	var count int
+	err := r.db.Model(&model.PasswordReset{}).Where("user_id = ? AND created_at >= ?", userID, since).Count(&count).Error
+	if err != nil {
+		return 0, errors.Wrap(err, "could not count password resets in DB")
+	}
+	return count, nil
+}
+
+// FindByHash returns the password reset with the given token hash from the database
+func (r *PasswordResetRepositoryMySQL) FindByHash(hash string) (*model.PasswordReset, error) {
+	reset := model.PasswordReset{
+		Hash: hash,
+	}
+
+	err := r.db.Where(&reset).First(&reset).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get password reset from db")
+	}
+
+	return &reset, nil
+}
+
+// MarkUsed marks a password reset as used. Since a password reset can only be used once,
+// ErrConflict is returned if it was already used in the meantime.
+func (r *PasswordResetRepositoryMySQL) MarkUsed(id uint, usedAt time.Time) error {
+	result := r.db.Model(&model.PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update password reset in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
+// DeleteByUser deletes all of the password resets of a user
+func (r *PasswordResetRepositoryMySQL) DeleteByUser(userID string) error {
+	err := r.db.Where("user_id = ?", userID).Delete(&model.PasswordReset{}).Error
+	return errors.Wrap(err, "could not delete password resets from DB")
+}
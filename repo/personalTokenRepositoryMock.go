@@ -0,0 +1,49 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// PersonalTokenRepositoryMock is a mock of PersonalTokenRepository
+type PersonalTokenRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *PersonalTokenRepositoryMock) Store(token *model.PersonalAccessToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+// FindByHash mock
+func (m *PersonalTokenRepositoryMock) FindByHash(hash string) (*model.PersonalAccessToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.PersonalAccessToken), args.Error(1)
+}
+
+// FindByUser mock
+func (m *PersonalTokenRepositoryMock) FindByUser(userID string) ([]*model.PersonalAccessToken, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.PersonalAccessToken), args.Error(1)
+}
+
+// Delete mock
+func (m *PersonalTokenRepositoryMock) Delete(id uint, userID string) error {
+	args := m.Called(id, userID)
+	return args.Error(0)
+}
+
+// Touch mock
+func (m *PersonalTokenRepositoryMock) Touch(id uint, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
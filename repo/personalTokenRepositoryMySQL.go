@@ -0,0 +1,92 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// PersonalTokenRepositoryMySQL is a repository to manage personal access tokens stored using Gorm
+type PersonalTokenRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewPersonalTokenRepositoryMySQL creates a new personal access token repository using the given gorm DB as backend
+func NewPersonalTokenRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *PersonalTokenRepositoryMySQL {
+	return &PersonalTokenRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new personal access token in the database
+func (r *PersonalTokenRepositoryMySQL) Store(token *model.PersonalAccessToken) error {
+	err := r.db.Create(token).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save personal access token in DB")
+}
+
+// FindByHash returns the personal access token with the given hash from the database
+func (r *PersonalTokenRepositoryMySQL) FindByHash(hash string) (*model.PersonalAccessToken, error) {
+	token := model.PersonalAccessToken{
+		Hash: hash,
+	}
+
+	err := r.db.Where(&token).First(&token).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get personal access token from db")
+	}
+
+	return &token, nil
+}
+
+// FindByUser returns the personal access tokens of a user, from the most recent one
+func (r *PersonalTokenRepositoryMySQL) FindByUser(userID string) ([]*model.PersonalAccessToken, error) {
+	var tokens []*model.PersonalAccessToken
+
+	err := r.db.Where(&model.PersonalAccessToken{UserID: userID}).Order("id desc").Find(&tokens).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get personal access tokens from db")
+	}
+
+	return tokens, nil
+}
+
+// Delete deletes a personal access token of a user. ErrNotFound is returned
+// if the user has no such token.
+func (r *PersonalTokenRepositoryMySQL) Delete(id uint, userID string) error {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&model.PersonalAccessToken{})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not delete personal access token from DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// Touch sets the date at which a personal access token was last used
+func (r *PersonalTokenRepositoryMySQL) Touch(id uint, usedAt time.Time) error {
+	err := r.db.Model(&model.PersonalAccessToken{}).
+		Where("id = ?", id).
+		Update("last_used_at", usedAt).Error
+	return errors.Wrap(err, "could not update personal access token in DB")
+}
@@ -0,0 +1,31 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// RecoveryCodeRepositoryMock is a mock of RecoveryCodeRepository
+type RecoveryCodeRepositoryMock struct {
+	mock.Mock
+}
+
+// Replace mock
+func (m *RecoveryCodeRepositoryMock) Replace(userID string, codes []*model.RecoveryCode) error {
+	args := m.Called(userID, codes)
+	return args.Error(0)
+}
+
+// Use mock
+func (m *RecoveryCodeRepositoryMock) Use(userID, hash string, usedAt time.Time) error {
+	args := m.Called(userID, hash, usedAt)
+	return args.Error(0)
+}
+
+// DeleteByUser mock
+func (m *RecoveryCodeRepositoryMock) DeleteByUser(userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
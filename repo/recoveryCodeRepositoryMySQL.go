@@ -0,0 +1,70 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// RecoveryCodeRepositoryMySQL is a repository to manage MFA recovery codes stored using Gorm
+type RecoveryCodeRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewRecoveryCodeRepositoryMySQL creates a new recovery code repository using the given gorm DB as backend
+func NewRecoveryCodeRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *RecoveryCodeRepositoryMySQL {
+	return &RecoveryCodeRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Replace replaces all of the recovery codes of a user with the given ones
+func (r *RecoveryCodeRepositoryMySQL) Replace(userID string, codes []*model.RecoveryCode) error {
+	tx := r.db.Begin()
+
+	err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not delete recovery codes from DB")
+	}
+
+	for _, code := range codes {
+		err = tx.Create(code).Error
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "could not save recovery code in DB")
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// Use marks the unused recovery code of a user with the given hash as used. ErrNotFound is
+// returned if the user has no such code, or if it was already used.
+func (r *RecoveryCodeRepositoryMySQL) Use(userID, hash string, usedAt time.Time) error {
+	result := r.db.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update recovery code in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteByUser deletes all of the recovery codes of a user
+func (r *RecoveryCodeRepositoryMySQL) DeleteByUser(userID string) error {
+	err := r.db.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	return errors.Wrap(err, "could not delete recovery codes from DB")
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// RefreshTokenRepositoryMock is a mock of RefreshTokenRepository
+type RefreshTokenRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *RefreshTokenRepositoryMock) Store(token *model.RefreshToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+// FindByHash mock
+func (m *RefreshTokenRepositoryMock) FindByHash(hash string) (*model.RefreshToken, error) {
+	args := m.Called(hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.RefreshToken), args.Error(1)
+}
+
+// MarkUsed mock
+func (m *RefreshTokenRepositoryMock) MarkUsed(id uint, usedAt time.Time) error {
+	args := m.Called(id, usedAt)
+	return args.Error(0)
+}
+
+// RevokeFamily mock
+func (m *RefreshTokenRepositoryMock) RevokeFamily(family string, revokedAt time.Time) error {
+	args := m.Called(family, revokedAt)
+	return args.Error(0)
+}
+
+// RevokeUser mock
+func (m *RefreshTokenRepositoryMock) RevokeUser(userID string, revokedAt time.Time) error {
+	args := m.Called(userID, revokedAt)
+	return args.Error(0)
+}
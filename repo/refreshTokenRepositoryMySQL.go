@@ -0,0 +1,90 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// RefreshTokenRepositoryMySQL is a repository to manage refresh tokens stored using Gorm
+type RefreshTokenRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewRefreshTokenRepositoryMySQL creates a new refresh token repository using the given gorm DB as backend
+func NewRefreshTokenRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *RefreshTokenRepositoryMySQL {
+	return &RefreshTokenRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new refresh token in the database
+func (r *RefreshTokenRepositoryMySQL) Store(token *model.RefreshToken) error {
+	err := r.db.Create(token).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save refresh token in DB")
+}
+
+// FindByHash returns the refresh token with the given hash from the database
+func (r *RefreshTokenRepositoryMySQL) FindByHash(hash string) (*model.RefreshToken, error) {
+	token := model.RefreshToken{
+		Hash: hash,
+	}
+
+	err := r.db.Where(&token).First(&token).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get refresh token from db")
+	}
+
+	return &token, nil
+}
+
+// MarkUsed marks a refresh token as used. Since a refresh token can only be used once,
+// ErrConflict is returned if it was already used or revoked in the meantime.
+func (r *RefreshTokenRepositoryMySQL) MarkUsed(id uint, usedAt time.Time) error {
+	result := r.db.Model(&model.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", usedAt)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update refresh token in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
+// RevokeFamily revokes all of the refresh tokens of a family
+func (r *RefreshTokenRepositoryMySQL) RevokeFamily(family string, revokedAt time.Time) error {
+	err := r.db.Model(&model.RefreshToken{}).
+		Where("family = ? AND revoked_at IS NULL", family).
+		Update("revoked_at", revokedAt).Error
+	return errors.Wrap(err, "could not revoke refresh tokens in DB")
+}
+
+// RevokeUser revokes all of the refresh tokens of a user
+func (r *RefreshTokenRepositoryMySQL) RevokeUser(userID string, revokedAt time.Time) error {
+	err := r.db.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+	return errors.Wrap(err, "could not revoke refresh tokens in DB")
+}
@@ -0,0 +1,34 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// RevokedTokenRepositoryMock is a mock of RevokedTokenRepository
+type RevokedTokenRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *RevokedTokenRepositoryMock) Store(token *model.RevokedToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+// FindActive mock
+func (m *RevokedTokenRepositoryMock) FindActive(now time.Time) ([]*model.RevokedToken, error) {
+	args := m.Called(now)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.RevokedToken), args.Error(1)
+}
+
+// DeleteExpired mock
+func (m *RevokedTokenRepositoryMock) DeleteExpired(now time.Time) error {
+	args := m.Called(now)
+	return args.Error(0)
+}
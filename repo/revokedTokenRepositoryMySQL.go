@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// RevokedTokenRepositoryMySQL is a repository to manage revoked access tokens stored using Gorm
+type RevokedTokenRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewRevokedTokenRepositoryMySQL creates a new revoked token repository using the given gorm DB as backend
+func NewRevokedTokenRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *RevokedTokenRepositoryMySQL {
+	return &RevokedTokenRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a revoked token in the database. Revoking a token twice is not an error.
+func (r *RevokedTokenRepositoryMySQL) Store(token *model.RevokedToken) error {
+	err := r.db.Save(token).Error
+	return errors.Wrap(err, "could not save revoked token in DB")
+}
+
+// FindActive returns the revoked tokens that are not expired yet
+func (r *RevokedTokenRepositoryMySQL) FindActive(now time.Time) ([]*model.RevokedToken, error) {
+	var tokens []*model.RevokedToken
+
+	err := r.db.Where("expires_at > ?", now).Find(&tokens).Error
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get revoked tokens from db")
+	}
+	return tokens, nil
+}
+
+// DeleteExpired removes the revoked tokens that expired, since they can't be used anymore
+func (r *RevokedTokenRepositoryMySQL) DeleteExpired(now time.Time) error {
+	err := r.db.Where("expires_at <= ?", now).Delete(&model.RevokedToken{}).Error
+	return errors.Wrap(err, "could not delete expired revoked tokens from db")
+}
@@ -0,0 +1,61 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/mock"
+)
+
+// SessionRepositoryMock is a mock of SessionRepository
+type SessionRepositoryMock struct {
+	mock.Mock
+}
+
+// Store mock
+func (m *SessionRepositoryMock) Store(session *model.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+// Find mock
+func (m *SessionRepositoryMock) Find(id string) (*model.Session, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Session), args.Error(1)
+}
+
+// ListActive mock
+func (m *SessionRepositoryMock) ListActive(userID string, since time.Time) ([]*model.Session, error) {
+	args := m.Called(userID, since)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*model.Session), args.Error(1)
+}
+
+// Touch mock
+func (m *SessionRepositoryMock) Touch(id string, seenAt time.Time) error {
+	args := m.Called(id, seenAt)
+	return args.Error(0)
+}
+
+// Revoke mock
+func (m *SessionRepositoryMock) Revoke(id string, revokedAt time.Time) error {
+	args := m.Called(id, revokedAt)
+	return args.Error(0)
+}
+
+// RevokeOthers mock
+func (m *SessionRepositoryMock) RevokeOthers(userID, id string, revokedAt time.Time) error {
+	args := m.Called(userID, id, revokedAt)
+	return args.Error(0)
+}
+
+// RevokeUser mock
+func (m *SessionRepositoryMock) RevokeUser(userID string, revokedAt time.Time) error {
+	args := m.Called(userID, revokedAt)
+	return args.Error(0)
+}
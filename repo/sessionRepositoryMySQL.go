@@ -0,0 +1,145 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SessionRepositoryMySQL is a repository to manage sessions stored using Gorm
+type SessionRepositoryMySQL struct {
+	db *gorm.DB
+
+	log *zerolog.Logger
+}
+
+// NewSessionRepositoryMySQL creates a new session repository using the given gorm DB as backend
+func NewSessionRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *SessionRepositoryMySQL {
+	return &SessionRepositoryMySQL{
+		db: db,
+
+		log: log,
+	}
+}
+
+// Store saves a new session in the database
+func (r *SessionRepositoryMySQL) Store(session *model.Session) error {
+	err := r.db.Create(session).Error
+	if mysqlError, ok := err.(*mysql.MySQLError); ok {
+		// if the error is of type duplicate entry
+		if mysqlError.Number == 1062 {
+			return errortype.ErrDuplicateEntry
+		}
+	}
+
+	return errors.Wrap(err, "could not save session in DB")
+}
+
+// Find returns the session with the given ID from the database
+func (r *SessionRepositoryMySQL) Find(id string) (*model.Session, error) {
+	var session model.Session
+
+	err := r.db.Where("id = ?", id).First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get session from db")
+	}
+
+	return &session, nil
+}
+
+// ListActive returns the sessions of a user that were not revoked and were seen since the given date,
+// the most recently seen first
+func (r *SessionRepositoryMySQL) ListActive(userID string, since time.Time) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND last_seen_at >= ?", userID, since).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, errors.Wrap(err, "could not get sessions from DB")
+}
+
+// Touch sets the date at which a session was last seen
+func (r *SessionRepositoryMySQL) Touch(id string, seenAt time.Time) error {
+	err := r.db.Model(&model.Session{}).Where("id = ?", id).Update("last_seen_at", seenAt).Error
+	return errors.Wrap(err, "could not update session in DB")
+}
+
+// Revoke revokes a session along with its refresh tokens. ErrNotFound is returned if the
+// session is unknown or was already revoked.
+func (r *SessionRepositoryMySQL) Revoke(id string, revokedAt time.Time) error {
+	tx := r.db.Begin()
+
+	result := tx.Model(&model.Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", revokedAt)
+	if result.Error != nil {
+		tx.Rollback()
+		return errors.Wrap(result.Error, "could not revoke session in DB")
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return errortype.ErrNotFound
+	}
+
+	err := tx.Model(&model.RefreshToken{}).
+		Where("family = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", revokedAt).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not revoke refresh tokens in DB")
+	}
+
+	return tx.Commit().Error
+}
+
+// RevokeOthers revokes the sessions of a user but the one with the given id, along with their refresh tokens
+func (r *SessionRepositoryMySQL) RevokeOthers(userID, id string, revokedAt time.Time) error {
+	tx := r.db.Begin()
+
+	err := tx.Model(&model.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, id).
+		Update("revoked_at", revokedAt).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not revoke sessions in DB")
+	}
+
+	err = tx.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND family <> ? AND revoked_at IS NULL", userID, id).
+		Update("revoked_at", revokedAt).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not revoke refresh tokens in DB")
+	}
+
+	return tx.Commit().Error
+}
+
+// RevokeUser revokes all of the sessions of a user along with their refresh tokens
+func (r *SessionRepositoryMySQL) RevokeUser(userID string, revokedAt time.Time) error {
+	tx := r.db.Begin()
+
+	err := tx.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not revoke sessions in DB")
+	}
+
+	err = tx.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", revokedAt).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not revoke refresh tokens in DB")
+	}
+
+	return tx.Commit().Error
+}
@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/Ullaakut/Bloggo/model"
 	"github.com/stretchr/testify/mock"
 )
@@ -19,6 +21,15 @@ func (m *UserRepositoryMock) Retrieve(user *model.User) (*model.User, error) {
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
+// FindByID mock
+func (m *UserRepositoryMock) FindByID(id uint) (*model.User, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
 // Store mock
 func (m *UserRepositoryMock) Store(user *model.User) (*model.User, error) {
 	args := m.Called(user)
@@ -33,3 +44,78 @@ func (m *UserRepositoryMock) AdminExists() bool {
 	args := m.Called()
 	return args.Bool(0)
 }
+
+// SetRole mock
+func (m *UserRepositoryMock) SetRole(id uint, role model.Role) error {
+	args := m.Called(id, role)
+	return args.Error(0)
+}
+
+// Approve mock
+func (m *UserRepositoryMock) Approve(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// Deactivate mock
+func (m *UserRepositoryMock) Deactivate(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// UpdateEmail mock
+func (m *UserRepositoryMock) UpdateEmail(id uint, email string) error {
+	args := m.Called(id, email)
+	return args.Error(0)
+}
+
+// VerifyEmail mock
+func (m *UserRepositoryMock) VerifyEmail(id uint, email string) error {
+	args := m.Called(id, email)
+	return args.Error(0)
+}
+
+// List mock
+func (m *UserRepositoryMock) List(status model.UserStatus, offset, limit uint) ([]*model.User, uint, error) {
+	args := m.Called(status, offset, limit)
+	if args.Get(0) == nil {
+		return nil, args.Get(1).(uint), args.Error(2)
+	}
+	return args.Get(0).([]*model.User), args.Get(1).(uint), args.Error(2)
+}
+
+// ResetPassword mock
+func (m *UserRepositoryMock) ResetPassword(id uint, hash string, resetAt time.Time) error {
+	args := m.Called(id, hash, resetAt)
+	return args.Error(0)
+}
+
+// UpdatePassword mock
+func (m *UserRepositoryMock) UpdatePassword(id uint, hash string) error {
+	args := m.Called(id, hash)
+	return args.Error(0)
+}
+
+// SetMFASecret mock
+func (m *UserRepositoryMock) SetMFASecret(id uint, secret string) error {
+	args := m.Called(id, secret)
+	return args.Error(0)
+}
+
+// EnableMFA mock
+func (m *UserRepositoryMock) EnableMFA(id uint, step int64) error {
+	args := m.Called(id, step)
+	return args.Error(0)
+}
+
+// DisableMFA mock
+func (m *UserRepositoryMock) DisableMFA(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+// UseMFAStep mock
+func (m *UserRepositoryMock) UseMFAStep(id uint, step int64) error {
+	args := m.Called(id, step)
+	return args.Error(0)
+}
@@ -1,10 +1,13 @@
 package repo
 
 import (
+	"time"
+
 	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/model"
 	"github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
@@ -24,21 +27,262 @@ func NewUserRepositoryMySQL(log *zerolog.Logger, db *gorm.DB) *UserRepositoryMyS
 	}
 }
 
-// Retrieve returns the user with the given ID from the database
+// Retrieve returns the user that matches the given user from the database
 func (r *UserRepositoryMySQL) Retrieve(user *model.User) (*model.User, error) {
 	err := r.db.Where(user).First(user).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
 	return user, err
 }
 
+// FindByID returns the user with the given ID from the database. Unlike Retrieve, which leaves
+// the zero values of its filter out of the query, it doesn't match any user for id 0.
+func (r *UserRepositoryMySQL) FindByID(id uint) (*model.User, error) {
+	var user model.User
+
+	err := r.db.First(&user, id).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errortype.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get user from db")
+	}
+
+	return &user, nil
+}
+
 // AdminExists returns true if an admin exists, false otherwise
 func (r *UserRepositoryMySQL) AdminExists() bool {
 	filter := &model.User{
-		IsAdmin: true,
+		Role: model.RoleAdmin,
 	}
 
 	return r.db.Where(filter).First(filter).Error == nil
 }
 
+// SetRole changes the role of the user with the given ID. ErrConflict is returned if
+// the user is the last admin, since nobody could manage the other users anymore.
+func (r *UserRepositoryMySQL) SetRole(id uint, role model.Role) error {
+	tx := r.db.Begin()
+
+	// Admins are locked before the user, so that concurrent demotions wait
+	// for each other instead of both seeing that another admin is left
+	var admins []model.User
+	if role != model.RoleAdmin {
+		err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").Where("role = ? AND status = ?", model.RoleAdmin, model.StatusActive).Find(&admins).Error
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "could not get admins from db")
+		}
+	}
+
+	var user model.User
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&user, id).Error
+	if err == gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return errortype.ErrNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not get user from db")
+	}
+
+	if user.Role == model.RoleAdmin && user.Status == model.StatusActive && role != model.RoleAdmin && len(admins) <= 1 {
+		tx.Rollback()
+		return errors.Wrap(errortype.ErrConflict, "the last admin can't be demoted")
+	}
+
+	err = tx.Model(&user).Update("role", role).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not update user role in DB")
+	}
+
+	return tx.Commit().Error
+}
+
+// Deactivate prevents the user with the given ID from logging in. ErrConflict is returned if
+// the user is the last active admin, since nobody could manage the other users anymore.
+func (r *UserRepositoryMySQL) Deactivate(id uint) error {
+	tx := r.db.Begin()
+
+	// Admins are locked before the user, for the same reasons as when changing roles
+	var admins []model.User
+	err := tx.Set("gorm:query_option", "FOR UPDATE").Select("id").Where("role = ? AND status = ?", model.RoleAdmin, model.StatusActive).Find(&admins).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not get admins from db")
+	}
+
+	var user model.User
+	err = tx.Set("gorm:query_option", "FOR UPDATE").First(&user, id).Error
+	if err == gorm.ErrRecordNotFound {
+		tx.Rollback()
+		return errortype.ErrNotFound
+	}
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not get user from db")
+	}
+
+	if user.Role == model.RoleAdmin && user.Status == model.StatusActive && len(admins) <= 1 {
+		tx.Rollback()
+		return errors.Wrap(errortype.ErrConflict, "the last admin can't be deactivated")
+	}
+
+	err = tx.Model(&user).Update("status", model.StatusDeactivated).Error
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "could not update user status in DB")
+	}
+
+	return tx.Commit().Error
+}
+
+// Approve activates the user with the given ID, if they are pending approval
+func (r *UserRepositoryMySQL) Approve(id uint) error {
+	result := r.db.Model(&model.User{}).Where("id = ? AND status = ?", id, model.StatusPending).Update("status", model.StatusActive)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user status in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// UpdateEmail changes the email address of the user with the given ID. The new email address
+// is not verified.
+func (r *UserRepositoryMySQL) UpdateEmail(id uint, email string) error {
+	result := r.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":          email,
+		"email_verified": false,
+	})
+	if mysqlError, ok := result.Error.(*mysql.MySQLError); ok && mysqlError.Number == 1062 {
+		return errortype.ErrDuplicateEntry
+	}
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user email in DB")
+	}
+	return nil
+}
+
+// VerifyEmail marks the email address of the user with the given ID as verified, unless
+// it was changed since. ErrNotFound is returned if it was.
+func (r *UserRepositoryMySQL) VerifyEmail(id uint, email string) error {
+	result := r.db.Model(&model.User{}).Where("id = ? AND email = ?", id, email).Update("email_verified", true)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// List returns the users with the given status, or all of them if status is empty, ordered by id,
+// along with how many users there are in total
+func (r *UserRepositoryMySQL) List(status model.UserStatus, offset, limit uint) ([]*model.User, uint, error) {
+	query := r.db.Model(&model.User{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total uint
+	err := query.Count(&total).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not count users in DB")
+	}
+
+	var users []*model.User
+	err = query.Order("id").Offset(offset).Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "could not get users from DB")
+	}
+
+	return users, total, nil
+}
+
+// UpdatePassword replaces the password hash of the user with the given ID
+func (r *UserRepositoryMySQL) UpdatePassword(id uint, hash string) error {
+	result := r.db.Model(&model.User{}).Where("id = ?", id).Update("password", hash)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user password in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// ResetPassword replaces the password hash of the user with the given ID, and saves when it was reset
+func (r *UserRepositoryMySQL) ResetPassword(id uint, hash string, resetAt time.Time) error {
+	result := r.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"password":          hash,
+		"password_reset_at": resetAt,
+	})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not reset user password in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// SetMFASecret starts the enrollment of the user with the given ID in two-factor authentication,
+// with the given TOTP secret. It is refused with ErrConflict if two-factor authentication is already enabled.
+func (r *UserRepositoryMySQL) SetMFASecret(id uint, secret string) error {
+	result := r.db.Model(&model.User{}).Where("id = ? AND mfa_enabled = ?", id, false).Update("mfa_secret", secret)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user MFA secret in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
+// EnableMFA enables the two-factor authentication of the user with the given ID, with the time step
+// of the code they used to confirm it
+func (r *UserRepositoryMySQL) EnableMFA(id uint, step int64) error {
+	result := r.db.Model(&model.User{}).Where("id = ? AND mfa_secret != ''", id).Updates(map[string]interface{}{
+		"mfa_enabled":   true,
+		"mfa_last_step": step,
+	})
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not enable user MFA in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrNotFound
+	}
+	return nil
+}
+
+// DisableMFA disables the two-factor authentication of the user with the given ID, and forgets their secret
+func (r *UserRepositoryMySQL) DisableMFA(id uint) error {
+	err := r.db.Model(&model.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"mfa_secret":    "",
+		"mfa_enabled":   false,
+		"mfa_last_step": 0,
+	}).Error
+	return errors.Wrap(err, "could not disable user MFA in DB")
+}
+
+// UseMFAStep saves the time step of a TOTP code that the user with the given ID used. Since codes can only
+// be used once, ErrConflict is returned if a code of the same or a later step was used in the meantime.
+func (r *UserRepositoryMySQL) UseMFAStep(id uint, step int64) error {
+	result := r.db.Model(&model.User{}).Where("id = ? AND mfa_last_step < ?", id, step).Update("mfa_last_step", step)
+	if result.Error != nil {
+		return errors.Wrap(result.Error, "could not update user MFA step in DB")
+	}
+	if result.RowsAffected == 0 {
+		return errortype.ErrConflict
+	}
+	return nil
+}
+
 // Store saves a new user in the database.
 func (r *UserRepositoryMySQL) Store(user *model.User) (*model.User, error) {
 	err := r.db.Create(user).Error
@@ -1,6 +1,10 @@
 package service
 
 import (
+	"encoding/json"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/model"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -12,64 +16,274 @@ type UserRepository interface {
 	Retrieve(user *model.User) (*model.User, error)
 }
 
-// Access is a service that verifies access tokens
-type Access struct {
-	users UserRepository
+// UserFinder represents a user repository to get users from their database ids
+type UserFinder interface {
+	FindByID(id uint) (*model.User, error)
+}
 
-	trustedSource string
-	jws           string
+// RevocationChecker represents a service that knows which access tokens were revoked
+type RevocationChecker interface {
+	IsRevoked(jti string) (bool, error)
+}
 
-	log *zerolog.Logger
+// SessionChecker represents a repository in which the sessions of access tokens can be checked
+type SessionChecker interface {
+	Find(id string) (*model.Session, error)
+	Touch(id string, seenAt time.Time) error
 }
 
-// NewAccess creates and configures an Access service
-func NewAccess(log *zerolog.Logger, userRepository UserRepository, jws string) *Access {
-	return &Access{
-		log:   log,
-		users: userRepository,
-		jws:   jws,
-	}
+// KeyResolver represents a set of keys that can verify access tokens
+type KeyResolver interface {
+	Keyfunc(token *jwt.Token) (interface{}, error)
+	Algorithms() []string
 }
 
-// ValidateToken decodes the user info in an ID token and checks its iss, sub and exp claims
-func (a *Access) ValidateToken(IDToken string) (string, error) {
-	// Since the token's signature is already verified when getting the token information,
-	// We can skil the verifications in the parser and use it just to parse the token
+// verifiableClaims represents the claims of a token that parseClaims can verify
+type verifiableClaims interface {
+	jwt.Claims
+	VerifyExpiresAt(cmp int64, req bool) bool
+	VerifyIssuer(cmp string, req bool) bool
+	VerifyAudience(cmp string, req bool) bool
+}
+
+// parseClaims decodes the claims of a token signed with one of the keys, and checks that it was issued
+// by the issuer for the audience, and that it has an exp claim and is neither expired nor used before it
+// was issued. All of the tokens that Bloggo signs are verified with it.
+func parseClaims(keys KeyResolver, token, issuer, audience string, claims verifiableClaims) error {
+	// Only the algorithms of the key set are accepted, so that a token can't pick
+	// how it is verified. The claims are validated below.
 	p := &jwt.Parser{
+		ValidMethods:         keys.Algorithms(),
 		SkipClaimsValidation: true,
 	}
-	token, err := p.Parse(IDToken, func(*jwt.Token) (interface{}, error) {
-		return []byte(a.jws), nil
-	})
+	_, err := p.ParseWithClaims(token, claims, keys.Keyfunc)
 	if err != nil {
-		return "", errors.Wrap(err, "invalid token")
+		return err
 	}
 
-	// should not be able to fail if call to p.Parse didn't fail
-	claims := token.Claims.(jwt.MapClaims)
-
-	// Verifies if token is expired or not yet valid (exp claim)
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, false) {
+		return errors.New("token has expired")
+	}
+	if !claims.VerifyExpiresAt(now, true) {
+		return errors.New("missing 'exp' claim")
+	}
+	// Checks the iat and nbf claims
 	err = claims.Valid()
 	if err != nil {
-		return "", errors.Wrap(err, "invalid claims")
+		return err
+	}
+
+	if !claims.VerifyIssuer(issuer, true) {
+		return errors.New("invalid 'iss' claim")
+	}
+	if !claims.VerifyAudience(audience, true) {
+		return errors.New("invalid 'aud' claim")
+	}
+
+	return nil
+}
+
+// Access is a service that verifies access tokens
+type Access struct {
+	users       UserRepository
+	revocations RevocationChecker
+	sessions    SessionChecker
+	keys        KeyResolver
+
+	issuer   string
+	audience string
+
+	log *zerolog.Logger
+}
+
+// NewAccess creates and configures an Access service, which only accepts tokens
+// issued by the given issuer for the given audience
+func NewAccess(log *zerolog.Logger, userRepository UserRepository, revocations RevocationChecker, sessions SessionChecker, keys KeyResolver, issuer, audience string) *Access {
+	return &Access{
+		log:         log,
+		users:       userRepository,
+		revocations: revocations,
+		sessions:    sessions,
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience,
+	}
+}
+
+// ValidateToken decodes the user info in an ID token, checks its signature along with its iss,
+// aud, sub and exp claims and returns the user that owns it along with the scopes that the token grants.
+func (a *Access) ValidateToken(IDToken string) (*model.Principal, error) {
+	claims := jwt.MapClaims{}
+	err := parseClaims(a.keys, IDToken, a.issuer, a.audience, claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid token")
 	}
 
 	// Verifies the sub claim
 	userID, err := a.verifySubject(claims)
 	if err != nil {
-		return "", errors.Wrap(err, "invalid 'sub' claim")
+		return nil, errors.Wrap(err, "invalid 'sub' claim")
+	}
+
+	// Tokens issued before they could be revoked have no jti
+	tokenID, _ := claims["jti"].(string)
+	if tokenID != "" {
+		revoked, err := a.revocations.IsRevoked(tokenID)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not check token revocation")
+		}
+		if revoked {
+			return nil, errors.New("token has been revoked")
+		}
+	}
+
+	// Tokens issued before sessions existed have no sid
+	sessionID, _ := claims["sid"].(string)
+	if sessionID != "" {
+		err = a.checkSession(sessionID, userID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	user, err := a.users.Retrieve(&model.User{TokenUserID: userID})
 	if err != nil {
-		return userID, err
+		return nil, err
+	}
+
+	// Users who were deactivated can't use the tokens they already have
+	err = checkStatus(user)
+	if err != nil {
+		return nil, err
 	}
-	if !user.IsAdmin {
-		a.log.Debug().Msg("unauthorized user")
-		return userID, errors.New("user does not have write access")
+
+	// Resetting a password logs out whoever knew the previous one by revoking their sessions, which
+	// was checked above. Tokens without a session must have been issued after the reset, and since
+	// iat is in whole seconds, the ones issued in the same second as the reset are refused too.
+	if user.PasswordResetAt != nil && sessionID == "" {
+		issuedAt, _ := claims["iat"].(float64)
+		if int64(issuedAt) <= user.PasswordResetAt.Unix() {
+			return nil, errors.New("token was issued before the password was reset")
+		}
 	}
-	a.log.Debug().Msg("authorized user")
-	return userID, nil
+
+	// Impersonation tokens have an act claim
+	actorID, err := a.verifyActor(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid 'act' claim")
+	}
+
+	scopes := a.grantedScopes(claims, user.Role)
+
+	a.log.Debug().Str("role", string(user.Role)).Str("scope", model.FormatScopes(scopes)).Str("actor_id", actorID).Msg("authenticated user")
+	return &model.Principal{
+		User:      user,
+		Scopes:    scopes,
+		TokenID:   tokenID,
+		SessionID: sessionID,
+		ExpiresAt: expiration(claims),
+		ActorID:   actorID,
+	}, nil
+}
+
+// verifyActor returns the user ID of the admin who impersonates the subject of a token, or an empty
+// string if the token has no act claim. Admins who were demoted or deactivated since they started
+// impersonating a user can't use their impersonation tokens anymore.
+func (a *Access) verifyActor(claims jwt.MapClaims) (string, error) {
+	unconvertedActor, ok := claims["act"]
+	if !ok {
+		return "", nil
+	}
+
+	actor, ok := unconvertedActor.(map[string]interface{})
+	if !ok {
+		return "", errors.New("invalid format")
+	}
+
+	actorID, ok := actor["sub"].(string)
+	if !ok || actorID == "" {
+		return "", errors.New("missing actor subject")
+	}
+
+	user, err := a.users.Retrieve(&model.User{TokenUserID: actorID})
+	if err != nil {
+		return "", errors.Wrap(err, "could not retrieve actor")
+	}
+	if user.Role != model.RoleAdmin {
+		return "", errors.New("actor is not an admin")
+	}
+	err = checkStatus(user)
+	if err != nil {
+		return "", err
+	}
+
+	return actorID, nil
+}
+
+// checkSession verifies that the session of a token belongs to its user and was not revoked,
+// and records that it was seen
+func (a *Access) checkSession(id, userID string) error {
+	session, err := a.sessions.Find(id)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.New("unknown session")
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not check session")
+	}
+
+	if session.UserID != userID {
+		return errors.New("session belongs to another user")
+	}
+	if session.RevokedAt != nil {
+		return errors.New("session has been revoked")
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) > touchInterval {
+		err = a.sessions.Touch(id, now)
+		if err != nil {
+			// The session can still be used
+			a.log.Warn().Err(err).Str("session_id", id).Msg("could not record session activity")
+		}
+	}
+
+	return nil
+}
+
+// grantedScopes returns the scopes of a token that are still allowed for the role of its user,
+// since the user might have been demoted after the token was issued. Tokens that were issued
+// without scopes are granted all of the scopes of the role.
+func (a *Access) grantedScopes(claims jwt.MapClaims, role model.Role) []model.Scope {
+	scope, ok := claims["scope"].(string)
+	if !ok {
+		return role.Scopes()
+	}
+
+	return restrictScopes(model.ParseScopes(scope), role)
+}
+
+// restrictScopes returns the scopes that can be granted to the given role
+func restrictScopes(scopes []model.Scope, role model.Role) []model.Scope {
+	var granted []model.Scope
+	for _, scope := range scopes {
+		if model.HasScope(role.Scopes(), scope) {
+			granted = append(granted, scope)
+		}
+	}
+	return granted
+}
+
+// expiration returns the expiration date of a token from its exp claim
+func expiration(claims jwt.MapClaims) time.Time {
+	switch exp := claims["exp"].(type) {
+	case float64:
+		return time.Unix(int64(exp), 0)
+	case json.Number:
+		v, _ := exp.Int64()
+		return time.Unix(v, 0)
+	}
+	return time.Time{}
 }
 
 // Verifies that the subject of the token (the user id of who owns it) exists
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// AuditRepository represents a repository in which the actions of admins on behalf of other users are stored
+type AuditRepository interface {
+	Store(event *model.AuditEvent) error
+	List(userID string, offset, limit uint) ([]*model.AuditEvent, uint, error)
+}
+
+// Audit is a service that keeps the audit trail of what admins do on behalf of other users
+type Audit struct {
+	events AuditRepository
+	users  UserFinder
+
+	log *zerolog.Logger
+}
+
+// NewAudit creates and configures an Audit service
+func NewAudit(log *zerolog.Logger, events AuditRepository, users UserFinder) *Audit {
+	return &Audit{
+		events: events,
+		users:  users,
+
+		log: log,
+	}
+}
+
+// Record adds an event to the audit trail. Unlike login events, callers must not go
+// on with what they were doing if it can't be recorded.
+func (a *Audit) Record(event *model.AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	event.UserAgent = truncate(event.UserAgent, maxUserAgentLength)
+
+	err := a.events.Store(event)
+	if err != nil {
+		return errors.Wrap(err, "could not record audit event")
+	}
+
+	a.log.Info().
+		Str("actor_id", event.ActorID).
+		Str("subject_id", event.SubjectID).
+		Str("action", string(event.Action)).
+		Str("detail", event.Detail).
+		Msg("audit event recorded")
+	return nil
+}
+
+// List returns a page of the audit trail, newest first, along with its total count
+func (a *Audit) List(offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	return a.events.List("", offset, limit)
+}
+
+// UserList returns a page of the audit events of which a user is the actor or subject, from
+// their id, newest first, along with their total count
+func (a *Audit) UserList(id uint, offset, limit uint) ([]*model.AuditEvent, uint, error) {
+	user, err := a.users.FindByID(id)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "could not retrieve user id %d", id)
+	}
+
+	return a.events.List(user.TokenUserID, offset, limit)
+}
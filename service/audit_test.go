@@ -0,0 +1,182 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewAudit(t *testing.T) {
+	auditEventRepositoryMock := &repo.AuditEventRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	a := NewAudit(log, auditEventRepositoryMock, userRepositoryMock)
+
+	assert.Equal(t, auditEventRepositoryMock, a.events, "unexpected audit event repo set")
+	assert.Equal(t, userRepositoryMock, a.users, "unexpected user repo set")
+	assert.Equal(t, log, a.log, "unexpected logger set")
+}
+
+func TestRecordAuditEvent(t *testing.T) {
+	tests := []struct {
+		description string
+
+		storeErr error
+
+		expectedError error
+	}{
+		{
+			description: "event recorded",
+		},
+		{
+			description: "repository error",
+
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not record audit event: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var stored *model.AuditEvent
+			auditEventRepositoryMock := &repo.AuditEventRepositoryMock{}
+			auditEventRepositoryMock.
+				On("Store", mock.AnythingOfType("*model.AuditEvent")).
+				Run(func(args mock.Arguments) { stored = args.Get(0).(*model.AuditEvent) }).
+				Return(test.storeErr).
+				Once()
+
+			a := &Audit{
+				events: auditEventRepositoryMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err := a.Record(&model.AuditEvent{
+				ActorID:   "bloggo|admin",
+				SubjectID: "bloggo|author",
+				Action:    model.AuditImpersonatedRequest,
+				Detail:    "GET /api/users/me",
+				UserAgent: strings.Repeat("a", 600),
+			})
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			// Events are dated and their user agent truncated like the ones of sessions
+			assert.WithinDuration(t, time.Now(), stored.CreatedAt, time.Second, "wrong event date")
+			assert.Len(t, stored.UserAgent, maxUserAgentLength, "user agent should be truncated")
+
+			auditEventRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	events := []*model.AuditEvent{{ID: 2, Action: model.AuditImpersonatedRequest}, {ID: 1, Action: model.AuditImpersonationStarted}}
+
+	auditEventRepositoryMock := &repo.AuditEventRepositoryMock{}
+	auditEventRepositoryMock.On("List", "", uint(20), uint(10)).Return(events, uint(22), nil).Once()
+
+	a := &Audit{
+		events: auditEventRepositoryMock,
+
+		log: logger.NewZeroLog(&bytes.Buffer{}),
+	}
+
+	listed, total, err := a.List(20, 10)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, events, listed, "wrong events listed")
+	assert.Equal(t, uint(22), total, "wrong total count")
+
+	auditEventRepositoryMock.AssertExpectations(t)
+}
+
+func TestListUserAuditEvents(t *testing.T) {
+	events := []*model.AuditEvent{{ID: 1, Action: model.AuditImpersonationStarted}}
+
+	tests := []struct {
+		description string
+
+		id      uint
+		user    *model.User
+		userErr error
+
+		expectList    bool
+		expectedError error
+	}{
+		{
+			description: "events listed",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "test"},
+
+			expectList: true,
+		},
+		{
+			description: "unknown user",
+
+			id:      42,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 42: resource not found"),
+		},
+		{
+			description: "user id 0",
+
+			id:      0,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 0: resource not found"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.On("FindByID", test.id).Return(test.user, test.userErr).Once()
+
+			auditEventRepositoryMock := &repo.AuditEventRepositoryMock{}
+			if test.expectList {
+				auditEventRepositoryMock.On("List", "test", uint(0), uint(20)).Return(events, uint(1), nil).Once()
+			}
+
+			a := &Audit{
+				events: auditEventRepositoryMock,
+				users:  userRepositoryMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			listed, total, err := a.UserList(test.id, 0, 20)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Equal(t, errortype.ErrNotFound, errors.Cause(err), "the cause should be kept")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, events, listed, "wrong events listed")
+				assert.Equal(t, uint(1), total, "wrong total count")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			auditEventRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// emailVerificationAudience is appended to the audience of access tokens to make the audience of
+// email verification tokens, so that they can't be used as access tokens and vice versa
+const emailVerificationAudience = "/email-verification"
+
+// EmailVerificationClaims are the claims of the tokens generated by the EmailVerifications service
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// EmailUserRepository represents a user repository in which email addresses can be marked as verified
+type EmailUserRepository interface {
+	UserRepository
+	VerifyEmail(id uint, email string) error
+}
+
+// EmailVerifications is a service that sends users a signed link with which they prove that they
+// own their email address. Links are only valid for the email address they were sent to.
+type EmailVerifications struct {
+	issuer    string
+	audience  string
+	ttl       time.Duration
+	verifyURL string
+
+	users  EmailUserRepository
+	signer Signer
+	keys   KeyResolver
+	mailer Mailer
+
+	log *zerolog.Logger
+}
+
+// NewEmailVerifications creates and configures an EmailVerifications service. Verification tokens are
+// issued by the issuer for the audience of access tokens, expire after ttl, and are sent in the token
+// query parameter of verifyURL.
+func NewEmailVerifications(log *zerolog.Logger, users EmailUserRepository, signer Signer, keys KeyResolver, mailer Mailer, issuer, audience, verifyURL string, ttl time.Duration) *EmailVerifications {
+	return &EmailVerifications{
+		log:       log,
+		users:     users,
+		signer:    signer,
+		keys:      keys,
+		mailer:    mailer,
+		issuer:    issuer,
+		audience:  audience + emailVerificationAudience,
+		verifyURL: verifyURL,
+		ttl:       ttl,
+	}
+}
+
+// Send sends a verification link to the email address of the user
+func (e *EmailVerifications) Send(user *model.User) error {
+	now := time.Now()
+
+	token, err := e.signer.Sign(&EmailVerificationClaims{
+		Email: user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   user.TokenUserID,
+			Issuer:    e.issuer,
+			Audience:  e.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(e.ttl).Unix(),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not sign verification token")
+	}
+
+	link, err := url.Parse(e.verifyURL)
+	if err != nil {
+		return errors.Wrap(err, "invalid email verification URL")
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	expiresAt := now.Add(e.ttl).UTC().Format("Mon, 2 Jan 2006 15:04 MST")
+	body := fmt.Sprintf("Hello,\n\nFollow this link before %s to verify your email address:\n\n%s\n\nIf you didn't create an account, you can ignore this email.\n", expiresAt, link)
+
+	err = e.mailer.Send(user.Email, "Verify your email address", body)
+	if err != nil {
+		return errors.Wrap(err, "could not send verification email")
+	}
+
+	e.log.Info().Str("user_id", user.TokenUserID).Msg("verification email sent")
+	return nil
+}
+
+// Verify marks the email address to which the verification token was sent as verified, if it
+// still belongs to the same user
+func (e *EmailVerifications) Verify(token string) error {
+	claims, err := e.verify(token)
+	if err != nil {
+		return errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	user, err := e.users.Retrieve(&model.User{TokenUserID: claims.Subject})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.Wrap(errortype.ErrInvalidToken, "user not found")
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve user")
+	}
+
+	if !strings.EqualFold(user.Email, claims.Email) {
+		return errors.Wrap(errortype.ErrInvalidToken, "email address was changed since the link was sent")
+	}
+	if user.EmailVerified {
+		return nil
+	}
+
+	err = e.users.VerifyEmail(user.ID, user.Email)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.Wrap(errortype.ErrInvalidToken, "email address was changed since the link was sent")
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not verify email address")
+	}
+
+	e.log.Info().Str("user_id", user.TokenUserID).Msg("email address verified")
+	return nil
+}
+
+// verify checks the signature, issuer, audience and expiration of a verification token, and returns its claims
+func (e *EmailVerifications) verify(token string) (*EmailVerificationClaims, error) {
+	var claims EmailVerificationClaims
+
+	err := parseClaims(e.keys, token, e.issuer, e.audience, &claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid verification token")
+	}
+	if claims.Subject == "" || claims.Email == "" {
+		return nil, errors.New("missing 'sub' or 'email' claim")
+	}
+
+	return &claims, nil
+}
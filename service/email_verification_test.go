@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewEmailVerifications(t *testing.T) {
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	signerMock := &SignerMock{}
+	keysMock := &KeyResolverMock{}
+	mailerMock := &MailerMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	e := NewEmailVerifications(log, userRepositoryMock, signerMock, keysMock, mailerMock, "https://bloggo.example.com/", "bloggo", "https://bloggo.example.com/api/verify-email", 48*time.Hour)
+
+	assert.Equal(t, userRepositoryMock, e.users, "unexpected user repo set")
+	assert.Equal(t, signerMock, e.signer, "unexpected signer set")
+	assert.Equal(t, keysMock, e.keys, "unexpected key resolver set")
+	assert.Equal(t, mailerMock, e.mailer, "unexpected mailer set")
+	assert.Equal(t, "https://bloggo.example.com/", e.issuer, "unexpected issuer set")
+	assert.Equal(t, "bloggo/email-verification", e.audience, "verification tokens should have their own audience")
+	assert.Equal(t, "https://bloggo.example.com/api/verify-email", e.verifyURL, "unexpected verification URL set")
+	assert.Equal(t, 48*time.Hour, e.ttl, "unexpected TTL set")
+	assert.Equal(t, log, e.log, "unexpected logger set")
+}
+
+func TestSendEmailVerification(t *testing.T) {
+	tests := []struct {
+		description string
+
+		signErr error
+		sendErr error
+
+		expectSend    bool
+		expectedError error
+	}{
+		{
+			description: "verification link sent",
+
+			expectSend: true,
+		},
+		{
+			description: "signing error",
+
+			signErr: errors.New("no signing key"),
+
+			expectedError: errors.New("could not sign verification token: no signing key"),
+		},
+		{
+			description: "mailer error",
+
+			sendErr: errors.New("connection refused"),
+
+			expectSend:    true,
+			expectedError: errors.New("could not send verification email: connection refused"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var claims *EmailVerificationClaims
+			signerMock := &SignerMock{}
+			signerMock.
+				On("Sign", mock.AnythingOfType("*service.EmailVerificationClaims")).
+				Run(func(args mock.Arguments) { claims = args.Get(0).(*EmailVerificationClaims) }).
+				Return("x.y.z", test.signErr).
+				Once()
+
+			var body string
+			mailerMock := &MailerMock{}
+			if test.expectSend {
+				mailerMock.
+					On("Send", "pam@dunder-mifflin.com", "Verify your email address", mock.AnythingOfType("string")).
+					Run(func(args mock.Arguments) { body = args.String(2) }).
+					Return(test.sendErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			e := NewEmailVerifications(log, &repo.UserRepositoryMock{}, signerMock, &KeyResolverMock{}, mailerMock, "https://bloggo.example.com/", "bloggo", "https://bloggo.example.com/api/verify-email", 48*time.Hour)
+
+			err := e.Send(&model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			assert.Equal(t, "test", claims.Subject, "token should belong to the user")
+			assert.Equal(t, "pam@dunder-mifflin.com", claims.Email, "token should be valid for the email address it is sent to")
+			assert.Equal(t, "bloggo/email-verification", claims.Audience, "wrong audience")
+			assert.InDelta(t, time.Now().Add(48*time.Hour).Unix(), claims.ExpiresAt, 60, "wrong expiration date")
+
+			if test.expectSend {
+				link := regexp.MustCompile(`https://\S+`).FindString(body)
+				assert.Equal(t, "https://bloggo.example.com/api/verify-email?token="+url.QueryEscape("x.y.z"), link, "the email should contain the verification link")
+			}
+
+			signerMock.AssertExpectations(t)
+			mailerMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	validClaims := func() *EmailVerificationClaims {
+		return &EmailVerificationClaims{
+			Email: "pam@dunder-mifflin.com",
+			StandardClaims: jwt.StandardClaims{
+				Subject:   "test",
+				Issuer:    "https://bloggo.example.com/",
+				Audience:  "bloggo/email-verification",
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	tests := []struct {
+		description string
+
+		claims      func() *EmailVerificationClaims
+		user        *model.User
+		retrieveErr error
+		verifyErr   error
+
+		expectRetrieve bool
+		expectVerify   bool
+		expectedError  error
+	}{
+		{
+			description: "email address verified",
+
+			claims: validClaims,
+			user:   &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+
+			expectRetrieve: true,
+			expectVerify:   true,
+		},
+		{
+			description: "email address already verified",
+
+			claims: validClaims,
+			user:   &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", EmailVerified: true},
+
+			expectRetrieve: true,
+		},
+		{
+			description: "expired token",
+
+			claims: func() *EmailVerificationClaims {
+				claims := validClaims()
+				claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+				return claims
+			},
+
+			expectedError: errors.New("invalid verification token: token has expired: invalid token"),
+		},
+		{
+			description: "access token used as a verification token",
+
+			claims: func() *EmailVerificationClaims {
+				claims := validClaims()
+				claims.Audience = "bloggo"
+				return claims
+			},
+
+			expectedError: errors.New("invalid verification token: invalid 'aud' claim: invalid token"),
+		},
+		{
+			description: "token of another issuer",
+
+			claims: func() *EmailVerificationClaims {
+				claims := validClaims()
+				claims.Issuer = "https://evil.example.com/"
+				return claims
+			},
+
+			expectedError: errors.New("invalid verification token: invalid 'iss' claim: invalid token"),
+		},
+		{
+			description: "missing email claim",
+
+			claims: func() *EmailVerificationClaims {
+				claims := validClaims()
+				claims.Email = ""
+				return claims
+			},
+
+			expectedError: errors.New("missing 'sub' or 'email' claim: invalid token"),
+		},
+		{
+			description: "email address changed since the link was sent",
+
+			claims: validClaims,
+			user:   &model.User{ID: 42, TokenUserID: "test", Email: "pam@athlead.com"},
+
+			expectRetrieve: true,
+			expectedError:  errors.New("email address was changed since the link was sent: invalid token"),
+		},
+		{
+			description: "email address changed concurrently",
+
+			claims:    validClaims,
+			user:      &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			verifyErr: errortype.ErrNotFound,
+
+			expectRetrieve: true,
+			expectVerify:   true,
+			expectedError:  errors.New("email address was changed since the link was sent: invalid token"),
+		},
+		{
+			description: "user was deleted",
+
+			claims:      validClaims,
+			retrieveErr: errortype.ErrNotFound,
+
+			expectRetrieve: true,
+			expectedError:  errors.New("user not found: invalid token"),
+		},
+		{
+			description: "repository error",
+
+			claims:    validClaims,
+			user:      &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			verifyErr: errors.New("database exploded"),
+
+			expectRetrieve: true,
+			expectVerify:   true,
+			expectedError:  errors.New("could not verify email address: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.retrieveErr).
+					Once()
+			}
+			if test.expectVerify {
+				userRepositoryMock.
+					On("VerifyEmail", uint(42), "pam@dunder-mifflin.com").
+					Return(test.verifyErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			e := NewEmailVerifications(log, userRepositoryMock, &SignerMock{}, newKeyResolverMock(), &MailerMock{}, "https://bloggo.example.com/", "bloggo", "https://bloggo.example.com/api/verify-email", time.Hour)
+
+			err := e.Verify(signTestToken(t, test.claims()))
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
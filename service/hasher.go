@@ -1,6 +1,14 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -20,6 +28,22 @@ type HasherComparer interface {
 	HashComparer
 }
 
+// PasswordHasher describes something that can hash passwords, compare them with clear passwords
+// and tell whether hashes were made with outdated settings and should be upgraded
+type PasswordHasher interface {
+	HasherComparer
+	NeedsRehash(hash string) bool
+}
+
+// algorithmHasher is a PasswordHasher for a single algorithm, which recognizes its hashes
+type algorithmHasher interface {
+	PasswordHasher
+	Supports(hash string) bool
+}
+
+// ErrUnsupportedHash is returned when a hash was not made by any of the supported algorithms
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
 // BcryptHasher implements the Hasher and HashComparer interfaces and uses Provos and
 // Mazières's bcrypt adaptive hashing algorithm
 type BcryptHasher struct {
@@ -27,7 +51,7 @@ type BcryptHasher struct {
 }
 
 // NewBcryptHasher instanciates a BcryptHasher and sets its number of runs
-func NewBcryptHasher(runs int) HasherComparer {
+func NewBcryptHasher(runs int) *BcryptHasher {
 	return &BcryptHasher{
 		runs: runs,
 	}
@@ -43,3 +67,164 @@ func (bh *BcryptHasher) Hash(password string) (string, error) {
 func (bh *BcryptHasher) Compare(hash, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
+
+// NeedsRehash returns true if a hash was made with another number of runs
+func (bh *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != bh.runs
+}
+
+// Supports returns true if a hash was made by bcrypt
+func (bh *BcryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2Hasher implements the PasswordHasher interface and uses the Argon2id memory-hard hashing
+// algorithm. Hashes are encoded in the PHC string format, which contains the parameters that made them.
+type Argon2Hasher struct {
+	// memory is the amount of memory used to hash a password, in KiB
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// NewArgon2Hasher instanciates an Argon2Hasher that uses the given amount of memory in KiB,
+// number of passes over the memory and number of threads
+func NewArgon2Hasher(memory, time uint32, threads uint8) *Argon2Hasher {
+	return &Argon2Hasher{
+		memory:  memory,
+		time:    time,
+		threads: threads,
+	}
+}
+
+// argon2Hash is a decoded Argon2id hash
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Hash hashes a password
+func (ah *Argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate salt")
+	}
+
+	key := argon2.IDKey([]byte(password), salt, ah.time, ah.memory, ah.threads, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, ah.memory, ah.time, ah.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Compare compares a hashed password to a clear password, using the parameters of the hash
+func (ah *Argon2Hasher) Compare(hash, password string) error {
+	decoded, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+
+	key := argon2.IDKey([]byte(password), decoded.salt, decoded.time, decoded.memory, decoded.threads, uint32(len(decoded.key)))
+	if subtle.ConstantTimeCompare(key, decoded.key) != 1 {
+		return errors.New("password does not match hash")
+	}
+	return nil
+}
+
+// NeedsRehash returns true if a hash was made with other parameters
+func (ah *Argon2Hasher) NeedsRehash(hash string) bool {
+	decoded, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+	return decoded.memory != ah.memory || decoded.time != ah.time || decoded.threads != ah.threads || len(decoded.key) != argon2KeyLength
+}
+
+// Supports returns true if a hash was made by Argon2id
+func (ah *Argon2Hasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+// decodeArgon2Hash decodes a hash in the PHC string format
+func decodeArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, ErrUnsupportedHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, errors.Wrapf(ErrUnsupportedHash, "argon2 version %q", parts[2])
+	}
+
+	var decoded argon2Hash
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &decoded.memory, &decoded.time, &decoded.threads)
+	if err != nil || decoded.time == 0 || decoded.threads == 0 {
+		return nil, errors.Wrapf(ErrUnsupportedHash, "argon2 parameters %q", parts[3])
+	}
+
+	decoded.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, errors.Wrap(ErrUnsupportedHash, "invalid argon2 salt")
+	}
+	decoded.key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(decoded.key) == 0 {
+		return nil, errors.Wrap(ErrUnsupportedHash, "invalid argon2 key")
+	}
+
+	return &decoded, nil
+}
+
+// MultiHasher hashes passwords with its current algorithm, and compares them with hashes made by any
+// of the supported algorithms, which is detected from the format of the hash. Hashes made by another
+// algorithm than the current one, or with outdated parameters, need to be rehashed.
+type MultiHasher struct {
+	current   algorithmHasher
+	supported []algorithmHasher
+}
+
+// NewMultiHasher instanciates a MultiHasher that hashes passwords with the Argon2Hasher if useArgon2
+// is set, or with the BcryptHasher otherwise, and compares them with both
+func NewMultiHasher(bcryptHasher *BcryptHasher, argon2Hasher *Argon2Hasher, useArgon2 bool) *MultiHasher {
+	mh := &MultiHasher{
+		current:   bcryptHasher,
+		supported: []algorithmHasher{bcryptHasher, argon2Hasher},
+	}
+	if useArgon2 {
+		mh.current = argon2Hasher
+	}
+	return mh
+}
+
+// Hash hashes a password with the current algorithm
+func (mh *MultiHasher) Hash(password string) (string, error) {
+	return mh.current.Hash(password)
+}
+
+// Compare compares a hashed password to a clear password, with the algorithm that made the hash
+func (mh *MultiHasher) Compare(hash, password string) error {
+	for _, hasher := range mh.supported {
+		if hasher.Supports(hash) {
+			return hasher.Compare(hash, password)
+		}
+	}
+	return ErrUnsupportedHash
+}
+
+// NeedsRehash returns true if a hash was not made by the current algorithm, or with outdated parameters
+func (mh *MultiHasher) NeedsRehash(hash string) bool {
+	return !mh.current.Supports(hash) || mh.current.NeedsRehash(hash)
+}
@@ -77,3 +77,249 @@ func TestCompare(t *testing.T) {
 		})
 	}
 }
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	tests := []struct {
+		description string
+
+		hash string
+
+		expectedRehash bool
+	}{
+		{
+			description: "same number of runs",
+
+			hash: "$2a$11$tP88VYt33B1vvCnzdm9UO.x/gVTxcB8mUtWma0/ba9YbZ6s51.r2y",
+		},
+		{
+			description: "other number of runs",
+
+			hash: "$2a$10$tP88VYt33B1vvCnzdm9UO.x/gVTxcB8mUtWma0/ba9YbZ6s51.r2y",
+
+			expectedRehash: true,
+		},
+		{
+			description: "not a bcrypt hash",
+
+			hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+
+			expectedRehash: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			hasher := NewBcryptHasher(11)
+
+			assert.Equal(t, test.expectedRehash, hasher.NeedsRehash(test.hash), "unexpected rehash decision")
+		})
+	}
+}
+
+func TestArgon2Hasher(t *testing.T) {
+	hasher := NewArgon2Hasher(64, 1, 1)
+
+	hash, err := hasher.Hash("refrigerator2000")
+	if !assert.NoError(t, err, "unexpected error") {
+		return
+	}
+
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, hash, "unexpected hash format")
+	assert.True(t, hasher.Supports(hash), "hash should be supported")
+
+	other, err := hasher.Hash("refrigerator2000")
+	if !assert.NoError(t, err, "unexpected error") {
+		return
+	}
+	assert.NotEqual(t, hash, other, "hashes should be salted")
+
+	assert.NoError(t, hasher.Compare(hash, "refrigerator2000"), "password should match")
+	assert.Error(t, hasher.Compare(hash, "refrigerator2001"), "wrong password should not match")
+	assert.Error(t, hasher.Compare("$2a$11$tP88VYt33B1vvCnzdm9UO.x/gVTxcB8mUtWma0/ba9YbZ6s51.r2y", "test"), "bcrypt hashes should not be supported")
+}
+
+func TestArgon2Compare(t *testing.T) {
+	tests := []struct {
+		description string
+
+		hash string
+
+		expectedErr bool
+	}{
+		{
+			description: "hash made with other parameters",
+
+			hash: argon2TestHash(t, 32, 2, 1, "test"),
+		},
+		{
+			description: "unsupported version",
+
+			hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+
+			expectedErr: true,
+		},
+		{
+			description: "argon2i hash",
+
+			hash: "$argon2i$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+
+			expectedErr: true,
+		},
+		{
+			description: "malformed parameters",
+
+			hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+
+			expectedErr: true,
+		},
+		{
+			description: "malformed salt",
+
+			hash: "$argon2id$v=19$m=64,t=1,p=1$%%%$a2V5",
+
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			hasher := NewArgon2Hasher(64, 1, 1)
+
+			err := hasher.Compare(test.hash, "test")
+			if test.expectedErr {
+				assert.Error(t, err, "expected an error")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
+
+func TestArgon2NeedsRehash(t *testing.T) {
+	tests := []struct {
+		description string
+
+		hash string
+
+		expectedRehash bool
+	}{
+		{
+			description: "same parameters",
+
+			hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+		},
+		{
+			description: "less memory",
+
+			hash: "$argon2id$v=19$m=32,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+
+			expectedRehash: true,
+		},
+		{
+			description: "more passes",
+
+			hash: "$argon2id$v=19$m=64,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+
+			expectedRehash: true,
+		},
+		{
+			description: "other number of threads",
+
+			hash: "$argon2id$v=19$m=64,t=1,p=2$c2FsdHNhbHRzYWx0c2FsdA$a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U",
+
+			expectedRehash: true,
+		},
+		{
+			description: "shorter key",
+
+			hash: "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$a2V5",
+
+			expectedRehash: true,
+		},
+		{
+			description: "not an argon2 hash",
+
+			hash: "$2a$11$tP88VYt33B1vvCnzdm9UO.x/gVTxcB8mUtWma0/ba9YbZ6s51.r2y",
+
+			expectedRehash: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			hasher := NewArgon2Hasher(64, 1, 1)
+
+			assert.Equal(t, test.expectedRehash, hasher.NeedsRehash(test.hash), "unexpected rehash decision")
+		})
+	}
+}
+
+func TestMultiHasher(t *testing.T) {
+	bcryptHasher := NewBcryptHasher(4)
+	argon2Hasher := NewArgon2Hasher(64, 1, 1)
+
+	bcryptHash, err := bcryptHasher.Hash("refrigerator2000")
+	if err != nil {
+		t.Fatal("could not hash password")
+	}
+	argon2Hash, err := argon2Hasher.Hash("refrigerator2000")
+	if err != nil {
+		t.Fatal("could not hash password")
+	}
+
+	tests := []struct {
+		description string
+
+		useArgon2 bool
+
+		expectedPrefix     string
+		expectBcryptRehash bool
+		expectArgon2Rehash bool
+	}{
+		{
+			description: "hashing with argon2",
+
+			useArgon2: true,
+
+			expectedPrefix:     "$argon2id$",
+			expectBcryptRehash: true,
+		},
+		{
+			description: "hashing with bcrypt",
+
+			expectedPrefix:     "$2a$04$",
+			expectArgon2Rehash: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			hasher := NewMultiHasher(bcryptHasher, argon2Hasher, test.useArgon2)
+
+			hash, err := hasher.Hash("refrigerator2000")
+			if assert.NoError(t, err, "unexpected error") {
+				assert.Contains(t, hash, test.expectedPrefix, "hash should be made by the current algorithm")
+				assert.False(t, hasher.NeedsRehash(hash), "new hashes should not need to be rehashed")
+			}
+
+			// Hashes of both algorithms can be compared
+			assert.NoError(t, hasher.Compare(bcryptHash, "refrigerator2000"), "bcrypt hash should match")
+			assert.NoError(t, hasher.Compare(argon2Hash, "refrigerator2000"), "argon2 hash should match")
+			assert.Error(t, hasher.Compare(bcryptHash, "wrong"), "wrong password should not match bcrypt hash")
+			assert.Error(t, hasher.Compare(argon2Hash, "wrong"), "wrong password should not match argon2 hash")
+			assert.Equal(t, ErrUnsupportedHash, hasher.Compare("plaintext", "plaintext"), "unknown hash formats should be rejected")
+
+			assert.Equal(t, test.expectBcryptRehash, hasher.NeedsRehash(bcryptHash), "unexpected rehash decision for bcrypt hash")
+			assert.Equal(t, test.expectArgon2Rehash, hasher.NeedsRehash(argon2Hash), "unexpected rehash decision for argon2 hash")
+		})
+	}
+}
+
+// argon2TestHash hashes a password with the given argon2 parameters
+func argon2TestHash(t *testing.T, memory, time uint32, threads uint8, password string) string {
+	hash, err := NewArgon2Hasher(memory, time, threads).Hash(password)
+	if err != nil {
+		t.Fatal("could not hash password")
+	}
+	return hash
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// ImpersonationIssuer represents a service that signs the tokens with which admins act as other users
+type ImpersonationIssuer interface {
+	IssueImpersonation(user *model.User, actorID string, ttl time.Duration) (*model.Token, error)
+}
+
+// AuditRecorder represents a service that keeps an audit trail
+type AuditRecorder interface {
+	Record(event *model.AuditEvent) error
+}
+
+// Impersonation is a service that lets admins act as other users, to see what they see
+type Impersonation struct {
+	users  UserFinder
+	tokens ImpersonationIssuer
+	audit  AuditRecorder
+
+	// ttl is how long impersonation tokens are valid
+	ttl time.Duration
+
+	log *zerolog.Logger
+}
+
+// NewImpersonation creates and configures an Impersonation service, whose tokens are valid for ttl
+func NewImpersonation(log *zerolog.Logger, users UserFinder, tokens ImpersonationIssuer, audit AuditRecorder, ttl time.Duration) *Impersonation {
+	return &Impersonation{
+		users:  users,
+		tokens: tokens,
+		audit:  audit,
+		ttl:    ttl,
+
+		log: log,
+	}
+}
+
+// Start issues a token with which an admin acts as a user, from the id of the user. Admins can't
+// be impersonated, so that impersonation tokens are never granted the users:admin scope. No token
+// is issued if the impersonation can't be recorded in the audit trail.
+func (i *Impersonation) Start(actorID string, id uint, client *model.Client) (*model.Token, error) {
+	user, err := i.users.FindByID(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not retrieve user id %d", id)
+	}
+
+	if user.TokenUserID == actorID {
+		return nil, errors.Wrap(errortype.ErrForbidden, "admins can't impersonate themselves")
+	}
+	if user.Role == model.RoleAdmin {
+		return nil, errors.Wrap(errortype.ErrForbidden, "admins can't be impersonated")
+	}
+
+	// Users who can't log in can't be impersonated either
+	err = checkStatus(user)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := i.tokens.IssueImpersonation(user, actorID, i.ttl)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not issue impersonation token")
+	}
+
+	err = i.audit.Record(&model.AuditEvent{
+		ActorID:   actorID,
+		SubjectID: user.TokenUserID,
+		Action:    model.AuditImpersonationStarted,
+		Detail:    "expires in " + i.ttl.String(),
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type ImpersonationIssuerMock struct {
+	mock.Mock
+}
+
+func (m *ImpersonationIssuerMock) IssueImpersonation(user *model.User, actorID string, ttl time.Duration) (*model.Token, error) {
+	args := m.Called(user, actorID, ttl)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*model.Token), args.Error(1)
+}
+
+type AuditRecorderMock struct {
+	mock.Mock
+}
+
+func (m *AuditRecorderMock) Record(event *model.AuditEvent) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+func TestNewImpersonation(t *testing.T) {
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	issuerMock := &ImpersonationIssuerMock{}
+	auditMock := &AuditRecorderMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	i := NewImpersonation(log, userRepositoryMock, issuerMock, auditMock, 15*time.Minute)
+
+	assert.Equal(t, userRepositoryMock, i.users, "unexpected user repo set")
+	assert.Equal(t, issuerMock, i.tokens, "unexpected token issuer set")
+	assert.Equal(t, auditMock, i.audit, "unexpected audit recorder set")
+	assert.Equal(t, 15*time.Minute, i.ttl, "unexpected ttl set")
+	assert.Equal(t, log, i.log, "unexpected logger set")
+}
+
+func TestStartImpersonation(t *testing.T) {
+	token := &model.Token{AccessToken: "x.y.z", TokenType: "Bearer", ExpiresIn: 900, Scope: "posts:read", ImpersonatedBy: "bloggo|admin"}
+	client := &model.Client{IP: "10.0.0.1", UserAgent: "Mozilla/5.0"}
+
+	tests := []struct {
+		description string
+
+		id        uint
+		user      *model.User
+		userErr   error
+		issueErr  error
+		recordErr error
+
+		expectIssue   bool
+		expectRecord  bool
+		expectedError error
+	}{
+		{
+			description: "impersonation started",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "bloggo|reader", Role: model.RoleReader},
+
+			expectIssue:  true,
+			expectRecord: true,
+		},
+		{
+			description: "unknown user",
+
+			id:      42,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 42: resource not found"),
+		},
+		{
+			description: "user id 0",
+
+			id:      0,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 0: resource not found"),
+		},
+		{
+			description: "admin impersonates themselves",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "bloggo|admin", Role: model.RoleAdmin},
+
+			expectedError: errors.New("admins can't impersonate themselves: forbidden"),
+		},
+		{
+			description: "admin impersonates another admin",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "bloggo|other-admin", Role: model.RoleAdmin},
+
+			expectedError: errors.New("admins can't be impersonated: forbidden"),
+		},
+		{
+			description: "deactivated user",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "bloggo|reader", Role: model.RoleReader, Status: model.StatusDeactivated},
+
+			expectedError: errors.New("account is deactivated: forbidden"),
+		},
+		{
+			description: "token can't be issued",
+
+			id:       42,
+			user:     &model.User{ID: 42, TokenUserID: "bloggo|reader", Role: model.RoleReader},
+			issueErr: errors.New("key not found"),
+
+			expectIssue:   true,
+			expectedError: errors.New("could not issue impersonation token: key not found"),
+		},
+		{
+			description: "impersonation can't be recorded",
+
+			id:        42,
+			user:      &model.User{ID: 42, TokenUserID: "bloggo|reader", Role: model.RoleReader},
+			recordErr: errors.New("could not record audit event: database exploded"),
+
+			expectIssue:   true,
+			expectRecord:  true,
+			expectedError: errors.New("could not record audit event: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.On("FindByID", test.id).Return(test.user, test.userErr).Once()
+
+			issuerMock := &ImpersonationIssuerMock{}
+			if test.expectIssue {
+				issuerMock.On("IssueImpersonation", test.user, "bloggo|admin", 15*time.Minute).Return(token, test.issueErr).Once()
+			}
+
+			auditMock := &AuditRecorderMock{}
+			if test.expectRecord {
+				auditMock.
+					On("Record", &model.AuditEvent{
+						ActorID:   "bloggo|admin",
+						SubjectID: "bloggo|reader",
+						Action:    model.AuditImpersonationStarted,
+						Detail:    "expires in 15m0s",
+						IP:        "10.0.0.1",
+						UserAgent: "Mozilla/5.0",
+					}).
+					Return(test.recordErr).
+					Once()
+			}
+
+			i := &Impersonation{
+				users:  userRepositoryMock,
+				tokens: issuerMock,
+				audit:  auditMock,
+				ttl:    15 * time.Minute,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			issued, err := i.Start("bloggo|admin", test.id, client)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Nil(t, issued, "no token should be returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, token, issued, "wrong token returned")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			issuerMock.AssertExpectations(t)
+			auditMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// invitationAudience is appended to the audience of access tokens to make the audience of
+// invitation tokens, so that invitations can't be used as access tokens and vice versa
+const invitationAudience = "/invitations"
+
+// InvitationClaims are the claims of the invitation tokens generated by the Invitations service
+type InvitationClaims struct {
+	Email string     `json:"email,omitempty"`
+	Role  model.Role `json:"role"`
+	jwt.StandardClaims
+}
+
+// InvitationRepository represents a repository in which invitations are stored
+type InvitationRepository interface {
+	Store(invitation *model.Invitation) error
+	Find(id uint) (*model.Invitation, error)
+	FindAll() ([]*model.Invitation, error)
+	Accept(id uint, acceptedAt time.Time) error
+	Release(id uint) error
+	Delete(id uint) error
+}
+
+// Invitations is a service with which admins invite users to register with a given role. Invitations
+// are signed tokens which expire, and are also stored so that they can be revoked and only accepted once.
+type Invitations struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+
+	invitations InvitationRepository
+	users       UserStore
+	hash        Hasher
+	signer      Signer
+	keys        KeyResolver
+
+	log *zerolog.Logger
+}
+
+// NewInvitations creates and configures an Invitations service. Invitations are issued by the issuer
+// for the audience of access tokens, and have to be accepted within ttl.
+func NewInvitations(log *zerolog.Logger, invitations InvitationRepository, users UserStore, hash Hasher, signer Signer, keys KeyResolver, issuer, audience string, ttl time.Duration) *Invitations {
+	return &Invitations{
+		log:         log,
+		invitations: invitations,
+		users:       users,
+		hash:        hash,
+		signer:      signer,
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience + invitationAudience,
+		ttl:         ttl,
+	}
+}
+
+// Create invites someone to register with the given role. The invitation can only be accepted
+// with the given email address, unless it is empty.
+func (i *Invitations) Create(invitedBy, email string, role model.Role) (*model.Invitation, error) {
+	now := time.Now()
+
+	invitation := &model.Invitation{
+		Email:     email,
+		Role:      role,
+		InvitedBy: invitedBy,
+		ExpiresAt: now.Add(i.ttl),
+		CreatedAt: now,
+	}
+
+	err := i.invitations.Store(invitation)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation.Token, err = i.signer.Sign(&InvitationClaims{
+		Email: email,
+		Role:  role,
+		StandardClaims: jwt.StandardClaims{
+			Id:        strconv.FormatUint(uint64(invitation.ID), 10),
+			Issuer:    i.issuer,
+			Audience:  i.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: invitation.ExpiresAt.Unix(),
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign invitation")
+	}
+
+	i.log.Info().Str("invited_by", invitedBy).Uint("id", invitation.ID).Str("role", string(role)).Msg("invitation created")
+	return invitation, nil
+}
+
+// List returns every invitation, from the most recent one
+func (i *Invitations) List() ([]*model.Invitation, error) {
+	return i.invitations.FindAll()
+}
+
+// Revoke revokes an invitation that was not accepted yet
+func (i *Invitations) Revoke(id uint) error {
+	err := i.invitations.Delete(id)
+	if err != nil {
+		return err
+	}
+
+	i.log.Info().Uint("id", id).Msg("invitation revoked")
+	return nil
+}
+
+// Accept registers a user with an invitation token, and gives them the role of the invitation
+func (i *Invitations) Accept(token string, user *model.User) (*model.User, error) {
+	now := time.Now()
+
+	id, err := i.verify(token)
+	if err != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	invitation, err := i.invitations.Find(id)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invitation was revoked")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if invitation.AcceptedAt != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invitation was already accepted")
+	}
+	if invitation.Email != "" && !strings.EqualFold(invitation.Email, user.Email) {
+		return nil, errors.Wrap(errortype.ErrUnprocessableEntity, "invitation was sent to another email address")
+	}
+
+	hash, err := i.hash.Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = i.invitations.Accept(id, now)
+	if errors.Cause(err) == errortype.ErrConflict {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invitation was already accepted")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := i.users.Store(&model.User{
+		TokenUserID:   generateID(),
+		Email:         user.Email,
+		Password:      hash,
+		Role:          invitation.Role,
+		Status:        model.StatusActive,
+		EmailVerified: invitation.Email != "",
+	})
+	if err != nil {
+		// The invitation can be accepted again, for instance with another email address
+		if releaseErr := i.invitations.Release(id); releaseErr != nil {
+			i.log.Error().Err(releaseErr).Uint("id", id).Msg("could not release invitation")
+		}
+		return nil, err
+	}
+
+	i.log.Info().Uint("id", id).Str("user_id", created.TokenUserID).Str("role", string(created.Role)).Msg("invitation accepted")
+	return created, nil
+}
+
+// verify checks the signature, issuer, audience and expiration of an invitation token, and returns its id
+func (i *Invitations) verify(token string) (uint, error) {
+	var claims InvitationClaims
+
+	err := parseClaims(i.keys, token, i.issuer, i.audience, &claims)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid invitation")
+	}
+
+	id, err := strconv.ParseUint(claims.Id, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid 'jti' claim")
+	}
+
+	return uint(id), nil
+}
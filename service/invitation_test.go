@@ -0,0 +1,368 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewInvitations(t *testing.T) {
+	invitationRepositoryMock := &repo.InvitationRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	hasherMock := &PasswordHasherMock{}
+	signerMock := &SignerMock{}
+	keysMock := &KeyResolverMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	i := NewInvitations(log, invitationRepositoryMock, userRepositoryMock, hasherMock, signerMock, keysMock, "https://bloggo.example.com/", "bloggo", 72*time.Hour)
+
+	assert.Equal(t, invitationRepositoryMock, i.invitations, "unexpected invitation repo set")
+	assert.Equal(t, userRepositoryMock, i.users, "unexpected user repo set")
+	assert.Equal(t, hasherMock, i.hash, "unexpected hasher set")
+	assert.Equal(t, signerMock, i.signer, "unexpected signer set")
+	assert.Equal(t, keysMock, i.keys, "unexpected key resolver set")
+	assert.Equal(t, "https://bloggo.example.com/", i.issuer, "unexpected issuer set")
+	assert.Equal(t, "bloggo/invitations", i.audience, "invitations should have their own audience")
+	assert.Equal(t, 72*time.Hour, i.ttl, "unexpected TTL set")
+	assert.Equal(t, log, i.log, "unexpected logger set")
+}
+
+func TestCreateInvitation(t *testing.T) {
+	tests := []struct {
+		description string
+
+		storeErr error
+		signErr  error
+
+		expectedError error
+	}{
+		{
+			description: "invitation created",
+		},
+		{
+			description: "repository error",
+
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+		{
+			description: "signing error",
+
+			signErr: errors.New("no key"),
+
+			expectedError: errors.New("could not sign invitation: no key"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			invitationRepositoryMock := &repo.InvitationRepositoryMock{}
+			invitationRepositoryMock.
+				On("Store", mock.AnythingOfType("*model.Invitation")).
+				Run(func(args mock.Arguments) { args.Get(0).(*model.Invitation).ID = 42 }).
+				Return(test.storeErr).
+				Once()
+
+			var claims *InvitationClaims
+			signerMock := &SignerMock{}
+			if test.storeErr == nil {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.InvitationClaims")).
+					Run(func(args mock.Arguments) { claims = args.Get(0).(*InvitationClaims) }).
+					Return("x.y.z", test.signErr).
+					Once()
+			}
+
+			i := NewInvitations(log, invitationRepositoryMock, &repo.UserRepositoryMock{}, &PasswordHasherMock{}, signerMock, &KeyResolverMock{}, "https://bloggo.example.com/", "bloggo", 72*time.Hour)
+
+			invitation, err := i.Create("bloggo|admin", "phyllis@vance-refrigeration.com", model.RoleEditor)
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Nil(t, invitation, "unexpected invitation returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, "x.y.z", invitation.Token, "wrong invitation token")
+				assert.Equal(t, "bloggo|admin", invitation.InvitedBy, "wrong inviter")
+				assert.Equal(t, model.RoleEditor, invitation.Role, "wrong role")
+				assert.WithinDuration(t, time.Now().Add(72*time.Hour), invitation.ExpiresAt, time.Minute, "wrong expiration")
+
+				assert.Equal(t, "42", claims.Id, "the token should identify the invitation")
+				assert.Equal(t, "phyllis@vance-refrigeration.com", claims.Email, "wrong email claim")
+				assert.Equal(t, model.RoleEditor, claims.Role, "wrong role claim")
+				assert.Equal(t, "https://bloggo.example.com/", claims.Issuer, "wrong issuer")
+				assert.Equal(t, "bloggo/invitations", claims.Audience, "wrong audience")
+				assert.Equal(t, invitation.ExpiresAt.Unix(), claims.ExpiresAt, "token should expire with the invitation")
+			}
+
+			invitationRepositoryMock.AssertExpectations(t)
+			signerMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeInvitation(t *testing.T) {
+	tests := []struct {
+		description string
+
+		deleteErr error
+
+		expectedError error
+	}{
+		{
+			description: "invitation revoked",
+		},
+		{
+			description: "unknown invitation",
+
+			deleteErr: errortype.ErrNotFound,
+
+			expectedError: errortype.ErrNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			invitationRepositoryMock := &repo.InvitationRepositoryMock{}
+			invitationRepositoryMock.
+				On("Delete", uint(42)).
+				Return(test.deleteErr).
+				Once()
+
+			i := NewInvitations(log, invitationRepositoryMock, &repo.UserRepositoryMock{}, &PasswordHasherMock{}, &SignerMock{}, &KeyResolverMock{}, "https://bloggo.example.com/", "bloggo", 72*time.Hour)
+
+			err := i.Revoke(42)
+			assert.Equal(t, test.expectedError, err, "wrong error returned")
+
+			invitationRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAcceptInvitation(t *testing.T) {
+	validClaims := func() *InvitationClaims {
+		return &InvitationClaims{
+			Email: "phyllis@vance-refrigeration.com",
+			Role:  model.RoleEditor,
+			StandardClaims: jwt.StandardClaims{
+				Id:        "42",
+				Issuer:    "https://bloggo.example.com/",
+				Audience:  "bloggo/invitations",
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+	}
+	accepted := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		description string
+
+		claims     func() *InvitationClaims
+		email      string
+		invitation *model.Invitation
+		findErr    error
+		acceptErr  error
+		storeErr   error
+
+		expectAccept  bool
+		expectStore   bool
+		expectRelease bool
+		expectedError error
+	}{
+		{
+			description: "invitation accepted",
+
+			claims:     validClaims,
+			email:      "Phyllis@Vance-Refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Email: "phyllis@vance-refrigeration.com", Role: model.RoleEditor},
+
+			expectAccept: true,
+			expectStore:  true,
+		},
+		{
+			description: "invitation for anyone",
+
+			claims:     validClaims,
+			email:      "stanley@vance-refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Role: model.RoleEditor},
+
+			expectAccept: true,
+			expectStore:  true,
+		},
+		{
+			description: "expired invitation",
+
+			claims: func() *InvitationClaims {
+				claims := validClaims()
+				claims.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+				return claims
+			},
+			email: "phyllis@vance-refrigeration.com",
+
+			expectedError: errors.New("invalid invitation: token has expired: invalid token"),
+		},
+		{
+			description: "access token used as an invitation",
+
+			claims: func() *InvitationClaims {
+				claims := validClaims()
+				claims.Audience = "bloggo"
+				return claims
+			},
+			email: "phyllis@vance-refrigeration.com",
+
+			expectedError: errors.New("invalid invitation: invalid 'aud' claim: invalid token"),
+		},
+		{
+			description: "invitation of another issuer",
+
+			claims: func() *InvitationClaims {
+				claims := validClaims()
+				claims.Issuer = "https://evil.example.com/"
+				return claims
+			},
+			email: "phyllis@vance-refrigeration.com",
+
+			expectedError: errors.New("invalid invitation: invalid 'iss' claim: invalid token"),
+		},
+		{
+			description: "revoked invitation",
+
+			claims:  validClaims,
+			email:   "phyllis@vance-refrigeration.com",
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("invitation was revoked: invalid token"),
+		},
+		{
+			description: "invitation already accepted",
+
+			claims:     validClaims,
+			email:      "phyllis@vance-refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Email: "phyllis@vance-refrigeration.com", Role: model.RoleEditor, AcceptedAt: &accepted},
+
+			expectedError: errors.New("invitation was already accepted: invalid token"),
+		},
+		{
+			description: "invitation accepted concurrently",
+
+			claims:     validClaims,
+			email:      "phyllis@vance-refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Email: "phyllis@vance-refrigeration.com", Role: model.RoleEditor},
+			acceptErr:  errortype.ErrConflict,
+
+			expectAccept:  true,
+			expectedError: errors.New("invitation was already accepted: invalid token"),
+		},
+		{
+			description: "invitation sent to another email address",
+
+			claims:     validClaims,
+			email:      "stanley@vance-refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Email: "phyllis@vance-refrigeration.com", Role: model.RoleEditor},
+
+			expectedError: errors.New("invitation was sent to another email address: unprocessable entity"),
+		},
+		{
+			description: "user can't be stored",
+
+			claims:     validClaims,
+			email:      "phyllis@vance-refrigeration.com",
+			invitation: &model.Invitation{ID: 42, Email: "phyllis@vance-refrigeration.com", Role: model.RoleEditor},
+			storeErr:   errortype.ErrDuplicateEntry,
+
+			expectAccept:  true,
+			expectStore:   true,
+			expectRelease: true,
+			expectedError: errortype.ErrDuplicateEntry,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			invitationRepositoryMock := &repo.InvitationRepositoryMock{}
+			if test.invitation != nil || test.findErr != nil {
+				invitationRepositoryMock.
+					On("Find", uint(42)).
+					Return(test.invitation, test.findErr).
+					Once()
+			}
+			if test.expectAccept {
+				invitationRepositoryMock.
+					On("Accept", uint(42), mock.AnythingOfType("time.Time")).
+					Return(test.acceptErr).
+					Once()
+			}
+			if test.expectRelease {
+				invitationRepositoryMock.
+					On("Release", uint(42)).
+					Return(nil).
+					Once()
+			}
+
+			hasherMock := &PasswordHasherMock{}
+			if test.expectAccept {
+				hasherMock.
+					On("Hash", "refrigerator2000").
+					Return("fakeHash", nil).
+					Once()
+			}
+
+			var stored *model.User
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectStore {
+				userRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.User")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.User) }).
+					Return(func() interface{} {
+						if test.storeErr != nil {
+							return nil
+						}
+						return &model.User{ID: 7, TokenUserID: "bloggo|id", Email: test.email, Role: model.RoleEditor}
+					}(), test.storeErr).
+					Once()
+			}
+
+			i := NewInvitations(log, invitationRepositoryMock, userRepositoryMock, hasherMock, &SignerMock{}, newKeyResolverMock(), "https://bloggo.example.com/", "bloggo", 72*time.Hour)
+
+			user, err := i.Accept(signTestToken(t, test.claims()), &model.User{Email: test.email, Password: "refrigerator2000"})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Nil(t, user, "unexpected user returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, uint(7), user.ID, "wrong user returned")
+			}
+
+			if stored != nil {
+				assert.Equal(t, test.email, stored.Email, "wrong email stored")
+				assert.Equal(t, "fakeHash", stored.Password, "password should be stored hashed")
+				assert.Equal(t, model.RoleEditor, stored.Role, "user should be given the role of the invitation")
+				assert.Equal(t, test.invitation.Email != "", stored.EmailVerified, "only email addresses of invitations should be verified")
+			}
+
+			invitationRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			hasherMock.AssertExpectations(t)
+		})
+	}
+}
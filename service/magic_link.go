@@ -0,0 +1,233 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// magicLinkAudience is appended to the audience of access tokens to make the audience of
+// magic link tokens, so that they can't be used as access tokens and vice versa
+const magicLinkAudience = "/magic-link"
+
+// magicLinkRateWindow is the period over which the magic links sent to an email address are limited
+const magicLinkRateWindow = time.Hour
+
+// MagicLinkClaims are the claims of the tokens generated by the MagicLinks service
+type MagicLinkClaims struct {
+	Email string `json:"email"`
+	Scope string `json:"scope,omitempty"`
+	jwt.StandardClaims
+}
+
+// MagicLinkRepository represents a repository in which the magic links sent to users are stored
+type MagicLinkRepository interface {
+	Store(link *model.MagicLink) error
+	CountSince(email string, since time.Time) (int, error)
+	Use(hash string, usedAt time.Time) error
+}
+
+// VerifiedLogin represents a service that issues tokens to users who proved who they are without their password
+type VerifiedLogin interface {
+	LoginVerified(user *model.User, scopes []model.Scope, method model.LoginMethod, client *model.Client) (*model.Token, *model.MFAChallenge, error)
+}
+
+// MagicLinks is a service that lets users log in without a password, by sending them a signed
+// single-use link by email
+type MagicLinks struct {
+	issuer   string
+	audience string
+	ttl      time.Duration
+	loginURL string
+	maxLinks int
+
+	links  MagicLinkRepository
+	users  UserRepository
+	tokens VerifiedLogin
+	signer Signer
+	keys   KeyResolver
+	mailer Mailer
+
+	log *zerolog.Logger
+}
+
+// NewMagicLinks creates and configures a MagicLinks service. Links are issued by the issuer for the audience
+// of access tokens and expire after ttl. If loginURL is set, emails contain a link to it with the token in
+// its query, otherwise only the token. At most maxLinks links are sent to an email address per hour.
+func NewMagicLinks(log *zerolog.Logger, links MagicLinkRepository, users UserRepository, tokens VerifiedLogin, signer Signer, keys KeyResolver, mailer Mailer, issuer, audience, loginURL string, ttl time.Duration, maxLinks int) *MagicLinks {
+	return &MagicLinks{
+		log:      log,
+		links:    links,
+		users:    users,
+		tokens:   tokens,
+		signer:   signer,
+		keys:     keys,
+		mailer:   mailer,
+		issuer:   issuer,
+		audience: audience + magicLinkAudience,
+		loginURL: loginURL,
+		ttl:      ttl,
+		maxLinks: maxLinks,
+	}
+}
+
+// Send sends a magic link to the user with the given email address, which logs them in with the given
+// scopes. Nothing is sent to unknown email addresses, to users who can't log in, or once too many links
+// were sent recently, but no error is returned either, so that callers can't find out which email
+// addresses have an account.
+func (m *MagicLinks) Send(email string, scopes []model.Scope) error {
+	now := time.Now()
+
+	count, err := m.links.CountSince(email, now.Add(-magicLinkRateWindow))
+	if err != nil {
+		return errors.Wrap(err, "could not count magic links")
+	}
+	if count >= m.maxLinks {
+		m.log.Warn().Str("email", email).Int("count", count).Msg("too many magic links requested")
+		return nil
+	}
+
+	user, err := m.users.Retrieve(&model.User{Email: email})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		m.log.Debug().Str("email", email).Msg("magic link requested for unknown user")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve user")
+	}
+
+	if checkStatus(user) != nil {
+		m.log.Debug().Str("user_id", user.TokenUserID).Str("status", string(user.Status)).Msg("magic link requested for inactive user")
+		return nil
+	}
+
+	id, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := now.Add(m.ttl)
+	token, err := m.signer.Sign(&MagicLinkClaims{
+		Email: user.Email,
+		Scope: model.FormatScopes(scopes),
+		StandardClaims: jwt.StandardClaims{
+			Id:        id,
+			Subject:   user.TokenUserID,
+			Issuer:    m.issuer,
+			Audience:  m.audience,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not sign magic link token")
+	}
+
+	err = m.links.Store(&model.MagicLink{
+		UserID:    user.TokenUserID,
+		Email:     user.Email,
+		Hash:      hashToken(id),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store magic link")
+	}
+
+	body, err := m.body(token)
+	if err != nil {
+		return err
+	}
+
+	err = m.mailer.Send(user.Email, "Log in to your account", body)
+	if err != nil {
+		return errors.Wrap(err, "could not send magic link email")
+	}
+
+	m.log.Info().Str("user_id", user.TokenUserID).Msg("magic link sent")
+	return nil
+}
+
+// Login exchanges the token of a magic link for the same tokens as a login with a password. If the
+// user enabled two-factor authentication, a challenge is returned instead.
+func (m *MagicLinks) Login(token string, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	now := time.Now()
+
+	claims, err := m.verify(token)
+	if err != nil {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	// Using the link fails if it was used concurrently
+	err = m.links.Use(hashToken(claims.Id), now)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "magic link was already used")
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not use magic link")
+	}
+
+	user, err := m.users.Retrieve(&model.User{TokenUserID: claims.Subject})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "user not found")
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not retrieve user")
+	}
+
+	// Whoever controlled the previous email address of the user shouldn't be able to log in anymore
+	if !strings.EqualFold(user.Email, claims.Email) {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "email address was changed since the link was sent")
+	}
+
+	issued, challenge, err := m.tokens.LoginVerified(user, model.ParseScopes(claims.Scope), model.LoginMethodMagicLink, client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.log.Info().Str("user_id", user.TokenUserID).Msg("logged in with magic link")
+	return issued, challenge, nil
+}
+
+// verify checks the signature, issuer, audience and expiration of a magic link token, and returns its claims
+func (m *MagicLinks) verify(token string) (*MagicLinkClaims, error) {
+	var claims MagicLinkClaims
+
+	err := parseClaims(m.keys, token, m.issuer, m.audience, &claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid magic link token")
+	}
+	if claims.Subject == "" || claims.Email == "" || claims.Id == "" {
+		return nil, errors.New("missing 'sub', 'email' or 'jti' claim")
+	}
+
+	return &claims, nil
+}
+
+// body writes the email that contains a magic link
+func (m *MagicLinks) body(token string) (string, error) {
+	minutes := int(m.ttl / time.Minute)
+
+	instructions := fmt.Sprintf("Use this token to log in within %d minutes:\n\n%s", minutes, token)
+	if m.loginURL != "" {
+		link, err := url.Parse(m.loginURL)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid magic link URL")
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+
+		instructions = fmt.Sprintf("Follow this link to log in within %d minutes:\n\n%s", minutes, link)
+	}
+
+	return fmt.Sprintf("Hello,\n\nSomeone asked to log in to your account without a password. %s\n\nIt can only be used once. If you didn't ask for it, you can ignore this email.\n", instructions), nil
+}
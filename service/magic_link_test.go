@@ -0,0 +1,411 @@
+package service
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type VerifiedLoginMock struct {
+	mock.Mock
+}
+
+func (m *VerifiedLoginMock) LoginVerified(user *model.User, scopes []model.Scope, method model.LoginMethod, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	args := m.Called(user, scopes, method, client)
+	token, _ := args.Get(0).(*model.Token)
+	challenge, _ := args.Get(1).(*model.MFAChallenge)
+	return token, challenge, args.Error(2)
+}
+
+func TestNewMagicLinks(t *testing.T) {
+	magicLinkRepositoryMock := &repo.MagicLinkRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	verifiedLoginMock := &VerifiedLoginMock{}
+	signerMock := &SignerMock{}
+	keysMock := &KeyResolverMock{}
+	mailerMock := &MailerMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	m := NewMagicLinks(log, magicLinkRepositoryMock, userRepositoryMock, verifiedLoginMock, signerMock, keysMock, mailerMock, "https://bloggo.example.com/", "bloggo", "https://bloggo.example.com/login", 15*time.Minute, 5)
+
+	assert.Equal(t, magicLinkRepositoryMock, m.links, "unexpected magic link repo set")
+	assert.Equal(t, userRepositoryMock, m.users, "unexpected user repo set")
+	assert.Equal(t, verifiedLoginMock, m.tokens, "unexpected token service set")
+	assert.Equal(t, signerMock, m.signer, "unexpected signer set")
+	assert.Equal(t, keysMock, m.keys, "unexpected key resolver set")
+	assert.Equal(t, mailerMock, m.mailer, "unexpected mailer set")
+	assert.Equal(t, "https://bloggo.example.com/", m.issuer, "unexpected issuer set")
+	assert.Equal(t, "bloggo/magic-link", m.audience, "magic link tokens should have their own audience")
+	assert.Equal(t, "https://bloggo.example.com/login", m.loginURL, "unexpected login URL set")
+	assert.Equal(t, 15*time.Minute, m.ttl, "unexpected TTL set")
+	assert.Equal(t, 5, m.maxLinks, "unexpected link limit set")
+	assert.Equal(t, log, m.log, "unexpected logger set")
+}
+
+func TestSendMagicLink(t *testing.T) {
+	tests := []struct {
+		description string
+
+		loginURL    string
+		count       int
+		countErr    error
+		user        *model.User
+		retrieveErr error
+		storeErr    error
+		sendErr     error
+
+		expectRetrieve bool
+		expectSend     bool
+		expectedLink   string
+		expectedError  error
+	}{
+		{
+			description: "magic link sent",
+
+			loginURL: "https://bloggo.example.com/login",
+			user:     &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+
+			expectRetrieve: true,
+			expectSend:     true,
+			expectedLink:   "https://bloggo.example.com/login?token=" + url.QueryEscape("x.y.z"),
+		},
+		{
+			description: "token sent without a login URL",
+
+			count: 4,
+			user:  &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+
+			expectRetrieve: true,
+			expectSend:     true,
+		},
+		{
+			description: "too many links sent recently",
+
+			count: 5,
+		},
+		{
+			description: "unknown user",
+
+			retrieveErr: errortype.ErrNotFound,
+
+			expectRetrieve: true,
+		},
+		{
+			description: "deactivated user",
+
+			user: &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusDeactivated},
+
+			expectRetrieve: true,
+		},
+		{
+			description: "count error",
+
+			countErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not count magic links: database exploded"),
+		},
+		{
+			description: "repository error",
+
+			user:     &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			storeErr: errors.New("database exploded"),
+
+			expectRetrieve: true,
+			expectedError:  errors.New("could not store magic link: database exploded"),
+		},
+		{
+			description: "mailer error",
+
+			user:    &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			sendErr: errors.New("connection refused"),
+
+			expectRetrieve: true,
+			expectSend:     true,
+			expectedError:  errors.New("could not send magic link email: connection refused"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			magicLinkRepositoryMock := &repo.MagicLinkRepositoryMock{}
+			magicLinkRepositoryMock.
+				On("CountSince", "pam@dunder-mifflin.com", mock.AnythingOfType("time.Time")).
+				Return(test.count, test.countErr).
+				Once()
+
+			var stored *model.MagicLink
+			if test.user != nil && test.user.Status == "" {
+				magicLinkRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.MagicLink")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.MagicLink) }).
+					Return(test.storeErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{Email: "pam@dunder-mifflin.com"}).
+					Return(test.user, test.retrieveErr).
+					Once()
+			}
+
+			var claims *MagicLinkClaims
+			signerMock := &SignerMock{}
+			if test.user != nil && test.user.Status == "" {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.MagicLinkClaims")).
+					Run(func(args mock.Arguments) { claims = args.Get(0).(*MagicLinkClaims) }).
+					Return("x.y.z", nil).
+					Once()
+			}
+
+			var body string
+			mailerMock := &MailerMock{}
+			if test.expectSend {
+				mailerMock.
+					On("Send", "pam@dunder-mifflin.com", "Log in to your account", mock.AnythingOfType("string")).
+					Run(func(args mock.Arguments) { body = args.String(2) }).
+					Return(test.sendErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			m := NewMagicLinks(log, magicLinkRepositoryMock, userRepositoryMock, &VerifiedLoginMock{}, signerMock, &KeyResolverMock{}, mailerMock, "https://bloggo.example.com/", "bloggo", test.loginURL, 15*time.Minute, 5)
+
+			err := m.Send("pam@dunder-mifflin.com", []model.Scope{model.ScopePostsRead})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			if claims != nil {
+				assert.Equal(t, "test", claims.Subject, "token should belong to the user")
+				assert.Equal(t, "pam@dunder-mifflin.com", claims.Email, "token should be valid for the email address it is sent to")
+				assert.Equal(t, "posts:read", claims.Scope, "token should carry the requested scopes")
+				assert.Equal(t, "bloggo/magic-link", claims.Audience, "wrong audience")
+				assert.InDelta(t, time.Now().Add(15*time.Minute).Unix(), claims.ExpiresAt, 60, "wrong expiration date")
+
+				if assert.NotNil(t, stored, "the magic link should be stored") {
+					assert.Equal(t, hashToken(claims.Id), stored.Hash, "only the hash of the token ID should be stored")
+					assert.Equal(t, "pam@dunder-mifflin.com", stored.Email, "the email address should be stored for rate limiting")
+				}
+			}
+
+			if test.expectSend {
+				if test.expectedLink != "" {
+					link := regexp.MustCompile(`https://\S+`).FindString(body)
+					assert.Equal(t, test.expectedLink, link, "the email should contain the magic link")
+				} else {
+					assert.Contains(t, body, "x.y.z", "the email should contain the token")
+				}
+			}
+
+			magicLinkRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			signerMock.AssertExpectations(t)
+			mailerMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMagicLinkLogin(t *testing.T) {
+	validClaims := func() *MagicLinkClaims {
+		return &MagicLinkClaims{
+			Email: "pam@dunder-mifflin.com",
+			Scope: "posts:read",
+			StandardClaims: jwt.StandardClaims{
+				Id:        "0123456789abcdef",
+				Subject:   "test",
+				Issuer:    "https://bloggo.example.com/",
+				Audience:  "bloggo/magic-link",
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			},
+		}
+	}
+
+	tests := []struct {
+		description string
+
+		claims      func() *MagicLinkClaims
+		useErr      error
+		user        *model.User
+		retrieveErr error
+		token       *model.Token
+		challenge   *model.MFAChallenge
+		loginErr    error
+
+		expectUse      bool
+		expectRetrieve bool
+		expectLogin    bool
+		expectedError  error
+	}{
+		{
+			description: "logged in",
+
+			claims: validClaims,
+			user:   &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			token:  &model.Token{AccessToken: "a.b.c"},
+
+			expectUse:      true,
+			expectRetrieve: true,
+			expectLogin:    true,
+		},
+		{
+			description: "two-factor authentication enabled",
+
+			claims:    validClaims,
+			user:      &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com", MFAEnabled: true},
+			challenge: &model.MFAChallenge{MFARequired: true, MFAToken: "mfa"},
+
+			expectUse:      true,
+			expectRetrieve: true,
+			expectLogin:    true,
+		},
+		{
+			description: "expired link",
+
+			claims: func() *MagicLinkClaims {
+				claims := validClaims()
+				claims.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+				return claims
+			},
+
+			expectedError: errors.New("invalid magic link token: token has expired: invalid token"),
+		},
+		{
+			description: "email verification token used as a magic link",
+
+			claims: func() *MagicLinkClaims {
+				claims := validClaims()
+				claims.Audience = "bloggo/email-verification"
+				return claims
+			},
+
+			expectedError: errors.New("invalid magic link token: invalid 'aud' claim: invalid token"),
+		},
+		{
+			description: "missing jti claim",
+
+			claims: func() *MagicLinkClaims {
+				claims := validClaims()
+				claims.Id = ""
+				return claims
+			},
+
+			expectedError: errors.New("missing 'sub', 'email' or 'jti' claim: invalid token"),
+		},
+		{
+			description: "link already used",
+
+			claims: validClaims,
+			useErr: errortype.ErrNotFound,
+
+			expectUse:     true,
+			expectedError: errors.New("magic link was already used: invalid token"),
+		},
+		{
+			description: "email address changed since the link was sent",
+
+			claims: validClaims,
+			user:   &model.User{TokenUserID: "test", Email: "pam@athlead.com"},
+
+			expectUse:      true,
+			expectRetrieve: true,
+			expectedError:  errors.New("email address was changed since the link was sent: invalid token"),
+		},
+		{
+			description: "user was deleted",
+
+			claims:      validClaims,
+			retrieveErr: errortype.ErrNotFound,
+
+			expectUse:      true,
+			expectRetrieve: true,
+			expectedError:  errors.New("user not found: invalid token"),
+		},
+		{
+			description: "account deactivated",
+
+			claims:   validClaims,
+			user:     &model.User{TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			loginErr: errors.Wrap(errortype.ErrForbidden, "account is deactivated"),
+
+			expectUse:      true,
+			expectRetrieve: true,
+			expectLogin:    true,
+			expectedError:  errors.New("account is deactivated: forbidden"),
+		},
+		{
+			description: "repository error",
+
+			claims: validClaims,
+			useErr: errors.New("database exploded"),
+
+			expectUse:     true,
+			expectedError: errors.New("could not use magic link: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			magicLinkRepositoryMock := &repo.MagicLinkRepositoryMock{}
+			if test.expectUse {
+				magicLinkRepositoryMock.
+					On("Use", hashToken("0123456789abcdef"), mock.AnythingOfType("time.Time")).
+					Return(test.useErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.retrieveErr).
+					Once()
+			}
+
+			verifiedLoginMock := &VerifiedLoginMock{}
+			if test.expectLogin {
+				verifiedLoginMock.
+					On("LoginVerified", test.user, []model.Scope{model.ScopePostsRead}, model.LoginMethodMagicLink, &model.Client{IP: "10.0.0.1"}).
+					Return(test.token, test.challenge, test.loginErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			m := NewMagicLinks(log, magicLinkRepositoryMock, userRepositoryMock, verifiedLoginMock, &SignerMock{}, newKeyResolverMock(), &MailerMock{}, "https://bloggo.example.com/", "bloggo", "", 15*time.Minute, 5)
+
+			token, challenge, err := m.Login(signTestToken(t, test.claims()), &model.Client{IP: "10.0.0.1"})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.token, token, "wrong token returned")
+				assert.Equal(t, test.challenge, challenge, "wrong challenge returned")
+			}
+
+			magicLinkRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			verifiedLoginMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/Ullaakut/Bloggo/imaging"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Number of components of the blurhash placeholders on each axis
+const (
+	blurhashX = 4
+	blurhashY = 3
+)
+
+// ErrProcessingQueueFull is returned when too many media are already waiting to be processed
+var ErrProcessingQueueFull = errors.New("media processing queue is full")
+
+// MediaRepository represents a repository in which the processing results of media are saved
+type MediaRepository interface {
+	Update(media *model.Media) error
+}
+
+// BlobStore represents a store in which the processed images are saved
+type BlobStore interface {
+	Put(key string, content []byte, contentType string) error
+}
+
+type mediaJob struct {
+	media   model.Media
+	content []byte
+}
+
+// MediaProcessor is a service that processes uploaded images in the background. It strips
+// their metadata, resizes them and computes their placeholders. Images are processed by a
+// fixed number of workers, so that processing large images can't slow down the API.
+type MediaProcessor struct {
+	media  MediaRepository
+	blobs  BlobStore
+	widths []int
+
+	jobs    chan mediaJob
+	workers sync.WaitGroup
+	mutex   sync.RWMutex
+	closed  bool
+
+	log *zerolog.Logger
+}
+
+// NewMediaProcessor creates a MediaProcessor that generates variants of the given widths, and
+// starts its workers. At most queueSize images can wait to be processed.
+func NewMediaProcessor(log *zerolog.Logger, mediaRepository MediaRepository, blobs BlobStore, widths []int, workers, queueSize int) *MediaProcessor {
+	p := &MediaProcessor{
+		media:  mediaRepository,
+		blobs:  blobs,
+		widths: widths,
+
+		jobs: make(chan mediaJob, queueSize),
+
+		log: log,
+	}
+
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.work()
+	}
+
+	return p
+}
+
+// Process queues an image to be processed. If the queue is full, the
+// media is marked as failed and ErrProcessingQueueFull is returned.
+func (p *MediaProcessor) Process(media *model.Media, content []byte) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if !p.closed {
+		select {
+		case p.jobs <- mediaJob{media: *media, content: content}:
+			return nil
+		default:
+		}
+	}
+
+	p.fail(media, ErrProcessingQueueFull)
+	return ErrProcessingQueueFull
+}
+
+// Close stops accepting images and waits for the queued ones to be processed
+func (p *MediaProcessor) Close() {
+	p.mutex.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mutex.Unlock()
+
+	p.workers.Wait()
+}
+
+func (p *MediaProcessor) work() {
+	defer p.workers.Done()
+
+	for job := range p.jobs {
+		media := job.media
+		err := p.process(&media, job.content)
+		if err != nil {
+			p.fail(&media, err)
+			continue
+		}
+
+		p.log.Debug().Uint("id", media.ID).Str("variants", media.VariantWidths).Msg("media processed")
+	}
+}
+
+// process generates the variants and the placeholders of an image and saves them
+func (p *MediaProcessor) process(media *model.Media, content []byte) (err error) {
+	// Don't let a malformed image take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing media: %v", r)
+		}
+	}()
+
+	stripped, contentType, err := imaging.Strip(content)
+	if err != nil {
+		return err
+	}
+
+	err = p.blobs.Put(media.StrippedKey(), stripped, contentType)
+	if err != nil {
+		return errors.Wrap(err, "could not store stripped image")
+	}
+
+	img, format, err := imaging.Decode(content)
+	if err != nil {
+		return err
+	}
+	bounds := img.Bounds()
+
+	var widths []int
+	for _, width := range p.widths {
+		if width >= bounds.Dx() {
+			continue
+		}
+
+		buf := &bytes.Buffer{}
+		contentType, err := imaging.Encode(buf, imaging.Resize(img, width), format)
+		if err != nil {
+			return errors.Wrapf(err, "could not encode %dpx variant", width)
+		}
+
+		err = p.blobs.Put(media.VariantKey(width), buf.Bytes(), contentType)
+		if err != nil {
+			return errors.Wrapf(err, "could not store %dpx variant", width)
+		}
+		widths = append(widths, width)
+	}
+
+	media.Blurhash, err = imaging.Blurhash(img, blurhashX, blurhashY)
+	if err != nil {
+		return err
+	}
+
+	media.Status = model.MediaReady
+	media.Width = bounds.Dx()
+	media.Height = bounds.Dy()
+	media.DominantColor = imaging.DominantColor(img)
+	media.SetWidths(widths)
+
+	return p.media.Update(media)
+}
+
+// fail marks a media as failed to be processed
+func (p *MediaProcessor) fail(media *model.Media, cause error) {
+	p.log.Error().Err(cause).Uint("id", media.ID).Msg("could not process media")
+
+	failed := *media
+	failed.Status = model.MediaFailed
+	err := p.media.Update(&failed)
+	if err != nil {
+		p.log.Error().Err(err).Uint("id", media.ID).Msg("could not mark media as failed")
+	}
+}
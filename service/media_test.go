@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type BlobStoreMock struct {
+	mock.Mock
+}
+
+func (m *BlobStoreMock) Put(key string, content []byte, contentType string) error {
+	args := m.Called(key, content, contentType)
+	return args.Error(0)
+}
+
+func newTestPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, img)
+	if err != nil {
+		t.Fatal("could not encode png")
+	}
+	return buf.Bytes()
+}
+
+func TestNewMediaProcessor(t *testing.T) {
+	mediaRepositoryMock := &repo.MediaRepositoryMock{}
+	blobStoreMock := &BlobStoreMock{}
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	p := NewMediaProcessor(log, mediaRepositoryMock, blobStoreMock, []int{320, 640}, 2, 10)
+	defer p.Close()
+
+	assert.Equal(t, mediaRepositoryMock, p.media, "unexpected media repository set")
+	assert.Equal(t, blobStoreMock, p.blobs, "unexpected blob store set")
+	assert.Equal(t, []int{320, 640}, p.widths, "unexpected widths set")
+	assert.Equal(t, 10, cap(p.jobs), "unexpected queue size set")
+	assert.Equal(t, log, p.log, "unexpected logger set")
+}
+
+func TestMediaProcessorProcess(t *testing.T) {
+	media := &model.Media{
+		ID:     3,
+		Hash:   "abcdef",
+		Status: model.MediaProcessing,
+	}
+
+	tests := []struct {
+		description string
+
+		content []byte
+		putErr  error
+
+		expectedPuts     []string
+		expectedStatus   string
+		expectedWidths   string
+		expectedBlurhash bool
+	}{
+		{
+			description: "image is processed",
+
+			content: newTestPNG(t, 100, 50),
+
+			expectedPuts:     []string{"variants/ab/abcdef/full", "variants/ab/abcdef/32", "variants/ab/abcdef/64"},
+			expectedStatus:   model.MediaReady,
+			expectedWidths:   "32,64",
+			expectedBlurhash: true,
+		},
+		{
+			description: "small images have no variants",
+
+			content: newTestPNG(t, 16, 16),
+
+			expectedPuts:     []string{"variants/ab/abcdef/full"},
+			expectedStatus:   model.MediaReady,
+			expectedBlurhash: true,
+		},
+		{
+			description: "invalid image",
+
+			content: []byte("\x89PNG\r\n\x1a\nnot really"),
+
+			expectedStatus: model.MediaFailed,
+		},
+		{
+			description: "blob store failure",
+
+			content: newTestPNG(t, 100, 50),
+			putErr:  errors.New("disk full"),
+
+			expectedPuts:   []string{"variants/ab/abcdef/full"},
+			expectedStatus: model.MediaFailed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			blobStoreMock := &BlobStoreMock{}
+			for _, key := range test.expectedPuts {
+				blobStoreMock.
+					On("Put", key, mock.Anything, "image/png").
+					Return(test.putErr).
+					Once()
+			}
+
+			var updated *model.Media
+			mediaRepositoryMock := &repo.MediaRepositoryMock{}
+			mediaRepositoryMock.
+				On("Update", mock.AnythingOfType("*model.Media")).
+				Run(func(args mock.Arguments) { updated = args.Get(0).(*model.Media) }).
+				Return(nil).
+				Once()
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			p := NewMediaProcessor(log, mediaRepositoryMock, blobStoreMock, []int{32, 64, 200}, 1, 1)
+
+			err := p.Process(media, test.content)
+			assert.NoError(t, err, "unexpected error")
+
+			// Wait for the image to be processed
+			p.Close()
+
+			if assert.NotNil(t, updated, "media should be updated") {
+				assert.Equal(t, uint(3), updated.ID, "wrong media updated")
+				assert.Equal(t, test.expectedStatus, updated.Status, "wrong status")
+				assert.Equal(t, test.expectedWidths, updated.VariantWidths, "wrong variants")
+				assert.Equal(t, test.expectedBlurhash, updated.Blurhash != "", "wrong blurhash")
+				if test.expectedStatus == model.MediaReady {
+					assert.Equal(t, "#ff0000", updated.DominantColor, "wrong dominant color")
+				}
+			}
+			assert.Equal(t, model.MediaProcessing, media.Status, "original media should not be modified")
+
+			blobStoreMock.AssertExpectations(t)
+			mediaRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMediaProcessorQueueFull(t *testing.T) {
+	mediaRepositoryMock := &repo.MediaRepositoryMock{}
+	mediaRepositoryMock.
+		On("Update", mock.MatchedBy(func(media *model.Media) bool { return media.Status == model.MediaFailed })).
+		Return(nil)
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	// Without workers, the queue is never emptied
+	p := NewMediaProcessor(log, mediaRepositoryMock, &BlobStoreMock{}, []int{32}, 0, 1)
+
+	err := p.Process(&model.Media{ID: 1, Hash: "abcdef"}, []byte("first"))
+	assert.NoError(t, err, "unexpected error")
+
+	err = p.Process(&model.Media{ID: 2, Hash: "abcdef"}, []byte("second"))
+	assert.Equal(t, ErrProcessingQueueFull, err, "expected the queue to be full")
+
+	mediaRepositoryMock.AssertNumberOfCalls(t, "Update", 1)
+
+	p.Close()
+	err = p.Process(&model.Media{ID: 3, Hash: "abcdef"}, []byte("third"))
+	assert.Equal(t, ErrProcessingQueueFull, err, "closed processor should not accept media")
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/totp"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// recoveryCodeCount is how many recovery codes users get when they enable two-factor authentication
+const recoveryCodeCount = 10
+
+// MFAUserRepository represents a user repository in which the two-factor authentication of users is stored
+type MFAUserRepository interface {
+	UserRepository
+	SetMFASecret(id uint, secret string) error
+	EnableMFA(id uint, step int64) error
+	DisableMFA(id uint) error
+	UseMFAStep(id uint, step int64) error
+}
+
+// RecoveryCodeRepository represents a repository in which the recovery codes of users are stored
+type RecoveryCodeRepository interface {
+	Replace(userID string, codes []*model.RecoveryCode) error
+	Use(userID, hash string, usedAt time.Time) error
+	DeleteByUser(userID string) error
+}
+
+// MFA is a service that lets users enroll in two-factor authentication with TOTP codes,
+// and verifies their codes when they log in
+type MFA struct {
+	issuer string
+
+	users MFAUserRepository
+	codes RecoveryCodeRepository
+
+	log *zerolog.Logger
+}
+
+// NewMFA creates and configures an MFA service. The issuer is the name under which
+// accounts are shown in authenticator apps.
+func NewMFA(log *zerolog.Logger, users MFAUserRepository, codes RecoveryCodeRepository, issuer string) *MFA {
+	return &MFA{
+		log:    log,
+		users:  users,
+		codes:  codes,
+		issuer: issuer,
+	}
+}
+
+// Enroll generates a new TOTP secret for a user. Two-factor authentication is only enabled once
+// the user confirms that their authenticator app generates valid codes.
+func (m *MFA) Enroll(userID string) (*model.MFAEnrollment, error) {
+	user, err := m.retrieve(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, errors.Wrap(errortype.ErrConflict, "two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.users.SetMFASecret(user.ID, secret)
+	if errors.Cause(err) == errortype.ErrConflict {
+		return nil, errors.Wrap(errortype.ErrConflict, "two-factor authentication is already enabled")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not save MFA secret")
+	}
+
+	return &model.MFAEnrollment{
+		Secret: secret,
+		URI:    totp.URI(m.issuer, user.Email, secret),
+	}, nil
+}
+
+// Confirm enables the two-factor authentication of a user who enrolled, if the code is valid, and
+// returns their recovery codes. They are only returned once, since only their hashes are stored.
+func (m *MFA) Confirm(userID, code string) ([]string, error) {
+	user, err := m.retrieve(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		return nil, errors.Wrap(errortype.ErrConflict, "two-factor authentication is already enabled")
+	}
+	if user.MFASecret == "" {
+		return nil, errors.Wrap(errortype.ErrConflict, "enrollment in two-factor authentication was not started")
+	}
+
+	step, ok := totp.Validate(user.MFASecret, code, time.Now(), user.MFALastStep)
+	if !ok {
+		return nil, errors.Wrap(errortype.ErrInvalidCredentials, "invalid code")
+	}
+
+	codes, err := m.generateRecoveryCodes(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.users.EnableMFA(user.ID, step)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		// The secret was replaced by another enrollment in the meantime
+		return nil, errors.Wrap(errortype.ErrConflict, "enrollment in two-factor authentication was restarted")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not enable two-factor authentication")
+	}
+
+	m.log.Info().Str("user_id", user.TokenUserID).Msg("two-factor authentication enabled")
+	return codes, nil
+}
+
+// Disable disables the two-factor authentication of a user, who must give a valid code or recovery code
+func (m *MFA) Disable(userID, code string) error {
+	user, err := m.retrieve(userID)
+	if err != nil {
+		return err
+	}
+
+	err = m.Verify(user, code)
+	if err != nil {
+		return err
+	}
+
+	err = m.users.DisableMFA(user.ID)
+	if err != nil {
+		return errors.Wrap(err, "could not disable two-factor authentication")
+	}
+
+	// Leftover recovery codes are useless, but they could be mistaken for valid ones
+	err = m.codes.DeleteByUser(user.TokenUserID)
+	if err != nil {
+		m.log.Warn().Err(err).Str("user_id", user.TokenUserID).Msg("could not delete recovery codes")
+	}
+
+	m.log.Info().Str("user_id", user.TokenUserID).Msg("two-factor authentication disabled")
+	return nil
+}
+
+// Verify checks a TOTP code or a recovery code of a user. Both can only be used once, so
+// ErrInvalidCredentials is returned for codes that were already used, as for invalid ones.
+func (m *MFA) Verify(user *model.User, code string) error {
+	if !user.MFAEnabled {
+		return errors.Wrap(errortype.ErrConflict, "two-factor authentication is not enabled")
+	}
+
+	now := time.Now()
+
+	step, ok := totp.Validate(user.MFASecret, code, now, user.MFALastStep)
+	if ok {
+		err := m.users.UseMFAStep(user.ID, step)
+		if errors.Cause(err) == errortype.ErrConflict {
+			return errors.Wrap(errortype.ErrInvalidCredentials, "code was already used")
+		}
+		return errors.Wrap(err, "could not save MFA step")
+	}
+
+	err := m.codes.Use(user.TokenUserID, hashToken(normalizeRecoveryCode(code)), now)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.Wrap(errortype.ErrInvalidCredentials, "invalid code")
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not use recovery code")
+	}
+
+	m.log.Info().Str("user_id", user.TokenUserID).Msg("recovery code used")
+	return nil
+}
+
+// generateRecoveryCodes replaces the recovery codes of a user with new ones
+func (m *MFA) generateRecoveryCodes(user *model.User) ([]string, error) {
+	now := time.Now()
+
+	codes := make([]string, 0, recoveryCodeCount)
+	stored := make([]*model.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := randomToken(8)
+		if err != nil {
+			return nil, err
+		}
+
+		// Recovery codes are split in groups to be easier to write down
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		stored = append(stored, &model.RecoveryCode{
+			UserID:    user.TokenUserID,
+			Hash:      hashToken(code),
+			CreatedAt: now,
+		})
+	}
+
+	err := m.codes.Replace(user.TokenUserID, stored)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store recovery codes")
+	}
+	return codes, nil
+}
+
+// retrieve returns the user with the given ID
+func (m *MFA) retrieve(userID string) (*model.User, error) {
+	user, err := m.users.Retrieve(&model.User{TokenUserID: userID})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve user")
+	}
+	return user, nil
+}
+
+// normalizeRecoveryCode removes the separators that users might type along with a recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return strings.ToLower(code)
+}
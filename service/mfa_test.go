@@ -0,0 +1,488 @@
+package service
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+	"github.com/Ullaakut/Bloggo/totp"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// testMFASecret is the TOTP secret of the test vectors of RFC 6238
+const testMFASecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// currentCode returns the TOTP code of testMFASecret at the current time, along with its time step
+func currentCode(t *testing.T) (string, int64) {
+	step := totp.Step(time.Now())
+	code, err := totp.Code(testMFASecret, step)
+	if err != nil {
+		t.Fatal("could not generate TOTP code")
+	}
+	return code, step
+}
+
+func TestNewMFA(t *testing.T) {
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	recoveryCodeRepositoryMock := &repo.RecoveryCodeRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	m := NewMFA(log, userRepositoryMock, recoveryCodeRepositoryMock, "Bloggo")
+
+	assert.Equal(t, userRepositoryMock, m.users, "unexpected user repo set")
+	assert.Equal(t, recoveryCodeRepositoryMock, m.codes, "unexpected recovery code repo set")
+	assert.Equal(t, "Bloggo", m.issuer, "unexpected issuer set")
+	assert.Equal(t, log, m.log, "unexpected logger set")
+}
+
+func TestEnrollMFA(t *testing.T) {
+	tests := []struct {
+		description string
+
+		user         *model.User
+		retrieveErr  error
+		setSecretErr error
+
+		expectSetSecret bool
+		expectedError   error
+	}{
+		{
+			description: "enrollment started",
+
+			user: &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+
+			expectSetSecret: true,
+		},
+		{
+			description: "enrollment restarted",
+
+			user: &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", MFASecret: testMFASecret},
+
+			expectSetSecret: true,
+		},
+		{
+			description: "already enabled",
+
+			user: &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", MFASecret: testMFASecret, MFAEnabled: true},
+
+			expectedError: errors.New("two-factor authentication is already enabled: datamodel conflict"),
+		},
+		{
+			description: "enabled concurrently",
+
+			user:         &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			setSecretErr: errortype.ErrConflict,
+
+			expectSetSecret: true,
+			expectedError:   errors.New("two-factor authentication is already enabled: datamodel conflict"),
+		},
+		{
+			description: "user not found",
+
+			retrieveErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user: resource not found"),
+		},
+		{
+			description: "repository error",
+
+			user:         &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com"},
+			setSecretErr: errors.New("database exploded"),
+
+			expectSetSecret: true,
+			expectedError:   errors.New("could not save MFA secret: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{TokenUserID: "test"}).
+				Return(test.user, test.retrieveErr).
+				Once()
+
+			var secret string
+			if test.expectSetSecret {
+				userRepositoryMock.
+					On("SetMFASecret", uint(42), mock.AnythingOfType("string")).
+					Run(func(args mock.Arguments) { secret = args.String(1) }).
+					Return(test.setSecretErr).
+					Once()
+			}
+
+			m := NewMFA(log, userRepositoryMock, &repo.RecoveryCodeRepositoryMock{}, "Bloggo")
+
+			enrollment, err := m.Enroll("test")
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, secret, enrollment.Secret, "the stored secret should be returned")
+				assert.NotEqual(t, testMFASecret, enrollment.Secret, "a new secret should be generated")
+
+				uri, err := url.Parse(enrollment.URI)
+				if assert.NoError(t, err, "provisioning URI should be valid") {
+					assert.Equal(t, "/Bloggo:pam@dunder-mifflin.com", uri.Path, "wrong provisioning URI label")
+					assert.Equal(t, secret, uri.Query().Get("secret"), "wrong provisioning URI secret")
+				}
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestConfirmMFA(t *testing.T) {
+	code, step := currentCode(t)
+
+	tests := []struct {
+		description string
+
+		user       *model.User
+		code       string
+		replaceErr error
+		enableErr  error
+
+		expectReplace bool
+		expectEnable  bool
+		expectedError error
+	}{
+		{
+			description: "two-factor authentication enabled",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret},
+			code: code,
+
+			expectReplace: true,
+			expectEnable:  true,
+		},
+		{
+			description: "invalid code",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret},
+			code: "000000",
+
+			expectedError: errors.New("invalid code: invalid credentials"),
+		},
+		{
+			description: "enrollment not started",
+
+			user: &model.User{ID: 42, TokenUserID: "test"},
+			code: code,
+
+			expectedError: errors.New("enrollment in two-factor authentication was not started: datamodel conflict"),
+		},
+		{
+			description: "already enabled",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code: code,
+
+			expectedError: errors.New("two-factor authentication is already enabled: datamodel conflict"),
+		},
+		{
+			description: "enrollment restarted concurrently",
+
+			user:      &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret},
+			code:      code,
+			enableErr: errortype.ErrNotFound,
+
+			expectReplace: true,
+			expectEnable:  true,
+			expectedError: errors.New("enrollment in two-factor authentication was restarted: datamodel conflict"),
+		},
+		{
+			description: "recovery codes could not be stored",
+
+			user:       &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret},
+			code:       code,
+			replaceErr: errors.New("database exploded"),
+
+			expectReplace: true,
+			expectedError: errors.New("could not store recovery codes: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{TokenUserID: "test"}).
+				Return(test.user, nil).
+				Once()
+			if test.expectEnable {
+				userRepositoryMock.
+					On("EnableMFA", uint(42), step).
+					Return(test.enableErr).
+					Once()
+			}
+
+			var stored []*model.RecoveryCode
+			recoveryCodeRepositoryMock := &repo.RecoveryCodeRepositoryMock{}
+			if test.expectReplace {
+				recoveryCodeRepositoryMock.
+					On("Replace", "test", mock.AnythingOfType("[]*model.RecoveryCode")).
+					Run(func(args mock.Arguments) { stored = args.Get(1).([]*model.RecoveryCode) }).
+					Return(test.replaceErr).
+					Once()
+			}
+
+			m := NewMFA(log, userRepositoryMock, recoveryCodeRepositoryMock, "Bloggo")
+
+			codes, err := m.Confirm("test", test.code)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Len(t, codes, recoveryCodeCount, "wrong number of recovery codes")
+				if assert.Len(t, stored, recoveryCodeCount, "wrong number of stored recovery codes") {
+					// Only the hashes of the recovery codes are stored
+					for i, code := range codes {
+						assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{4}(-[0-9a-f]{4}){3}$`), code, "wrong recovery code format")
+						assert.Equal(t, hashToken(normalizeRecoveryCode(code)), stored[i].Hash, "wrong recovery code hash")
+						assert.Equal(t, "test", stored[i].UserID, "wrong recovery code user")
+					}
+				}
+				assert.Contains(t, logsBuff.String(), "two-factor authentication enabled", "enabling MFA should be logged")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			recoveryCodeRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestVerifyMFA(t *testing.T) {
+	code, step := currentCode(t)
+
+	tests := []struct {
+		description string
+
+		user    *model.User
+		code    string
+		stepErr error
+		useErr  error
+
+		expectUseStep bool
+		expectUseCode bool
+		expectedError error
+	}{
+		{
+			description: "valid TOTP code",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code: code,
+
+			expectUseStep: true,
+		},
+		{
+			description: "TOTP code already used",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true, MFALastStep: step + 1},
+			code: code,
+
+			expectUseCode: true,
+			useErr:        errortype.ErrNotFound,
+			expectedError: errors.New("invalid code: invalid credentials"),
+		},
+		{
+			description: "TOTP code used concurrently",
+
+			user:    &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code:    code,
+			stepErr: errortype.ErrConflict,
+
+			expectUseStep: true,
+			expectedError: errors.New("code was already used: invalid credentials"),
+		},
+		{
+			description: "valid recovery code",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code: "0A1B-2C3D-4E5F-6A7B",
+
+			expectUseCode: true,
+		},
+		{
+			description: "invalid recovery code",
+
+			user:   &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code:   "0a1b-2c3d-4e5f-6a7b",
+			useErr: errortype.ErrNotFound,
+
+			expectUseCode: true,
+			expectedError: errors.New("invalid code: invalid credentials"),
+		},
+		{
+			description: "two-factor authentication not enabled",
+
+			user: &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret},
+			code: code,
+
+			expectedError: errors.New("two-factor authentication is not enabled: datamodel conflict"),
+		},
+		{
+			description: "repository error",
+
+			user:   &model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true},
+			code:   "0a1b-2c3d-4e5f-6a7b",
+			useErr: errors.New("database exploded"),
+
+			expectUseCode: true,
+			expectedError: errors.New("could not use recovery code: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectUseStep {
+				userRepositoryMock.
+					On("UseMFAStep", uint(42), step).
+					Return(test.stepErr).
+					Once()
+			}
+
+			recoveryCodeRepositoryMock := &repo.RecoveryCodeRepositoryMock{}
+			if test.expectUseCode {
+				recoveryCodeRepositoryMock.
+					On("Use", "test", hashToken(normalizeRecoveryCode(test.code)), mock.AnythingOfType("time.Time")).
+					Return(test.useErr).
+					Once()
+			}
+
+			m := NewMFA(log, userRepositoryMock, recoveryCodeRepositoryMock, "Bloggo")
+
+			err := m.Verify(test.user, test.code)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			recoveryCodeRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDisableMFA(t *testing.T) {
+	code, step := currentCode(t)
+
+	tests := []struct {
+		description string
+
+		code       string
+		disableErr error
+		deleteErr  error
+
+		expectDisable bool
+		expectedError error
+	}{
+		{
+			description: "two-factor authentication disabled",
+
+			code: code,
+
+			expectDisable: true,
+		},
+		{
+			description: "recovery codes could not be deleted",
+
+			code:      code,
+			deleteErr: errors.New("database exploded"),
+
+			expectDisable: true,
+		},
+		{
+			description: "invalid code",
+
+			code: "000000",
+
+			expectedError: errors.New("invalid code: invalid credentials"),
+		},
+		{
+			description: "repository error",
+
+			code:       code,
+			disableErr: errors.New("database exploded"),
+
+			expectDisable: true,
+			expectedError: errors.New("could not disable two-factor authentication: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{TokenUserID: "test"}).
+				Return(&model.User{ID: 42, TokenUserID: "test", MFASecret: testMFASecret, MFAEnabled: true}, nil).
+				Once()
+
+			recoveryCodeRepositoryMock := &repo.RecoveryCodeRepositoryMock{}
+			if test.code == code {
+				userRepositoryMock.
+					On("UseMFAStep", uint(42), step).
+					Return(nil).
+					Once()
+			} else {
+				recoveryCodeRepositoryMock.
+					On("Use", "test", hashToken(test.code), mock.AnythingOfType("time.Time")).
+					Return(errortype.ErrNotFound).
+					Once()
+			}
+			if test.expectDisable {
+				userRepositoryMock.
+					On("DisableMFA", uint(42)).
+					Return(test.disableErr).
+					Once()
+			}
+			if test.expectDisable && test.disableErr == nil {
+				recoveryCodeRepositoryMock.
+					On("DeleteByUser", "test").
+					Return(test.deleteErr).
+					Once()
+			}
+
+			m := NewMFA(log, userRepositoryMock, recoveryCodeRepositoryMock, "Bloggo")
+
+			err := m.Disable("test", test.code)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Contains(t, logsBuff.String(), "two-factor authentication disabled", "disabling MFA should be logged")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			recoveryCodeRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
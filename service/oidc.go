@@ -0,0 +1,217 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/oidc"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// IdentityProvider represents an OpenID Connect identity provider with which users can log in
+type IdentityProvider interface {
+	AuthCodeURL(state, nonce, verifier string) (string, error)
+	Exchange(code, verifier, nonce string) (*oidc.Identity, error)
+}
+
+// OIDCLoginRepository represents a repository in which the logins with identity providers that are in progress are stored
+type OIDCLoginRepository interface {
+	Store(login *model.OIDCLogin) error
+	Consume(stateHash string) (*model.OIDCLogin, error)
+}
+
+// IdentityRepository represents a repository of the identities that link users to identity providers
+type IdentityRepository interface {
+	Find(provider, subject string) (*model.Identity, error)
+	Store(identity *model.Identity) error
+}
+
+// UserStore represents a user repository in which users can be created
+type UserStore interface {
+	UserRepository
+	Store(user *model.User) (*model.User, error)
+}
+
+// RegistrationPolicy represents a policy that decides who can create an account
+type RegistrationPolicy interface {
+	Check(email string, invited bool) error
+	Status() model.UserStatus
+}
+
+// OIDC is a service that logs users in with OpenID Connect identity providers. Users who log in
+// with a provider for the first time are linked to the account that has the same verified email
+// address, or get a new account if the registration policy allows it. Users who enabled two-factor
+// authentication still have to complete their login with their second factor.
+type OIDC struct {
+	providers map[string]IdentityProvider
+	loginTTL  time.Duration
+
+	logins       OIDCLoginRepository
+	identities   IdentityRepository
+	users        UserStore
+	tokens       VerifiedLogin
+	registration RegistrationPolicy
+
+	log *zerolog.Logger
+}
+
+// NewOIDC creates and configures an OIDC service with the given providers, indexed by name.
+// Logins have to be completed within loginTTL.
+func NewOIDC(log *zerolog.Logger, providers map[string]IdentityProvider, logins OIDCLoginRepository, identities IdentityRepository, users UserStore, tokens VerifiedLogin, registration RegistrationPolicy, loginTTL time.Duration) *OIDC {
+	return &OIDC{
+		log:          log,
+		providers:    providers,
+		logins:       logins,
+		identities:   identities,
+		users:        users,
+		tokens:       tokens,
+		registration: registration,
+		loginTTL:     loginTTL,
+	}
+}
+
+// Start starts a login with an identity provider. It returns the URL of the provider to which the
+// user should be redirected, and the state that the provider sends back along with the authorization code.
+func (o *OIDC) Start(providerName string) (string, string, error) {
+	provider, ok := o.providers[providerName]
+	if !ok {
+		return "", "", errors.Wrapf(errortype.ErrNotFound, "identity provider %s", providerName)
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not build authorization URL")
+	}
+
+	now := time.Now()
+	err = o.logins.Store(&model.OIDCLogin{
+		StateHash:    hashToken(state),
+		Provider:     providerName,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    now.Add(o.loginTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not store login")
+	}
+
+	return authURL, state, nil
+}
+
+// Callback completes a login with an identity provider, by exchanging the authorization code that
+// it returned for the identity of the user. Users are provisioned the first time they log in. Users who
+// enabled two-factor authentication get a challenge instead of a token.
+func (o *OIDC) Callback(providerName, state, code string, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	provider, ok := o.providers[providerName]
+	if !ok {
+		return nil, nil, errors.Wrapf(errortype.ErrNotFound, "identity provider %s", providerName)
+	}
+
+	// Each login can only be completed once
+	login, err := o.logins.Consume(hashToken(state))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "unknown login state")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if login.Provider != providerName {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "login was started with another identity provider")
+	}
+	if !time.Now().Before(login.ExpiresAt) {
+		return nil, nil, errors.Wrap(errortype.ErrInvalidToken, "login has expired")
+	}
+
+	identity, err := provider.Exchange(code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := o.provision(providerName, identity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Accounts can be linked by email address, so the provider can't replace their second factor
+	return o.tokens.LoginVerified(user, nil, model.LoginMethodOIDC, client)
+}
+
+// provision returns the user linked to an identity. Identities that aren't linked yet are linked to the
+// user with the same email address if the provider verified it, or to a new user.
+func (o *OIDC) provision(providerName string, identity *oidc.Identity) (*model.User, error) {
+	linked, err := o.identities.Find(providerName, identity.Subject)
+	if err == nil {
+		user, err := o.users.Retrieve(&model.User{TokenUserID: linked.UserID})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not retrieve linked user")
+		}
+		return user, nil
+	}
+	if errors.Cause(err) != errortype.ErrNotFound {
+		return nil, err
+	}
+
+	if identity.Email == "" {
+		return nil, errors.Wrap(errortype.ErrUnprocessableEntity, "identity provider did not share an email address")
+	}
+
+	user, err := o.users.Retrieve(&model.User{Email: identity.Email})
+	switch {
+	case err == nil:
+		// Otherwise, anyone able to create an account on the provider could take over local accounts
+		if !identity.EmailVerified {
+			return nil, errors.Wrapf(errortype.ErrConflict, "an account already exists for %s, which the identity provider did not verify", identity.Email)
+		}
+	case errors.Cause(err) == errortype.ErrNotFound:
+		err = o.registration.Check(identity.Email, false)
+		if err != nil {
+			return nil, err
+		}
+
+		user, err = o.users.Store(&model.User{
+			TokenUserID:   providerName + "|" + identity.Subject,
+			Email:         identity.Email,
+			Role:          model.RoleReader,
+			Status:        o.registration.Status(),
+			EmailVerified: identity.EmailVerified,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create user")
+		}
+		o.log.Info().Str("provider", providerName).Str("user_id", user.TokenUserID).Msg("user provisioned")
+	default:
+		return nil, err
+	}
+
+	err = o.identities.Store(&model.Identity{
+		Provider:  providerName,
+		Subject:   identity.Subject,
+		UserID:    user.TokenUserID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not link identity")
+	}
+
+	o.log.Info().Str("provider", providerName).Str("user_id", user.TokenUserID).Msg("identity linked")
+
+	return user, nil
+}
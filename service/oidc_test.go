@@ -0,0 +1,505 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/oidc"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type IdentityProviderMock struct {
+	mock.Mock
+}
+
+func (m *IdentityProviderMock) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	args := m.Called(state, nonce, verifier)
+	return args.String(0), args.Error(1)
+}
+
+func (m *IdentityProviderMock) Exchange(code, verifier, nonce string) (*oidc.Identity, error) {
+	args := m.Called(code, verifier, nonce)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*oidc.Identity), args.Error(1)
+}
+
+func TestNewOIDC(t *testing.T) {
+	providers := map[string]IdentityProvider{"example": &IdentityProviderMock{}}
+	oidcLoginRepositoryMock := &repo.OIDCLoginRepositoryMock{}
+	identityRepositoryMock := &repo.IdentityRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	verifiedLoginMock := &VerifiedLoginMock{}
+	registration := NewRegistration(RegistrationOpen, nil, false)
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	o := NewOIDC(log, providers, oidcLoginRepositoryMock, identityRepositoryMock, userRepositoryMock, verifiedLoginMock, registration, 10*time.Minute)
+
+	assert.Equal(t, providers, o.providers, "unexpected providers set")
+	assert.Equal(t, oidcLoginRepositoryMock, o.logins, "unexpected login repo set")
+	assert.Equal(t, identityRepositoryMock, o.identities, "unexpected identity repo set")
+	assert.Equal(t, userRepositoryMock, o.users, "unexpected user repo set")
+	assert.Equal(t, verifiedLoginMock, o.tokens, "unexpected token issuer set")
+	assert.Equal(t, registration, o.registration, "unexpected registration policy set")
+	assert.Equal(t, 10*time.Minute, o.loginTTL, "unexpected login TTL set")
+	assert.Equal(t, log, o.log, "unexpected logger set")
+}
+
+func TestStartOIDC(t *testing.T) {
+	tests := []struct {
+		description string
+
+		provider string
+		urlErr   error
+		storeErr error
+
+		expectedError error
+	}{
+		{
+			description: "login started",
+
+			provider: "example",
+		},
+		{
+			description: "unknown provider",
+
+			provider: "unknown",
+
+			expectedError: errors.New("identity provider unknown: resource not found"),
+		},
+		{
+			description: "provider can't be discovered",
+
+			provider: "example",
+			urlErr:   errors.New("connection refused"),
+
+			expectedError: errors.New("could not build authorization URL: connection refused"),
+		},
+		{
+			description: "repository error",
+
+			provider: "example",
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not store login: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			var state, nonce, verifier string
+			identityProviderMock := &IdentityProviderMock{}
+			if test.provider == "example" {
+				identityProviderMock.
+					On("AuthCodeURL", mock.AnythingOfType("string"), mock.AnythingOfType("string"), mock.AnythingOfType("string")).
+					Run(func(args mock.Arguments) {
+						state, nonce, verifier = args.String(0), args.String(1), args.String(2)
+					}).
+					Return("https://idp.example.com/authorize", test.urlErr).
+					Once()
+			}
+
+			var stored *model.OIDCLogin
+			oidcLoginRepositoryMock := &repo.OIDCLoginRepositoryMock{}
+			if test.provider == "example" && test.urlErr == nil {
+				oidcLoginRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.OIDCLogin")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.OIDCLogin) }).
+					Return(test.storeErr).
+					Once()
+			}
+
+			o := &OIDC{
+				providers: map[string]IdentityProvider{"example": identityProviderMock},
+				loginTTL:  10 * time.Minute,
+				logins:    oidcLoginRepositoryMock,
+
+				log: log,
+			}
+
+			authURL, returnedState, err := o.Start(test.provider)
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, "https://idp.example.com/authorize", authURL, "wrong authorization URL")
+				assert.Equal(t, state, returnedState, "wrong state")
+				assert.NotEqual(t, state, nonce, "state and nonce should differ")
+				assert.NotEqual(t, state, verifier, "state and verifier should differ")
+
+				// The state is returned to the user, so only its hash is stored
+				assert.Equal(t, hashToken(state), stored.StateHash, "wrong state hash stored")
+				assert.Equal(t, "example", stored.Provider, "wrong provider stored")
+				assert.Equal(t, nonce, stored.Nonce, "wrong nonce stored")
+				assert.Equal(t, verifier, stored.CodeVerifier, "wrong verifier stored")
+				assert.WithinDuration(t, time.Now().Add(10*time.Minute), stored.ExpiresAt, time.Minute, "wrong expiration date")
+			}
+
+			identityProviderMock.AssertExpectations(t)
+			oidcLoginRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCallbackOIDC(t *testing.T) {
+	validLogin := &model.OIDCLogin{
+		StateHash:    hashToken("state"),
+		Provider:     "example",
+		Nonce:        "nonce",
+		CodeVerifier: "verifier",
+		ExpiresAt:    time.Now().Add(time.Minute),
+	}
+	verified := &oidc.Identity{Subject: "248289761001", Email: "jane@example.com", EmailVerified: true}
+	unverified := &oidc.Identity{Subject: "248289761001", Email: "jane@example.com"}
+	localUser := &model.User{TokenUserID: "bloggo|local", Email: "jane@example.com", Role: model.RoleAuthor}
+	mfaUser := &model.User{TokenUserID: "bloggo|local", Email: "jane@example.com", Role: model.RoleAdmin, MFAEnabled: true}
+	provisioned := &model.User{TokenUserID: "example|248289761001", Email: "jane@example.com", Role: model.RoleReader, Status: model.StatusActive}
+	pending := &model.User{TokenUserID: "example|248289761001", Email: "jane@example.com", Role: model.RoleReader, Status: model.StatusPending}
+
+	tests := []struct {
+		description string
+
+		provider    string
+		login       *model.OIDCLogin
+		consumeErr  error
+		identity    *oidc.Identity
+		exchangeErr error
+
+		registration RegistrationMode
+		approval     bool
+
+		linked      *model.Identity
+		findErr     error
+		userByID    *model.User
+		userByEmail *model.User
+		emailErr    error
+		storedUser  *model.User
+		storeErr    error
+		linkErr     error
+
+		challenge *model.MFAChallenge
+
+		expectLink    bool
+		expectedUser  *model.User
+		expectedError error
+	}{
+		{
+			description: "already linked identity",
+
+			provider: "example",
+			login:    validLogin,
+			identity: verified,
+			linked:   &model.Identity{Provider: "example", Subject: "248289761001", UserID: "bloggo|local"},
+			userByID: localUser,
+
+			expectedUser: localUser,
+		},
+		{
+			description: "identity linked to the user with the same verified email",
+
+			provider:    "example",
+			login:       validLogin,
+			identity:    verified,
+			findErr:     errortype.ErrNotFound,
+			userByEmail: localUser,
+
+			expectLink:   true,
+			expectedUser: localUser,
+		},
+		{
+			description: "user with two-factor authentication linked by verified email",
+
+			provider:    "example",
+			login:       validLogin,
+			identity:    verified,
+			findErr:     errortype.ErrNotFound,
+			userByEmail: mfaUser,
+			challenge:   &model.MFAChallenge{MFARequired: true, MFAToken: "mfa", ExpiresIn: 300},
+
+			expectLink:   true,
+			expectedUser: mfaUser,
+		},
+		{
+			description: "new user provisioned",
+
+			provider:   "example",
+			login:      validLogin,
+			identity:   unverified,
+			findErr:    errortype.ErrNotFound,
+			emailErr:   errortype.ErrNotFound,
+			storedUser: provisioned,
+
+			expectLink:   true,
+			expectedUser: provisioned,
+		},
+		{
+			description: "new user pending approval",
+
+			provider:   "example",
+			login:      validLogin,
+			identity:   verified,
+			approval:   true,
+			findErr:    errortype.ErrNotFound,
+			emailErr:   errortype.ErrNotFound,
+			storedUser: pending,
+
+			expectLink:   true,
+			expectedUser: pending,
+		},
+		{
+			description: "new user with a domain that is not allowed",
+
+			provider:     "example",
+			login:        validLogin,
+			identity:     verified,
+			registration: RegistrationDomainAllowlist,
+			findErr:      errortype.ErrNotFound,
+			emailErr:     errortype.ErrNotFound,
+
+			expectedError: errors.New("registration is not allowed for this email domain: forbidden"),
+		},
+		{
+			description: "existing user while registration is closed",
+
+			provider:     "example",
+			login:        validLogin,
+			identity:     verified,
+			registration: RegistrationClosed,
+			findErr:      errortype.ErrNotFound,
+			userByEmail:  localUser,
+
+			expectLink:   true,
+			expectedUser: localUser,
+		},
+		{
+			description: "existing account with an unverified email",
+
+			provider:    "example",
+			login:       validLogin,
+			identity:    unverified,
+			findErr:     errortype.ErrNotFound,
+			userByEmail: localUser,
+
+			expectedError: errors.New("an account already exists for jane@example.com, which the identity provider did not verify: datamodel conflict"),
+		},
+		{
+			description: "identity without email",
+
+			provider: "example",
+			login:    validLogin,
+			identity: &oidc.Identity{Subject: "248289761001"},
+			findErr:  errortype.ErrNotFound,
+
+			expectedError: errors.New("identity provider did not share an email address: unprocessable entity"),
+		},
+		{
+			description: "unknown provider",
+
+			provider: "unknown",
+
+			expectedError: errors.New("identity provider unknown: resource not found"),
+		},
+		{
+			description: "unknown or already used state",
+
+			provider:   "example",
+			consumeErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("unknown login state: invalid token"),
+		},
+		{
+			description: "login started with another provider",
+
+			provider: "example",
+			login:    &model.OIDCLogin{Provider: "other", ExpiresAt: time.Now().Add(time.Minute)},
+
+			expectedError: errors.New("login was started with another identity provider: invalid token"),
+		},
+		{
+			description: "expired login",
+
+			provider: "example",
+			login:    &model.OIDCLogin{Provider: "example", ExpiresAt: time.Now().Add(-time.Minute)},
+
+			expectedError: errors.New("login has expired: invalid token"),
+		},
+		{
+			description: "code rejected by the provider",
+
+			provider:    "example",
+			login:       validLogin,
+			exchangeErr: errors.Wrap(errortype.ErrInvalidToken, "invalid 'nonce' claim"),
+
+			expectedError: errors.New("invalid 'nonce' claim: invalid token"),
+		},
+		{
+			description: "user creation error",
+
+			provider: "example",
+			login:    validLogin,
+			identity: verified,
+			findErr:  errortype.ErrNotFound,
+			emailErr: errortype.ErrNotFound,
+			storeErr: errortype.ErrDuplicateEntry,
+
+			expectedError: errors.New("could not create user: duplicate entry"),
+		},
+		{
+			description: "identity link error",
+
+			provider:    "example",
+			login:       validLogin,
+			identity:    verified,
+			findErr:     errortype.ErrNotFound,
+			userByEmail: localUser,
+			linkErr:     errors.New("database exploded"),
+
+			expectLink:    true,
+			expectedError: errors.New("could not link identity: database exploded"),
+		},
+		{
+			description: "identity repository error",
+
+			provider: "example",
+			login:    validLogin,
+			identity: verified,
+			findErr:  errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			oidcLoginRepositoryMock := &repo.OIDCLoginRepositoryMock{}
+			if test.provider == "example" {
+				oidcLoginRepositoryMock.
+					On("Consume", hashToken("state")).
+					Return(test.login, test.consumeErr).
+					Once()
+			}
+
+			identityProviderMock := &IdentityProviderMock{}
+			if test.identity != nil || test.exchangeErr != nil {
+				identityProviderMock.
+					On("Exchange", "code", "verifier", "nonce").
+					Return(test.identity, test.exchangeErr).
+					Once()
+			}
+
+			identityRepositoryMock := &repo.IdentityRepositoryMock{}
+			if test.linked != nil || test.findErr != nil {
+				identityRepositoryMock.
+					On("Find", "example", "248289761001").
+					Return(test.linked, test.findErr).
+					Once()
+			}
+			if test.expectLink {
+				linkedUser := test.userByEmail
+				if test.storedUser != nil {
+					linkedUser = test.storedUser
+				}
+				identityRepositoryMock.
+					On("Store", mock.MatchedBy(func(identity *model.Identity) bool {
+						return identity.Provider == "example" && identity.Subject == "248289761001" && identity.UserID == linkedUser.TokenUserID
+					})).
+					Return(test.linkErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.userByID != nil {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: test.linked.UserID}).
+					Return(test.userByID, nil).
+					Once()
+			}
+			if test.userByEmail != nil || test.emailErr != nil {
+				userRepositoryMock.
+					On("Retrieve", &model.User{Email: "jane@example.com"}).
+					Return(test.userByEmail, test.emailErr).
+					Once()
+			}
+			if test.storedUser != nil || test.storeErr != nil {
+				status := model.StatusActive
+				if test.approval {
+					status = model.StatusPending
+				}
+				userRepositoryMock.
+					On("Store", &model.User{TokenUserID: "example|248289761001", Email: "jane@example.com", Role: model.RoleReader, Status: status, EmailVerified: test.identity.EmailVerified}).
+					Return(test.storedUser, test.storeErr).
+					Once()
+			}
+
+			verifiedLoginMock := &VerifiedLoginMock{}
+			if test.expectedUser != nil {
+				var token *model.Token
+				if test.challenge == nil {
+					token = &model.Token{AccessToken: "x.y.z"}
+				}
+				verifiedLoginMock.
+					On("LoginVerified", test.expectedUser, []model.Scope(nil), model.LoginMethodOIDC, &model.Client{IP: "10.0.0.1"}).
+					Return(token, test.challenge, nil).
+					Once()
+			}
+
+			registration := test.registration
+			if registration == "" {
+				registration = RegistrationOpen
+			}
+
+			o := &OIDC{
+				providers:    map[string]IdentityProvider{"example": identityProviderMock},
+				loginTTL:     10 * time.Minute,
+				logins:       oidcLoginRepositoryMock,
+				identities:   identityRepositoryMock,
+				users:        userRepositoryMock,
+				tokens:       verifiedLoginMock,
+				registration: NewRegistration(registration, []string{"vance-refrigeration.com"}, test.approval),
+
+				log: log,
+			}
+
+			token, challenge, err := o.Callback(test.provider, "state", "code", &model.Client{IP: "10.0.0.1"})
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if test.challenge != nil {
+				if assert.NoError(t, err, "unexpected error") {
+					assert.Nil(t, token, "token returned without a second factor")
+					assert.Equal(t, test.challenge, challenge, "wrong challenge returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, "x.y.z", token.AccessToken, "wrong token returned")
+				assert.Nil(t, challenge, "unexpected challenge returned")
+			}
+
+			oidcLoginRepositoryMock.AssertExpectations(t)
+			identityProviderMock.AssertExpectations(t)
+			identityRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			verifiedLoginMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// passwordResetRateWindow is the period over which the reset tokens sent to a user are limited
+const passwordResetRateWindow = time.Hour
+
+// PasswordResetRepository represents a repository in which password resets are stored
+type PasswordResetRepository interface {
+	Store(reset *model.PasswordReset) error
+	CountSince(userID string, since time.Time) (int, error)
+	FindByHash(hash string) (*model.PasswordReset, error)
+	MarkUsed(id uint, usedAt time.Time) error
+	DeleteByUser(userID string) error
+}
+
+// PasswordUserRepository represents a user repository in which passwords can be reset
+type PasswordUserRepository interface {
+	UserRepository
+	ResetPassword(id uint, hash string, resetAt time.Time) error
+}
+
+// SessionRevoker represents a repository in which all of the sessions of a user can be revoked
+type SessionRevoker interface {
+	RevokeUser(userID string, revokedAt time.Time) error
+}
+
+// Mailer represents something that sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// PasswordResets is a service that lets users who forgot their password choose a new one, by
+// sending them a single-use reset token by email
+type PasswordResets struct {
+	ttl       time.Duration
+	resetURL  string
+	maxResets int
+
+	resets   PasswordResetRepository
+	users    PasswordUserRepository
+	sessions SessionRevoker
+	hash     Hasher
+	mailer   Mailer
+
+	// sending is the emails that are being sent
+	sending sync.WaitGroup
+
+	log *zerolog.Logger
+}
+
+// NewPasswordResets creates and configures a PasswordResets service. Reset tokens expire after ttl. If
+// resetURL is set, emails contain a link to it with the token in its query, otherwise only the token.
+// At most maxResets tokens are sent to a user per hour.
+func NewPasswordResets(log *zerolog.Logger, resets PasswordResetRepository, users PasswordUserRepository, sessions SessionRevoker, hash Hasher, mailer Mailer, resetURL string, ttl time.Duration, maxResets int) *PasswordResets {
+	return &PasswordResets{
+		log:       log,
+		resets:    resets,
+		users:     users,
+		sessions:  sessions,
+		hash:      hash,
+		mailer:    mailer,
+		resetURL:  resetURL,
+		ttl:       ttl,
+		maxResets: maxResets,
+	}
+}
+
+// Forgot sends a reset token to the user with the given email address. Nothing is sent to
+// unknown email addresses, to users who can't log in, or once too many tokens were sent
+// recently, but no error is returned either, so that callers can't find out which email
+// addresses have an account. The email is sent in the background, so that the time it takes
+// doesn't reveal it either.
+func (p *PasswordResets) Forgot(email string) error {
+	user, err := p.users.Retrieve(&model.User{Email: email})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		p.log.Debug().Str("email", email).Msg("password reset requested for unknown user")
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve user")
+	}
+
+	if checkStatus(user) != nil {
+		p.log.Debug().Str("user_id", user.TokenUserID).Str("status", string(user.Status)).Msg("password reset requested for inactive user")
+		return nil
+	}
+
+	now := time.Now()
+
+	count, err := p.resets.CountSince(user.TokenUserID, now.Add(-passwordResetRateWindow))
+	if err != nil {
+		return errors.Wrap(err, "could not count password resets")
+	}
+	if count >= p.maxResets {
+		p.log.Warn().Str("user_id", user.TokenUserID).Int("count", count).Msg("too many password resets requested")
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	err = p.resets.Store(&model.PasswordReset{
+		UserID:    user.TokenUserID,
+		Hash:      hashToken(token),
+		ExpiresAt: now.Add(p.ttl),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not store password reset")
+	}
+
+	body, err := p.body(token)
+	if err != nil {
+		return err
+	}
+
+	p.sending.Add(1)
+	go func() {
+		defer p.sending.Done()
+
+		err := p.mailer.Send(user.Email, "Reset your password", body)
+		if err != nil {
+			p.log.Error().Err(err).Str("user_id", user.TokenUserID).Msg("could not send password reset email")
+			return
+		}
+
+		p.log.Info().Str("user_id", user.TokenUserID).Msg("password reset email sent")
+	}()
+
+	return nil
+}
+
+// Wait waits for the password reset emails that are being sent
+func (p *PasswordResets) Wait() {
+	p.sending.Wait()
+}
+
+// Reset replaces the password of the user who was sent the reset token. Their refresh tokens
+// are revoked and the access tokens they already have are refused, so that whoever knew their
+// previous password is logged out.
+func (p *PasswordResets) Reset(token, password string) error {
+	now := time.Now()
+
+	reset, err := p.resets.FindByHash(hashToken(token))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.Wrap(errortype.ErrInvalidToken, "unknown reset token")
+	}
+	if err != nil {
+		return err
+	}
+
+	if reset.UsedAt != nil {
+		return errors.Wrap(errortype.ErrInvalidToken, "reset token was already used")
+	}
+	if !now.Before(reset.ExpiresAt) {
+		return errors.Wrap(errortype.ErrInvalidToken, "reset token is expired")
+	}
+
+	// Marking the reset as used fails if it was used concurrently
+	err = p.resets.MarkUsed(reset.ID, now)
+	if errors.Cause(err) == errortype.ErrConflict {
+		return errors.Wrap(errortype.ErrInvalidToken, "reset token was already used")
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := p.users.Retrieve(&model.User{TokenUserID: reset.UserID})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return errors.Wrap(errortype.ErrInvalidToken, "user not found")
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not retrieve user")
+	}
+
+	// The user might have been deactivated since they asked for the reset
+	if err = checkStatus(user); err != nil {
+		return errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	hash, err := p.hash.Hash(password)
+	if err != nil {
+		return errors.Wrap(err, "could not hash password")
+	}
+
+	err = p.users.ResetPassword(user.ID, hash, now)
+	if err != nil {
+		return errors.Wrap(err, "could not reset password")
+	}
+
+	err = p.sessions.RevokeUser(user.TokenUserID, now)
+	if err != nil {
+		return errors.Wrap(err, "could not revoke sessions")
+	}
+
+	// The other reset tokens the user was sent can't be used anymore
+	err = p.resets.DeleteByUser(user.TokenUserID)
+	if err != nil {
+		p.log.Warn().Err(err).Str("user_id", user.TokenUserID).Msg("could not delete password resets")
+	}
+
+	p.log.Info().Str("user_id", user.TokenUserID).Msg("password reset")
+	return nil
+}
+
+// body writes the email that contains a reset token
+func (p *PasswordResets) body(token string) (string, error) {
+	minutes := int(p.ttl / time.Minute)
+
+	instructions := fmt.Sprintf("Use this token to choose a new password within %d minutes:\n\n%s", minutes, token)
+	if p.resetURL != "" {
+		link, err := url.Parse(p.resetURL)
+		if err != nil {
+			return "", errors.Wrap(err, "invalid password reset URL")
+		}
+		query := link.Query()
+		query.Set("token", token)
+		link.RawQuery = query.Encode()
+
+		instructions = fmt.Sprintf("Follow this link to choose a new password within %d minutes:\n\n%s", minutes, link)
+	}
+
+	return fmt.Sprintf("Hello,\n\nSomeone asked to reset the password of your account. %s\n\nIf you didn't ask for it, you can ignore this email.\n", instructions), nil
+}
@@ -0,0 +1,432 @@
+package service
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MailerMock struct {
+	mock.Mock
+}
+
+func (m *MailerMock) Send(to, subject, body string) error {
+	args := m.Called(to, subject, body)
+	return args.Error(0)
+}
+
+func TestNewPasswordResets(t *testing.T) {
+	resetRepositoryMock := &repo.PasswordResetRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+	hasherMock := &PasswordHasherMock{}
+	mailerMock := &MailerMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	p := NewPasswordResets(log, resetRepositoryMock, userRepositoryMock, refreshTokenRepositoryMock, hasherMock, mailerMock, "https://bloggo.example.com/reset", time.Hour, 5)
+
+	assert.Equal(t, resetRepositoryMock, p.resets, "unexpected password reset repo set")
+	assert.Equal(t, userRepositoryMock, p.users, "unexpected user repo set")
+	assert.Equal(t, refreshTokenRepositoryMock, p.sessions, "unexpected session revoker set")
+	assert.Equal(t, hasherMock, p.hash, "unexpected hasher set")
+	assert.Equal(t, mailerMock, p.mailer, "unexpected mailer set")
+	assert.Equal(t, "https://bloggo.example.com/reset", p.resetURL, "unexpected reset URL set")
+	assert.Equal(t, time.Hour, p.ttl, "unexpected TTL set")
+	assert.Equal(t, 5, p.maxResets, "unexpected rate limit set")
+	assert.Equal(t, log, p.log, "unexpected logger set")
+}
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		description string
+
+		resetURL    string
+		user        *model.User
+		retrieveErr error
+		count       int
+		countErr    error
+		storeErr    error
+		sendErr     error
+
+		expectCount   bool
+		expectStore   bool
+		expectSend    bool
+		expectedLink  string
+		expectedLog   string
+		expectedError error
+	}{
+		{
+			description: "reset token sent",
+
+			user: &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+
+			expectCount: true,
+			expectStore: true,
+			expectSend:  true,
+			expectedLog: "password reset email sent",
+		},
+		{
+			description: "reset link sent",
+
+			resetURL: "https://bloggo.example.com/reset?lang=en",
+			user:     &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+
+			expectCount:  true,
+			expectStore:  true,
+			expectSend:   true,
+			expectedLink: "https://bloggo.example.com/reset?lang=en&token=",
+		},
+		{
+			description: "too many resets requested",
+
+			user:  &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+			count: 5,
+
+			expectCount: true,
+			expectedLog: "too many password resets requested",
+		},
+		{
+			description: "unknown email address",
+
+			retrieveErr: errortype.ErrNotFound,
+		},
+		{
+			description: "deactivated user",
+
+			user: &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusDeactivated},
+		},
+		{
+			description: "user repository error",
+
+			retrieveErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not retrieve user: database exploded"),
+		},
+		{
+			description: "reset count error",
+
+			user:     &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+			countErr: errors.New("database exploded"),
+
+			expectCount:   true,
+			expectedError: errors.New("could not count password resets: database exploded"),
+		},
+		{
+			description: "reset repository error",
+
+			user:     &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+			storeErr: errors.New("database exploded"),
+
+			expectCount:   true,
+			expectStore:   true,
+			expectedError: errors.New("could not store password reset: database exploded"),
+		},
+		{
+			description: "mailer error is logged",
+
+			user:    &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive},
+			sendErr: errors.New("connection refused"),
+
+			expectCount: true,
+			expectStore: true,
+			expectSend:  true,
+			expectedLog: "could not send password reset email",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("Retrieve", &model.User{Email: "pam@dunder-mifflin.com"}).
+				Return(test.user, test.retrieveErr).
+				Once()
+
+			var stored *model.PasswordReset
+			resetRepositoryMock := &repo.PasswordResetRepositoryMock{}
+			if test.expectCount {
+				resetRepositoryMock.
+					On("CountSince", "test", mock.AnythingOfType("time.Time")).
+					Return(test.count, test.countErr).
+					Once()
+			}
+			if test.expectStore {
+				resetRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.PasswordReset")).
+					Return(test.storeErr).
+					Run(func(args mock.Arguments) {
+						stored = args.Get(0).(*model.PasswordReset)
+					}).
+					Once()
+			}
+
+			var body string
+			mailerMock := &MailerMock{}
+			if test.expectSend {
+				mailerMock.
+					On("Send", "pam@dunder-mifflin.com", "Reset your password", mock.AnythingOfType("string")).
+					Return(test.sendErr).
+					Run(func(args mock.Arguments) {
+						body = args.String(2)
+					}).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			p := NewPasswordResets(log, resetRepositoryMock, userRepositoryMock, &repo.RefreshTokenRepositoryMock{}, &PasswordHasherMock{}, mailerMock, test.resetURL, time.Hour, 5)
+
+			err := p.Forgot("pam@dunder-mifflin.com")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			// The email is sent in the background
+			p.Wait()
+			assert.Contains(t, logsBuff.String(), test.expectedLog, "wrong log message")
+
+			if test.expectSend {
+				assert.Equal(t, "test", stored.UserID, "reset should belong to the user")
+				assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt, time.Minute, "wrong expiration date")
+				assert.Contains(t, body, "within 60 minutes", "the email should say when the token expires")
+				assert.Contains(t, body, test.expectedLink, "wrong reset link")
+
+				// Only the hash of the token is stored
+				token := regexp.MustCompile(`[0-9a-f]{64}`).FindString(body)
+				assert.Equal(t, hashToken(token), stored.Hash, "the hash of the emailed token should be stored")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			resetRepositoryMock.AssertExpectations(t)
+			mailerMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	used := time.Now().Add(-time.Minute)
+	activeUser := &model.User{ID: 42, TokenUserID: "test", Email: "pam@dunder-mifflin.com", Status: model.StatusActive}
+
+	tests := []struct {
+		description string
+
+		reset       *model.PasswordReset
+		findErr     error
+		markUsedErr error
+		user        *model.User
+		retrieveErr error
+		hashErr     error
+		updateErr   error
+		revokeErr   error
+		deleteErr   error
+
+		expectMarkUsed bool
+		expectRetrieve bool
+		expectHash     bool
+		expectUpdate   bool
+		expectRevoke   bool
+		expectDelete   bool
+		expectedError  error
+	}{
+		{
+			description: "password reset",
+
+			reset: &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:  activeUser,
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectRevoke:   true,
+			expectDelete:   true,
+		},
+		{
+			description: "other resets can't be deleted",
+
+			reset:     &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:      activeUser,
+			deleteErr: errors.New("database exploded"),
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectRevoke:   true,
+			expectDelete:   true,
+		},
+		{
+			description: "unknown token",
+
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("unknown reset token: invalid token"),
+		},
+		{
+			description: "token already used",
+
+			reset: &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &used},
+
+			expectedError: errors.New("reset token was already used: invalid token"),
+		},
+		{
+			description: "expired token",
+
+			reset: &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(-time.Minute)},
+
+			expectedError: errors.New("reset token is expired: invalid token"),
+		},
+		{
+			description: "token used concurrently",
+
+			reset:       &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			markUsedErr: errortype.ErrConflict,
+
+			expectMarkUsed: true,
+			expectedError:  errors.New("reset token was already used: invalid token"),
+		},
+		{
+			description: "user was deactivated since they asked for the reset",
+
+			reset: &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:  &model.User{ID: 42, TokenUserID: "test", Status: model.StatusDeactivated},
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectedError:  errors.New("account is deactivated: forbidden: invalid token"),
+		},
+		{
+			description: "user was deleted",
+
+			reset:       &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			retrieveErr: errortype.ErrNotFound,
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectedError:  errors.New("user not found: invalid token"),
+		},
+		{
+			description: "hashing error",
+
+			reset:   &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:    activeUser,
+			hashErr: errors.New("out of entropy"),
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectHash:     true,
+			expectedError:  errors.New("could not hash password: out of entropy"),
+		},
+		{
+			description: "user repository error",
+
+			reset:     &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:      activeUser,
+			updateErr: errors.New("database exploded"),
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectedError:  errors.New("could not reset password: database exploded"),
+		},
+		{
+			description: "sessions can't be revoked",
+
+			reset:     &model.PasswordReset{ID: 1, UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:      activeUser,
+			revokeErr: errors.New("database exploded"),
+
+			expectMarkUsed: true,
+			expectRetrieve: true,
+			expectHash:     true,
+			expectUpdate:   true,
+			expectRevoke:   true,
+			expectedError:  errors.New("could not revoke sessions: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			resetRepositoryMock := &repo.PasswordResetRepositoryMock{}
+			resetRepositoryMock.
+				On("FindByHash", hashToken("resettoken")).
+				Return(test.reset, test.findErr).
+				Once()
+			if test.expectMarkUsed {
+				resetRepositoryMock.
+					On("MarkUsed", uint(1), mock.AnythingOfType("time.Time")).
+					Return(test.markUsedErr).
+					Once()
+			}
+			if test.expectDelete {
+				resetRepositoryMock.
+					On("DeleteByUser", "test").
+					Return(test.deleteErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.expectRetrieve {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.retrieveErr).
+					Once()
+			}
+			if test.expectUpdate {
+				userRepositoryMock.
+					On("ResetPassword", uint(42), "newhash", mock.AnythingOfType("time.Time")).
+					Return(test.updateErr).
+					Once()
+			}
+
+			hasherMock := &PasswordHasherMock{}
+			if test.expectHash {
+				hasherMock.
+					On("Hash", "halpert1234").
+					Return("newhash", test.hashErr).
+					Once()
+			}
+
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			if test.expectRevoke {
+				refreshTokenRepositoryMock.
+					On("RevokeUser", "test", mock.AnythingOfType("time.Time")).
+					Return(test.revokeErr).
+					Once()
+			}
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			p := NewPasswordResets(log, resetRepositoryMock, userRepositoryMock, refreshTokenRepositoryMock, hasherMock, &MailerMock{}, "", time.Hour, 5)
+
+			err := p.Reset("resettoken", "halpert1234")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			resetRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			hasherMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// touchInterval is how often the last use of a personal access token or a session is saved
+// at most, so that tokens used by every request don't cause as many writes
+const touchInterval = time.Minute
+
+// PersonalTokenRepository represents a repository in which personal access tokens are stored
+type PersonalTokenRepository interface {
+	Store(token *model.PersonalAccessToken) error
+	FindByHash(hash string) (*model.PersonalAccessToken, error)
+	FindByUser(userID string) ([]*model.PersonalAccessToken, error)
+	Delete(id uint, userID string) error
+	Touch(id uint, usedAt time.Time) error
+}
+
+// PersonalTokens is a service that manages and verifies personal access tokens
+type PersonalTokens struct {
+	tokens PersonalTokenRepository
+	users  UserRepository
+
+	log *zerolog.Logger
+}
+
+// NewPersonalTokens creates and configures a PersonalTokens service
+func NewPersonalTokens(log *zerolog.Logger, tokens PersonalTokenRepository, users UserRepository) *PersonalTokens {
+	return &PersonalTokens{
+		tokens: tokens,
+		users:  users,
+
+		log: log,
+	}
+}
+
+// Create creates a personal access token for the owner. Like access tokens, personal access
+// tokens can only be granted scopes of the role of their owner.
+func (p *PersonalTokens) Create(owner *model.User, name string, scopes []model.Scope, expiresAt *time.Time) (*model.PersonalAccessToken, error) {
+	for _, scope := range scopes {
+		if !model.HasScope(owner.Role.Scopes(), scope) {
+			return nil, errors.Wrapf(errortype.ErrInvalidScope, "scope %s can't be granted to %s users", scope, owner.Role)
+		}
+	}
+
+	now := time.Now()
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, errors.Wrap(errortype.ErrUnprocessableEntity, "expiration date is in the past")
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &model.PersonalAccessToken{
+		UserID:    owner.TokenUserID,
+		Name:      name,
+		Scope:     model.FormatScopes(scopes),
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+
+		Token: model.PersonalTokenPrefix + secret,
+	}
+	token.Hash = hashToken(token.Token)
+
+	err = p.tokens.Store(token)
+	if err != nil {
+		return nil, err
+	}
+
+	p.log.Info().Str("user_id", owner.TokenUserID).Uint("id", token.ID).Str("scope", token.Scope).Msg("personal access token created")
+	return token, nil
+}
+
+// List returns the personal access tokens of a user
+func (p *PersonalTokens) List(userID string) ([]*model.PersonalAccessToken, error) {
+	return p.tokens.FindByUser(userID)
+}
+
+// Revoke deletes a personal access token of a user
+func (p *PersonalTokens) Revoke(userID string, id uint) error {
+	err := p.tokens.Delete(id, userID)
+	if err != nil {
+		return err
+	}
+
+	p.log.Info().Str("user_id", userID).Uint("id", id).Msg("personal access token revoked")
+	return nil
+}
+
+// ValidateToken returns the user that owns a personal access token along with
+// the scopes that the token grants, and records that the token was used.
+func (p *PersonalTokens) ValidateToken(token string) (*model.Principal, error) {
+	now := time.Now()
+
+	stored, err := p.tokens.FindByHash(hashToken(token))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, errors.New("unknown personal access token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.ExpiresAt != nil && !now.Before(*stored.ExpiresAt) {
+		return nil, errors.New("personal access token is expired")
+	}
+
+	user, err := p.users.Retrieve(&model.User{TokenUserID: stored.UserID})
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkStatus(user)
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) > touchInterval {
+		err = p.tokens.Touch(stored.ID, now)
+		if err != nil {
+			// The token can still be used
+			p.log.Warn().Err(err).Uint("id", stored.ID).Msg("could not record personal access token use")
+		}
+	}
+
+	principal := &model.Principal{
+		User:            user,
+		Scopes:          restrictScopes(model.ParseScopes(stored.Scope), user.Role),
+		PersonalTokenID: stored.ID,
+	}
+	if stored.ExpiresAt != nil {
+		principal.ExpiresAt = *stored.ExpiresAt
+	}
+
+	return principal, nil
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewPersonalTokens(t *testing.T) {
+	personalTokenRepositoryMock := &repo.PersonalTokenRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	p := NewPersonalTokens(log, personalTokenRepositoryMock, userRepositoryMock)
+
+	assert.Equal(t, personalTokenRepositoryMock, p.tokens, "unexpected personal token repo set")
+	assert.Equal(t, userRepositoryMock, p.users, "unexpected user repo set")
+	assert.Equal(t, log, p.log, "unexpected logger set")
+}
+
+func TestCreatePersonalToken(t *testing.T) {
+	future := time.Now().Add(24 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		description string
+
+		role      model.Role
+		scopes    []model.Scope
+		expiresAt *time.Time
+		storeErr  error
+
+		expectedError error
+	}{
+		{
+			description: "token created",
+
+			role:   model.RoleAuthor,
+			scopes: []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+		},
+		{
+			description: "token with an expiration date",
+
+			role:      model.RoleAuthor,
+			scopes:    []model.Scope{model.ScopePostsWrite},
+			expiresAt: &future,
+		},
+		{
+			description: "scope not granted to the role",
+
+			role:   model.RoleAuthor,
+			scopes: []model.Scope{model.ScopePostsWrite, model.ScopeUsersAdmin},
+
+			expectedError: errors.New("scope users:admin can't be granted to author users: invalid scope"),
+		},
+		{
+			description: "expiration date in the past",
+
+			role:      model.RoleAuthor,
+			scopes:    []model.Scope{model.ScopePostsWrite},
+			expiresAt: &past,
+
+			expectedError: errors.New("expiration date is in the past: unprocessable entity"),
+		},
+		{
+			description: "repository error",
+
+			role:     model.RoleAuthor,
+			scopes:   []model.Scope{model.ScopePostsWrite},
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			var stored *model.PersonalAccessToken
+			personalTokenRepositoryMock := &repo.PersonalTokenRepositoryMock{}
+			if test.expectedError == nil || test.storeErr != nil {
+				personalTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.PersonalAccessToken")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.PersonalAccessToken) }).
+					Return(test.storeErr).
+					Once()
+			}
+
+			p := &PersonalTokens{
+				tokens: personalTokenRepositoryMock,
+
+				log: log,
+			}
+
+			owner := &model.User{TokenUserID: "test", Role: test.role}
+			token, err := p.Create(owner, "release-bot", test.scopes, test.expiresAt)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.True(t, strings.HasPrefix(token.Token, model.PersonalTokenPrefix), "token should be prefixed")
+				assert.Len(t, token.Token, len(model.PersonalTokenPrefix)+64, "wrong token length")
+				assert.Equal(t, "release-bot", token.Name, "wrong name")
+				assert.Equal(t, model.FormatScopes(test.scopes), token.Scope, "wrong scope")
+				assert.Equal(t, test.expiresAt, token.ExpiresAt, "wrong expiration date")
+
+				// Only the hash of the token is stored
+				assert.Equal(t, stored, token, "the stored token should be returned")
+				assert.Equal(t, hashToken(token.Token), stored.Hash, "wrong hash stored")
+				assert.Equal(t, "test", stored.UserID, "wrong owner stored")
+			}
+
+			personalTokenRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListPersonalTokens(t *testing.T) {
+	tokens := []*model.PersonalAccessToken{{ID: 2, Name: "release-bot"}, {ID: 1, Name: "backup"}}
+
+	personalTokenRepositoryMock := &repo.PersonalTokenRepositoryMock{}
+	personalTokenRepositoryMock.On("FindByUser", "test").Return(tokens, nil).Once()
+
+	p := &PersonalTokens{
+		tokens: personalTokenRepositoryMock,
+
+		log: logger.NewZeroLog(&bytes.Buffer{}),
+	}
+
+	listed, err := p.List("test")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, tokens, listed, "wrong tokens listed")
+
+	personalTokenRepositoryMock.AssertExpectations(t)
+}
+
+func TestRevokePersonalToken(t *testing.T) {
+	personalTokenRepositoryMock := &repo.PersonalTokenRepositoryMock{}
+	personalTokenRepositoryMock.On("Delete", uint(1), "test").Return(nil).Once()
+	personalTokenRepositoryMock.On("Delete", uint(2), "test").Return(errortype.ErrNotFound).Once()
+
+	p := &PersonalTokens{
+		tokens: personalTokenRepositoryMock,
+
+		log: logger.NewZeroLog(&bytes.Buffer{}),
+	}
+
+	assert.NoError(t, p.Revoke("test", 1), "unexpected error")
+	assert.Equal(t, errortype.ErrNotFound, errors.Cause(p.Revoke("test", 2)), "expected a not found error")
+
+	personalTokenRepositoryMock.AssertExpectations(t)
+}
+
+func TestValidatePersonalToken(t *testing.T) {
+	token := model.PersonalTokenPrefix + "fakeToken"
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	recently := time.Now().Add(-10 * time.Second)
+
+	tests := []struct {
+		description string
+
+		stored   *model.PersonalAccessToken
+		findErr  error
+		user     *model.User
+		userErr  error
+		touchErr error
+
+		expectTouch    bool
+		expectedScopes []model.Scope
+		expectedError  error
+	}{
+		{
+			description: "valid token",
+
+			stored: &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:read posts:write"},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+
+			expectTouch:    true,
+			expectedScopes: []model.Scope{model.ScopePostsRead, model.ScopePostsWrite},
+		},
+		{
+			description: "valid token used recently",
+
+			stored: &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:write", ExpiresAt: &future, LastUsedAt: &recently},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+
+			expectedScopes: []model.Scope{model.ScopePostsWrite},
+		},
+		{
+			description: "use can't be recorded",
+
+			stored:   &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:write"},
+			user:     &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+			touchErr: errors.New("database exploded"),
+
+			expectTouch:    true,
+			expectedScopes: []model.Scope{model.ScopePostsWrite},
+		},
+		{
+			description: "user was demoted after the token was created",
+
+			stored: &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:read posts:write"},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleReader},
+
+			expectTouch:    true,
+			expectedScopes: []model.Scope{model.ScopePostsRead},
+		},
+		{
+			description: "unknown token",
+
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("unknown personal access token"),
+		},
+		{
+			description: "repository error",
+
+			findErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+		{
+			description: "expired token",
+
+			stored: &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:write", ExpiresAt: &past},
+
+			expectedError: errors.New("personal access token is expired"),
+		},
+		{
+			description: "owner was deactivated",
+
+			stored: &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:write"},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor, Status: model.StatusDeactivated},
+
+			expectedError: errors.New("account is deactivated: forbidden"),
+		},
+		{
+			description: "owner doesn't exist anymore",
+
+			stored:  &model.PersonalAccessToken{ID: 1, UserID: "test", Scope: "posts:write"},
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errortype.ErrNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			personalTokenRepositoryMock := &repo.PersonalTokenRepositoryMock{}
+			personalTokenRepositoryMock.
+				On("FindByHash", hashToken(token)).
+				Return(test.stored, test.findErr).
+				Once()
+			if test.expectTouch {
+				personalTokenRepositoryMock.
+					On("Touch", uint(1), mock.AnythingOfType("time.Time")).
+					Return(test.touchErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.user != nil || test.userErr != nil {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.userErr).
+					Once()
+			}
+
+			p := &PersonalTokens{
+				tokens: personalTokenRepositoryMock,
+				users:  userRepositoryMock,
+
+				log: log,
+			}
+
+			principal, err := p.ValidateToken(token)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.user, principal.User, "wrong user")
+				assert.Equal(t, test.expectedScopes, principal.Scopes, "wrong scopes granted")
+				assert.Equal(t, uint(1), principal.PersonalTokenID, "wrong personal token ID")
+				assert.Empty(t, principal.TokenID, "personal access tokens have no jti")
+			}
+
+			personalTokenRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
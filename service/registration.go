@@ -0,0 +1,102 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+)
+
+// RegistrationMode represents who can create an account
+type RegistrationMode string
+
+// Registration modes
+const (
+	// RegistrationOpen lets anyone create an account
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationClosed prevents anyone from creating an account, even with an invitation
+	RegistrationClosed RegistrationMode = "closed"
+	// RegistrationInviteOnly only lets invited users create an account
+	RegistrationInviteOnly RegistrationMode = "invite_only"
+	// RegistrationDomainAllowlist only lets invited users, and users whose email
+	// address belongs to one of the allowed domains, create an account
+	RegistrationDomainAllowlist RegistrationMode = "domain_allowlist"
+)
+
+// Registration is the policy that decides who can create an account, and whether new accounts
+// have to be approved by an admin. It applies to registrations, invitations and users who log
+// in with an identity provider for the first time. Admins created by the setup are not affected.
+type Registration struct {
+	mode     RegistrationMode
+	domains  []string
+	approval bool
+}
+
+// NewRegistration creates a registration policy. The domains are only used by the domain_allowlist
+// mode, and new accounts have to be approved by an admin if approval is set, unless they were invited.
+func NewRegistration(mode RegistrationMode, domains []string, approval bool) *Registration {
+	return &Registration{
+		mode:     mode,
+		domains:  domains,
+		approval: approval,
+	}
+}
+
+// Check returns ErrForbidden if an account can't be created for the email address. Invited
+// users were chosen by an admin, so their email address doesn't have to be in an allowed domain.
+func (r *Registration) Check(email string, invited bool) error {
+	switch r.mode {
+	case RegistrationOpen:
+		return nil
+	case RegistrationClosed:
+		return errors.Wrap(errortype.ErrForbidden, "registration is closed")
+	case RegistrationInviteOnly:
+		if !invited {
+			return errors.Wrap(errortype.ErrForbidden, "registration requires an invitation")
+		}
+		return nil
+	case RegistrationDomainAllowlist:
+		if invited || r.allowed(email) {
+			return nil
+		}
+		return errors.Wrap(errortype.ErrForbidden, "registration is not allowed for this email domain")
+	default:
+		return errors.Wrapf(errortype.ErrForbidden, "unknown registration mode %q", r.mode)
+	}
+}
+
+// CheckEmail returns ErrForbidden if users can't move their account to the email address. Closed
+// and invite-only registrations don't concern existing accounts, but an account can't be moved to
+// a domain that isn't allowed.
+func (r *Registration) CheckEmail(email string) error {
+	if r.mode == RegistrationDomainAllowlist && !r.allowed(email) {
+		return errors.Wrap(errortype.ErrForbidden, "email addresses of this domain are not allowed")
+	}
+	return nil
+}
+
+// Status returns the status of new accounts that were not invited
+func (r *Registration) Status() model.UserStatus {
+	if r.approval {
+		return model.StatusPending
+	}
+	return model.StatusActive
+}
+
+// allowed returns true if the domain of the email address is one of the allowed domains
+func (r *Registration) allowed(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range r.domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
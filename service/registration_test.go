@@ -0,0 +1,181 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegistration(t *testing.T) {
+	r := NewRegistration(RegistrationDomainAllowlist, []string{"vance-refrigeration.com"}, true)
+
+	assert.Equal(t, RegistrationDomainAllowlist, r.mode, "unexpected mode set")
+	assert.Equal(t, []string{"vance-refrigeration.com"}, r.domains, "unexpected domains set")
+	assert.True(t, r.approval, "unexpected approval set")
+}
+
+func TestRegistrationCheck(t *testing.T) {
+	tests := []struct {
+		description string
+
+		mode    RegistrationMode
+		email   string
+		invited bool
+
+		expectedError error
+	}{
+		{
+			description: "open registration",
+
+			mode:  RegistrationOpen,
+			email: "stanley@dunder-mifflin.com",
+		},
+		{
+			description: "closed registration",
+
+			mode:  RegistrationClosed,
+			email: "bob@vance-refrigeration.com",
+
+			expectedError: errors.New("registration is closed: forbidden"),
+		},
+		{
+			description: "closed registration with an invitation",
+
+			mode:    RegistrationClosed,
+			email:   "bob@vance-refrigeration.com",
+			invited: true,
+
+			expectedError: errors.New("registration is closed: forbidden"),
+		},
+		{
+			description: "invite only registration without invitation",
+
+			mode:  RegistrationInviteOnly,
+			email: "bob@vance-refrigeration.com",
+
+			expectedError: errors.New("registration requires an invitation: forbidden"),
+		},
+		{
+			description: "invite only registration with an invitation",
+
+			mode:    RegistrationInviteOnly,
+			email:   "bob@vance-refrigeration.com",
+			invited: true,
+		},
+		{
+			description: "allowed domain",
+
+			mode:  RegistrationDomainAllowlist,
+			email: "Phyllis@Vance-Refrigeration.com",
+		},
+		{
+			description: "domain not allowed",
+
+			mode:  RegistrationDomainAllowlist,
+			email: "stanley@dunder-mifflin.com",
+
+			expectedError: errors.New("registration is not allowed for this email domain: forbidden"),
+		},
+		{
+			description: "subdomain of an allowed domain",
+
+			mode:  RegistrationDomainAllowlist,
+			email: "stanley@evil.vance-refrigeration.com",
+
+			expectedError: errors.New("registration is not allowed for this email domain: forbidden"),
+		},
+		{
+			description: "domain not allowed with an invitation",
+
+			mode:    RegistrationDomainAllowlist,
+			email:   "stanley@dunder-mifflin.com",
+			invited: true,
+		},
+		{
+			description: "unknown mode",
+
+			mode:  "potato",
+			email: "bob@vance-refrigeration.com",
+
+			expectedError: errors.New(`unknown registration mode "potato": forbidden`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := NewRegistration(test.mode, []string{"vance-refrigeration.com"}, false)
+
+			err := r.Check(test.email, test.invited)
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
+
+func TestRegistrationCheckEmail(t *testing.T) {
+	tests := []struct {
+		description string
+
+		mode  RegistrationMode
+		email string
+
+		expectedError error
+	}{
+		{
+			description: "open registration",
+
+			mode:  RegistrationOpen,
+			email: "stanley@dunder-mifflin.com",
+		},
+		{
+			description: "closed registration",
+
+			mode:  RegistrationClosed,
+			email: "stanley@dunder-mifflin.com",
+		},
+		{
+			description: "invite only registration",
+
+			mode:  RegistrationInviteOnly,
+			email: "stanley@dunder-mifflin.com",
+		},
+		{
+			description: "allowed domain",
+
+			mode:  RegistrationDomainAllowlist,
+			email: "Phyllis@Vance-Refrigeration.com",
+		},
+		{
+			description: "domain not allowed",
+
+			mode:  RegistrationDomainAllowlist,
+			email: "stanley@dunder-mifflin.com",
+
+			expectedError: errors.New("email addresses of this domain are not allowed: forbidden"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := NewRegistration(test.mode, []string{"vance-refrigeration.com"}, false)
+
+			err := r.CheckEmail(test.email)
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+		})
+	}
+}
+
+func TestRegistrationStatus(t *testing.T) {
+	assert.Equal(t, model.StatusActive, NewRegistration(RegistrationOpen, nil, false).Status(), "new accounts should be active")
+	assert.Equal(t, model.StatusPending, NewRegistration(RegistrationOpen, nil, true).Status(), "new accounts should wait for approval")
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// RevokedTokenRepository represents a repository in which revoked access tokens are stored
+type RevokedTokenRepository interface {
+	Store(token *model.RevokedToken) error
+	FindActive(now time.Time) ([]*model.RevokedToken, error)
+	DeleteExpired(now time.Time) error
+}
+
+// Revocations is a service that keeps track of the access tokens that were revoked before
+// they expired. Since every authenticated request needs to be checked, revoked tokens are
+// cached in memory, and reloaded from the repository once the cache is older than its TTL
+// so that tokens revoked by other instances of Bloggo are eventually rejected too.
+type Revocations struct {
+	tokens RevokedTokenRepository
+	ttl    time.Duration
+
+	mutex    sync.RWMutex
+	revoked  map[string]time.Time
+	loadedAt time.Time
+
+	log *zerolog.Logger
+}
+
+// NewRevocations creates a Revocations service that caches revoked tokens for the given duration
+func NewRevocations(log *zerolog.Logger, revokedTokenRepository RevokedTokenRepository, ttl time.Duration) *Revocations {
+	return &Revocations{
+		tokens: revokedTokenRepository,
+		ttl:    ttl,
+
+		revoked: make(map[string]time.Time),
+
+		log: log,
+	}
+}
+
+// Revoke revokes the access token with the given jti until it expires
+func (r *Revocations) Revoke(jti string, expiresAt time.Time) error {
+	err := r.tokens.Store(&model.RevokedToken{JTI: jti, ExpiresAt: expiresAt})
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	r.revoked[jti] = expiresAt
+	r.mutex.Unlock()
+
+	r.log.Debug().Str("jti", jti).Msg("access token revoked")
+	return nil
+}
+
+// IsRevoked returns whether or not the access token with the given jti was revoked
+func (r *Revocations) IsRevoked(jti string) (bool, error) {
+	now := time.Now()
+
+	r.mutex.RLock()
+	stale := now.Sub(r.loadedAt) > r.ttl
+	_, revoked := r.revoked[jti]
+	r.mutex.RUnlock()
+
+	if !stale {
+		return revoked, nil
+	}
+
+	err := r.reload(now)
+	if err != nil {
+		return false, err
+	}
+
+	r.mutex.RLock()
+	_, revoked = r.revoked[jti]
+	r.mutex.RUnlock()
+
+	return revoked, nil
+}
+
+// reload replaces the cache with the revoked tokens of the repository, unless
+// another request already did it in the meantime
+func (r *Revocations) reload(now time.Time) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if now.Sub(r.loadedAt) <= r.ttl {
+		return nil
+	}
+
+	// Expired tokens don't need to be revoked anymore
+	err := r.tokens.DeleteExpired(now)
+	if err != nil {
+		r.log.Warn().Err(err).Msg("could not delete expired revoked tokens")
+	}
+
+	tokens, err := r.tokens.FindActive(now)
+	if err != nil {
+		return errors.Wrap(err, "could not load revoked tokens")
+	}
+
+	r.revoked = make(map[string]time.Time, len(tokens))
+	for _, token := range tokens {
+		r.revoked[token.JTI] = token.ExpiresAt
+	}
+	r.loadedAt = now
+
+	return nil
+}
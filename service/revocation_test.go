@@ -0,0 +1,181 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewRevocations(t *testing.T) {
+	revokedTokenRepositoryMock := &repo.RevokedTokenRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	r := NewRevocations(log, revokedTokenRepositoryMock, time.Minute)
+
+	assert.Equal(t, revokedTokenRepositoryMock, r.tokens, "unexpected revoked token repo set")
+	assert.Equal(t, time.Minute, r.ttl, "unexpected TTL set")
+	assert.Equal(t, log, r.log, "unexpected logger set")
+	assert.NotNil(t, r.revoked, "cache should be initialized")
+}
+
+func TestRevoke(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		description string
+
+		storeErr error
+
+		expectRevoked bool
+		expectedError error
+	}{
+		{
+			description: "token revoked",
+
+			expectRevoked: true,
+		},
+		{
+			description: "repository error",
+
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			revokedTokenRepositoryMock := &repo.RevokedTokenRepositoryMock{}
+			revokedTokenRepositoryMock.
+				On("Store", &model.RevokedToken{JTI: "fakeJTI", ExpiresAt: expiresAt}).
+				Return(test.storeErr).
+				Once()
+
+			r := NewRevocations(log, revokedTokenRepositoryMock, time.Minute)
+
+			// The cache is fresh, so the repository isn't queried
+			r.loadedAt = time.Now()
+
+			err := r.Revoke("fakeJTI", expiresAt)
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			revoked, err := r.IsRevoked("fakeJTI")
+			assert.NoError(t, err, "unexpected error")
+			assert.Equal(t, test.expectRevoked, revoked, "wrong revocation status")
+
+			revokedTokenRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIsRevoked(t *testing.T) {
+	tests := []struct {
+		description string
+
+		cached    map[string]time.Time
+		loadedAt  time.Time
+		stored    []*model.RevokedToken
+		deleteErr error
+		findErr   error
+
+		expectReload  bool
+		expectRevoked bool
+		expectedError error
+	}{
+		{
+			description: "revoked token in fresh cache",
+
+			cached:   map[string]time.Time{"fakeJTI": time.Now().Add(time.Minute)},
+			loadedAt: time.Now(),
+
+			expectRevoked: true,
+		},
+		{
+			description: "valid token in fresh cache",
+
+			cached:   map[string]time.Time{"otherJTI": time.Now().Add(time.Minute)},
+			loadedAt: time.Now(),
+		},
+		{
+			description: "stale cache is reloaded",
+
+			cached:   map[string]time.Time{},
+			loadedAt: time.Now().Add(-time.Hour),
+			stored:   []*model.RevokedToken{{JTI: "fakeJTI", ExpiresAt: time.Now().Add(time.Minute)}},
+
+			expectReload:  true,
+			expectRevoked: true,
+		},
+		{
+			description: "expired tokens can't be deleted",
+
+			cached:    map[string]time.Time{},
+			loadedAt:  time.Now().Add(-time.Hour),
+			stored:    []*model.RevokedToken{{JTI: "fakeJTI", ExpiresAt: time.Now().Add(time.Minute)}},
+			deleteErr: errors.New("database exploded"),
+
+			expectReload:  true,
+			expectRevoked: true,
+		},
+		{
+			description: "stale cache can't be reloaded",
+
+			cached:   map[string]time.Time{},
+			loadedAt: time.Now().Add(-time.Hour),
+			findErr:  errors.New("database exploded"),
+
+			expectReload:  true,
+			expectedError: errors.New("could not load revoked tokens: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			revokedTokenRepositoryMock := &repo.RevokedTokenRepositoryMock{}
+			if test.expectReload {
+				revokedTokenRepositoryMock.
+					On("DeleteExpired", mock.AnythingOfType("time.Time")).
+					Return(test.deleteErr).
+					Once()
+				revokedTokenRepositoryMock.
+					On("FindActive", mock.AnythingOfType("time.Time")).
+					Return(test.stored, test.findErr).
+					Once()
+			}
+
+			r := NewRevocations(log, revokedTokenRepositoryMock, time.Minute)
+			r.revoked = test.cached
+			r.loadedAt = test.loadedAt
+
+			revoked, err := r.IsRevoked("fakeJTI")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Equal(t, test.expectRevoked, revoked, "wrong revocation status")
+			}
+
+			revokedTokenRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
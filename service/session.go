@@ -0,0 +1,105 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// SessionRepository represents a repository in which the sessions of users can be listed and revoked
+type SessionRepository interface {
+	Find(id string) (*model.Session, error)
+	ListActive(userID string, since time.Time) ([]*model.Session, error)
+	Revoke(id string, revokedAt time.Time) error
+	RevokeOthers(userID, id string, revokedAt time.Time) error
+}
+
+// LoginHistoryRepository represents a repository in which the login attempts of users are stored
+type LoginHistoryRepository interface {
+	List(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error)
+}
+
+// Sessions is a service that lets users see where they are logged in, log out other
+// devices and look at their login history
+type Sessions struct {
+	sessions SessionRepository
+	events   LoginHistoryRepository
+	users    UserFinder
+
+	// ttl is how long a session stays active without being used, which
+	// is the lifetime of its refresh tokens
+	ttl time.Duration
+
+	log *zerolog.Logger
+}
+
+// NewSessions creates and configures a Sessions service
+func NewSessions(log *zerolog.Logger, sessions SessionRepository, events LoginHistoryRepository, users UserFinder, ttl time.Duration) *Sessions {
+	return &Sessions{
+		sessions: sessions,
+		events:   events,
+		users:    users,
+		ttl:      ttl,
+
+		log: log,
+	}
+}
+
+// List returns the active sessions of a user, most recently used first. Sessions that were
+// not used for longer than the lifetime of refresh tokens can't be resumed, so they are left out.
+func (s *Sessions) List(userID string) ([]*model.Session, error) {
+	return s.sessions.ListActive(userID, time.Now().Add(-s.ttl))
+}
+
+// Revoke logs out a session of a user. ErrNotFound is returned if the session belongs
+// to another user, so that the sessions of other users can't be found out.
+func (s *Sessions) Revoke(userID, id string) error {
+	session, err := s.sessions.Find(id)
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != userID || session.RevokedAt != nil {
+		return errortype.ErrNotFound
+	}
+
+	err = s.sessions.Revoke(id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.log.Info().Str("user_id", userID).Str("session_id", id).Msg("session revoked")
+	return nil
+}
+
+// RevokeOthers logs out the sessions of a user but the one with the given id, for
+// example after they changed their password from it
+func (s *Sessions) RevokeOthers(userID, id string) error {
+	err := s.sessions.RevokeOthers(userID, id, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.log.Info().Str("user_id", userID).Str("session_id", id).Msg("other sessions revoked")
+	return nil
+}
+
+// History returns a page of the login attempts of a user, newest first, along with their total count
+func (s *Sessions) History(userID string, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	return s.events.List(userID, offset, limit)
+}
+
+// UserHistory returns a page of the login attempts of a user from their id, newest first,
+// along with their total count
+func (s *Sessions) UserHistory(id uint, offset, limit uint) ([]*model.LoginEvent, uint, error) {
+	user, err := s.users.FindByID(id)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "could not retrieve user id %d", id)
+	}
+
+	return s.events.List(user.TokenUserID, offset, limit)
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewSessions(t *testing.T) {
+	sessionRepositoryMock := &repo.SessionRepositoryMock{}
+	loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+	userRepositoryMock := &repo.UserRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	s := NewSessions(log, sessionRepositoryMock, loginEventRepositoryMock, userRepositoryMock, 24*time.Hour)
+
+	assert.Equal(t, sessionRepositoryMock, s.sessions, "unexpected session repo set")
+	assert.Equal(t, loginEventRepositoryMock, s.events, "unexpected login event repo set")
+	assert.Equal(t, userRepositoryMock, s.users, "unexpected user repo set")
+	assert.Equal(t, 24*time.Hour, s.ttl, "unexpected ttl set")
+	assert.Equal(t, log, s.log, "unexpected logger set")
+}
+
+func TestListSessions(t *testing.T) {
+	sessions := []*model.Session{{ID: "b", UserID: "test"}, {ID: "a", UserID: "test"}}
+
+	var since time.Time
+	sessionRepositoryMock := &repo.SessionRepositoryMock{}
+	sessionRepositoryMock.
+		On("ListActive", "test", mock.AnythingOfType("time.Time")).
+		Run(func(args mock.Arguments) { since = args.Get(1).(time.Time) }).
+		Return(sessions, nil).
+		Once()
+
+	s := &Sessions{
+		sessions: sessionRepositoryMock,
+		ttl:      24 * time.Hour,
+
+		log: logger.NewZeroLog(&bytes.Buffer{}),
+	}
+
+	listed, err := s.List("test")
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, sessions, listed, "wrong sessions listed")
+
+	// Sessions that were not used within the lifetime of refresh tokens are left out
+	assert.WithinDuration(t, time.Now().Add(-24*time.Hour), since, time.Second, "wrong activity cutoff")
+
+	sessionRepositoryMock.AssertExpectations(t)
+}
+
+func TestRevokeSession(t *testing.T) {
+	revokedAt := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		description string
+
+		session   *model.Session
+		findErr   error
+		revokeErr error
+
+		expectRevoke  bool
+		expectedError error
+	}{
+		{
+			description: "session revoked",
+
+			session: &model.Session{ID: "a", UserID: "test"},
+
+			expectRevoke: true,
+		},
+		{
+			description: "unknown session",
+
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errortype.ErrNotFound,
+		},
+		{
+			description: "session of another user",
+
+			session: &model.Session{ID: "a", UserID: "someone-else"},
+
+			expectedError: errortype.ErrNotFound,
+		},
+		{
+			description: "session already revoked",
+
+			session: &model.Session{ID: "a", UserID: "test", RevokedAt: &revokedAt},
+
+			expectedError: errortype.ErrNotFound,
+		},
+		{
+			description: "repository error",
+
+			session:   &model.Session{ID: "a", UserID: "test"},
+			revokeErr: errors.New("database exploded"),
+
+			expectRevoke:  true,
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			sessionRepositoryMock.On("Find", "a").Return(test.session, test.findErr).Once()
+			if test.expectRevoke {
+				sessionRepositoryMock.
+					On("Revoke", "a", mock.AnythingOfType("time.Time")).
+					Return(test.revokeErr).
+					Once()
+			}
+
+			s := &Sessions{
+				sessions: sessionRepositoryMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err := s.Revoke("test", "a")
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			sessionRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	tests := []struct {
+		description string
+
+		revokeErr error
+
+		expectedError error
+	}{
+		{
+			description: "other sessions revoked",
+		},
+		{
+			description: "repository error",
+
+			revokeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			sessionRepositoryMock.
+				On("RevokeOthers", "test", "a", mock.AnythingOfType("time.Time")).
+				Return(test.revokeErr).
+				Once()
+
+			s := &Sessions{
+				sessions: sessionRepositoryMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			err := s.RevokeOthers("test", "a")
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			sessionRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	events := []*model.LoginEvent{{ID: 2, Success: true}, {ID: 1, Reason: "invalid password"}}
+
+	loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+	loginEventRepositoryMock.On("List", "test", uint(20), uint(10)).Return(events, uint(22), nil).Once()
+
+	s := &Sessions{
+		events: loginEventRepositoryMock,
+
+		log: logger.NewZeroLog(&bytes.Buffer{}),
+	}
+
+	listed, total, err := s.History("test", 20, 10)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, events, listed, "wrong events listed")
+	assert.Equal(t, uint(22), total, "wrong total count")
+
+	loginEventRepositoryMock.AssertExpectations(t)
+}
+
+func TestUserLoginHistory(t *testing.T) {
+	events := []*model.LoginEvent{{ID: 1, Success: true}}
+
+	tests := []struct {
+		description string
+
+		id      uint
+		user    *model.User
+		userErr error
+
+		expectList    bool
+		expectedError error
+	}{
+		{
+			description: "history listed",
+
+			id:   42,
+			user: &model.User{ID: 42, TokenUserID: "test"},
+
+			expectList: true,
+		},
+		{
+			description: "unknown user",
+
+			id:      42,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 42: resource not found"),
+		},
+		{
+			description: "user id 0",
+
+			id:      0,
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("could not retrieve user id 0: resource not found"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.On("FindByID", test.id).Return(test.user, test.userErr).Once()
+
+			loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+			if test.expectList {
+				loginEventRepositoryMock.On("List", "test", uint(0), uint(20)).Return(events, uint(1), nil).Once()
+			}
+
+			s := &Sessions{
+				events: loginEventRepositoryMock,
+				users:  userRepositoryMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			listed, total, err := s.UserHistory(test.id, 0, 20)
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Equal(t, errortype.ErrNotFound, errors.Cause(err), "the cause should be kept")
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, events, listed, "wrong events listed")
+				assert.Equal(t, uint(1), total, "wrong total count")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			loginEventRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"crypto/subtle"
+	"sync"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// AdminRepository represents a user repository in which the first admin can be created
+type AdminRepository interface {
+	Store(user *model.User) (*model.User, error)
+	AdminExists() bool
+}
+
+// Setup is a service that creates the first admin of the blog. Until there is one, it can be
+// created by whoever holds the setup token, which is useless once the setup is complete.
+type Setup struct {
+	token string
+
+	// mutex prevents concurrent setups from creating several admins
+	mutex sync.Mutex
+
+	users AdminRepository
+	hash  Hasher
+
+	log *zerolog.Logger
+}
+
+// NewSetup creates a Setup service that accepts the given setup token. The
+// setup can only be completed by other means if the token is empty.
+func NewSetup(log *zerolog.Logger, users AdminRepository, hash Hasher, token string) *Setup {
+	return &Setup{
+		token: token,
+		users: users,
+		hash:  hash,
+
+		log: log,
+	}
+}
+
+// Complete creates the first admin of the blog if the setup token is valid
+func (s *Setup) Complete(token string, user *model.User) (*model.User, error) {
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "invalid setup token")
+	}
+
+	return s.CreateAdmin(user)
+}
+
+// CreateAdmin creates the first admin of the blog. It fails with ErrConflict if there is already one.
+func (s *Setup) CreateAdmin(user *model.User) (*model.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.users.AdminExists() {
+		return nil, errors.Wrap(errortype.ErrConflict, "admin account has already been created")
+	}
+
+	hash, err := s.hash.Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	admin, err := s.users.Store(&model.User{
+		TokenUserID:   generateID(),
+		Email:         user.Email,
+		Password:      hash,
+		Role:          model.RoleAdmin,
+		Status:        model.StatusActive,
+		EmailVerified: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create admin")
+	}
+
+	s.log.Info().Str("user_id", admin.TokenUserID).Str("email", admin.Email).Msg("admin account created")
+
+	return admin, nil
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewSetup(t *testing.T) {
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	hasherMock := &PasswordHasherMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	s := NewSetup(log, userRepositoryMock, hasherMock, "setupToken")
+
+	assert.Equal(t, userRepositoryMock, s.users, "unexpected user repository set")
+	assert.Equal(t, hasherMock, s.hash, "unexpected hasher set")
+	assert.Equal(t, "setupToken", s.token, "unexpected setup token set")
+	assert.Equal(t, log, s.log, "unexpected logger set")
+}
+
+func TestCompleteSetup(t *testing.T) {
+	tests := []struct {
+		description string
+
+		setupToken string
+		token      string
+		adminCheck bool
+
+		expectedError error
+	}{
+		{
+			description: "admin created",
+
+			setupToken: "setupToken",
+			token:      "setupToken",
+			adminCheck: true,
+		},
+		{
+			description: "wrong setup token",
+
+			setupToken: "setupToken",
+			token:      "otherToken",
+
+			expectedError: errors.New("invalid setup token: invalid token"),
+		},
+		{
+			description: "setup without token",
+
+			setupToken: "",
+			token:      "",
+
+			expectedError: errors.New("invalid setup token: invalid token"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			hasherMock := &PasswordHasherMock{}
+			if test.adminCheck {
+				userRepositoryMock.
+					On("AdminExists").
+					Return(false).
+					Once()
+				hasherMock.
+					On("Hash", "refrigerator2000").
+					Return("fakeHash", nil).
+					Once()
+				userRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.User")).
+					Return(&model.User{ID: 1, Email: "bob@vance-refrigeration.com", Role: model.RoleAdmin}, nil).
+					Once()
+			}
+
+			s := NewSetup(log, userRepositoryMock, hasherMock, test.setupToken)
+
+			admin, err := s.Complete(test.token, &model.User{Email: "bob@vance-refrigeration.com", Password: "refrigerator2000"})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Nil(t, admin, "unexpected admin returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.NotNil(t, admin, "expected an admin")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			hasherMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateAdmin(t *testing.T) {
+	tests := []struct {
+		description string
+
+		adminExists bool
+		hashErr     error
+		storeErr    error
+
+		expectedError error
+	}{
+		{
+			description: "admin created",
+		},
+		{
+			description: "admin already exists",
+
+			adminExists: true,
+
+			expectedError: errors.New("admin account has already been created: datamodel conflict"),
+		},
+		{
+			description: "hashing error",
+
+			hashErr: errors.New("could not hash"),
+
+			expectedError: errors.New("could not hash"),
+		},
+		{
+			description: "repository error",
+
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not create admin: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			userRepositoryMock.
+				On("AdminExists").
+				Return(test.adminExists).
+				Once()
+
+			hasherMock := &PasswordHasherMock{}
+			if !test.adminExists {
+				hasherMock.
+					On("Hash", "refrigerator2000").
+					Return("fakeHash", test.hashErr).
+					Once()
+			}
+
+			var stored *model.User
+			if !test.adminExists && test.hashErr == nil {
+				userRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.User")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.User) }).
+					Return(func() interface{} {
+						if test.storeErr != nil {
+							return nil
+						}
+						return &model.User{ID: 1, TokenUserID: "bloggo|id", Email: "bob@vance-refrigeration.com", Role: model.RoleAdmin}
+					}(), test.storeErr).
+					Once()
+			}
+
+			s := NewSetup(log, userRepositoryMock, hasherMock, "")
+
+			admin, err := s.CreateAdmin(&model.User{Email: "bob@vance-refrigeration.com", Password: "refrigerator2000", Role: model.RoleReader})
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.Nil(t, admin, "unexpected admin returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Equal(t, uint(1), admin.ID, "wrong admin returned")
+				assert.Contains(t, logsBuff.String(), "admin account created", "admin creation should be logged")
+			}
+
+			if stored != nil {
+				assert.Equal(t, "bob@vance-refrigeration.com", stored.Email, "wrong email stored")
+				assert.Equal(t, "fakeHash", stored.Password, "password should be stored hashed")
+				assert.Equal(t, model.RoleAdmin, stored.Role, "admin should be stored with the admin role")
+				assert.True(t, stored.EmailVerified, "the email address of the admin doesn't need to be verified")
+				assert.True(t, strings.HasPrefix(stored.TokenUserID, "bloggo|"), "admin should be given a user id")
+			}
+
+			userRepositoryMock.AssertExpectations(t)
+			hasherMock.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/model"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// LoginThrottleRepository represents a repository in which failed login attempts are stored
+type LoginThrottleRepository interface {
+	Find(key string) (*model.LoginThrottle, error)
+	Save(throttle *model.LoginThrottle) error
+	Delete(key string) error
+}
+
+// LoginThrottle is a service that slows down password guessing. Each failed login makes the
+// next attempt on the same account, or from the same IP address, wait exponentially longer,
+// until the account or address is locked out once it reaches its threshold of failures.
+// Failures are forgotten once no attempt failed for as long as a lockout lasts.
+//
+// Attempts are tracked in the repository so that all instances of Bloggo share them. Concurrent
+// attempts might each be allowed before the failures of the others are recorded, which only lets
+// a few more guesses through.
+type LoginThrottle struct {
+	maxAccountFailures int
+	maxIPFailures      int
+	backoff            time.Duration
+	lockout            time.Duration
+
+	throttles LoginThrottleRepository
+
+	log *zerolog.Logger
+}
+
+// NewLoginThrottle creates a LoginThrottle service. Accounts are locked out after maxAccountFailures failed
+// attempts and IP addresses after maxIPFailures, for the lockout duration. The first failure delays the next
+// attempt by backoff, and each following failure doubles the delay.
+func NewLoginThrottle(log *zerolog.Logger, throttles LoginThrottleRepository, maxAccountFailures, maxIPFailures int, backoff, lockout time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		throttles:          throttles,
+		maxAccountFailures: maxAccountFailures,
+		maxIPFailures:      maxIPFailures,
+		backoff:            backoff,
+		lockout:            lockout,
+
+		log: log,
+	}
+}
+
+// Check returns how long the client has to wait before it can attempt to log in to the
+// account with the given email address from the given IP address, or 0 if it can right away
+func (l *LoginThrottle) Check(email, ip string) (time.Duration, error) {
+	now := time.Now()
+
+	var wait time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		throttle, err := l.throttles.Find(key)
+		if errors.Cause(err) == errortype.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if blocked := throttle.BlockedUntil.Sub(now); blocked > wait {
+			wait = blocked
+		}
+	}
+
+	return wait, nil
+}
+
+// Fail records a failed attempt to log in to the account with the given
+// email address from the given IP address
+func (l *LoginThrottle) Fail(email, ip string) error {
+	err := l.fail(accountKey(email), l.maxAccountFailures)
+	if err != nil {
+		return err
+	}
+
+	return l.fail(ipKey(ip), l.maxIPFailures)
+}
+
+// Succeed forgets the failed attempts to log in to the account with the given email address.
+// The failures of the IP address are kept, since a client that owns an account could otherwise
+// use it to keep guessing the passwords of other accounts.
+func (l *LoginThrottle) Succeed(email string) error {
+	return l.throttles.Delete(accountKey(email))
+}
+
+// Unlock lifts the lockout of the account with the given email address, and forgets its failed attempts
+func (l *LoginThrottle) Unlock(email string) error {
+	err := l.throttles.Delete(accountKey(email))
+	if err != nil {
+		return err
+	}
+
+	l.log.Info().Str("email", email).Msg("account unlocked")
+	return nil
+}
+
+// fail records a failed attempt for the given key, and blocks it until the next attempt is allowed
+func (l *LoginThrottle) fail(key string, maxFailures int) error {
+	now := time.Now()
+
+	throttle, err := l.throttles.Find(key)
+	if errors.Cause(err) == errortype.ErrNotFound || (err == nil && now.Sub(throttle.LastFailureAt) > l.lockout) {
+		throttle, err = &model.LoginThrottle{Key: key}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = now
+	throttle.BlockedUntil = now.Add(l.delay(throttle.Failures, maxFailures))
+
+	err = l.throttles.Save(throttle)
+	if err != nil {
+		return err
+	}
+
+	if throttle.Failures == maxFailures {
+		l.log.Warn().
+			Str("key", key).
+			Int("failures", throttle.Failures).
+			Time("locked_until", throttle.BlockedUntil).
+			Msg("too many failed login attempts, locking out")
+	}
+
+	return nil
+}
+
+// delay returns how long to wait after the given number of consecutive failures
+func (l *LoginThrottle) delay(failures, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return l.lockout
+	}
+
+	delay := l.backoff
+	for i := 1; i < failures && delay < l.lockout; i++ {
+		delay *= 2
+	}
+	if delay > l.lockout {
+		return l.lockout
+	}
+	return delay
+}
+
+// accountKey returns the key under which the failed attempts on an account are tracked.
+// Email addresses are compared regardless of their case, like the users repository does.
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey returns the key under which the failed attempts from an IP address are tracked
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
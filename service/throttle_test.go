@@ -0,0 +1,334 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNewLoginThrottle(t *testing.T) {
+	loginThrottleRepositoryMock := &repo.LoginThrottleRepositoryMock{}
+
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	l := NewLoginThrottle(log, loginThrottleRepositoryMock, 5, 20, time.Second, 15*time.Minute)
+
+	assert.Equal(t, loginThrottleRepositoryMock, l.throttles, "unexpected login throttle repo set")
+	assert.Equal(t, 5, l.maxAccountFailures, "unexpected account failure threshold set")
+	assert.Equal(t, 20, l.maxIPFailures, "unexpected IP failure threshold set")
+	assert.Equal(t, time.Second, l.backoff, "unexpected backoff set")
+	assert.Equal(t, 15*time.Minute, l.lockout, "unexpected lockout set")
+	assert.Equal(t, log, l.log, "unexpected logger set")
+}
+
+func TestLoginThrottleCheck(t *testing.T) {
+	tests := []struct {
+		description string
+
+		account    *model.LoginThrottle
+		accountErr error
+		ip         *model.LoginThrottle
+
+		expectedMinWait time.Duration
+		expectedMaxWait time.Duration
+		expectedError   error
+	}{
+		{
+			description: "no failed attempts",
+
+			accountErr: errortype.ErrNotFound,
+		},
+		{
+			description: "backoff is over",
+
+			account: &model.LoginThrottle{BlockedUntil: time.Now().Add(-time.Second)},
+			ip:      &model.LoginThrottle{BlockedUntil: time.Now().Add(-time.Second)},
+		},
+		{
+			description: "account is locked out",
+
+			account: &model.LoginThrottle{BlockedUntil: time.Now().Add(15 * time.Minute)},
+			ip:      &model.LoginThrottle{BlockedUntil: time.Now().Add(time.Second)},
+
+			expectedMinWait: 14 * time.Minute,
+			expectedMaxWait: 15 * time.Minute,
+		},
+		{
+			description: "IP address is locked out",
+
+			account: &model.LoginThrottle{BlockedUntil: time.Now().Add(-time.Second)},
+			ip:      &model.LoginThrottle{BlockedUntil: time.Now().Add(15 * time.Minute)},
+
+			expectedMinWait: 14 * time.Minute,
+			expectedMaxWait: 15 * time.Minute,
+		},
+		{
+			description: "repository error",
+
+			accountErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			loginThrottleRepositoryMock := &repo.LoginThrottleRepositoryMock{}
+			loginThrottleRepositoryMock.
+				On("Find", "account:bob@vance-refrigeration.com").
+				Return(test.account, test.accountErr).
+				Once()
+			if test.expectedError == nil {
+				ipErr := error(nil)
+				if test.ip == nil {
+					ipErr = errortype.ErrNotFound
+				}
+				loginThrottleRepositoryMock.
+					On("Find", "ip:192.0.2.1").
+					Return(test.ip, ipErr).
+					Once()
+			}
+
+			l := NewLoginThrottle(log, loginThrottleRepositoryMock, 5, 20, time.Second, 15*time.Minute)
+
+			wait, err := l.Check("Bob@Vance-Refrigeration.com", "192.0.2.1")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.True(t, wait >= test.expectedMinWait && wait <= test.expectedMaxWait, "wrong wait %s", wait)
+			}
+
+			loginThrottleRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginThrottleFail(t *testing.T) {
+	tests := []struct {
+		description string
+
+		account *model.LoginThrottle
+		ip      *model.LoginThrottle
+		saveErr error
+
+		expectedAccountFailures int
+		expectedAccountDelay    time.Duration
+		expectedIPFailures      int
+		expectedIPDelay         time.Duration
+		expectLockoutLog        bool
+		expectedError           error
+	}{
+		{
+			description: "first failure",
+
+			expectedAccountFailures: 1,
+			expectedAccountDelay:    time.Second,
+			expectedIPFailures:      1,
+			expectedIPDelay:         time.Second,
+		},
+		{
+			description: "backoff doubles with each failure",
+
+			account: &model.LoginThrottle{Key: "account:bob@vance-refrigeration.com", Failures: 2, LastFailureAt: time.Now().Add(-time.Minute)},
+			ip:      &model.LoginThrottle{Key: "ip:192.0.2.1", Failures: 9, LastFailureAt: time.Now().Add(-time.Minute)},
+
+			expectedAccountFailures: 3,
+			expectedAccountDelay:    4 * time.Second,
+			expectedIPFailures:      10,
+			expectedIPDelay:         512 * time.Second,
+		},
+		{
+			description: "backoff is capped by the lockout",
+
+			ip: &model.LoginThrottle{Key: "ip:192.0.2.1", Failures: 15, LastFailureAt: time.Now().Add(-time.Minute)},
+
+			expectedAccountFailures: 1,
+			expectedAccountDelay:    time.Second,
+			expectedIPFailures:      16,
+			expectedIPDelay:         15 * time.Minute,
+		},
+		{
+			description: "account locked out",
+
+			account: &model.LoginThrottle{Key: "account:bob@vance-refrigeration.com", Failures: 4, LastFailureAt: time.Now().Add(-time.Minute)},
+
+			expectedAccountFailures: 5,
+			expectedAccountDelay:    15 * time.Minute,
+			expectedIPFailures:      1,
+			expectedIPDelay:         time.Second,
+			expectLockoutLog:        true,
+		},
+		{
+			description: "old failures are forgotten",
+
+			account: &model.LoginThrottle{Key: "account:bob@vance-refrigeration.com", Failures: 4, LastFailureAt: time.Now().Add(-time.Hour)},
+
+			expectedAccountFailures: 1,
+			expectedAccountDelay:    time.Second,
+			expectedIPFailures:      1,
+			expectedIPDelay:         time.Second,
+		},
+		{
+			description: "repository error",
+
+			saveErr: errors.New("database exploded"),
+
+			expectedAccountFailures: 1,
+			expectedAccountDelay:    time.Second,
+			expectedError:           errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			var saved []*model.LoginThrottle
+			loginThrottleRepositoryMock := &repo.LoginThrottleRepositoryMock{}
+			loginThrottleRepositoryMock.
+				On("Save", mock.AnythingOfType("*model.LoginThrottle")).
+				Return(test.saveErr).
+				Run(func(args mock.Arguments) {
+					saved = append(saved, args.Get(0).(*model.LoginThrottle))
+				})
+
+			for key, throttle := range map[string]*model.LoginThrottle{
+				"account:bob@vance-refrigeration.com": test.account,
+				"ip:192.0.2.1":                        test.ip,
+			} {
+				if key == "ip:192.0.2.1" && test.expectedError != nil {
+					continue
+				}
+
+				findErr := error(nil)
+				if throttle == nil {
+					findErr = errortype.ErrNotFound
+				}
+				loginThrottleRepositoryMock.
+					On("Find", key).
+					Return(throttle, findErr).
+					Once()
+			}
+
+			l := NewLoginThrottle(log, loginThrottleRepositoryMock, 5, 20, time.Second, 15*time.Minute)
+
+			start := time.Now()
+			err := l.Fail("bob@vance-refrigeration.com", "192.0.2.1")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			expected := []struct {
+				key      string
+				failures int
+				delay    time.Duration
+			}{
+				{"account:bob@vance-refrigeration.com", test.expectedAccountFailures, test.expectedAccountDelay},
+				{"ip:192.0.2.1", test.expectedIPFailures, test.expectedIPDelay},
+			}
+			if test.expectedError != nil {
+				expected = expected[:1]
+			}
+
+			if assert.Len(t, saved, len(expected), "wrong number of throttles saved") {
+				for idx, e := range expected {
+					assert.Equal(t, e.key, saved[idx].Key, "wrong key saved")
+					assert.Equal(t, e.failures, saved[idx].Failures, "wrong number of failures saved for %s", e.key)
+
+					delay := saved[idx].BlockedUntil.Sub(start)
+					assert.True(t, delay >= e.delay && delay < e.delay+time.Second, "wrong delay %s saved for %s", delay, e.key)
+				}
+			}
+
+			if test.expectLockoutLog {
+				assert.Contains(t, logsBuff.String(), "too many failed login attempts, locking out", "lockout should be logged")
+			} else {
+				assert.NotContains(t, logsBuff.String(), "locking out", "unexpected lockout log")
+			}
+
+			loginThrottleRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	loginThrottleRepositoryMock := &repo.LoginThrottleRepositoryMock{}
+	loginThrottleRepositoryMock.
+		On("Delete", "account:bob@vance-refrigeration.com").
+		Return(nil).
+		Once()
+
+	l := NewLoginThrottle(log, loginThrottleRepositoryMock, 5, 20, time.Second, 15*time.Minute)
+
+	err := l.Succeed("bob@vance-refrigeration.com")
+	assert.NoError(t, err, "unexpected error")
+
+	loginThrottleRepositoryMock.AssertExpectations(t)
+}
+
+func TestLoginThrottleUnlock(t *testing.T) {
+	tests := []struct {
+		description string
+
+		deleteErr error
+
+		expectedError error
+	}{
+		{
+			description: "account unlocked",
+		},
+		{
+			description: "repository error",
+
+			deleteErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			loginThrottleRepositoryMock := &repo.LoginThrottleRepositoryMock{}
+			loginThrottleRepositoryMock.
+				On("Delete", "account:bob@vance-refrigeration.com").
+				Return(test.deleteErr).
+				Once()
+
+			l := NewLoginThrottle(log, loginThrottleRepositoryMock, 5, 20, time.Second, 15*time.Minute)
+
+			err := l.Unlock("bob@vance-refrigeration.com")
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+				assert.NotContains(t, logsBuff.String(), "account unlocked", "unexpected unlock log")
+			} else {
+				assert.NoError(t, err, "unexpected error")
+				assert.Contains(t, logsBuff.String(), "account unlocked", "unlock should be logged")
+			}
+
+			loginThrottleRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
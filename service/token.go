@@ -1,9 +1,15 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/model"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -11,50 +17,624 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// Claims are the claims of the access tokens generated by the Token service
+type Claims struct {
+	Scope     string `json:"scope"`
+	SessionID string `json:"sid,omitempty"`
+	Actor     *Actor `json:"act,omitempty"`
+	jwt.StandardClaims
+}
+
+// Actor is the act claim of impersonation tokens, which identifies the admin who acts as their subject
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
+// RefreshTokenRepository represents a repository in which refresh tokens are stored
+type RefreshTokenRepository interface {
+	Store(token *model.RefreshToken) error
+	FindByHash(hash string) (*model.RefreshToken, error)
+	MarkUsed(id uint, usedAt time.Time) error
+	RevokeFamily(family string, revokedAt time.Time) error
+}
+
+// SessionStore represents a repository in which the sessions started by logins are stored
+type SessionStore interface {
+	Store(session *model.Session) error
+	Touch(id string, seenAt time.Time) error
+	Revoke(id string, revokedAt time.Time) error
+}
+
+// LoginEventStore represents a repository in which login attempts are recorded
+type LoginEventStore interface {
+	Store(event *model.LoginEvent) error
+}
+
+// CredentialRepository represents a user repository in which password hashes can be upgraded
+type CredentialRepository interface {
+	UserRepository
+	UpdatePassword(id uint, hash string) error
+}
+
+// Revoker represents a service that revokes access tokens before they expire
+type Revoker interface {
+	Revoke(jti string, expiresAt time.Time) error
+}
+
+// Signer represents a service that signs access tokens
+type Signer interface {
+	Sign(claims jwt.Claims) (string, error)
+}
+
+// MFAVerifier represents a service that verifies the codes of users who enabled two-factor authentication
+type MFAVerifier interface {
+	Verify(user *model.User, code string) error
+}
+
+// MFALoginRepository represents a repository in which the logins that wait for their second factor are stored
+type MFALoginRepository interface {
+	Store(login *model.MFALogin) error
+	FindByHash(hash string) (*model.MFALogin, error)
+	Attempt(id uint, maxAttempts uint) error
+	Delete(id uint) error
+}
+
+// mfaMaxAttempts is how many codes can be tried to complete a login, before the user has to enter their password again
+const mfaMaxAttempts = 5
+
+// maxUserAgentLength is the length after which the user agents of sessions are truncated
+const maxUserAgentLength = 512
+
 // Token is a service that generates JWT tokens
 type Token struct {
-	jws string
+	issuer     string
+	audience   string
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	mfaTTL     time.Duration
+
+	user          CredentialRepository
+	refreshTokens RefreshTokenRepository
+	sessions      SessionStore
+	loginEvents   LoginEventStore
+	revoker       Revoker
+	hash          PasswordHasher
+	signer        Signer
+	mfa           MFAVerifier
+	mfaLogins     MFALoginRepository
 
-	user UserRepository
-	hash HashComparer
+	// dummyHash is compared with the passwords given for unknown users, so that
+	// they take as long to be rejected as wrong passwords
+	dummyHash     string
+	dummyHashOnce sync.Once
 
 	log *zerolog.Logger
 }
 
-// NewToken creates and configures an Token service
-func NewToken(log *zerolog.Logger, user UserRepository, hash HashComparer, jws string) *Token {
+// NewToken creates and configures an Token service. Access tokens are issued by the issuer for the
+// audience and are valid for accessTTL, and refresh tokens are valid for refreshTTL. Users who enabled
+// two-factor authentication have mfaTTL to give a code after their password. Each login starts a session,
+// and every attempt is recorded in the login events.
+func NewToken(log *zerolog.Logger, user CredentialRepository, refreshTokens RefreshTokenRepository, sessions SessionStore, loginEvents LoginEventStore, revoker Revoker, hash PasswordHasher, signer Signer, mfa MFAVerifier, mfaLogins MFALoginRepository, issuer, audience string, accessTTL, refreshTTL, mfaTTL time.Duration) *Token {
 	return &Token{
-		log:  log,
-		user: user,
-		hash: hash,
-		jws:  jws,
+		log:           log,
+		user:          user,
+		refreshTokens: refreshTokens,
+		sessions:      sessions,
+		loginEvents:   loginEvents,
+		revoker:       revoker,
+		hash:          hash,
+		signer:        signer,
+		mfa:           mfa,
+		mfaLogins:     mfaLogins,
+		issuer:        issuer,
+		audience:      audience,
+		accessTTL:     accessTTL,
+		refreshTTL:    refreshTTL,
+		mfaTTL:        mfaTTL,
 	}
 }
 
 // GenerateID generate a unique ID
 func (t *Token) GenerateID() string {
-	return "bloggo|" + jwt.EncodeSegment([]byte(fmt.Sprint(time.Now().UnixNano())))
+	return generateID()
 }
 
-// Login generates a signed JWT from the user information if it's valid
-func (t *Token) Login(userInfo *model.User) (string, error) {
+// Login generates a signed JWT and a refresh token from the user information if it's valid. The
+// tokens are granted the requested scopes, or all of the scopes of the user's role if none are requested.
+// If the user enabled two-factor authentication, a challenge is returned instead, which is exchanged
+// for the tokens with LoginMFA.
+func (t *Token) Login(userInfo *model.User, scopes []model.Scope, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
 
 	actualUser, err := t.user.Retrieve(&model.User{Email: userInfo.Email})
+	if errors.Cause(err) == errortype.ErrNotFound {
+		// Otherwise, response times would reveal which email addresses have an account
+		t.hash.Compare(t.getDummyHash(), userInfo.Password)
+		t.recordFailure(&model.User{Email: userInfo.Email}, model.LoginMethodPassword, client, "unknown user")
+		return nil, nil, errors.Wrap(errortype.ErrInvalidCredentials, "user not found")
+	}
 	if err != nil {
-		return "", errors.Wrap(err, "user not found")
+		return nil, nil, errors.Wrap(err, "could not retrieve user")
 	}
 
 	err = t.hash.Compare(actualUser.Password, userInfo.Password)
 	if err != nil {
-		return "", errors.New("invalid password")
+		t.recordFailure(actualUser, model.LoginMethodPassword, client, "invalid password")
+		return nil, nil, errors.Wrap(errortype.ErrInvalidCredentials, "invalid password")
+	}
+
+	// Hashes made with an outdated algorithm or cost can only be upgraded while the password is known
+	if t.hash.NeedsRehash(actualUser.Password) {
+		t.rehash(actualUser, userInfo.Password)
+	}
+
+	return t.LoginVerified(actualUser, scopes, model.LoginMethodPassword, client)
+}
+
+// LoginVerified generates a signed JWT and a refresh token for a user who proved who they are by other
+// means than their password, such as a magic link. The scopes and second factor are handled as by Login.
+func (t *Token) LoginVerified(user *model.User, scopes []model.Scope, method model.LoginMethod, client *model.Client) (*model.Token, *model.MFAChallenge, error) {
+	err := checkStatus(user)
+	if err != nil {
+		t.recordFailure(user, method, client, "account is "+string(user.Status))
+		return nil, nil, err
+	}
+
+	// Users can only narrow down the scopes of their role
+	granted := user.Role.Scopes()
+	if len(scopes) > 0 {
+		for _, scope := range scopes {
+			if !model.HasScope(granted, scope) {
+				return nil, nil, errors.Wrapf(errortype.ErrInvalidScope, "scope %s can't be granted to %s users", scope, user.Role)
+			}
+		}
+		granted = scopes
+	}
+
+	if user.MFAEnabled {
+		challenge, err := t.challenge(user, granted)
+		return nil, challenge, err
+	}
+
+	token, err := t.startSession(user, granted, method, client)
+	return token, nil, err
+}
+
+// challenge starts a login that waits for its second factor, with the given scopes
+func (t *Token) challenge(user *model.User, scopes []model.Scope) (*model.MFAChallenge, error) {
+	now := time.Now()
+
+	token, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.mfaLogins.Store(&model.MFALogin{
+		Hash:      hashToken(token),
+		UserID:    user.TokenUserID,
+		Scope:     model.FormatScopes(scopes),
+		ExpiresAt: now.Add(t.mfaTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store MFA login")
+	}
+
+	return &model.MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int64(t.mfaTTL / time.Second),
+	}, nil
+}
+
+// LoginMFA completes a login that waits for its second factor, with a TOTP code or a recovery code. Only a
+// few codes can be tried for each login, after which the user has to enter their password again.
+func (t *Token) LoginMFA(mfaToken, code string, client *model.Client) (*model.Token, error) {
+	login, err := t.mfaLogins.FindByHash(hashToken(mfaToken))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "unknown MFA token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !time.Now().Before(login.ExpiresAt) {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "MFA token is expired")
+	}
+
+	// Attempts are counted before the code is verified, so that concurrent
+	// requests can't try more codes than allowed
+	err = t.mfaLogins.Attempt(login.ID, mfaMaxAttempts)
+	if errors.Cause(err) == errortype.ErrConflict {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "too many invalid codes, log in again")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := t.user.Retrieve(&model.User{TokenUserID: login.UserID})
+	if err != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "user not found")
+	}
+
+	// The user might have been deactivated since they entered their password
+	if err = checkStatus(user); err != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	err = t.mfa.Verify(user, code)
+	if errors.Cause(err) == errortype.ErrConflict {
+		// Two-factor authentication was disabled since the user entered their password
+		return nil, errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+	if errors.Cause(err) == errortype.ErrInvalidCredentials {
+		t.recordFailure(user, model.LoginMethodMFA, client, "invalid code")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Completing a login consumes it, which fails if it was completed concurrently
+	err = t.mfaLogins.Delete(login.ID)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "MFA token was already used")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The role of the user might have changed since they entered their password
+	return t.startSession(user, restrictScopes(model.ParseScopes(login.Scope), user.Role), model.LoginMethodMFA, client)
+}
+
+// getDummyHash returns a hash of a random password, made with the current hashing settings
+func (t *Token) getDummyHash() string {
+	t.dummyHashOnce.Do(func() {
+		password, err := randomToken(16)
+		if err == nil {
+			t.dummyHash, err = t.hash.Hash(password)
+		}
+		if err != nil {
+			t.log.Error().Err(err).Msg("could not generate dummy password hash")
+		}
+	})
+	return t.dummyHash
+}
+
+// LoginUser generates a signed JWT and a refresh token for a user whose account was just created, with
+// a setup token or an invitation, and can't have a second factor yet. The tokens are granted all of the
+// scopes of the user's role.
+func (t *Token) LoginUser(user *model.User, method model.LoginMethod, client *model.Client) (*model.Token, error) {
+	err := checkStatus(user)
+	if err != nil {
+		t.recordFailure(user, method, client, "account is "+string(user.Status))
+		return nil, err
+	}
+
+	return t.startSession(user, user.Role.Scopes(), method, client)
+}
+
+// Refresh exchanges a refresh token for a new pair of tokens. Refresh tokens can only be used once:
+// if one is used again, it was probably stolen, so all of the refresh tokens of its family are revoked.
+func (t *Token) Refresh(refreshToken string) (*model.Token, error) {
+	now := time.Now()
+
+	stored, err := t.refreshTokens.FindByHash(hashToken(refreshToken))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "unknown refresh token")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stored.RevokedAt != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "refresh token was revoked")
+	}
+	if !now.Before(stored.ExpiresAt) {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "refresh token is expired")
+	}
+
+	// Marking the token as used fails if it was used concurrently
+	if stored.UsedAt == nil {
+		err = t.refreshTokens.MarkUsed(stored.ID, now)
+	}
+	if stored.UsedAt != nil || errors.Cause(err) == errortype.ErrConflict {
+		t.log.Warn().Str("user_id", stored.UserID).Str("family", stored.Family).Msg("refresh token reused, revoking its session")
+
+		err = t.revokeSession(stored.Family, now)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "refresh token was already used")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := t.user.Retrieve(&model.User{TokenUserID: stored.UserID})
+	if err != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, "user not found")
+	}
+
+	// The user might have been deactivated since they logged in
+	if err = checkStatus(user); err != nil {
+		return nil, errors.Wrap(errortype.ErrInvalidToken, err.Error())
+	}
+
+	// The role of the user might have changed since they logged in
+	token, err := t.issue(user, restrictScopes(model.ParseScopes(stored.Scope), user.Role), stored.Family)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.sessions.Touch(stored.Family, now)
+	if err != nil {
+		// The session can still be used
+		t.log.Warn().Err(err).Str("session_id", stored.Family).Msg("could not record session activity")
+	}
+
+	return token, nil
+}
+
+// checkStatus returns ErrForbidden if the user is not allowed to log in
+func checkStatus(user *model.User) error {
+	switch user.Status {
+	case model.StatusPending:
+		return errors.Wrap(errortype.ErrForbidden, "account is pending approval")
+	case model.StatusDeactivated:
+		return errors.Wrap(errortype.ErrForbidden, "account is deactivated")
+	default:
+		return nil
+	}
+}
+
+// Logout revokes an access token along with its session, and the session of the given refresh token if there is one
+func (t *Token) Logout(tokenID, sessionID string, expiresAt time.Time, refreshToken string) error {
+	now := time.Now()
+
+	if tokenID != "" {
+		err := t.revoker.Revoke(tokenID, expiresAt)
+		if err != nil {
+			return errors.Wrap(err, "could not revoke access token")
+		}
+	}
+
+	if sessionID != "" {
+		err := t.revokeSession(sessionID, now)
+		if err != nil {
+			return errors.Wrap(err, "could not revoke session")
+		}
+	}
+
+	if refreshToken == "" {
+		return nil
+	}
+
+	stored, err := t.refreshTokens.FindByHash(hashToken(refreshToken))
+	if errors.Cause(err) == errortype.ErrNotFound {
+		// The refresh token can't be used anyway
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if stored.Family == sessionID {
+		return nil
+	}
+	return t.revokeSession(stored.Family, now)
+}
+
+// revokeSession revokes a session along with its refresh tokens. Refresh tokens issued
+// before sessions existed have none, so only their family is revoked.
+func (t *Token) revokeSession(id string, now time.Time) error {
+	err := t.sessions.Revoke(id, now)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return t.refreshTokens.RevokeFamily(id, now)
+	}
+	return err
+}
+
+// rehash hashes the password of a user again with the current settings. Failures are only
+// logged, since the user can still log in with their current hash.
+func (t *Token) rehash(user *model.User, password string) {
+	hash, err := t.hash.Hash(password)
+	if err != nil {
+		t.log.Warn().Err(err).Str("user_id", user.TokenUserID).Msg("could not rehash password")
+		return
+	}
+
+	err = t.user.UpdatePassword(user.ID, hash)
+	if err != nil {
+		t.log.Warn().Err(err).Str("user_id", user.TokenUserID).Msg("could not upgrade password hash")
+		return
+	}
+
+	t.log.Info().Str("user_id", user.TokenUserID).Msg("password hash upgraded")
+}
+
+// startSession starts a session for a user who logged in from the given client, and issues its first tokens
+func (t *Token) startSession(user *model.User, scopes []model.Scope, method model.LoginMethod, client *model.Client) (*model.Token, error) {
+	now := time.Now()
+
+	id, err := randomToken(16)
+	if err != nil {
+		return nil, err
 	}
 
-	claims := &jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(),
-		Subject:   actualUser.TokenUserID,
-		IssuedAt:  time.Now().Unix(),
+	userAgent := truncate(client.UserAgent, maxUserAgentLength)
+
+	err = t.sessions.Store(&model.Session{
+		ID:         id,
+		UserID:     user.TokenUserID,
+		IP:         client.IP,
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store session")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	return token.SignedString([]byte(t.jws))
+	token, err := t.issue(user, scopes, id)
+	if err != nil {
+		return nil, err
+	}
+
+	t.record(&model.LoginEvent{
+		UserID:    user.TokenUserID,
+		Email:     user.Email,
+		Method:    method,
+		Success:   true,
+		SessionID: id,
+		IP:        client.IP,
+		UserAgent: userAgent,
+		CreatedAt: now,
+	})
+	return token, nil
+}
+
+// recordFailure records a failed login attempt of a user, who might be unknown
+func (t *Token) recordFailure(user *model.User, method model.LoginMethod, client *model.Client, reason string) {
+	userAgent := truncate(client.UserAgent, maxUserAgentLength)
+
+	t.record(&model.LoginEvent{
+		UserID:    user.TokenUserID,
+		Email:     user.Email,
+		Method:    method,
+		Reason:    reason,
+		IP:        client.IP,
+		UserAgent: userAgent,
+		CreatedAt: time.Now(),
+	})
+}
+
+// record stores a login event. Failures are only logged, since they shouldn't prevent users from logging in.
+func (t *Token) record(event *model.LoginEvent) {
+	err := t.loginEvents.Store(event)
+	if err != nil {
+		t.log.Error().Err(err).Str("user_id", event.UserID).Str("method", string(event.Method)).Msg("could not record login event")
+	}
+}
+
+// issue generates a signed JWT and a refresh token of the given family, which is the ID of their session
+func (t *Token) issue(user *model.User, scopes []model.Scope, family string) (*model.Token, error) {
+	now := time.Now()
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		Scope:     model.FormatScopes(scopes),
+		SessionID: family,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    t.issuer,
+			Audience:  t.audience,
+			ExpiresAt: now.Add(t.accessTTL).Unix(),
+			Subject:   user.TokenUserID,
+			IssuedAt:  now.Unix(),
+		},
+	}
+	accessToken, err := t.signer.Sign(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign access token")
+	}
+
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.refreshTokens.Store(&model.RefreshToken{
+		Hash:      hashToken(refreshToken),
+		Family:    family,
+		UserID:    user.TokenUserID,
+		Scope:     claims.Scope,
+		ExpiresAt: now.Add(t.refreshTTL),
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not store refresh token")
+	}
+
+	return &model.Token{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(t.accessTTL / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        claims.Scope,
+	}, nil
+}
+
+// IssueImpersonation generates a signed JWT with which the actor acts as a user, valid for ttl. It
+// is granted all of the scopes of the user's role, and comes without a refresh token or session,
+// so the actor has to impersonate the user again once it expires.
+func (t *Token) IssueImpersonation(user *model.User, actorID string, ttl time.Duration) (*model.Token, error) {
+	now := time.Now()
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{
+		Scope: model.FormatScopes(user.Role.Scopes()),
+		Actor: &Actor{Subject: actorID},
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Issuer:    t.issuer,
+			Audience:  t.audience,
+			ExpiresAt: now.Add(ttl).Unix(),
+			Subject:   user.TokenUserID,
+			IssuedAt:  now.Unix(),
+		},
+	}
+	accessToken, err := t.signer.Sign(claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not sign impersonation token")
+	}
+
+	return &model.Token{
+		AccessToken:    accessToken,
+		TokenType:      "Bearer",
+		ExpiresIn:      int64(ttl / time.Second),
+		Scope:          claims.Scope,
+		ImpersonatedBy: actorID,
+	}, nil
+}
+
+// generateID generates the unique ID of a user who registers with Bloggo
+func generateID() string {
+	return "bloggo|" + jwt.EncodeSegment([]byte(fmt.Sprint(time.Now().UnixNano())))
+}
+
+// randomToken generates a random token of the given number of bytes, encoded in hexadecimal
+func randomToken(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate random token")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// truncate cuts a string to at most the given number of bytes, without splitting a character
+func truncate(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	for length > 0 && !utf8.RuneStart(s[length]) {
+		length--
+	}
+	return s[:length]
+}
+
+// hashToken hashes a token so that it can be stored. Unlike passwords, tokens are
+// random enough not to need a slow hashing algorithm.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -2,41 +2,96 @@ package service
 
 import (
 	"bytes"
-	"strings"
 	"testing"
+	"time"
 
+	"github.com/Ullaakut/Bloggo/errortype"
 	"github.com/Ullaakut/Bloggo/logger"
 	"github.com/Ullaakut/Bloggo/model"
 	"github.com/Ullaakut/Bloggo/repo"
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
-type HashComparerMock struct {
+type PasswordHasherMock struct {
 	mock.Mock
 }
 
-func (m *HashComparerMock) Compare(hash, password string) error {
+func (m *PasswordHasherMock) Hash(password string) (string, error) {
+	args := m.Called(password)
+	return args.String(0), args.Error(1)
+}
+
+func (m *PasswordHasherMock) Compare(hash, password string) error {
 	args := m.Called(hash, password)
 	return args.Error(0)
 }
 
-func TestNewToken(t *testing.T) {
-	jws := "MySuperSecretSecret"
+func (m *PasswordHasherMock) NeedsRehash(hash string) bool {
+	args := m.Called(hash)
+	return args.Bool(0)
+}
+
+type RevokerMock struct {
+	mock.Mock
+}
+
+func (m *RevokerMock) Revoke(jti string, expiresAt time.Time) error {
+	args := m.Called(jti, expiresAt)
+	return args.Error(0)
+}
+
+type SignerMock struct {
+	mock.Mock
+}
+
+func (m *SignerMock) Sign(claims jwt.Claims) (string, error) {
+	args := m.Called(claims)
+	return args.String(0), args.Error(1)
+}
+
+type MFAVerifierMock struct {
+	mock.Mock
+}
+
+func (m *MFAVerifierMock) Verify(user *model.User, code string) error {
+	args := m.Called(user, code)
+	return args.Error(0)
+}
 
+func TestNewToken(t *testing.T) {
 	userRepositoryMock := &repo.UserRepositoryMock{}
-	hasherMock := &HashComparerMock{}
+	refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+	sessionRepositoryMock := &repo.SessionRepositoryMock{}
+	loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+	revokerMock := &RevokerMock{}
+	hasherMock := &PasswordHasherMock{}
+	signerMock := &SignerMock{}
+	mfaMock := &MFAVerifierMock{}
+	mfaLoginRepositoryMock := &repo.MFALoginRepositoryMock{}
 
 	logsBuff := &bytes.Buffer{}
 	log := logger.NewZeroLog(logsBuff)
 
-	a := NewToken(log, userRepositoryMock, hasherMock, jws)
+	a := NewToken(log, userRepositoryMock, refreshTokenRepositoryMock, sessionRepositoryMock, loginEventRepositoryMock, revokerMock, hasherMock, signerMock, mfaMock, mfaLoginRepositoryMock, "https://bloggo.example.com/", "bloggo", 15*time.Minute, 24*time.Hour, 5*time.Minute)
 
-	assert.Equal(t, jws, a.jws, "unexpected jws set")
+	assert.Equal(t, signerMock, a.signer, "unexpected signer set")
+	assert.Equal(t, "https://bloggo.example.com/", a.issuer, "unexpected issuer set")
+	assert.Equal(t, "bloggo", a.audience, "unexpected audience set")
+	assert.Equal(t, 15*time.Minute, a.accessTTL, "unexpected access token TTL set")
+	assert.Equal(t, 24*time.Hour, a.refreshTTL, "unexpected refresh token TTL set")
 	assert.Equal(t, log, a.log, "unexpected logger set")
 	assert.Equal(t, userRepositoryMock, a.user, "unexpected user repo set")
+	assert.Equal(t, refreshTokenRepositoryMock, a.refreshTokens, "unexpected refresh token repo set")
+	assert.Equal(t, sessionRepositoryMock, a.sessions, "unexpected session repo set")
+	assert.Equal(t, loginEventRepositoryMock, a.loginEvents, "unexpected login event repo set")
+	assert.Equal(t, revokerMock, a.revoker, "unexpected revoker set")
 	assert.Equal(t, hasherMock, a.hash, "unexpected hasher set")
+	assert.Equal(t, mfaMock, a.mfa, "unexpected MFA verifier set")
+	assert.Equal(t, mfaLoginRepositoryMock, a.mfaLogins, "unexpected MFA login repo set")
+	assert.Equal(t, 5*time.Minute, a.mfaTTL, "unexpected MFA login TTL set")
 }
 
 func TestGenerateID(t *testing.T) {
@@ -56,13 +111,17 @@ func TestLogin(t *testing.T) {
 		description string
 
 		userInfo    *model.User
+		scopes      []model.Scope
 		actualUser  *model.User
 		invalidHash error
 		repoError   error
+		needsRehash bool
+		rehashError error
 
 		// Can't verify the second and third segments without faking the time.Now() call
-		expectedFirstSegment string
-		expectedError        error
+		expectedScope  string
+		expectedReason string
+		expectedError  error
 	}{
 		{
 			description: "valid token, no errors",
@@ -75,10 +134,101 @@ func TestLogin(t *testing.T) {
 				Email:       "bob@vance-refrigeration.com",
 				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
 				TokenUserID: "test",
+				Role:        model.RoleAuthor,
+			},
+
+			expectedScope: "posts:read posts:write posts:delete",
+			expectedError: nil,
+		},
+		{
+			description: "valid token with narrower scopes",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			scopes: []model.Scope{model.ScopePostsRead},
+			actualUser: &model.User{
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleAdmin,
+			},
+
+			expectedScope: "posts:read",
+		},
+		{
+			description: "scope not granted to the user's role",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			scopes: []model.Scope{model.ScopePostsRead, model.ScopeUsersAdmin},
+			actualUser: &model.User{
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleEditor,
+			},
+
+			expectedError: errors.New("scope users:admin can't be granted to editor users: invalid scope"),
+		},
+		{
+			description: "outdated hash upgraded",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			actualUser: &model.User{
+				ID:          42,
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleReader,
+			},
+			needsRehash: true,
+
+			expectedScope: "posts:read",
+		},
+		{
+			description: "failure to upgrade an outdated hash does not prevent logging in",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			actualUser: &model.User{
+				ID:          42,
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleReader,
+			},
+			needsRehash: true,
+			rehashError: errors.New("dummy error"),
+
+			expectedScope: "posts:read",
+		},
+		{
+			description: "two-factor authentication enabled",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			scopes: []model.Scope{model.ScopePostsRead},
+			actualUser: &model.User{
+				ID:          42,
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleAuthor,
+				MFAEnabled:  true,
 			},
 
-			expectedFirstSegment: "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9",
-			expectedError:        nil,
+			expectedScope: "posts:read",
 		},
 		{
 			description: "wrong password",
@@ -94,11 +244,61 @@ func TestLogin(t *testing.T) {
 			},
 			invalidHash: errors.New("dummy error"),
 
-			expectedError: errors.New("invalid password"),
+			expectedReason: "invalid password",
+			expectedError:  errors.New("invalid password: invalid credentials"),
+		},
+		{
+			description: "account pending approval",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			actualUser: &model.User{
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleReader,
+				Status:      model.StatusPending,
+			},
+
+			expectedReason: "account is pending",
+			expectedError:  errors.New("account is pending approval: forbidden"),
+		},
+		{
+			description: "deactivated account",
+
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			actualUser: &model.User{
+				Email:       "bob@vance-refrigeration.com",
+				Password:    "$2y$11$MbHIFLRyIR4lTcSTsm3sDOZ896vyr0.ijtDwCFSzvk9dJNXuR40AW",
+				TokenUserID: "test",
+				Role:        model.RoleReader,
+				Status:      model.StatusDeactivated,
+			},
+
+			expectedReason: "account is deactivated",
+			expectedError:  errors.New("account is deactivated: forbidden"),
 		},
 		{
 			description: "user does not exist",
 
+			userInfo: &model.User{
+				Email:    "bob@vance-refrigeration.com",
+				Password: "refrigerator2000",
+			},
+			actualUser: nil,
+			repoError:  errortype.ErrNotFound,
+
+			expectedReason: "unknown user",
+			expectedError:  errors.New("user not found: invalid credentials"),
+		},
+		{
+			description: "repository error",
+
 			userInfo: &model.User{
 				Email:    "bob@vance-refrigeration.com",
 				Password: "refrigerator2000",
@@ -106,7 +306,7 @@ func TestLogin(t *testing.T) {
 			actualUser: nil,
 			repoError:  errors.New("dummy error"),
 
-			expectedError: errors.New("user not found: dummy error"),
+			expectedError: errors.New("could not retrieve user: dummy error"),
 		},
 	}
 
@@ -121,34 +321,1127 @@ func TestLogin(t *testing.T) {
 				Return(test.actualUser, test.repoError).
 				Once()
 
-			hasherMock := &HashComparerMock{}
+			hasherMock := &PasswordHasherMock{}
 			if test.repoError == nil {
 				hasherMock.
 					On("Compare", test.actualUser.Password, test.userInfo.Password).
 					Return(test.invalidHash).
 					Once()
 			}
+			if errors.Cause(test.repoError) == errortype.ErrNotFound {
+				hasherMock.
+					On("Hash", mock.AnythingOfType("string")).
+					Return("$argon2id$v=19$m=65536,t=3,p=2$ZHVtbXk$ZHVtbXk", nil).
+					Once()
+				hasherMock.
+					On("Compare", "$argon2id$v=19$m=65536,t=3,p=2$ZHVtbXk$ZHVtbXk", test.userInfo.Password).
+					Return(errors.New("mismatched hash and password")).
+					Once()
+			}
+			if test.repoError == nil && test.invalidHash == nil {
+				hasherMock.
+					On("NeedsRehash", test.actualUser.Password).
+					Return(test.needsRehash).
+					Once()
+			}
+			if test.needsRehash {
+				hasherMock.
+					On("Hash", test.userInfo.Password).
+					Return("$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$a2V5", nil).
+					Once()
+				userRepositoryMock.
+					On("UpdatePassword", uint(42), "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$a2V5").
+					Return(test.rehashError).
+					Once()
+			}
+
+			// Users who enabled two-factor authentication get a challenge instead of tokens
+			mfaEnabled := test.actualUser != nil && test.actualUser.MFAEnabled
+
+			var storedLogin *model.MFALogin
+			mfaLoginRepositoryMock := &repo.MFALoginRepositoryMock{}
+			if test.expectedError == nil && mfaEnabled {
+				mfaLoginRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.MFALogin")).
+					Run(func(args mock.Arguments) { storedLogin = args.Get(0).(*model.MFALogin) }).
+					Return(nil).
+					Once()
+			}
+
+			var stored *model.RefreshToken
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			if test.expectedError == nil && !mfaEnabled {
+				refreshTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.RefreshToken")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.RefreshToken) }).
+					Return(nil).
+					Once()
+			}
+
+			var session *model.Session
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			if test.expectedError == nil && !mfaEnabled {
+				sessionRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.Session")).
+					Run(func(args mock.Arguments) { session = args.Get(0).(*model.Session) }).
+					Return(nil).
+					Once()
+			}
+
+			// Successful logins and failed attempts are recorded, unlike errors that aren't the user's doing
+			var event *model.LoginEvent
+			loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+			if (test.expectedError == nil && !mfaEnabled) || test.expectedReason != "" {
+				loginEventRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.LoginEvent")).
+					Run(func(args mock.Arguments) { event = args.Get(0).(*model.LoginEvent) }).
+					Return(nil).
+					Once()
+			}
+
+			var claims *Claims
+			signerMock := &SignerMock{}
+			if test.expectedError == nil && !mfaEnabled {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.Claims")).
+					Run(func(args mock.Arguments) { claims = args.Get(0).(*Claims) }).
+					Return("x.y.z", nil).
+					Once()
+			}
 
 			a := &Token{
-				log:  log,
-				jws:  "x5fVmkmyMLAQJiJ8rvsGEAgetl9GS7j8",
-				user: userRepositoryMock,
-				hash: hasherMock,
+				log:           log,
+				issuer:        "https://bloggo.example.com/",
+				audience:      "bloggo",
+				accessTTL:     15 * time.Minute,
+				refreshTTL:    24 * time.Hour,
+				user:          userRepositoryMock,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				loginEvents:   loginEventRepositoryMock,
+				hash:          hasherMock,
+				signer:        signerMock,
+				mfaLogins:     mfaLoginRepositoryMock,
+				mfaTTL:        5 * time.Minute,
 			}
 
-			token, err := a.Login(test.userInfo)
+			token, challenge, err := a.Login(test.userInfo, test.scopes, &model.Client{IP: "10.0.0.1", UserAgent: "Mozilla/5.0"})
 
 			if test.expectedError != nil {
 				assert.NotEqual(t, nil, err, "unexpected success in test case %d", idx)
 				assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned in test case %d", idx)
-			} else {
-				segments := strings.Split(token, ".")
-				assert.Equal(t, test.expectedFirstSegment, segments[0], "unexpected token in test case %d", idx)
+				if test.expectedReason != "" && assert.NotNil(t, event, "failed attempt should be recorded in test case %d", idx) {
+					assert.False(t, event.Success, "failed attempt recorded as a success in test case %d", idx)
+					assert.Equal(t, test.expectedReason, event.Reason, "wrong failure reason in test case %d", idx)
+					assert.Equal(t, test.userInfo.Email, event.Email, "wrong email recorded in test case %d", idx)
+					assert.Equal(t, model.LoginMethodPassword, event.Method, "wrong method recorded in test case %d", idx)
+					assert.Equal(t, "10.0.0.1", event.IP, "wrong IP recorded in test case %d", idx)
+				}
+			} else if mfaEnabled {
+				assert.NoError(t, err, "unexpected error in test case %d", idx)
+				assert.Nil(t, token, "no token should be issued before the second factor in test case %d", idx)
+				if assert.NotNil(t, challenge, "expected a challenge in test case %d", idx) && assert.NotNil(t, storedLogin, "MFA login should be stored in test case %d", idx) {
+					assert.True(t, challenge.MFARequired, "challenge should require MFA in test case %d", idx)
+					assert.Equal(t, int64(300), challenge.ExpiresIn, "wrong challenge expiration in test case %d", idx)
+					assert.Equal(t, hashToken(challenge.MFAToken), storedLogin.Hash, "wrong MFA token hash in test case %d", idx)
+					assert.Equal(t, test.actualUser.TokenUserID, storedLogin.UserID, "wrong MFA login user in test case %d", idx)
+					assert.Equal(t, test.expectedScope, storedLogin.Scope, "wrong MFA login scope in test case %d", idx)
+				}
+			} else if assert.NotNil(t, token, "expected a token in test case %d", idx) {
+				assert.Nil(t, challenge, "unexpected challenge in test case %d", idx)
+				assert.Equal(t, "x.y.z", token.AccessToken, "unexpected token in test case %d", idx)
 				assert.Equal(t, nil, err, "unexpected error in test case %d", idx)
+				assert.Equal(t, "Bearer", token.TokenType, "wrong token type in test case %d", idx)
+				assert.Equal(t, int64(900), token.ExpiresIn, "wrong expiration in test case %d", idx)
+				assert.Equal(t, test.expectedScope, token.Scope, "wrong scope in test case %d", idx)
+
+				if assert.NotNil(t, claims, "access token should be signed in test case %d", idx) {
+					assert.Equal(t, test.expectedScope, claims.Scope, "wrong scope granted in test case %d", idx)
+					assert.NotEmpty(t, claims.Id, "access token should have a jti in test case %d", idx)
+					assert.Equal(t, "https://bloggo.example.com/", claims.Issuer, "wrong issuer in test case %d", idx)
+					assert.Equal(t, "bloggo", claims.Audience, "wrong audience in test case %d", idx)
+					assert.Equal(t, test.actualUser.TokenUserID, claims.Subject, "wrong subject in test case %d", idx)
+				}
+
+				// Only the hash of the refresh token is stored
+				if assert.NotNil(t, stored, "refresh token should be stored in test case %d", idx) {
+					assert.Equal(t, hashToken(token.RefreshToken), stored.Hash, "wrong refresh token hash in test case %d", idx)
+					assert.Equal(t, test.actualUser.TokenUserID, stored.UserID, "wrong refresh token user in test case %d", idx)
+					assert.Equal(t, test.expectedScope, stored.Scope, "wrong refresh token scope in test case %d", idx)
+					assert.Len(t, stored.Family, 32, "refresh token should have a family in test case %d", idx)
+				}
+
+				// The refresh token family is the session, which the access token is bound to
+				if assert.NotNil(t, session, "session should be stored in test case %d", idx) {
+					assert.Equal(t, stored.Family, session.ID, "session should be the refresh token family in test case %d", idx)
+					assert.Equal(t, session.ID, claims.SessionID, "access token should carry the session in test case %d", idx)
+					assert.Equal(t, test.actualUser.TokenUserID, session.UserID, "wrong session user in test case %d", idx)
+					assert.Equal(t, "10.0.0.1", session.IP, "wrong session IP in test case %d", idx)
+					assert.Equal(t, "Mozilla/5.0", session.UserAgent, "wrong session user agent in test case %d", idx)
+				}
+				if assert.NotNil(t, event, "login should be recorded in test case %d", idx) {
+					assert.True(t, event.Success, "login should be recorded as a success in test case %d", idx)
+					assert.Equal(t, session.ID, event.SessionID, "wrong session recorded in test case %d", idx)
+					assert.Equal(t, model.LoginMethodPassword, event.Method, "wrong method recorded in test case %d", idx)
+				}
 			}
 
 			userRepositoryMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			mfaLoginRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+			loginEventRepositoryMock.AssertExpectations(t)
 			hasherMock.AssertExpectations(t)
 		})
 	}
 }
+
+func TestLoginUnknownUsers(t *testing.T) {
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+
+	userRepositoryMock := &repo.UserRepositoryMock{}
+	userRepositoryMock.
+		On("Retrieve", mock.AnythingOfType("*model.User")).
+		Return(nil, errortype.ErrNotFound).
+		Twice()
+
+	// The dummy hash is only generated once, but compared with each password
+	hasherMock := &PasswordHasherMock{}
+	hasherMock.
+		On("Hash", mock.AnythingOfType("string")).
+		Return("$argon2id$v=19$m=65536,t=3,p=2$ZHVtbXk$ZHVtbXk", nil).
+		Once()
+	hasherMock.
+		On("Compare", "$argon2id$v=19$m=65536,t=3,p=2$ZHVtbXk$ZHVtbXk", "refrigerator2000").
+		Return(errors.New("mismatched hash and password")).
+		Twice()
+
+	// Both attempts are recorded, without a user
+	loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+	loginEventRepositoryMock.
+		On("Store", mock.MatchedBy(func(event *model.LoginEvent) bool { return event.UserID == "" && event.Reason == "unknown user" })).
+		Return(nil).
+		Twice()
+
+	a := NewToken(log, userRepositoryMock, &repo.RefreshTokenRepositoryMock{}, &repo.SessionRepositoryMock{}, loginEventRepositoryMock, &RevokerMock{}, hasherMock, &SignerMock{}, &MFAVerifierMock{}, &repo.MFALoginRepositoryMock{}, "https://bloggo.example.com/", "bloggo", 15*time.Minute, 24*time.Hour, 5*time.Minute)
+
+	for _, email := range []string{"bob@vance-refrigeration.com", "phyllis@vance-refrigeration.com"} {
+		token, challenge, err := a.Login(&model.User{Email: email, Password: "refrigerator2000"}, nil, &model.Client{})
+
+		assert.Nil(t, token, "unexpected token for unknown user %s", email)
+		assert.Nil(t, challenge, "unexpected challenge for unknown user %s", email)
+		assert.Equal(t, errortype.ErrInvalidCredentials, errors.Cause(err), "wrong error for unknown user %s", email)
+	}
+
+	userRepositoryMock.AssertExpectations(t)
+	loginEventRepositoryMock.AssertExpectations(t)
+	hasherMock.AssertExpectations(t)
+}
+
+func TestLoginUser(t *testing.T) {
+	tests := []struct {
+		description string
+
+		user     *model.User
+		signErr  error
+		storeErr error
+
+		expectedScope string
+		expectedError error
+	}{
+		{
+			description: "tokens issued with the scopes of the user's role",
+
+			user: &model.User{TokenUserID: "example|248289761001", Role: model.RoleAuthor},
+
+			expectedScope: "posts:read posts:write posts:delete",
+		},
+		{
+			description: "account pending approval",
+
+			user: &model.User{TokenUserID: "example|248289761001", Role: model.RoleReader, Status: model.StatusPending},
+
+			expectedError: errors.New("account is pending approval: forbidden"),
+		},
+		{
+			description: "signing error",
+
+			user:    &model.User{TokenUserID: "example|248289761001", Role: model.RoleReader},
+			signErr: errors.New("no key"),
+
+			expectedError: errors.New("could not sign access token: no key"),
+		},
+		{
+			description: "repository error",
+
+			user:     &model.User{TokenUserID: "example|248289761001", Role: model.RoleReader},
+			storeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not store refresh token: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			var claims *Claims
+			signerMock := &SignerMock{}
+			if test.user.Status != model.StatusPending {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.Claims")).
+					Run(func(args mock.Arguments) { claims = args.Get(0).(*Claims) }).
+					Return("x.y.z", test.signErr).
+					Once()
+			}
+
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			if test.user.Status != model.StatusPending && test.signErr == nil {
+				refreshTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.RefreshToken")).
+					Return(test.storeErr).
+					Once()
+			}
+
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			if test.user.Status != model.StatusPending {
+				sessionRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.Session")).
+					Return(nil).
+					Once()
+			}
+
+			// Logins of pending users are recorded as failures
+			var event *model.LoginEvent
+			loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+			if test.expectedError == nil || test.user.Status == model.StatusPending {
+				loginEventRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.LoginEvent")).
+					Run(func(args mock.Arguments) { event = args.Get(0).(*model.LoginEvent) }).
+					Return(nil).
+					Once()
+			}
+
+			a := &Token{
+				log:           log,
+				accessTTL:     15 * time.Minute,
+				refreshTTL:    24 * time.Hour,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				loginEvents:   loginEventRepositoryMock,
+				signer:        signerMock,
+			}
+
+			token, err := a.LoginUser(test.user, model.LoginMethodOIDC, &model.Client{IP: "10.0.0.1"})
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.expectedScope, token.Scope, "wrong scope")
+				assert.Equal(t, "x.y.z", token.AccessToken, "wrong access token")
+				assert.Equal(t, test.user.TokenUserID, claims.Subject, "wrong subject")
+			}
+			if event != nil {
+				assert.Equal(t, test.expectedError == nil, event.Success, "wrong outcome recorded")
+				assert.Equal(t, model.LoginMethodOIDC, event.Method, "wrong method recorded")
+			}
+
+			signerMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+			loginEventRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginVerified(t *testing.T) {
+	tests := []struct {
+		description string
+
+		user   *model.User
+		scopes []model.Scope
+
+		expectIssue     bool
+		expectChallenge bool
+		expectedScope   string
+		expectedError   error
+	}{
+		{
+			description: "tokens issued with the scopes of the user's role",
+
+			user: &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+
+			expectIssue:   true,
+			expectedScope: "posts:read posts:write posts:delete",
+		},
+		{
+			description: "tokens issued with narrower scopes",
+
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+			scopes: []model.Scope{model.ScopePostsRead},
+
+			expectIssue:   true,
+			expectedScope: "posts:read",
+		},
+		{
+			description: "scope not granted to the user's role",
+
+			user:   &model.User{TokenUserID: "test", Role: model.RoleReader},
+			scopes: []model.Scope{model.ScopePostsWrite},
+
+			expectedError: errors.New("scope posts:write can't be granted to reader users: invalid scope"),
+		},
+		{
+			description: "two-factor authentication enabled",
+
+			user: &model.User{TokenUserID: "test", Role: model.RoleAuthor, MFAEnabled: true},
+
+			expectChallenge: true,
+		},
+		{
+			description: "account deactivated",
+
+			user: &model.User{TokenUserID: "test", Role: model.RoleAuthor, Status: model.StatusDeactivated},
+
+			expectedError: errors.New("account is deactivated: forbidden"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			signerMock := &SignerMock{}
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			if test.expectIssue {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.Claims")).
+					Return("x.y.z", nil).
+					Once()
+				refreshTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.RefreshToken")).
+					Return(nil).
+					Once()
+			}
+
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+			if test.expectIssue {
+				sessionRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.Session")).
+					Return(nil).
+					Once()
+				loginEventRepositoryMock.
+					On("Store", mock.MatchedBy(func(event *model.LoginEvent) bool { return event.Success })).
+					Return(nil).
+					Once()
+			}
+			if test.user.Status == model.StatusDeactivated {
+				loginEventRepositoryMock.
+					On("Store", mock.MatchedBy(func(event *model.LoginEvent) bool { return event.Reason == "account is deactivated" })).
+					Return(nil).
+					Once()
+			}
+
+			mfaLoginRepositoryMock := &repo.MFALoginRepositoryMock{}
+			if test.expectChallenge {
+				mfaLoginRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.MFALogin")).
+					Return(nil).
+					Once()
+			}
+
+			a := &Token{
+				log:           log,
+				accessTTL:     15 * time.Minute,
+				refreshTTL:    24 * time.Hour,
+				mfaTTL:        5 * time.Minute,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				loginEvents:   loginEventRepositoryMock,
+				mfaLogins:     mfaLoginRepositoryMock,
+				signer:        signerMock,
+			}
+
+			token, challenge, err := a.LoginVerified(test.user, test.scopes, model.LoginMethodMagicLink, &model.Client{})
+
+			if test.expectedError != nil {
+				assert.EqualError(t, err, test.expectedError.Error(), "wrong error returned")
+			} else if assert.NoError(t, err, "unexpected error") {
+				if test.expectChallenge {
+					assert.Nil(t, token, "no token should be issued before the second factor")
+					if assert.NotNil(t, challenge, "expected a challenge") {
+						assert.True(t, challenge.MFARequired, "challenge should require MFA")
+					}
+				} else {
+					assert.Nil(t, challenge, "unexpected challenge")
+					assert.Equal(t, test.expectedScope, token.Scope, "wrong scope")
+				}
+			}
+
+			signerMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+			loginEventRepositoryMock.AssertExpectations(t)
+			mfaLoginRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	mfaToken := "fakeMFAToken"
+	pending := func() *model.MFALogin {
+		return &model.MFALogin{ID: 7, UserID: "test", Scope: "posts:read posts:write", ExpiresAt: time.Now().Add(time.Minute)}
+	}
+
+	tests := []struct {
+		description string
+
+		login      *model.MFALogin
+		findErr    error
+		attemptErr error
+		user       *model.User
+		userErr    error
+		verifyErr  error
+		deleteErr  error
+
+		expectAttempt bool
+		expectVerify  bool
+		expectDelete  bool
+		expectedScope string
+		expectedError error
+	}{
+		{
+			description: "login completed",
+
+			login: pending(),
+			user:  &model.User{ID: 42, TokenUserID: "test", Role: model.RoleAuthor, MFAEnabled: true},
+
+			expectAttempt: true,
+			expectVerify:  true,
+			expectDelete:  true,
+			expectedScope: "posts:read posts:write",
+		},
+		{
+			description: "user was demoted since they entered their password",
+
+			login: pending(),
+			user:  &model.User{ID: 42, TokenUserID: "test", Role: model.RoleReader, MFAEnabled: true},
+
+			expectAttempt: true,
+			expectVerify:  true,
+			expectDelete:  true,
+			expectedScope: "posts:read",
+		},
+		{
+			description: "unknown MFA token",
+
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("unknown MFA token: invalid token"),
+		},
+		{
+			description: "expired MFA token",
+
+			login: &model.MFALogin{ID: 7, UserID: "test", ExpiresAt: time.Now().Add(-time.Minute)},
+
+			expectedError: errors.New("MFA token is expired: invalid token"),
+		},
+		{
+			description: "too many invalid codes",
+
+			login:      pending(),
+			attemptErr: errortype.ErrConflict,
+
+			expectAttempt: true,
+			expectedError: errors.New("too many invalid codes, log in again: invalid token"),
+		},
+		{
+			description: "invalid code",
+
+			login:     pending(),
+			user:      &model.User{ID: 42, TokenUserID: "test", Role: model.RoleAuthor, MFAEnabled: true},
+			verifyErr: errors.Wrap(errortype.ErrInvalidCredentials, "invalid code"),
+
+			expectAttempt: true,
+			expectVerify:  true,
+			expectedError: errors.New("invalid code: invalid credentials"),
+		},
+		{
+			description: "two-factor authentication disabled in the meantime",
+
+			login:     pending(),
+			user:      &model.User{ID: 42, TokenUserID: "test", Role: model.RoleAuthor},
+			verifyErr: errors.Wrap(errortype.ErrConflict, "two-factor authentication is not enabled"),
+
+			expectAttempt: true,
+			expectVerify:  true,
+			expectedError: errors.New("two-factor authentication is not enabled: datamodel conflict: invalid token"),
+		},
+		{
+			description: "MFA token used concurrently",
+
+			login:     pending(),
+			user:      &model.User{ID: 42, TokenUserID: "test", Role: model.RoleAuthor, MFAEnabled: true},
+			deleteErr: errortype.ErrNotFound,
+
+			expectAttempt: true,
+			expectVerify:  true,
+			expectDelete:  true,
+			expectedError: errors.New("MFA token was already used: invalid token"),
+		},
+		{
+			description: "user was deactivated since they entered their password",
+
+			login: pending(),
+			user:  &model.User{ID: 42, TokenUserID: "test", Role: model.RoleAuthor, Status: model.StatusDeactivated, MFAEnabled: true},
+
+			expectAttempt: true,
+			expectedError: errors.New("account is deactivated: forbidden: invalid token"),
+		},
+		{
+			description: "user does not exist anymore",
+
+			login:   pending(),
+			userErr: errortype.ErrNotFound,
+
+			expectAttempt: true,
+			expectedError: errors.New("user not found: invalid token"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			mfaLoginRepositoryMock := &repo.MFALoginRepositoryMock{}
+			mfaLoginRepositoryMock.
+				On("FindByHash", hashToken(mfaToken)).
+				Return(test.login, test.findErr).
+				Once()
+			if test.expectAttempt {
+				mfaLoginRepositoryMock.
+					On("Attempt", uint(7), uint(mfaMaxAttempts)).
+					Return(test.attemptErr).
+					Once()
+			}
+			if test.expectDelete {
+				mfaLoginRepositoryMock.
+					On("Delete", uint(7)).
+					Return(test.deleteErr).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.user != nil || test.userErr != nil {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.userErr).
+					Once()
+			}
+
+			mfaMock := &MFAVerifierMock{}
+			if test.expectVerify {
+				mfaMock.
+					On("Verify", test.user, "123456").
+					Return(test.verifyErr).
+					Once()
+			}
+
+			var stored *model.RefreshToken
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			signerMock := &SignerMock{}
+			if test.expectedError == nil {
+				refreshTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.RefreshToken")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.RefreshToken) }).
+					Return(nil).
+					Once()
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.Claims")).
+					Return("x.y.z", nil).
+					Once()
+			}
+
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			if test.expectedError == nil {
+				sessionRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.Session")).
+					Return(nil).
+					Once()
+			}
+
+			// Invalid codes are recorded as failed attempts
+			var event *model.LoginEvent
+			loginEventRepositoryMock := &repo.LoginEventRepositoryMock{}
+			if test.expectedError == nil || errors.Cause(test.verifyErr) == errortype.ErrInvalidCredentials {
+				loginEventRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.LoginEvent")).
+					Run(func(args mock.Arguments) { event = args.Get(0).(*model.LoginEvent) }).
+					Return(nil).
+					Once()
+			}
+
+			a := &Token{
+				log:           log,
+				accessTTL:     15 * time.Minute,
+				refreshTTL:    24 * time.Hour,
+				user:          userRepositoryMock,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				loginEvents:   loginEventRepositoryMock,
+				signer:        signerMock,
+				mfa:           mfaMock,
+				mfaLogins:     mfaLoginRepositoryMock,
+			}
+
+			token, err := a.LoginMFA(mfaToken, "123456", &model.Client{})
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, "x.y.z", token.AccessToken, "unexpected access token")
+				assert.Equal(t, test.expectedScope, token.Scope, "wrong scope")
+				if assert.NotNil(t, stored, "refresh token should be stored") {
+					assert.Equal(t, hashToken(token.RefreshToken), stored.Hash, "wrong refresh token hash")
+					assert.Len(t, stored.Family, 32, "refresh token should have a new family")
+				}
+			}
+			if event != nil {
+				assert.Equal(t, test.expectedError == nil, event.Success, "wrong outcome recorded")
+				assert.Equal(t, model.LoginMethodMFA, event.Method, "wrong method recorded")
+			}
+
+			mfaLoginRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+			mfaMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+			loginEventRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	refreshToken := "fakeRefreshToken"
+	used := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		description string
+
+		stored      *model.RefreshToken
+		findErr     error
+		markUsedErr error
+		user        *model.User
+		userErr     error
+
+		legacySession      bool
+		expectRevokeFamily bool
+		expectedScope      string
+		expectedError      error
+	}{
+		{
+			description: "refresh token exchanged",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", Scope: "posts:read posts:write", ExpiresAt: time.Now().Add(time.Hour)},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor},
+
+			expectedScope: "posts:read posts:write",
+		},
+		{
+			description: "user was demoted since they logged in",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", Scope: "posts:read posts:write", ExpiresAt: time.Now().Add(time.Hour)},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleReader},
+
+			expectedScope: "posts:read",
+		},
+		{
+			description: "unknown refresh token",
+
+			findErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("unknown refresh token: invalid token"),
+		},
+		{
+			description: "repository error",
+
+			findErr: errors.New("database exploded"),
+
+			expectedError: errors.New("database exploded"),
+		},
+		{
+			description: "revoked refresh token",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &used},
+
+			expectedError: errors.New("refresh token was revoked: invalid token"),
+		},
+		{
+			description: "expired refresh token",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(-time.Hour)},
+
+			expectedError: errors.New("refresh token is expired: invalid token"),
+		},
+		{
+			description: "reused refresh token revokes its family",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &used},
+
+			expectRevokeFamily: true,
+			expectedError:      errors.New("refresh token was already used: invalid token"),
+		},
+		{
+			description: "reused refresh token issued before sessions revokes its family",
+
+			stored:        &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour), UsedAt: &used},
+			legacySession: true,
+
+			expectRevokeFamily: true,
+			expectedError:      errors.New("refresh token was already used: invalid token"),
+		},
+		{
+			description: "concurrently used refresh token revokes its family",
+
+			stored:      &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			markUsedErr: errortype.ErrConflict,
+
+			expectRevokeFamily: true,
+			expectedError:      errors.New("refresh token was already used: invalid token"),
+		},
+		{
+			description: "user was deactivated since they logged in",
+
+			stored: &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			user:   &model.User{TokenUserID: "test", Role: model.RoleAuthor, Status: model.StatusDeactivated},
+
+			expectedError: errors.New("account is deactivated: forbidden: invalid token"),
+		},
+		{
+			description: "user does not exist anymore",
+
+			stored:  &model.RefreshToken{ID: 1, Family: "family", UserID: "test", ExpiresAt: time.Now().Add(time.Hour)},
+			userErr: errortype.ErrNotFound,
+
+			expectedError: errors.New("user not found: invalid token"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			refreshTokenRepositoryMock.
+				On("FindByHash", hashToken(refreshToken)).
+				Return(test.stored, test.findErr).
+				Once()
+			if test.stored != nil && test.stored.UsedAt == nil && test.stored.RevokedAt == nil && test.stored.ExpiresAt.After(time.Now()) {
+				refreshTokenRepositoryMock.
+					On("MarkUsed", uint(1), mock.AnythingOfType("time.Time")).
+					Return(test.markUsedErr).
+					Once()
+			}
+
+			// Reused refresh tokens revoke their session, or only their family if they have none
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			if test.expectRevokeFamily {
+				var revokeErr error
+				if test.legacySession {
+					revokeErr = errortype.ErrNotFound
+					refreshTokenRepositoryMock.
+						On("RevokeFamily", "family", mock.AnythingOfType("time.Time")).
+						Return(nil).
+						Once()
+				}
+				sessionRepositoryMock.
+					On("Revoke", "family", mock.AnythingOfType("time.Time")).
+					Return(revokeErr).
+					Once()
+			}
+			if test.expectedError == nil {
+				sessionRepositoryMock.
+					On("Touch", "family", mock.AnythingOfType("time.Time")).
+					Return(nil).
+					Once()
+			}
+
+			var stored *model.RefreshToken
+			if test.expectedError == nil {
+				refreshTokenRepositoryMock.
+					On("Store", mock.AnythingOfType("*model.RefreshToken")).
+					Run(func(args mock.Arguments) { stored = args.Get(0).(*model.RefreshToken) }).
+					Return(nil).
+					Once()
+			}
+
+			userRepositoryMock := &repo.UserRepositoryMock{}
+			if test.user != nil || test.userErr != nil {
+				userRepositoryMock.
+					On("Retrieve", &model.User{TokenUserID: "test"}).
+					Return(test.user, test.userErr).
+					Once()
+			}
+
+			signerMock := &SignerMock{}
+			if test.expectedError == nil {
+				signerMock.
+					On("Sign", mock.AnythingOfType("*service.Claims")).
+					Return("x.y.z", nil).
+					Once()
+			}
+
+			a := &Token{
+				log:           log,
+				accessTTL:     15 * time.Minute,
+				refreshTTL:    24 * time.Hour,
+				user:          userRepositoryMock,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				signer:        signerMock,
+			}
+
+			token, err := a.Refresh(refreshToken)
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.expectedScope, token.Scope, "wrong scope")
+				assert.NotEqual(t, refreshToken, token.RefreshToken, "refresh token should be rotated")
+				if assert.NotNil(t, stored, "new refresh token should be stored") {
+					assert.Equal(t, "family", stored.Family, "new refresh token should belong to the same family")
+				}
+			}
+
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+			userRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	expiresAt := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		description string
+
+		tokenID       string
+		sessionID     string
+		refreshToken  string
+		family        string
+		revokeErr     error
+		sessionErr    error
+		findErr       error
+		legacySession bool
+
+		expectedRevokedSessions []string
+		expectedError           error
+	}{
+		{
+			description: "access token and session revoked",
+
+			tokenID:      "fakeJTI",
+			sessionID:    "session",
+			refreshToken: "fakeRefreshToken",
+			family:       "session",
+
+			expectedRevokedSessions: []string{"session"},
+		},
+		{
+			description: "refresh token of another session",
+
+			tokenID:      "fakeJTI",
+			sessionID:    "session",
+			refreshToken: "fakeRefreshToken",
+			family:       "family",
+
+			expectedRevokedSessions: []string{"session", "family"},
+		},
+		{
+			description: "access token revoked",
+
+			tokenID:   "fakeJTI",
+			sessionID: "session",
+
+			expectedRevokedSessions: []string{"session"},
+		},
+		{
+			description: "tokens issued before sessions",
+
+			refreshToken:  "fakeRefreshToken",
+			family:        "family",
+			legacySession: true,
+
+			expectedRevokedSessions: []string{"family"},
+		},
+		{
+			description: "unknown refresh token",
+
+			tokenID:      "fakeJTI",
+			sessionID:    "session",
+			refreshToken: "fakeRefreshToken",
+			findErr:      errortype.ErrNotFound,
+
+			expectedRevokedSessions: []string{"session"},
+		},
+		{
+			description: "revocation failure",
+
+			tokenID:   "fakeJTI",
+			revokeErr: errors.New("database exploded"),
+
+			expectedError: errors.New("could not revoke access token: database exploded"),
+		},
+		{
+			description: "session revocation failure",
+
+			tokenID:    "fakeJTI",
+			sessionID:  "session",
+			sessionErr: errors.New("database exploded"),
+
+			expectedRevokedSessions: []string{"session"},
+			expectedError:           errors.New("could not revoke session: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			revokerMock := &RevokerMock{}
+			if test.tokenID != "" {
+				revokerMock.
+					On("Revoke", test.tokenID, expiresAt).
+					Return(test.revokeErr).
+					Once()
+			}
+
+			refreshTokenRepositoryMock := &repo.RefreshTokenRepositoryMock{}
+			if test.refreshToken != "" {
+				refreshTokenRepositoryMock.
+					On("FindByHash", hashToken(test.refreshToken)).
+					Return(&model.RefreshToken{Family: test.family}, test.findErr).
+					Once()
+			}
+
+			// Refresh tokens issued before sessions existed only have their family revoked
+			sessionRepositoryMock := &repo.SessionRepositoryMock{}
+			for _, id := range test.expectedRevokedSessions {
+				revokeErr := test.sessionErr
+				if test.legacySession {
+					revokeErr = errortype.ErrNotFound
+					refreshTokenRepositoryMock.
+						On("RevokeFamily", id, mock.AnythingOfType("time.Time")).
+						Return(nil).
+						Once()
+				}
+				sessionRepositoryMock.
+					On("Revoke", id, mock.AnythingOfType("time.Time")).
+					Return(revokeErr).
+					Once()
+			}
+
+			a := &Token{
+				log:           log,
+				refreshTokens: refreshTokenRepositoryMock,
+				sessions:      sessionRepositoryMock,
+				revoker:       revokerMock,
+			}
+
+			err := a.Logout(test.tokenID, test.sessionID, expiresAt, test.refreshToken)
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else {
+				assert.NoError(t, err, "unexpected error")
+			}
+
+			revokerMock.AssertExpectations(t)
+			refreshTokenRepositoryMock.AssertExpectations(t)
+			sessionRepositoryMock.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIssueImpersonation(t *testing.T) {
+	user := &model.User{TokenUserID: "bloggo|author", Role: model.RoleAuthor}
+
+	tests := []struct {
+		description string
+
+		signErr error
+
+		expectedError error
+	}{
+		{
+			description: "impersonation token issued",
+		},
+		{
+			description: "signer error",
+
+			signErr: errors.New("key not found"),
+
+			expectedError: errors.New("could not sign impersonation token: key not found"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var claims *Claims
+			signerMock := &SignerMock{}
+			signerMock.
+				On("Sign", mock.AnythingOfType("*service.Claims")).
+				Run(func(args mock.Arguments) { claims = args.Get(0).(*Claims) }).
+				Return("x.y.z", test.signErr).
+				Once()
+
+			a := &Token{
+				issuer:   "https://bloggo.example.com/",
+				audience: "bloggo",
+				signer:   signerMock,
+
+				log: logger.NewZeroLog(&bytes.Buffer{}),
+			}
+
+			token, err := a.IssueImpersonation(user, "bloggo|admin", 15*time.Minute)
+
+			if test.expectedError != nil {
+				if assert.Error(t, err, "expected an error") {
+					assert.Equal(t, test.expectedError.Error(), err.Error(), "wrong error returned")
+				}
+			} else if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, &model.Token{
+					AccessToken:    "x.y.z",
+					TokenType:      "Bearer",
+					ExpiresIn:      900,
+					Scope:          "posts:read posts:write posts:delete",
+					ImpersonatedBy: "bloggo|admin",
+				}, token, "wrong token issued")
+
+				assert.Equal(t, &Actor{Subject: "bloggo|admin"}, claims.Actor, "wrong act claim")
+				assert.Equal(t, "bloggo|author", claims.Subject, "wrong sub claim")
+				assert.Empty(t, claims.SessionID, "impersonation tokens should not belong to a session")
+				assert.NotEmpty(t, claims.Id, "impersonation tokens should be revocable")
+				assert.WithinDuration(t, time.Now().Add(15*time.Minute), time.Unix(claims.ExpiresAt, 0), 2*time.Second, "wrong expiration")
+			}
+
+			signerMock.AssertExpectations(t)
+		})
+	}
+}
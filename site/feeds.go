@@ -0,0 +1,181 @@
+package site
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/theme"
+)
+
+// feedSize is the maximum number of posts listed in the feeds
+const feedSize = 20
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Link      atomLink   `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Author    atomAuthor `xml:"author"`
+	Content   atomText   `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+// WriteAtom writes an Atom feed of the most recent posts
+func WriteAtom(w io.Writer, site theme.Site, posts []*model.BlogPost) error {
+	feed := atomFeed{
+		Title:   site.Title,
+		ID:      site.URL(""),
+		Updated: lastUpdate(posts).Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: site.URL("")},
+			{Href: site.URL("feed.xml"), Rel: "self"},
+		},
+	}
+
+	for _, post := range recent(posts) {
+		url := site.URL(PostPath(post.ID))
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:     post.Title,
+			ID:        url,
+			Link:      atomLink{Href: url},
+			Published: post.CreatedAt.Format(time.RFC3339),
+			Updated:   post.UpdatedAt.Format(time.RFC3339),
+			Author:    atomAuthor{Name: post.Author},
+			Content:   atomText{Type: "text", Body: post.Content},
+		})
+	}
+
+	return writeXML(w, feed)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+}
+
+// WriteRSS writes an RSS 2.0 feed of the most recent posts
+func WriteRSS(w io.Writer, site theme.Site, posts []*model.BlogPost) error {
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       site.Title,
+			Link:        site.URL(""),
+			Description: site.Description,
+		},
+	}
+
+	for _, post := range recent(posts) {
+		url := site.URL(PostPath(post.ID))
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       post.Title,
+			Link:        url,
+			GUID:        url,
+			PubDate:     post.CreatedAt.Format(time.RFC1123Z),
+			Description: post.Content,
+		})
+	}
+
+	return writeXML(w, feed)
+}
+
+type sitemap struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// WriteSitemap writes a sitemap listing the index pages and every post
+func WriteSitemap(w io.Writer, site theme.Site, posts []*model.BlogPost, totalPages int) error {
+	var s sitemap
+
+	for page := 1; page <= totalPages; page++ {
+		s.URLs = append(s.URLs, sitemapURL{Loc: site.URL(PagePath(page))})
+	}
+	if len(s.URLs) > 0 && len(posts) > 0 {
+		s.URLs[0].LastMod = lastUpdate(posts).Format("2006-01-02")
+	}
+
+	for _, post := range posts {
+		s.URLs = append(s.URLs, sitemapURL{
+			Loc:     site.URL(PostPath(post.ID)),
+			LastMod: post.UpdatedAt.Format("2006-01-02"),
+		})
+	}
+
+	return writeXML(w, s)
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(v)
+}
+
+// recent returns the most recent posts from a sorted list of posts
+func recent(posts []*model.BlogPost) []*model.BlogPost {
+	if len(posts) > feedSize {
+		return posts[:feedSize]
+	}
+	return posts
+}
+
+// lastUpdate returns the most recent update time of the given posts
+func lastUpdate(posts []*model.BlogPost) time.Time {
+	var last time.Time
+	for _, post := range posts {
+		if post.UpdatedAt.After(last) {
+			last = post.UpdatedAt
+		}
+	}
+	return last
+}
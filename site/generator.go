@@ -0,0 +1,321 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/theme"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// manifestFile is the name of the file in which the generator remembers
+// what it rendered during the previous build
+const manifestFile = ".bloggo-build.json"
+
+// BlogRepository represents a repository that allows to list blog posts
+type BlogRepository interface {
+	Find(contains *string, limit *uint) ([]*model.BlogPost, error)
+}
+
+// Options configures a static site build
+type Options struct {
+	OutputDir string
+	PageSize  int
+	Site      theme.Site
+}
+
+// Stats summarizes what a build did
+type Stats struct {
+	Rendered  int
+	Unchanged int
+	Removed   int
+}
+
+// manifest records the state of the previous build to allow incremental builds
+type manifest struct {
+	ThemeHash string               `json:"theme_hash"`
+	Site      theme.Site           `json:"site"`
+	PageSize  int                  `json:"page_size"`
+	Posts     map[string]time.Time `json:"posts"`
+	Static    []string             `json:"static"`
+}
+
+// Generator renders the blog posts as a static website
+type Generator struct {
+	posts BlogRepository
+	theme *theme.Theme
+
+	log *zerolog.Logger
+}
+
+// NewGenerator creates a Generator that reads posts from the given repository
+// and renders them using the given theme
+func NewGenerator(log *zerolog.Logger, blogPostRepository BlogRepository, th *theme.Theme) *Generator {
+	return &Generator{
+		posts: blogPostRepository,
+		theme: th,
+
+		log: log,
+	}
+}
+
+// Build renders the whole site in the output directory. Post pages are only
+// rendered again when the post was updated since the previous build, unless
+// the theme or the site options changed.
+func (g *Generator) Build(opts Options) (*Stats, error) {
+	if opts.PageSize < 1 {
+		return nil, errors.New("page size must be at least 1")
+	}
+
+	posts, err := g.posts.Find(nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read blog posts")
+	}
+	SortPosts(posts)
+
+	err = os.MkdirAll(opts.OutputDir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create output directory")
+	}
+
+	previous := g.readManifest(opts.OutputDir)
+	fullBuild := previous.ThemeHash != g.theme.Hash() ||
+		previous.Site != opts.Site ||
+		previous.PageSize != opts.PageSize
+
+	current := manifest{
+		ThemeHash: g.theme.Hash(),
+		Site:      opts.Site,
+		PageSize:  opts.PageSize,
+		Posts:     make(map[string]time.Time, len(posts)),
+	}
+
+	stats := &Stats{}
+	for _, post := range posts {
+		key := fmt.Sprint(post.ID)
+		current.Posts[key] = post.UpdatedAt
+
+		updatedAt, ok := previous.Posts[key]
+		if !fullBuild && ok && updatedAt.Equal(post.UpdatedAt) {
+			stats.Unchanged++
+			continue
+		}
+
+		err = g.render(opts.OutputDir, PostPath(post.ID), theme.PostTemplate, theme.PostPage{
+			Site: opts.Site,
+			Post: post,
+			URL:  opts.Site.URL(PostPath(post.ID)),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not render blog post %d", post.ID)
+		}
+		stats.Rendered++
+	}
+
+	// Remove the pages of the posts that were deleted since the last build
+	for key := range previous.Posts {
+		if _, ok := current.Posts[key]; ok {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(opts.OutputDir, "p", key))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not remove blog post %s", key)
+		}
+		stats.Removed++
+	}
+
+	// The listings only need to be rendered again if a post changed
+	if fullBuild || stats.Rendered > 0 || stats.Removed > 0 {
+		err = g.renderListings(opts, posts, len(previous.Posts))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	staticDir := filepath.Join(opts.OutputDir, theme.StaticDir)
+	if path := g.theme.StaticPath(); path != "" {
+		current.Static, err = copyDir(path, staticDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not copy theme assets")
+		}
+	}
+
+	// Remove the assets that are no longer part of the theme
+	err = removeStale(staticDir, previous.Static, current.Static)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not remove theme assets")
+	}
+
+	err = writeManifest(opts.OutputDir, current)
+	if err != nil {
+		return nil, err
+	}
+
+	g.log.Info().
+		Int("rendered", stats.Rendered).
+		Int("unchanged", stats.Unchanged).
+		Int("removed", stats.Removed).
+		Str("output", opts.OutputDir).
+		Msg("static site built")
+
+	return stats, nil
+}
+
+// renderListings renders the index pages, the feeds and the sitemap
+func (g *Generator) renderListings(opts Options, posts []*model.BlogPost, previousCount int) error {
+	totalPages := PageCount(len(posts), opts.PageSize)
+	for page := 1; page <= totalPages; page++ {
+		err := g.render(opts.OutputDir, PagePath(page), theme.IndexTemplate, NewIndexPage(opts.Site, posts, page, opts.PageSize))
+		if err != nil {
+			return errors.Wrapf(err, "could not render page %d", page)
+		}
+	}
+
+	// Remove the index pages that are now empty
+	for page := totalPages + 1; page <= PageCount(previousCount, opts.PageSize); page++ {
+		err := os.RemoveAll(filepath.Join(opts.OutputDir, PagePath(page)))
+		if err != nil {
+			return errors.Wrapf(err, "could not remove page %d", page)
+		}
+	}
+
+	files := map[string]func(io.Writer) error{
+		"feed.xml": func(w io.Writer) error { return WriteAtom(w, opts.Site, posts) },
+		"rss.xml":  func(w io.Writer) error { return WriteRSS(w, opts.Site, posts) },
+		"sitemap.xml": func(w io.Writer) error {
+			return WriteSitemap(w, opts.Site, posts, totalPages)
+		},
+	}
+	for name, write := range files {
+		buf := &bytes.Buffer{}
+		err := write(buf)
+		if err != nil {
+			return errors.Wrapf(err, "could not render %s", name)
+		}
+
+		err = ioutil.WriteFile(filepath.Join(opts.OutputDir, name), buf.Bytes(), 0644)
+		if err != nil {
+			return errors.Wrapf(err, "could not write %s", name)
+		}
+	}
+
+	return nil
+}
+
+// render executes a template into the index.html file of the given directory
+func (g *Generator) render(outputDir, path, name string, data interface{}) error {
+	buf := &bytes.Buffer{}
+	err := g.theme.Render(buf, name, data)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(outputDir, filepath.FromSlash(path))
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "index.html"), buf.Bytes(), 0644)
+}
+
+// readManifest reads the manifest of the previous build. A missing or invalid
+// manifest results in a full build.
+func (g *Generator) readManifest(outputDir string) manifest {
+	var m manifest
+
+	content, err := ioutil.ReadFile(filepath.Join(outputDir, manifestFile))
+	if err != nil {
+		return m
+	}
+
+	err = json.Unmarshal(content, &m)
+	if err != nil {
+		g.log.Warn().Err(err).Msg("ignoring invalid build manifest")
+		return manifest{}
+	}
+	return m
+}
+
+func writeManifest(outputDir string, m manifest) error {
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode build manifest")
+	}
+
+	err = ioutil.WriteFile(filepath.Join(outputDir, manifestFile), content, 0644)
+	return errors.Wrap(err, "could not write build manifest")
+}
+
+// SortPosts sorts posts from the most recent to the oldest
+func SortPosts(posts []*model.BlogPost) {
+	sort.SliceStable(posts, func(i, j int) bool {
+		if posts[i].CreatedAt.Equal(posts[j].CreatedAt) {
+			return posts[i].ID > posts[j].ID
+		}
+		return posts[i].CreatedAt.After(posts[j].CreatedAt)
+	})
+}
+
+// copyDir copies the content of a directory into another one, and returns the
+// paths of the files it copied, relative to the directories
+func copyDir(src, dst string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return ioutil.WriteFile(target, content, 0644)
+	})
+	return files, err
+}
+
+// removeStale removes the files of dir that were copied by the previous build but not by the current one
+func removeStale(dir string, previous, current []string) error {
+	kept := make(map[string]bool, len(current))
+	for _, file := range current {
+		kept[file] = true
+	}
+
+	for _, file := range previous {
+		// The manifest is read from the output directory, so its paths can't be trusted
+		rel := filepath.Clean(filepath.FromSlash(file))
+		if kept[file] || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(dir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
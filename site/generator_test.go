@@ -0,0 +1,240 @@
+package site
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/logger"
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/repo"
+	"github.com/Ullaakut/Bloggo/theme"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPosts(count int) []*model.BlogPost {
+	var posts []*model.BlogPost
+	for i := 1; i <= count; i++ {
+		posts = append(posts, &model.BlogPost{
+			ID:        uint(i),
+			Author:    "bob",
+			Title:     "lorem ipsum",
+			Content:   "dolor sit amet",
+			CreatedAt: time.Date(2018, 8, i, 0, 0, 0, 0, time.UTC),
+			UpdatedAt: time.Date(2018, 8, i, 0, 0, 0, 0, time.UTC),
+		})
+	}
+	return posts
+}
+
+type testBuild struct {
+	posts    []*model.BlogPost
+	pageSize int
+	title    string
+	// static are the files of the theme's static directory
+	static []string
+}
+
+// loadTestTheme loads a theme with the default templates and the given static files
+func loadTestTheme(t *testing.T, dir string, static []string) *theme.Theme {
+	themeDir := ""
+	if len(static) > 0 {
+		themeDir = filepath.Join(dir, ".theme")
+		os.RemoveAll(themeDir)
+		for _, file := range static {
+			path := filepath.Join(themeDir, theme.StaticDir, filepath.FromSlash(file))
+			err := os.MkdirAll(filepath.Dir(path), 0755)
+			if err == nil {
+				err = ioutil.WriteFile(path, []byte(file), 0644)
+			}
+			if err != nil {
+				t.Fatal("could not create theme assets")
+			}
+		}
+	}
+
+	th, err := theme.Load(themeDir)
+	if err != nil {
+		t.Fatal("could not load theme")
+	}
+	return th
+}
+
+func TestNewGenerator(t *testing.T) {
+	blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+	logsBuff := &bytes.Buffer{}
+	log := logger.NewZeroLog(logsBuff)
+	th := &theme.Theme{}
+
+	g := NewGenerator(log, blogPostRepositoryMock, th)
+
+	assert.Equal(t, blogPostRepositoryMock, g.posts, "unexpected blog post repository set")
+	assert.Equal(t, th, g.theme, "unexpected theme set")
+	assert.Equal(t, log, g.log, "unexpected logger set")
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		description string
+
+		// builds are run in sequence in the same output directory
+		builds        []testBuild
+		repositoryErr error
+
+		expectedStats   Stats
+		expectedFiles   []string
+		unexpectedFiles []string
+		expectedErr     error
+	}{
+		{
+			description: "full build with pagination",
+
+			builds: []testBuild{{newTestPosts(3), 2, "Bloggo", nil}},
+
+			expectedStats: Stats{Rendered: 3},
+			expectedFiles: []string{
+				"index.html",
+				"page/2/index.html",
+				"p/1/index.html",
+				"p/2/index.html",
+				"p/3/index.html",
+				"feed.xml",
+				"rss.xml",
+				"sitemap.xml",
+				manifestFile,
+			},
+			unexpectedFiles: []string{"page/3/index.html"},
+		},
+		{
+			description: "incremental build only renders updated posts",
+
+			builds: []testBuild{
+				{newTestPosts(3), 10, "Bloggo", nil},
+				{func() []*model.BlogPost {
+					posts := newTestPosts(3)
+					posts[1].UpdatedAt = posts[1].UpdatedAt.Add(time.Hour)
+					return posts
+				}(), 10, "Bloggo", nil},
+			},
+
+			expectedStats: Stats{Rendered: 1, Unchanged: 2},
+		},
+		{
+			description: "incremental build removes deleted posts",
+
+			builds: []testBuild{
+				{newTestPosts(3), 2, "Bloggo", nil},
+				{newTestPosts(1), 2, "Bloggo", nil},
+			},
+
+			expectedStats:   Stats{Unchanged: 1, Removed: 2},
+			expectedFiles:   []string{"index.html", "p/1/index.html"},
+			unexpectedFiles: []string{"p/2/index.html", "p/3/index.html", "page/2/index.html"},
+		},
+		{
+			description: "changing the page size triggers a full build",
+
+			builds: []testBuild{
+				{newTestPosts(3), 2, "Bloggo", nil},
+				{newTestPosts(3), 1, "Bloggo", nil},
+			},
+
+			expectedStats: Stats{Rendered: 3},
+			expectedFiles: []string{"page/3/index.html"},
+		},
+		{
+			description: "changing the site title triggers a full build",
+
+			builds: []testBuild{
+				{newTestPosts(3), 10, "Bloggo", nil},
+				{newTestPosts(3), 10, "The Dunder Mifflin blog", nil},
+			},
+
+			expectedStats: Stats{Rendered: 3},
+		},
+		{
+			description: "incremental build removes the assets that are no longer in the theme",
+
+			builds: []testBuild{
+				{newTestPosts(3), 10, "Bloggo", []string{"style.css", "img/logo.png"}},
+				{newTestPosts(3), 10, "Bloggo", []string{"style.css"}},
+			},
+
+			expectedStats:   Stats{Unchanged: 3},
+			expectedFiles:   []string{"static/style.css"},
+			unexpectedFiles: []string{"static/img/logo.png"},
+		},
+		{
+			description: "invalid page size",
+
+			builds: []testBuild{{newTestPosts(1), 0, "Bloggo", nil}},
+
+			expectedErr: errors.New("page size must be at least 1"),
+		},
+		{
+			description: "repository error",
+
+			builds:        []testBuild{{nil, 10, "Bloggo", nil}},
+			repositoryErr: errors.New("database exploded"),
+
+			expectedErr: errors.New("could not read blog posts: database exploded"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bloggo-site")
+			if err != nil {
+				t.Fatal("could not create temporary directory")
+			}
+			defer os.RemoveAll(dir)
+
+			logsBuff := &bytes.Buffer{}
+			log := logger.NewZeroLog(logsBuff)
+
+			var stats *Stats
+			for _, build := range test.builds {
+				blogPostRepositoryMock := &repo.BlogPostRepositoryMock{}
+				if build.pageSize > 0 {
+					blogPostRepositoryMock.
+						On("Find", (*string)(nil), (*uint)(nil)).
+						Return(build.posts, test.repositoryErr).
+						Once()
+				}
+
+				th := loadTestTheme(t, dir, build.static)
+				g := NewGenerator(log, blogPostRepositoryMock, th)
+				stats, err = g.Build(Options{
+					OutputDir: dir,
+					PageSize:  build.pageSize,
+					Site:      theme.Site{Title: build.title, BaseURL: "https://blog.example.com"},
+				})
+
+				blogPostRepositoryMock.AssertExpectations(t)
+			}
+
+			if test.expectedErr != nil {
+				assert.Error(t, err, "expected an error")
+				assert.Equal(t, test.expectedErr.Error(), err.Error(), "unexpected error")
+				return
+			}
+
+			assert.NoError(t, err, "unexpected error")
+			assert.Equal(t, test.expectedStats, *stats, "unexpected build stats")
+
+			for _, file := range test.expectedFiles {
+				_, err := os.Stat(filepath.Join(dir, file))
+				assert.NoError(t, err, "expected file %s to exist", file)
+			}
+			for _, file := range test.unexpectedFiles {
+				_, err := os.Stat(filepath.Join(dir, file))
+				assert.True(t, os.IsNotExist(err), "expected file %s not to exist", file)
+			}
+		})
+	}
+}
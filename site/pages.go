@@ -0,0 +1,59 @@
+package site
+
+import (
+	"fmt"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/Ullaakut/Bloggo/theme"
+)
+
+// PostPath returns the path of a post's page relative to the site root
+func PostPath(id uint) string {
+	return fmt.Sprintf("p/%d/", id)
+}
+
+// PagePath returns the path of an index page relative to the site root
+func PagePath(page int) string {
+	if page <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("page/%d/", page)
+}
+
+// PageCount returns the number of index pages needed to list the given number
+// of posts. There is always at least one page, even when there are no posts.
+func PageCount(posts, pageSize int) int {
+	if posts == 0 {
+		return 1
+	}
+	return (posts + pageSize - 1) / pageSize
+}
+
+// NewIndexPage builds the data of the given index page from the sorted list of posts
+func NewIndexPage(site theme.Site, posts []*model.BlogPost, page, pageSize int) theme.IndexPage {
+	totalPages := PageCount(len(posts), pageSize)
+
+	start := (page - 1) * pageSize
+	if start > len(posts) {
+		start = len(posts)
+	}
+	end := start + pageSize
+	if end > len(posts) {
+		end = len(posts)
+	}
+
+	index := theme.IndexPage{
+		Site:       site,
+		Posts:      posts[start:end],
+		Page:       page,
+		TotalPages: totalPages,
+		URL:        site.URL(PagePath(page)),
+	}
+	if page > 1 {
+		index.PrevURL = site.URL(PagePath(page - 1))
+	}
+	if page < totalPages {
+		index.NextURL = site.URL(PagePath(page + 1))
+	}
+	return index
+}
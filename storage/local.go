@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+
+	"github.com/pkg/errors"
+)
+
+// Local is a blob store that keeps blobs as files in a directory
+type Local struct {
+	dir string
+}
+
+// NewLocal creates a Local blob store that writes in the given directory
+func NewLocal(dir string) *Local {
+	return &Local{
+		dir: dir,
+	}
+}
+
+// Put writes a blob. The content is written in a temporary file first, so that
+// a blob is never partially written.
+func (l *Local) Put(key string, content []byte, contentType string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "could not create blob directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".upload-")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary blob")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not write blob")
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not set blob permissions")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), path), "could not write blob")
+}
+
+// Get opens a blob
+func (l *Local) Get(key string) (io.ReadCloser, error) {
+	path, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(errortype.ErrNotFound, "blob %s", key)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open blob")
+	}
+	return file, nil
+}
+
+// Delete removes a blob. Deleting a blob that does not exist is not an error.
+func (l *Local) Delete(key string) error {
+	path, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not delete blob")
+	}
+	return nil
+}
+
+func (l *Local) path(key string) (string, error) {
+	err := checkKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
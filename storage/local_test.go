@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloggo-storage")
+	if err != nil {
+		t.Fatal("could not create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	l := NewLocal(dir)
+
+	err = l.Put("ab/cdef", []byte("lorem ipsum"), "text/plain")
+	assert.NoError(t, err, "unexpected error")
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "ab", "cdef"))
+	assert.NoError(t, err, "blob should be written in the directory")
+	assert.Equal(t, "lorem ipsum", string(content), "wrong blob content")
+
+	blob, err := l.Get("ab/cdef")
+	if assert.NoError(t, err, "unexpected error") {
+		content, _ = ioutil.ReadAll(blob)
+		blob.Close()
+		assert.Equal(t, "lorem ipsum", string(content), "wrong blob content")
+	}
+
+	assert.NoError(t, l.Delete("ab/cdef"), "unexpected error")
+	assert.NoError(t, l.Delete("ab/cdef"), "deleting a missing blob should not fail")
+
+	_, err = l.Get("ab/cdef")
+	assert.Equal(t, errortype.ErrNotFound, errors.Cause(err), "expected a not found error")
+}
+
+func TestCheckKey(t *testing.T) {
+	valid := []string{"ab/cdef", "cdef", "a.b/c.d"}
+	for _, key := range valid {
+		assert.NoError(t, checkKey(key), "key %q should be valid", key)
+	}
+
+	invalid := []string{"", "/etc/passwd", "../secret", "ab/../../secret", "ab//cd", "ab/", `ab\cd`}
+	for _, key := range invalid {
+		assert.Error(t, checkKey(key), "key %q should be invalid", key)
+	}
+}
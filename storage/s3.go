@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/errortype"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	amzDateFormat = "20060102T150405Z"
+	amzAlgorithm  = "AWS4-HMAC-SHA256"
+)
+
+// S3Config configures the connection to an S3-compatible object storage
+type S3Config struct {
+	// Endpoint is the URL of the storage service, such as https://s3.eu-west-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// PathStyle puts the bucket name in the path of the URLs instead of
+	// the host name, which is what most self-hosted implementations expect
+	PathStyle bool
+}
+
+// S3 is a blob store that keeps blobs in a bucket of an S3-compatible object storage.
+// Requests are authenticated using AWS signature version 4.
+type S3 struct {
+	config S3Config
+	client *http.Client
+
+	now func() time.Time
+}
+
+// NewS3 creates an S3 blob store that sends its requests using the given HTTP client
+func NewS3(config S3Config, client *http.Client) (*S3, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid s3 endpoint")
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("missing s3 bucket name")
+	}
+
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &S3{
+		config: config,
+		client: client,
+
+		now: time.Now,
+	}, nil
+}
+
+// Put uploads a blob
+func (s *S3) Put(key string, content []byte, contentType string) error {
+	req, err := s.request(http.MethodPut, key, content)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := s.do(req, content)
+	if err != nil {
+		return errors.Wrap(err, "could not upload blob")
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads a blob
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	req, err := s.request(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not download blob")
+	}
+	return resp.Body, nil
+}
+
+// Delete removes a blob. Deleting a blob that does not exist is not an error.
+func (s *S3) Delete(key string) error {
+	req, err := s.request(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do(req, nil)
+	if errors.Cause(err) == errortype.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not delete blob")
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request creates a request for the object with the given key
+func (s *S3) request(method, key string, content []byte) (*http.Request, error) {
+	err := checkKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, _ := url.Parse(s.config.Endpoint)
+	if s.config.PathStyle {
+		endpoint.Path += "/" + s.config.Bucket + "/" + key
+	} else {
+		endpoint.Host = s.config.Bucket + "." + endpoint.Host
+		endpoint.Path += "/" + key
+	}
+
+	var body io.Reader
+	if content != nil {
+		body = bytes.NewReader(content)
+	}
+
+	req, err := http.NewRequest(method, endpoint.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create s3 request")
+	}
+	return req, nil
+}
+
+// do signs and sends a request, and turns error responses into errors
+func (s *S3) do(req *http.Request, content []byte) (*http.Response, error) {
+	payload := sha256.Sum256(content)
+	signV4(req, hex.EncodeToString(payload[:]), s.config, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.Wrap(errortype.ErrNotFound, req.URL.Path)
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return nil, errors.Errorf("s3 responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+}
+
+// signV4 adds an AWS signature version 4 to a request. All of the headers of the
+// request at the time of signing are signed.
+func signV4(req *http.Request, payloadHash string, config S3Config, now time.Time) {
+	now = now.UTC()
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, config.Region, "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		amzAlgorithm,
+		now.Format(amzDateFormat),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+config.SecretKey), date)
+	key = hmacSHA256(key, config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		amzAlgorithm, config.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes a string the way AWS expects it in canonical requests
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,30 @@
+// Package storage contains the blob stores in which Bloggo keeps uploaded files
+package storage
+
+import (
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BlobStore represents a store of binary objects identified by keys
+type BlobStore interface {
+	Put(key string, content []byte, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+// checkKey makes sure a key can't be used to escape the store
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return errors.Errorf("invalid blob key %q", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return errors.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,7 +19,7 @@ const (
 	Delete HTTPMethod = "DELETE"
 )
 
-const APIURL string = "http://0.0.0.0:4242"
+const APIURL string = "http://0.0.0.0:4242/api"
 
 var adminToken string
 var nonAdminToken string
@@ -46,17 +46,17 @@ func TestRegister(t *testing.T) {
 			expectedCode: 201,
 		},
 		{
-			description: "register admin - valid",
+			description: "register admin - should fail",
 
 			body: []byte(`
 				{
 					"email": "bob-admin@vance-refrigeration.com",
 					"password": "refrigerator2000",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
-			expectedCode: 201,
+			expectedCode: 403,
 		},
 		{
 			description: "register user that already exists - should fail",
@@ -77,7 +77,7 @@ func TestRegister(t *testing.T) {
 				{
 					"email": "michael.scarn@midnight.org",
 					"password": "goldenface",
-					"is_admin": true
+					"role": "admin"
 				}
 			`),
 
@@ -128,7 +128,7 @@ func TestLogin(t *testing.T) {
 			`),
 			nonAdminToken: true,
 
-			expectedCode: 201,
+			expectedCode: 200,
 		},
 		{
 			description: "login admin - valid",
@@ -141,7 +141,7 @@ func TestLogin(t *testing.T) {
 			`),
 			adminToken: true,
 
-			expectedCode: 201,
+			expectedCode: 200,
 		},
 	}
 
@@ -163,11 +163,15 @@ func TestLogin(t *testing.T) {
 				if err != nil {
 					assert.Equal(t, test.expectedError.Error(), err.Error(), "invalid error received")
 				}
+				var token struct {
+					AccessToken string `json:"access_token"`
+				}
+				json.Unmarshal(body, &token)
 				if test.adminToken {
-					adminToken = strings.Replace(string(body), "\"", "", -1)
+					adminToken = token.AccessToken
 				}
 				if test.nonAdminToken {
-					nonAdminToken = strings.Replace(string(body), "\"", "", -1)
+					nonAdminToken = token.AccessToken
 				}
 				assert.Equal(t, test.expectedCode, response.StatusCode, "invalid http code received")
 			}
@@ -176,6 +180,67 @@ func TestLogin(t *testing.T) {
 
 }
 
+// TestUserRoutes checks that the user routes are registered and restricted to the right scopes
+func TestUserRoutes(t *testing.T) {
+	tests := []struct {
+		description string
+
+		route  string
+		method HTTPMethod
+		token  string
+
+		expectedCode  int
+		expectedError error
+	}{
+		{
+			description: "list users",
+
+			route:  "/users",
+			method: Get,
+			token:  adminToken,
+
+			expectedCode: 200,
+		},
+		{
+			description: "list users without the users:admin scope - should fail",
+
+			route:  "/users",
+			method: Get,
+			token:  nonAdminToken,
+
+			expectedCode: 403,
+		},
+		{
+			description: "get the account of the authenticated user",
+
+			route:  "/users/me",
+			method: Get,
+			token:  nonAdminToken,
+
+			expectedCode: 200,
+		},
+	}
+
+	for _, test := range tests {
+		client := &http.Client{}
+
+		t.Run(test.description, func(t *testing.T) {
+			req, err := http.NewRequest(string(test.method), APIURL+test.route, nil)
+			if err != nil {
+				assert.FailNowf(t, err.Error(), "could not prepare HTTP request for %s%s", APIURL, test.route)
+			}
+
+			req.Header.Add("Authorization", "Bearer "+test.token)
+			response, err := client.Do(req)
+			if err != nil {
+				assert.Equal(t, test.expectedError.Error(), err.Error(), "invalid error received")
+			} else {
+				assert.Equal(t, test.expectedCode, response.StatusCode, "invalid http code received")
+			}
+		})
+	}
+}
+
 // TODO: Find a way to remake the authorization functional tests
 // For now the only way I can imagine is to parse the valid token that was created in the RegisterTests
 // Modify it to trigger the various errors we want to replicate
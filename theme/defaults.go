@@ -0,0 +1,103 @@
+package theme
+
+// defaultTemplates are used for every template that the theme directory
+// does not override. Themes can override the whole page templates
+// (index.html, post.html) or only some of the blocks they use
+// (head.html, header.html, footer.html).
+const defaultTemplates = `
+{{define "head.html"}}
+	<meta charset="utf-8" />
+	<meta name="viewport" content="width=device-width, initial-scale=1" />
+	{{with .Meta}}
+	{{if .URL}}<link rel="canonical" href="{{.URL}}" />{{end}}
+	{{if .Description}}<meta name="description" content="{{.Description}}" />{{end}}
+	<meta property="og:site_name" content="{{$.Site.Title}}" />
+	<meta property="og:type" content="{{.Type}}" />
+	<meta property="og:title" content="{{.Title}}" />
+	{{if .Description}}<meta property="og:description" content="{{.Description}}" />{{end}}
+	{{if .URL}}<meta property="og:url" content="{{.URL}}" />{{end}}
+	{{if not .Published.IsZero}}<meta property="article:published_time" content="{{isodate .Published}}" />{{end}}
+	{{if not .Modified.IsZero}}<meta property="article:modified_time" content="{{isodate .Modified}}" />{{end}}
+	{{if .Author}}<meta property="article:author" content="{{.Author}}" />{{end}}
+	<meta name="twitter:card" content="summary" />
+	<meta name="twitter:title" content="{{.Title}}" />
+	{{if .Description}}<meta name="twitter:description" content="{{.Description}}" />{{end}}
+	{{end}}
+	<link rel="alternate" type="application/atom+xml" title="{{.Site.Title}}" href="{{.Site.URL "feed.xml"}}" />
+	<link rel="alternate" type="application/rss+xml" title="{{.Site.Title}}" href="{{.Site.URL "rss.xml"}}" />
+	<style>
+		body { font-family: Roboto, sans-serif; max-width: 46rem; margin: 0 auto; padding: 1rem; color: #222; }
+		a { color: #1b6ac9; text-decoration: none; }
+		header, footer { border-bottom: 1px solid #ddd; margin-bottom: 2rem; }
+		footer { border-top: 1px solid #ddd; border-bottom: none; margin-top: 2rem; padding-top: 1rem; }
+		time { color: #777; font-size: 0.9rem; }
+		nav.pagination { display: flex; justify-content: space-between; }
+	</style>
+{{end}}
+
+{{define "header.html"}}
+	<header>
+		<h1><a href="{{.Site.URL ""}}">{{.Site.Title}}</a></h1>
+		{{with .Site.Description}}<p>{{.}}</p>{{end}}
+	</header>
+{{end}}
+
+{{define "footer.html"}}
+	<footer>
+		<p>Powered by Bloggo</p>
+	</footer>
+{{end}}
+
+{{define "index.html"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<title>{{.Site.Title}}{{if gt .Page 1}} - Page {{.Page}}{{end}}</title>
+	{{template "head.html" .}}
+</head>
+<body>
+	{{template "header.html" .}}
+	<main>
+		{{range .Posts}}
+		<article>
+			<h2><a href="{{$.Site.URL (printf "p/%d/" .ID)}}">{{.Title}}</a></h2>
+			<time datetime="{{isodate .CreatedAt}}">{{date .CreatedAt}}</time>
+			<p>{{excerpt .Content 280}}</p>
+		</article>
+		{{else}}
+		<p>Nothing here yet.</p>
+		{{end}}
+	</main>
+	{{if gt .TotalPages 1}}
+	<nav class="pagination">
+		{{if .PrevURL}}<a rel="prev" href="{{.PrevURL}}">Newer posts</a>{{else}}<span></span>{{end}}
+		<span>Page {{.Page}} of {{.TotalPages}}</span>
+		{{if .NextURL}}<a rel="next" href="{{.NextURL}}">Older posts</a>{{else}}<span></span>{{end}}
+	</nav>
+	{{end}}
+	{{template "footer.html" .}}
+</body>
+</html>
+{{end}}
+
+{{define "post.html"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+	<title>{{.Post.Title}} - {{.Site.Title}}</title>
+	{{template "head.html" .}}
+</head>
+<body>
+	{{template "header.html" .}}
+	<main>
+		<article>
+			<h2>{{.Post.Title}}</h2>
+			<time datetime="{{isodate .Post.CreatedAt}}">{{date .Post.CreatedAt}}</time>
+			{{range paragraphs .Post.Content}}
+			<p>{{.}}</p>
+			{{end}}
+		</article>
+	</main>
+	{{template "footer.html" .}}
+</body>
+</html>
+{{end}}
+`
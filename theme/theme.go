@@ -0,0 +1,208 @@
+package theme
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/pkg/errors"
+)
+
+// Template names that every theme must be able to render
+const (
+	IndexTemplate = "index.html"
+	PostTemplate  = "post.html"
+)
+
+// StaticDir is the name of the theme subdirectory that holds static assets
+const StaticDir = "static"
+
+// Site describes the blog being rendered
+type Site struct {
+	Title       string
+	Description string
+	BaseURL     string
+}
+
+// URL returns the absolute URL for the given path on the site
+func (s Site) URL(path string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+// IndexPage is the data given to the index template
+type IndexPage struct {
+	Site  Site
+	Posts []*model.BlogPost
+
+	Page       int
+	TotalPages int
+	URL        string
+	PrevURL    string
+	NextURL    string
+}
+
+// Meta returns the description of the index page for search engines and social networks
+func (p IndexPage) Meta() Meta {
+	return Meta{
+		Type:        "website",
+		Title:       p.Site.Title,
+		Description: p.Site.Description,
+		URL:         p.URL,
+	}
+}
+
+// PostPage is the data given to the post template
+type PostPage struct {
+	Site Site
+	Post *model.BlogPost
+	URL  string
+}
+
+// Meta returns the description of the post page for search engines and social networks
+func (p PostPage) Meta() Meta {
+	return Meta{
+		Type:        "article",
+		Title:       p.Post.Title,
+		Description: excerpt(p.Post.Content, 200),
+		URL:         p.URL,
+		Author:      p.Post.Author,
+		Published:   p.Post.CreatedAt,
+		Modified:    p.Post.UpdatedAt,
+	}
+}
+
+// Meta describes a page in its Open Graph and Twitter card tags
+type Meta struct {
+	Type        string
+	Title       string
+	Description string
+	URL         string
+
+	// Only set for articles
+	Author    string
+	Published time.Time
+	Modified  time.Time
+}
+
+// Theme holds the parsed templates used to render the blog
+type Theme struct {
+	dir       string
+	templates *template.Template
+	hash      string
+}
+
+// Load parses the default templates, then overrides them with the
+// templates found in the given directory, if any.
+func Load(dir string) (*Theme, error) {
+	tmpl, err := template.New("theme").Funcs(funcs).Parse(defaultTemplates)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse default templates")
+	}
+
+	digest := sha256.New()
+	io.WriteString(digest, defaultTemplates)
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not list theme templates")
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not read template %q", file)
+			}
+
+			// A file that does not define any template overrides the
+			// default template that has the same name.
+			name := filepath.Base(file)
+			_, err = tmpl.New(name).Parse(string(content))
+			if err != nil {
+				return nil, errors.Wrapf(err, "could not parse template %q", file)
+			}
+
+			io.WriteString(digest, name)
+			digest.Write(content)
+		}
+	}
+
+	return &Theme{
+		dir:       dir,
+		templates: tmpl,
+		hash:      hex.EncodeToString(digest.Sum(nil)),
+	}, nil
+}
+
+// Render executes the template with the given name
+func (t *Theme) Render(w io.Writer, name string, data interface{}) error {
+	return t.templates.ExecuteTemplate(w, name, data)
+}
+
+// Hash returns a digest of the templates used by the theme, which changes
+// whenever one of them is modified
+func (t *Theme) Hash() string {
+	return t.hash
+}
+
+// StaticPath returns the path of the theme's static assets directory, or an
+// empty string if the theme does not have any.
+func (t *Theme) StaticPath() string {
+	if t.dir == "" {
+		return ""
+	}
+
+	path := filepath.Join(t.dir, StaticDir)
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return ""
+	}
+	return path
+}
+
+var funcs = template.FuncMap{
+	"paragraphs": paragraphs,
+	"excerpt":    excerpt,
+	"date": func(t time.Time) string {
+		return t.Format("January 2, 2006")
+	},
+	"isodate": func(t time.Time) string {
+		return t.Format(time.RFC3339)
+	},
+}
+
+// paragraphs splits a post's content on blank lines
+func paragraphs(content string) []string {
+	var result []string
+	for _, p := range strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n\n") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// excerpt returns the beginning of a post's content, cut on a word boundary
+func excerpt(content string, length int) string {
+	content = strings.Join(strings.Fields(content), " ")
+	runes := []rune(content)
+	if len(runes) <= length {
+		return content
+	}
+
+	cut := string(runes[:length])
+	if idx := strings.LastIndex(cut, " "); idx > 0 {
+		cut = cut[:idx]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,169 @@
+package theme
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Ullaakut/Bloggo/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		description string
+
+		files map[string]string
+
+		expectedIndex string
+		expectedPost  string
+		expectedErr   bool
+	}{
+		{
+			description: "default templates",
+
+			expectedIndex: `<a href="https://blog.example.com/p/42/">lorem ipsum</a>`,
+			expectedPost:  `<p>dolor sit amet</p>`,
+		},
+		{
+			description: "override a full page",
+
+			files: map[string]string{
+				"post.html": `<h1>{{.Post.Title}}</h1>`,
+			},
+
+			expectedIndex: `<a href="https://blog.example.com/p/42/">lorem ipsum</a>`,
+			expectedPost:  `<h1>lorem ipsum</h1>`,
+		},
+		{
+			description: "override a block",
+
+			files: map[string]string{
+				"footer.html": `<footer>custom footer</footer>`,
+			},
+
+			expectedIndex: `<footer>custom footer</footer>`,
+			expectedPost:  `<footer>custom footer</footer>`,
+		},
+		{
+			description: "invalid template",
+
+			files: map[string]string{
+				"index.html": `{{.Site.Title`,
+			},
+
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "bloggo-theme")
+			if err != nil {
+				t.Fatal("could not create temporary directory")
+			}
+			defer os.RemoveAll(dir)
+
+			for name, content := range test.files {
+				err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+				if err != nil {
+					t.Fatal("could not write template")
+				}
+			}
+
+			th, err := Load(dir)
+			if test.expectedErr {
+				assert.Error(t, err, "expected an error")
+				return
+			}
+			assert.NoError(t, err, "unexpected error")
+
+			site := Site{Title: "Bloggo", BaseURL: "https://blog.example.com/"}
+			post := &model.BlogPost{
+				ID:        42,
+				Title:     "lorem ipsum",
+				Content:   "dolor sit amet",
+				CreatedAt: time.Date(2018, 8, 3, 0, 0, 0, 0, time.UTC),
+			}
+
+			index := &bytes.Buffer{}
+			err = th.Render(index, IndexTemplate, IndexPage{Site: site, Posts: []*model.BlogPost{post}, Page: 1, TotalPages: 1})
+			assert.NoError(t, err, "unexpected error rendering index")
+			assert.Contains(t, index.String(), test.expectedIndex, "unexpected index page")
+
+			page := &bytes.Buffer{}
+			err = th.Render(page, PostTemplate, PostPage{Site: site, Post: post})
+			assert.NoError(t, err, "unexpected error rendering post")
+			assert.Contains(t, page.String(), test.expectedPost, "unexpected post page")
+		})
+	}
+}
+
+func TestMeta(t *testing.T) {
+	th, err := Load("")
+	assert.NoError(t, err, "unexpected error")
+
+	site := Site{Title: "Bloggo", Description: "a blog", BaseURL: "https://blog.example.com"}
+	post := &model.BlogPost{
+		ID:        42,
+		Title:     "how to eat chinese food",
+		Content:   "using chopsticks",
+		Author:    "bob",
+		CreatedAt: time.Date(2018, 8, 3, 0, 0, 0, 0, time.UTC),
+	}
+
+	page := &bytes.Buffer{}
+	err = th.Render(page, PostTemplate, PostPage{Site: site, Post: post, URL: site.URL("p/42/")})
+	assert.NoError(t, err, "unexpected error rendering post")
+	assert.Contains(t, page.String(), `<meta property="og:type" content="article" />`)
+	assert.Contains(t, page.String(), `<meta property="og:title" content="how to eat chinese food" />`)
+	assert.Contains(t, page.String(), `<meta property="og:description" content="using chopsticks" />`)
+	assert.Contains(t, page.String(), `<meta property="og:url" content="https://blog.example.com/p/42/" />`)
+	assert.Contains(t, page.String(), `<meta property="article:published_time" content="2018-08-03T00:00:00Z" />`)
+	assert.Contains(t, page.String(), `<meta name="twitter:card" content="summary" />`)
+	assert.NotContains(t, page.String(), `article:modified_time`)
+
+	index := &bytes.Buffer{}
+	err = th.Render(index, IndexTemplate, IndexPage{Site: site, Page: 1, TotalPages: 1, URL: site.URL("")})
+	assert.NoError(t, err, "unexpected error rendering index")
+	assert.Contains(t, index.String(), `<meta property="og:type" content="website" />`)
+	assert.Contains(t, index.String(), `<meta name="twitter:title" content="Bloggo" />`)
+	assert.Contains(t, index.String(), `<link rel="canonical" href="https://blog.example.com/" />`)
+}
+
+func TestHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloggo-theme")
+	if err != nil {
+		t.Fatal("could not create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	defaults, err := Load("")
+	assert.NoError(t, err, "unexpected error")
+
+	empty, err := Load(dir)
+	assert.NoError(t, err, "unexpected error")
+	assert.Equal(t, defaults.Hash(), empty.Hash(), "empty theme should hash like the defaults")
+
+	err = ioutil.WriteFile(filepath.Join(dir, "footer.html"), []byte(`<footer></footer>`), 0644)
+	if err != nil {
+		t.Fatal("could not write template")
+	}
+
+	custom, err := Load(dir)
+	assert.NoError(t, err, "unexpected error")
+	assert.NotEqual(t, defaults.Hash(), custom.Hash(), "overriding a template should change the hash")
+}
+
+func TestExcerpt(t *testing.T) {
+	assert.Equal(t, "short", excerpt("short", 10))
+	assert.Equal(t, "lorem ipsum…", excerpt("lorem ipsum dolor sit amet", 14))
+	assert.Equal(t, "a b", excerpt("a\n\n  b", 10))
+}
+
+func TestParagraphs(t *testing.T) {
+	assert.Equal(t, []string{"first", "second line\nstill second"}, paragraphs("first\r\n\r\n\nsecond line\nstill second\n\n"))
+}
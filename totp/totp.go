@@ -0,0 +1,114 @@
+// Package totp implements the time-based one-time passwords of RFC 6238, which are
+// generated by authenticator apps from a secret shared with the server.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Parameters of the generated codes. They are the defaults of authenticator apps,
+// some of which ignore any other value.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	// Skew is how many periods before and after the current one are accepted,
+	// to allow for the clocks of devices to drift
+	Skew = 1
+
+	// secretSize is the size of secrets in bytes, which is the size of SHA-1 hashes as recommended by RFC 4226
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret generates a random secret, encoded in base32 as authenticator apps expect it
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "could not generate secret")
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the provisioning URI of a secret, which is usually shown as a QR code for
+// authenticator apps to scan. The account is shown in the app along with the issuer.
+func URI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Step returns the time step at the given time, which is the counter from which codes are generated
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code generates the code of the given time step
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", errors.Wrap(err, "invalid secret")
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, as defined by RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo), nil
+}
+
+// Validate checks that the code is valid at the given time, and returns its time step. Codes can
+// only be used once, so the codes of steps up to lastStep, which is the step of the last valid
+// code, are refused.
+func Validate(secret, code string, t time.Time, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if step <= lastStep {
+			continue
+		}
+
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,193 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal("could not generate secret")
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if assert.NoError(t, err, "secret should be encoded in base32") {
+		assert.Len(t, key, secretSize, "wrong secret size")
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal("could not generate secret")
+	}
+	assert.NotEqual(t, secret, other, "secrets should be random")
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Bloggo", "pam@dunder-mifflin.com", rfcSecret))
+	if err != nil {
+		t.Fatal("URI should be valid")
+	}
+
+	assert.Equal(t, "otpauth", uri.Scheme, "wrong scheme")
+	assert.Equal(t, "totp", uri.Host, "wrong type")
+	assert.Equal(t, "/Bloggo:pam@dunder-mifflin.com", uri.Path, "wrong label")
+	assert.Equal(t, rfcSecret, uri.Query().Get("secret"), "wrong secret")
+	assert.Equal(t, "Bloggo", uri.Query().Get("issuer"), "wrong issuer")
+	assert.Equal(t, "6", uri.Query().Get("digits"), "wrong number of digits")
+	assert.Equal(t, "30", uri.Query().Get("period"), "wrong period")
+}
+
+func TestCode(t *testing.T) {
+	// The test vectors of RFC 6238 have 8 digits, of which the codes are the last 6
+	tests := []struct {
+		description string
+
+		time int64
+
+		expectedCode string
+	}{
+		{
+			description: "first vector",
+
+			time: 59,
+
+			expectedCode: "287082",
+		},
+		{
+			description: "second vector",
+
+			time: 1111111109,
+
+			expectedCode: "081804",
+		},
+		{
+			description: "third vector",
+
+			time: 1111111111,
+
+			expectedCode: "050471",
+		},
+		{
+			description: "fourth vector",
+
+			time: 1234567890,
+
+			expectedCode: "005924",
+		},
+		{
+			description: "fifth vector",
+
+			time: 2000000000,
+
+			expectedCode: "279037",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			code, err := Code(rfcSecret, Step(time.Unix(test.time, 0)))
+			if assert.NoError(t, err, "unexpected error") {
+				assert.Equal(t, test.expectedCode, code, "wrong code")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	current := Step(now)
+
+	tests := []struct {
+		description string
+
+		secret   string
+		code     string
+		lastStep int64
+
+		expectedStep  int64
+		expectedValid bool
+	}{
+		{
+			description: "current code",
+
+			secret: rfcSecret,
+			code:   "081804",
+
+			expectedStep:  current,
+			expectedValid: true,
+		},
+		{
+			description: "code of the previous period",
+
+			secret: rfcSecret,
+			code:   mustCode(t, current-1),
+
+			expectedStep:  current - 1,
+			expectedValid: true,
+		},
+		{
+			description: "code of the next period",
+
+			secret: rfcSecret,
+			code:   mustCode(t, current+1),
+
+			expectedStep:  current + 1,
+			expectedValid: true,
+		},
+		{
+			description: "code too old",
+
+			secret: rfcSecret,
+			code:   mustCode(t, current-2),
+		},
+		{
+			description: "code already used",
+
+			secret:   rfcSecret,
+			code:     "081804",
+			lastStep: current,
+		},
+		{
+			description: "wrong code",
+
+			secret: rfcSecret,
+			code:   "123456",
+		},
+		{
+			description: "wrong length",
+
+			secret: rfcSecret,
+			code:   "81804",
+		},
+		{
+			description: "invalid secret",
+
+			secret: "not base32!",
+			code:   "081804",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			step, valid := Validate(test.secret, test.code, now, test.lastStep)
+
+			assert.Equal(t, test.expectedValid, valid, "wrong validity")
+			assert.Equal(t, test.expectedStep, step, "wrong step")
+		})
+	}
+}
+
+func mustCode(t *testing.T, step int64) string {
+	code, err := Code(rfcSecret, step)
+	if err != nil {
+		t.Fatal("could not generate code")
+	}
+	return code
+}